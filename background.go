@@ -0,0 +1,50 @@
+package brigadier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/subscriber"
+	"github.com/go-foreman/foreman/pubsub/transport"
+)
+
+// BackgroundTask is a long running job of a component, i.e. outbox relay. It must return when ctx is canceled
+type BackgroundTask func(ctx context.Context) error
+
+type backgroundTask struct {
+	name string
+	run  BackgroundTask
+}
+
+// backgroundSubscriber runs background tasks of components while the subscriber is running
+type backgroundSubscriber struct {
+	subscriber.Subscriber
+	logger log.Logger
+	tasks  []backgroundTask
+}
+
+func (s *backgroundSubscriber) Run(ctx context.Context, queues ...transport.Queue) error {
+	tasksCtx, cancel := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+
+	for _, task := range s.tasks {
+		wg.Add(1)
+
+		go func(task backgroundTask) {
+			defer wg.Done()
+
+			if err := task.run(tasksCtx); err != nil {
+				s.logger.Logf(log.ErrorLevel, "background task %s stopped with error. %s", task.name, err)
+			}
+		}(task)
+	}
+
+	err := s.Subscriber.Run(ctx, queues...)
+
+	//tasks are stopped once subscriber is stopped
+	cancel()
+	wg.Wait()
+
+	return err
+}
@@ -3,9 +3,11 @@ module github.com/go-foreman/foreman
 go 1.16
 
 require (
+	github.com/go-redis/redis/v8 v8.11.5
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/google/uuid v1.1.2
 	github.com/jackc/pgx/v4 v4.11.0
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/pkg/errors v0.9.1
 	github.com/streadway/amqp v1.0.0
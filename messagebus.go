@@ -8,6 +8,7 @@ import (
 	"github.com/go-foreman/foreman/pubsub/endpoint"
 	"github.com/go-foreman/foreman/pubsub/message"
 	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/go-foreman/foreman/pubsub/subscriber"
 	"github.com/go-foreman/foreman/pubsub/transport"
 	"github.com/go-foreman/foreman/runtime/scheme"
@@ -22,8 +23,9 @@ type Component interface {
 type SubscriberOption func(subscriberOpts *subscriberOpts, c *subscriberContainer)
 
 type subscriberOpts struct {
-	subscriber subscriber.Subscriber
-	transport  transport.Transport
+	subscriber        subscriber.Subscriber
+	transports        map[string]transport.Transport
+	subscriberOptions []subscriber.Option
 }
 
 type subscriberContainer struct {
@@ -39,9 +41,18 @@ func WithSubscriber(subscriber subscriber.Subscriber) SubscriberOption {
 }
 
 // DefaultWithTransport option allows to specify your own transport which will be used in the default subscriber
-func DefaultWithTransport(transport transport.Transport) SubscriberOption {
+func DefaultWithTransport(t transport.Transport) SubscriberOption {
 	return func(subscriberOpts *subscriberOpts, c *subscriberContainer) {
-		subscriberOpts.transport = transport
+		subscriberOpts.transports = map[string]transport.Transport{subscriber.DefaultTransportName: t}
+	}
+}
+
+// DefaultWithTransports option allows to consume from several named transports at once with the default subscriber.
+// Queues are bound to their transport with subscriber.TransportQueue, endpoints are created with a transport returned by MessageBus.Transport
+func DefaultWithTransports(transports map[string]transport.Transport, options ...subscriber.Option) SubscriberOption {
+	return func(subscriberOpts *subscriberOpts, c *subscriberContainer) {
+		subscriberOpts.transports = transports
+		subscriberOpts.subscriberOptions = options
 	}
 }
 
@@ -64,7 +75,10 @@ type container struct {
 	router                    endpoint.Router
 	msgMarshaller             message.Marshaller
 	processor                 subscriber.Processor
+	middlewares               []execution.Middleware
 	components                []Component
+	outbox                    outbox.Store
+	relayOpts                 []outbox.RelayOption
 }
 
 // WithComponents specifies a list of additional components you want to be registered in MessageBus
@@ -88,6 +102,22 @@ func WithDispatcher(dispatcher dispatcher.Dispatcher) ConfigOption {
 	}
 }
 
+// WithMiddlewares specifies middlewares which wrap execution of every received message, i.e. inbox deduplication
+func WithMiddlewares(middlewares ...execution.Middleware) ConfigOption {
+	return func(c *container) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// WithOutbox makes messages sent by handlers inside a transaction, i.e. the one of inbox entry available via inbox.TxFromContext,
+// be added to the outbox in it. They are sent by outbox relay which runs in background while the subscriber is running
+func WithOutbox(store outbox.Store, relayOpts ...outbox.RelayOption) ConfigOption {
+	return func(c *container) {
+		c.outbox = store
+		c.relayOpts = relayOpts
+	}
+}
+
 // WithMessageExecutionFactory allows to provide own execution.MessageExecutionCtxFactory
 func WithMessageExecutionFactory(factory execution.MessageExecutionCtxFactory) ConfigOption {
 	return func(c *container) {
@@ -101,7 +131,8 @@ type MessageBus struct {
 	messagesDispatcher dispatcher.Dispatcher
 	router             endpoint.Router
 	scheme             scheme.KnownTypesRegistry
-	subscriber         subscriber.Subscriber
+	subscriber         *backgroundSubscriber
+	transports         map[string]transport.Transport
 	logger             log.Logger
 }
 
@@ -125,11 +156,17 @@ func NewMessageBus(logger log.Logger, msgMarshaller message.Marshaller, scheme s
 	}
 
 	if container.messageExuctionCtxFactory == nil {
-		container.messageExuctionCtxFactory = execution.NewMessageExecutionCtxFactory(container.router, logger)
+		var factoryOpts []execution.FactoryOption
+
+		if container.outbox != nil {
+			factoryOpts = append(factoryOpts, execution.WithOutbox(container.outbox))
+		}
+
+		container.messageExuctionCtxFactory = execution.NewMessageExecutionCtxFactory(container.router, logger, factoryOpts...)
 	}
 
 	if container.processor == nil {
-		container.processor = subscriber.NewMessageProcessor(msgMarshaller, container.messageExuctionCtxFactory, container.messagesDispatcher, logger)
+		container.processor = subscriber.NewMessageProcessor(msgMarshaller, container.messageExuctionCtxFactory, container.messagesDispatcher, logger, container.middlewares...)
 	}
 
 	mBus.messagesDispatcher = container.messagesDispatcher
@@ -142,14 +179,20 @@ func NewMessageBus(logger log.Logger, msgMarshaller message.Marshaller, scheme s
 		processor:     container.processor,
 	})
 
+	mBus.transports = subscriberOpt.transports
+
 	if subscriberOpt.subscriber != nil {
-		mBus.subscriber = subscriberOpt.subscriber
-	} else if subscriberOpt.transport != nil {
-		mBus.subscriber = subscriber.NewSubscriber(subscriberOpt.transport, container.processor, logger)
+		mBus.subscriber = &backgroundSubscriber{Subscriber: subscriberOpt.subscriber, logger: logger}
+	} else if len(subscriberOpt.transports) > 0 {
+		mBus.subscriber = &backgroundSubscriber{Subscriber: subscriber.NewMultiTransportSubscriber(subscriberOpt.transports, container.processor, logger, subscriberOpt.subscriberOptions...), logger: logger}
 	} else {
 		return nil, errors.New("subscriber is nil")
 	}
 
+	if container.outbox != nil {
+		mBus.RunInBackground("outbox relay", outbox.NewRelay(container.outbox, container.router, logger, container.relayOpts...).Run)
+	}
+
 	for _, component := range container.components {
 		if err := component.Init(mBus); err != nil {
 			return nil, err
@@ -174,11 +217,28 @@ func (b *MessageBus) SchemeRegistry() scheme.KnownTypesRegistry {
 	return b.scheme
 }
 
-// Subscriber returns an instance of subscriber.Subscriber which controls the main flow of messages
+// Subscriber returns an instance of subscriber.Subscriber which controls the main flow of messages.
+// Background tasks of components run while it's running
 func (b *MessageBus) Subscriber() subscriber.Subscriber {
 	return b.subscriber
 }
 
+// RunInBackground registers a task which is started when the subscriber starts running and is stopped when the subscriber stops
+func (b *MessageBus) RunInBackground(name string, task BackgroundTask) {
+	b.subscriber.tasks = append(b.subscriber.tasks, backgroundTask{name: name, run: task})
+}
+
+// Transport returns a transport registered under the name, nil if there is no such transport.
+// Transport specified with DefaultWithTransport is registered as subscriber.DefaultTransportName
+func (b *MessageBus) Transport(name string) transport.Transport {
+	return b.transports[name]
+}
+
+// Transports returns all named transports the default subscriber consumes from
+func (b *MessageBus) Transports() map[string]transport.Transport {
+	return b.transports
+}
+
 // Logger returns an instance of logger
 func (b *MessageBus) Logger() log.Logger {
 	return b.logger
@@ -24,3 +24,20 @@ func WithDelay(delay time.Duration) DeliveryOption {
 }
 
 type DeliveryOption func(o *deliveryOptions) error
+
+// DeliveryDelay compiles delivery options and returns the delay specified by them, zero if there is none
+func DeliveryDelay(opts ...DeliveryOption) (time.Duration, error) {
+	deliveryOpts := &deliveryOptions{}
+
+	for _, opt := range opts {
+		if err := opt(deliveryOpts); err != nil {
+			return 0, err
+		}
+	}
+
+	if deliveryOpts.delay == nil {
+		return 0, nil
+	}
+
+	return *deliveryOpts.delay, nil
+}
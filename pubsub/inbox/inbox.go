@@ -0,0 +1,140 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRetention       = time.Hour * 24 * 7
+	defaultCleanupInterval = time.Hour
+)
+
+// Option allows to configure Inbox
+type Option func(i *Inbox)
+
+// WithRetention specifies how long uids of processed messages are kept. A duplicate received after this window won't be detected
+func WithRetention(retention time.Duration) Option {
+	return func(i *Inbox) {
+		i.retention = retention
+	}
+}
+
+// WithCleanupInterval specifies how often expired uids are removed by RunCleanup
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(i *Inbox) {
+		i.cleanupInterval = interval
+	}
+}
+
+// Inbox makes message processing idempotent by recording uids of processed messages and skipping the ones which were already processed
+type Inbox struct {
+	store           Store
+	logger          log.Logger
+	retention       time.Duration
+	cleanupInterval time.Duration
+}
+
+// NewInbox creates Inbox. Register Inbox.Middleware in MessageBus with brigadier.WithMiddlewares
+func NewInbox(store Store, logger log.Logger, opts ...Option) *Inbox {
+	i := &Inbox{store: store, logger: logger, retention: defaultRetention, cleanupInterval: defaultCleanupInterval}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// Middleware skips a message that was already processed, so it gets acked without calling handlers.
+// Uid of the message is recorded only if all handlers succeeded
+func (i *Inbox) Middleware(next execution.Executor) execution.Executor {
+	return func(execCtx execution.MessageExecutionCtx) error {
+		msg := execCtx.Message()
+
+		entry, err := i.store.Begin(execCtx.Context(), msg.UID())
+
+		if err != nil {
+			return errors.Wrapf(err, "recording message %s in inbox", msg.UID())
+		}
+
+		if entry.Duplicate() {
+			i.logger.Logf(log.InfoLevel, "message %s %s was already processed, skipping it", msg.UID(), msg.Payload().GroupKind())
+
+			if err := entry.Rollback(); err != nil {
+				i.logger.Logf(log.ErrorLevel, "error rolling back inbox entry of message %s. %s", msg.UID(), err)
+			}
+
+			return nil
+		}
+
+		ctx := execCtx.Context()
+
+		if tx := entry.Tx(); tx != nil {
+			ctx = WithTx(ctx, tx)
+		}
+
+		if err := next(execution.WithContext(execCtx, ctx)); err != nil {
+			if rErr := entry.Rollback(); rErr != nil {
+				return errors.Wrapf(rErr, "rollback of inbox entry when %s", err)
+			}
+
+			return err
+		}
+
+		if err := entry.Commit(); err != nil {
+			return errors.Wrapf(err, "committing inbox entry of message %s", msg.UID())
+		}
+
+		return nil
+	}
+}
+
+// Cleanup removes uids which are older than retention window
+func (i *Inbox) Cleanup(ctx context.Context) (int64, error) {
+	deleted, err := i.store.DeleteOlderThan(ctx, time.Now().Add(-i.retention))
+	if err != nil {
+		return 0, errors.Wrap(err, "cleaning up inbox")
+	}
+
+	return deleted, nil
+}
+
+// RunCleanup periodically removes expired uids until ctx is canceled
+func (i *Inbox) RunCleanup(ctx context.Context) error {
+	ticker := time.NewTicker(i.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			deleted, err := i.Cleanup(ctx)
+			if err != nil {
+				i.logger.Log(log.ErrorLevel, err)
+				continue
+			}
+
+			i.logger.Logf(log.DebugLevel, "removed %d expired records from inbox", deleted)
+		}
+	}
+}
+
+// WithTx puts transaction into context
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return outbox.WithTx(ctx, tx)
+}
+
+// TxFromContext returns a transaction the message uid is recorded in.
+// Handlers do their db work in it, so it's committed atomically with marking the message as processed.
+// Messages sent by handlers are added to outbox in it if the message bus is configured with brigadier.WithOutbox
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	return outbox.TxFromContext(ctx)
+}
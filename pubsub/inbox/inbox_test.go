@@ -0,0 +1,99 @@
+package inbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderPlacedEvent struct {
+	message.ObjectMeta
+}
+
+func newExecCtx(ctx context.Context, uid string) execution.MessageExecutionCtx {
+	msg := message.NewReceivedMessage(uid, &orderPlacedEvent{}, message.Headers{}, time.Now(), "orders")
+	return execution.NewMessageExecutionCtxFactory(endpoint.NewRouter(), log.NewNilLogger()).CreateCtx(ctx, nil, msg)
+}
+
+func TestInbox_Middleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var calls int
+	handler := func(execCtx execution.MessageExecutionCtx) error {
+		calls++
+		return nil
+	}
+
+	t.Run("duplicate is skipped", func(t *testing.T) {
+		calls = 0
+		execute := NewInbox(NewMemoryStore(), log.NewNilLogger()).Middleware(handler)
+
+		require.NoError(t, execute(newExecCtx(ctx, "aaa")))
+		require.NoError(t, execute(newExecCtx(ctx, "aaa")))
+		require.NoError(t, execute(newExecCtx(ctx, "bbb")))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("failed message is not recorded", func(t *testing.T) {
+		calls = 0
+		failing := true
+		execute := NewInbox(NewMemoryStore(), log.NewNilLogger()).Middleware(func(execCtx execution.MessageExecutionCtx) error {
+			calls++
+			if failing {
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+
+		assert.EqualError(t, execute(newExecCtx(ctx, "aaa")), "handler failed")
+		failing = false
+		require.NoError(t, execute(newExecCtx(ctx, "aaa")))
+		require.NoError(t, execute(newExecCtx(ctx, "aaa")))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("concurrent duplicate waits for the first one", func(t *testing.T) {
+		store := NewMemoryStore()
+		entry, err := store.Begin(ctx, "aaa")
+		require.NoError(t, err)
+		require.False(t, entry.Duplicate())
+
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			assert.NoError(t, entry.Commit())
+		}()
+
+		duplicate, err := store.Begin(ctx, "aaa")
+		require.NoError(t, err)
+		assert.True(t, duplicate.Duplicate())
+	})
+}
+
+func TestInbox_Cleanup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := NewMemoryStore()
+	inbox := NewInbox(store, log.NewNilLogger(), WithRetention(-time.Hour))
+
+	entry, err := store.Begin(ctx, "aaa")
+	require.NoError(t, err)
+	require.NoError(t, entry.Commit())
+
+	deleted, err := inbox.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, deleted)
+
+	entry, err = store.Begin(ctx, "aaa")
+	require.NoError(t, err)
+	assert.False(t, entry.Duplicate())
+}
@@ -0,0 +1,117 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewMemoryStore creates in-memory Store, suitable for tests and single replica consumers
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+type memoryStore struct {
+	lock    sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	processedAt time.Time
+	committed   bool
+	done        chan struct{}
+}
+
+func (m *memoryStore) Begin(ctx context.Context, msgUID string) (Entry, error) {
+	for {
+		m.lock.Lock()
+		entry, exists := m.entries[msgUID]
+
+		if !exists {
+			entry = &memoryEntry{processedAt: time.Now().Round(time.Second).UTC(), done: make(chan struct{})}
+			m.entries[msgUID] = entry
+			m.lock.Unlock()
+
+			return &memoryStoreEntry{store: m, uid: msgUID, entry: entry}, nil
+		}
+
+		if entry.committed {
+			m.lock.Unlock()
+			return &memoryStoreEntry{duplicate: true}, nil
+		}
+
+		m.lock.Unlock()
+
+		//the same message is being processed by another worker, wait until it's finished
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "waiting for message %s to be processed by another worker", msgUID)
+		}
+	}
+}
+
+func (m *memoryStore) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var deleted int64
+
+	for uid, entry := range m.entries {
+		if entry.committed && entry.processedAt.Before(t) {
+			delete(m.entries, uid)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryStoreEntry struct {
+	store     *memoryStore
+	uid       string
+	entry     *memoryEntry
+	duplicate bool
+	finished  bool
+}
+
+func (e *memoryStoreEntry) Duplicate() bool {
+	return e.duplicate
+}
+
+func (e *memoryStoreEntry) Tx() *sql.Tx {
+	return nil
+}
+
+func (e *memoryStoreEntry) Commit() error {
+	if e.duplicate || e.finished {
+		return nil
+	}
+
+	e.store.lock.Lock()
+	defer e.store.lock.Unlock()
+
+	e.finished = true
+	e.entry.committed = true
+	close(e.entry.done)
+
+	return nil
+}
+
+func (e *memoryStoreEntry) Rollback() error {
+	if e.duplicate || e.finished {
+		return nil
+	}
+
+	e.store.lock.Lock()
+	defer e.store.lock.Unlock()
+
+	e.finished = true
+	delete(e.store.entries, e.uid)
+	close(e.entry.done)
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package inbox
+
+import (
+	"fmt"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+)
+
+// MigrationsComponent is a name under which migrations of sql inbox store are recorded
+const MigrationsComponent = "inbox"
+
+// Migrations returns schema migrations of sql inbox store. They are applied by NewSQLStore unless WithoutMigrations is passed
+func Migrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create message_inbox table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf(`create table if not exists %v
+	(
+		uid varchar(255) not null primary key,
+		processed_at timestamp not null
+	);`, inboxTableName)}
+			},
+		},
+	}
+}
+
+// SQLStoreOption configures sql inbox store
+type SQLStoreOption func(o *sqlStoreOpts)
+
+type sqlStoreOpts struct {
+	withoutMigrations bool
+}
+
+func newSQLStoreOpts(opts ...SQLStoreOption) *sqlStoreOpts {
+	o := &sqlStoreOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithoutMigrations disables applying schema migrations of the store, i.e. when a DBA applies them using sqlmigrate.Migrator SQL
+func WithoutMigrations() SQLStoreOption {
+	return func(o *sqlStoreOpts) {
+		o.withoutMigrations = true
+	}
+}
@@ -0,0 +1,117 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+	"github.com/pkg/errors"
+)
+
+type sqlStore struct {
+	db     *sql.DB
+	driver sqldriver.Driver
+}
+
+// NewSQLStore creates sql inbox store, it supports mysql, postgres and sqlite drivers.
+// Uid of a message is recorded in a transaction which is available to handlers via TxFromContext, so their db work is committed atomically with it
+func NewSQLStore(db *sql.DB, driver sqldriver.Driver, opts ...SQLStoreOption) (Store, error) {
+	storeOpts := newSQLStoreOpts(opts...)
+	s := &sqlStore{db: db, driver: driver}
+	if storeOpts.withoutMigrations {
+		return s, nil
+	}
+
+	if err := s.migrate(); err != nil {
+		return nil, errors.Wrapf(err, "initializing tables for inbox SQLStore, driver %s", driver)
+	}
+
+	return s, nil
+}
+
+func (s sqlStore) Begin(ctx context.Context, msgUID string) (Entry, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "beginning a transaction for message %s", msgUID)
+	}
+
+	//concurrent insert of the same uid waits until the first transaction is finished
+	res, err := tx.ExecContext(ctx, s.insertQuery(), msgUID, sqldriver.Timestamp(s.driver, time.Now().Round(time.Second)))
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return nil, errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return nil, errors.Wrapf(err, "inserting uid of message %s into inbox", msgUID)
+	}
+
+	rows, err := res.RowsAffected()
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return nil, errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return nil, errors.Wrapf(err, "getting response of insert query for message %s", msgUID)
+	}
+
+	return &sqlEntry{tx: tx, duplicate: rows == 0}, nil
+}
+
+func (s sqlStore) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE processed_at < ?;", inboxTableName)), sqldriver.Timestamp(s.driver, t))
+	if err != nil {
+		return 0, errors.Wrapf(err, "deleting inbox records older than %s", t)
+	}
+
+	rows, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, errors.Wrap(err, "getting response of delete query for inbox records")
+	}
+
+	return rows, nil
+}
+
+func (s sqlStore) insertQuery() string {
+	if s.driver == sqldriver.MySQL {
+		return fmt.Sprintf("INSERT IGNORE INTO %v (uid, processed_at) VALUES (?, ?);", inboxTableName)
+	}
+
+	return s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, processed_at) VALUES (?, ?) ON CONFLICT (uid) DO NOTHING;", inboxTableName))
+}
+
+func (s sqlStore) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	return sqlmigrate.NewMigrator(s.db, s.driver).Migrate(ctx, MigrationsComponent, Migrations())
+}
+
+func (s sqlStore) prepQuery(query string) string {
+	return sqldriver.PrepQuery(s.driver, query)
+}
+
+type sqlEntry struct {
+	tx        *sql.Tx
+	duplicate bool
+}
+
+func (e sqlEntry) Duplicate() bool {
+	return e.duplicate
+}
+
+func (e sqlEntry) Tx() *sql.Tx {
+	return e.tx
+}
+
+func (e sqlEntry) Commit() error {
+	return e.tx.Commit()
+}
+
+func (e sqlEntry) Rollback() error {
+	return e.tx.Rollback()
+}
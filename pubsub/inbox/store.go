@@ -0,0 +1,27 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const inboxTableName = "message_inbox"
+
+// Store keeps uids of processed messages
+type Store interface {
+	// Begin records uid of a message that is going to be processed. If the same uid is being processed right now, Begin waits until it's finished.
+	// Entry.Duplicate reports whether the message was already processed.
+	Begin(ctx context.Context, msgUID string) (Entry, error)
+	// DeleteOlderThan removes uids recorded before the specified time and returns how many of them were removed
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
+}
+
+// Entry is a record of a message being processed. It has to be committed once the message is processed successfully or rolled back otherwise
+type Entry interface {
+	Duplicate() bool
+	// Tx returns a transaction the uid is recorded in. It's nil if the store is not transactional
+	Tx() *sql.Tx
+	Commit() error
+	Rollback() error
+}
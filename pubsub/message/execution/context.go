@@ -6,6 +6,7 @@ import (
 	"github.com/go-foreman/foreman/log"
 	"github.com/go-foreman/foreman/pubsub/endpoint"
 	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/go-foreman/foreman/pubsub/transport/pkg"
 	"github.com/pkg/errors"
 )
@@ -25,6 +26,7 @@ type messageExecutionCtx struct {
 	inPkg   pkg.IncomingPkg
 	message *message.ReceivedMessage
 	router  endpoint.Router
+	outbox  outbox.Store
 	logger  log.Logger
 }
 
@@ -36,6 +38,8 @@ func (m messageExecutionCtx) Context() context.Context {
 	return m.ctx
 }
 
+// Send publishes the message to endpoints it's routed to. If the factory is created WithOutbox and there is a transaction in the context,
+// i.e. the one of inbox entry, the message is added to outbox in this transaction and it's sent by outbox relay after the transaction is committed
 func (m messageExecutionCtx) Send(msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
 	endpoints := m.router.Route(msg.Payload())
 
@@ -44,6 +48,14 @@ func (m messageExecutionCtx) Send(msg *message.OutcomingMessage, options ...endp
 		return nil
 	}
 
+	if tx, ok := outbox.TxFromContext(m.ctx); ok && m.outbox != nil {
+		if err := m.outbox.Add(m.ctx, tx, msg, options...); err != nil {
+			return errors.Wrapf(err, "adding message %s to outbox", msg.UID())
+		}
+
+		return nil
+	}
+
 	for _, endp := range endpoints {
 		if err := endp.Send(m.ctx, msg, options...); err != nil {
 			m.logger.Logf(log.ErrorLevel, "error sending message id %s", msg.UID())
@@ -77,17 +89,74 @@ type MessageExecutionCtxFactory interface {
 	CreateCtx(ctx context.Context, inPkg pkg.IncomingPkg, message *message.ReceivedMessage) MessageExecutionCtx
 }
 
+// WithContext returns execution context which works with ctx, i.e. the one carrying a transaction, instead of the original one
+func WithContext(execCtx MessageExecutionCtx, ctx context.Context) MessageExecutionCtx {
+	if m, ok := execCtx.(*messageExecutionCtx); ok {
+		withCtx := *m
+		withCtx.ctx = ctx
+		return &withCtx
+	}
+
+	return &overriddenCtx{MessageExecutionCtx: execCtx, ctx: ctx}
+}
+
+type overriddenCtx struct {
+	MessageExecutionCtx
+	ctx context.Context
+}
+
+func (c overriddenCtx) Context() context.Context {
+	return c.ctx
+}
+
+// WithMessage returns execution context of msg instead of the received one, i.e. of an event carried by a received command
+func WithMessage(execCtx MessageExecutionCtx, msg *message.ReceivedMessage) MessageExecutionCtx {
+	if m, ok := execCtx.(*messageExecutionCtx); ok {
+		withMsg := *m
+		withMsg.message = msg
+		return &withMsg
+	}
+
+	return &overriddenMsg{MessageExecutionCtx: execCtx, message: msg}
+}
+
+type overriddenMsg struct {
+	MessageExecutionCtx
+	message *message.ReceivedMessage
+}
+
+func (c overriddenMsg) Message() *message.ReceivedMessage {
+	return c.message
+}
+
+// FactoryOption configures MessageExecutionCtxFactory
+type FactoryOption func(f *messageExecutionCtxFactory)
+
+// WithOutbox makes messages sent inside a transaction put into context with outbox.WithTx be added to outbox instead of being published immediately
+func WithOutbox(store outbox.Store) FactoryOption {
+	return func(f *messageExecutionCtxFactory) {
+		f.outbox = store
+	}
+}
+
 type messageExecutionCtxFactory struct {
 	router endpoint.Router
+	outbox outbox.Store
 	logger log.Logger
 }
 
-func NewMessageExecutionCtxFactory(router endpoint.Router, logger log.Logger) MessageExecutionCtxFactory {
-	return &messageExecutionCtxFactory{router: router, logger: logger}
+func NewMessageExecutionCtxFactory(router endpoint.Router, logger log.Logger, opts ...FactoryOption) MessageExecutionCtxFactory {
+	f := &messageExecutionCtxFactory{router: router, logger: logger}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
 }
 
 func (m messageExecutionCtxFactory) CreateCtx(ctx context.Context, inPkg pkg.IncomingPkg, message *message.ReceivedMessage) MessageExecutionCtx {
-	return &messageExecutionCtx{ctx: ctx, inPkg: inPkg, message: message, router: m.router, logger: m.logger}
+	return &messageExecutionCtx{ctx: ctx, inPkg: inPkg, message: message, router: m.router, outbox: m.outbox, logger: m.logger}
 }
 
 type NoDefinedEndpoints struct {
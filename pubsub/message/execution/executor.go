@@ -1,3 +1,7 @@
 package execution
 
 type Executor func(execCtx MessageExecutionCtx) error
+
+// Middleware wraps execution of a received message by all matched executors.
+// It may run logic before and after next or skip calling it at all
+type Middleware func(next Executor) Executor
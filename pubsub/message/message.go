@@ -114,6 +114,10 @@ func NewOutcomingMessage(payload Object, passedOptions ...MsgOption) *OutcomingM
 
 	msg := &OutcomingMessage{uid: uuid.New().String(), obj: payload}
 
+	if opts.uid != "" {
+		msg.uid = opts.uid
+	}
+
 	if opts.headers != nil {
 		msg.headers = opts.headers
 	}
@@ -139,6 +143,7 @@ type MsgOption func(attr *opts)
 
 type opts struct {
 	headers Headers
+	uid     string
 }
 
 func WithHeaders(headers Headers) MsgOption {
@@ -146,3 +151,10 @@ func WithHeaders(headers Headers) MsgOption {
 		attr.headers = headers
 	}
 }
+
+// WithUID sets uid of a message instead of generating a new one. Used when the same message is sent again, i.e. from outbox
+func WithUID(uid string) MsgOption {
+	return func(attr *opts) {
+		attr.uid = uid
+	}
+}
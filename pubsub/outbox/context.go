@@ -0,0 +1,19 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+)
+
+type txKey struct{}
+
+// WithTx puts transaction into context, messages sent by handlers with execution.WithOutbox are added to outbox in it
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns a transaction put into context by WithTx
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok && tx != nil
+}
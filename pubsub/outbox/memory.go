@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/pkg/errors"
+)
+
+// NewMemoryStore creates in-memory Store, suitable for tests and stores which are not backed by sql
+func NewMemoryStore() Store {
+	return &memoryStore{records: make(map[string]*memoryRecord)}
+}
+
+type memoryStore struct {
+	lock    sync.Mutex
+	records map[string]*memoryRecord
+}
+
+type memoryRecord struct {
+	entry       Entry
+	availableAt time.Time
+	finished    bool
+	claimed     bool
+}
+
+func (m *memoryStore) Add(ctx context.Context, tx *sql.Tx, msg *message.OutcomingMessage, opts ...endpoint.DeliveryOption) error {
+	delay, err := endpoint.DeliveryDelay(opts...)
+	if err != nil {
+		return errors.Wrapf(err, "compiling delivery options for message %s", msg.UID())
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.records[msg.UID()]; exists {
+		return errors.Errorf("message %s is already in outbox", msg.UID())
+	}
+
+	createdAt := time.Now().UTC()
+	m.records[msg.UID()] = &memoryRecord{
+		entry:       Entry{Message: msg, CreatedAt: createdAt},
+		availableAt: createdAt.Add(delay),
+	}
+
+	return nil
+}
+
+func (m *memoryStore) Claim(ctx context.Context, limit int, uids ...string) (Claim, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var candidates []*memoryRecord
+
+	if len(uids) > 0 {
+		for _, uid := range uids {
+			if record, exists := m.records[uid]; exists {
+				candidates = append(candidates, record)
+			}
+		}
+	} else {
+		for _, record := range m.records {
+			candidates = append(candidates, record)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].entry.CreatedAt.Before(candidates[j].entry.CreatedAt)
+	})
+
+	now := time.Now().UTC()
+	claim := &memoryClaim{store: m, changes: make(map[string]func(r *memoryRecord))}
+
+	for _, record := range candidates {
+		if len(claim.records) >= limit {
+			break
+		}
+
+		if record.finished || record.claimed || record.availableAt.After(now) {
+			continue
+		}
+
+		record.claimed = true
+		claim.records = append(claim.records, record)
+	}
+
+	return claim, nil
+}
+
+type memoryClaim struct {
+	store   *memoryStore
+	records []*memoryRecord
+	changes map[string]func(r *memoryRecord)
+}
+
+func (c *memoryClaim) Entries() []Entry {
+	entries := make([]Entry, len(c.records))
+
+	for i, record := range c.records {
+		entries[i] = record.entry
+	}
+
+	return entries
+}
+
+func (c *memoryClaim) Dispatched(ctx context.Context, uid string) error {
+	c.changes[uid] = func(r *memoryRecord) {
+		r.entry.Attempts++
+		r.finished = true
+	}
+	return nil
+}
+
+func (c *memoryClaim) Retry(ctx context.Context, uid string, at time.Time, reason error) error {
+	c.changes[uid] = func(r *memoryRecord) {
+		r.entry.Attempts++
+		r.availableAt = at
+	}
+	return nil
+}
+
+func (c *memoryClaim) Abandon(ctx context.Context, uid string, reason error) error {
+	c.changes[uid] = func(r *memoryRecord) {
+		r.entry.Attempts++
+		r.finished = true
+	}
+	return nil
+}
+
+func (c *memoryClaim) Commit() error {
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+
+	for _, record := range c.records {
+		if change, exists := c.changes[record.entry.Message.UID()]; exists {
+			change(record)
+		}
+
+		record.claimed = false
+
+		//there is no need to keep dispatched messages in memory
+		if record.finished {
+			delete(c.store.records, record.entry.Message.UID())
+		}
+	}
+
+	return nil
+}
+
+func (c *memoryClaim) Rollback() error {
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+
+	for _, record := range c.records {
+		record.claimed = false
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"fmt"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+)
+
+// MigrationsComponent is a name under which migrations of sql outbox store are recorded
+const MigrationsComponent = "outbox"
+
+// Migrations returns schema migrations of sql outbox store. They are applied by NewSQLStore unless WithoutMigrations is passed
+func Migrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create message_outbox table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf(`create table if not exists %v
+	(
+		uid varchar(255) not null primary key,
+		name varchar(255) null,
+		payload text not null,
+		headers text null,
+		created_at timestamp not null,
+		available_at timestamp not null,
+		dispatched_at timestamp null,
+		abandoned_at timestamp null,
+		attempts int not null default 0,
+		last_error text null
+	);`, outboxTableName)}
+			},
+		},
+	}
+}
+
+// SQLStoreOption configures sql outbox store
+type SQLStoreOption func(o *sqlStoreOpts)
+
+type sqlStoreOpts struct {
+	withoutMigrations bool
+}
+
+func newSQLStoreOpts(opts ...SQLStoreOption) *sqlStoreOpts {
+	o := &sqlStoreOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithoutMigrations disables applying schema migrations of the store, i.e. when a DBA applies them using sqlmigrate.Migrator SQL
+func WithoutMigrations() SQLStoreOption {
+	return func(o *sqlStoreOpts) {
+		o.withoutMigrations = true
+	}
+}
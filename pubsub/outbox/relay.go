@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second * 5
+	defaultMaxAttempts  = 10
+	maxBackoff          = time.Minute * 10
+)
+
+// RelayOption allows to configure Relay
+type RelayOption func(r *Relay)
+
+// WithBatchSize specifies how many messages are claimed at once
+func WithBatchSize(size int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+// WithPollInterval specifies how often Run checks outbox for pending messages
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = interval
+	}
+}
+
+// WithMaxAttempts specifies after how many failed attempts a message is abandoned
+func WithMaxAttempts(attempts int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = attempts
+	}
+}
+
+// WithBackoff specifies a delay before the next attempt of sending a message which failed attempt times
+func WithBackoff(backoff func(attempt int) time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.backoff = backoff
+	}
+}
+
+// Relay publishes messages from outbox after transactions which added them are committed
+type Relay struct {
+	store        Store
+	router       endpoint.Router
+	logger       log.Logger
+	batchSize    int
+	pollInterval time.Duration
+	maxAttempts  int
+	backoff      func(attempt int) time.Duration
+	wakeup       chan struct{}
+}
+
+// NewRelay creates Relay which sends messages to endpoints resolved by the router
+func NewRelay(store Store, router endpoint.Router, logger log.Logger, opts ...RelayOption) *Relay {
+	r := &Relay{
+		store:        store,
+		router:       router,
+		logger:       logger,
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+		maxAttempts:  defaultMaxAttempts,
+		backoff:      exponentialBackoff,
+		wakeup:       make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Run dispatches pending messages until ctx is canceled. Messages that failed to be sent are retried with backoff
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		dispatched, err := r.Dispatch(ctx)
+		if err != nil {
+			r.logger.Logf(log.ErrorLevel, "error dispatching messages from outbox. %s", err)
+		}
+
+		//the batch was full, there might be more messages waiting
+		if err == nil && dispatched == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-r.wakeup:
+		}
+	}
+}
+
+// Notify wakes up Run to check outbox without waiting for the next poll
+func (r *Relay) Notify() {
+	select {
+	case r.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch claims one batch of pending messages and sends them out. If uids are specified, only these messages are dispatched.
+// Returns how many messages were processed
+func (r *Relay) Dispatch(ctx context.Context, uids ...string) (int, error) {
+	claim, err := r.store.Claim(ctx, r.batchSize, uids...)
+	if err != nil {
+		return 0, errors.Wrap(err, "claiming messages from outbox")
+	}
+
+	entries := claim.Entries()
+
+	for _, entry := range entries {
+		if err := r.dispatchEntry(ctx, claim, entry); err != nil {
+			if rErr := claim.Rollback(); rErr != nil {
+				return 0, errors.Wrapf(rErr, "rollback when %s", err)
+			}
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	if err := claim.Commit(); err != nil {
+		return 0, errors.Wrap(err, "committing dispatched outbox messages")
+	}
+
+	return len(entries), nil
+}
+
+func (r *Relay) dispatchEntry(ctx context.Context, claim Claim, entry Entry) error {
+	msg := entry.Message
+	sendErr := r.send(ctx, msg)
+
+	if sendErr == nil {
+		return claim.Dispatched(ctx, msg.UID())
+	}
+
+	attempt := entry.Attempts + 1
+
+	if attempt >= r.maxAttempts {
+		r.logger.Logf(log.ErrorLevel, "abandoning outbox message %s after %d attempts. %s", msg.UID(), attempt, sendErr)
+		return claim.Abandon(ctx, msg.UID(), sendErr)
+	}
+
+	r.logger.Logf(log.WarnLevel, "error sending outbox message %s, attempt %d. %s", msg.UID(), attempt, sendErr)
+
+	return claim.Retry(ctx, msg.UID(), time.Now().Add(r.backoff(attempt)), sendErr)
+}
+
+// Routed checks whether there are endpoints a message is sent to, the relay drops a message without them
+func (r *Relay) Routed(obj message.Object) bool {
+	return len(r.router.Route(obj)) > 0
+}
+
+func (r *Relay) send(ctx context.Context, msg *message.OutcomingMessage) error {
+	endpoints := r.router.Route(msg.Payload())
+
+	if len(endpoints) == 0 {
+		r.logger.Logf(log.WarnLevel, "no endpoints defined for message %s", msg.UID())
+		return nil
+	}
+
+	for _, endp := range endpoints {
+		if err := endp.Send(ctx, msg); err != nil {
+			return errors.Wrapf(err, "sending message %s to endpoint %s", msg.UID(), endp.Name())
+		}
+	}
+
+	return nil
+}
+
+func exponentialBackoff(attempt int) time.Duration {
+	backoff := time.Second
+
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderPlacedEvent struct {
+	message.ObjectMeta
+}
+
+type endpointStub struct {
+	fail bool
+	sent []*message.OutcomingMessage
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	if e.fail {
+		return errors.New("broker is down")
+	}
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	newRelay := func(opts ...RelayOption) (*Relay, Store, *endpointStub) {
+		store := NewMemoryStore()
+		stub := &endpointStub{}
+		router := endpoint.NewRouter()
+		router.RegisterEndpoint(stub, &orderPlacedEvent{})
+		return NewRelay(store, router, log.NewNilLogger(), opts...), store, stub
+	}
+
+	t.Run("dispatch pending messages", func(t *testing.T) {
+		relay, store, stub := newRelay()
+		msg := message.NewOutcomingMessage(&orderPlacedEvent{})
+		require.NoError(t, store.Add(ctx, nil, msg))
+
+		dispatched, err := relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dispatched)
+		require.Len(t, stub.sent, 1)
+		assert.Equal(t, msg.UID(), stub.sent[0].UID())
+
+		dispatched, err = relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+	})
+
+	t.Run("dispatch only specified messages", func(t *testing.T) {
+		relay, store, stub := newRelay()
+		first := message.NewOutcomingMessage(&orderPlacedEvent{})
+		second := message.NewOutcomingMessage(&orderPlacedEvent{})
+		require.NoError(t, store.Add(ctx, nil, first))
+		require.NoError(t, store.Add(ctx, nil, second))
+
+		_, err := relay.Dispatch(ctx, second.UID())
+		require.NoError(t, err)
+		require.Len(t, stub.sent, 1)
+		assert.Equal(t, second.UID(), stub.sent[0].UID())
+	})
+
+	t.Run("delayed message is not dispatched before the delay", func(t *testing.T) {
+		relay, store, stub := newRelay()
+		require.NoError(t, store.Add(ctx, nil, message.NewOutcomingMessage(&orderPlacedEvent{}), endpoint.WithDelay(time.Hour)))
+
+		dispatched, err := relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+		assert.Len(t, stub.sent, 0)
+	})
+
+	t.Run("failed message is retried and then abandoned", func(t *testing.T) {
+		relay, store, stub := newRelay(WithMaxAttempts(2), WithBackoff(func(attempt int) time.Duration {
+			return 0
+		}))
+		stub.fail = true
+		require.NoError(t, store.Add(ctx, nil, message.NewOutcomingMessage(&orderPlacedEvent{})))
+
+		dispatched, err := relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dispatched)
+
+		dispatched, err = relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dispatched)
+
+		stub.fail = false
+		dispatched, err = relay.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+		assert.Len(t, stub.sent, 0)
+	})
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, exponentialBackoff(1))
+	assert.Equal(t, time.Second*4, exponentialBackoff(3))
+	assert.Equal(t, maxBackoff, exponentialBackoff(100))
+}
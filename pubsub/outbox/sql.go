@@ -0,0 +1,245 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+	"github.com/pkg/errors"
+)
+
+type sqlStore struct {
+	db            *sql.DB
+	driver        sqldriver.Driver
+	msgMarshaller message.Marshaller
+}
+
+// NewSQLStore creates sql outbox store, it supports mysql, postgres and sqlite drivers.
+// SQLite has no row locks, use a connection with immediate transactions (i.e. _txlock=immediate for mattn/go-sqlite3) if several relays share a database
+func NewSQLStore(db *sql.DB, driver sqldriver.Driver, msgMarshaller message.Marshaller, opts ...SQLStoreOption) (Store, error) {
+	storeOpts := newSQLStoreOpts(opts...)
+	s := &sqlStore{db: db, driver: driver, msgMarshaller: msgMarshaller}
+	if storeOpts.withoutMigrations {
+		return s, nil
+	}
+
+	if err := s.migrate(); err != nil {
+		return nil, errors.Wrapf(err, "initializing tables for outbox SQLStore, driver %s", driver)
+	}
+
+	return s, nil
+}
+
+func (s sqlStore) Add(ctx context.Context, tx *sql.Tx, msg *message.OutcomingMessage, opts ...endpoint.DeliveryOption) error {
+	delay, err := endpoint.DeliveryDelay(opts...)
+	if err != nil {
+		return errors.Wrapf(err, "compiling delivery options for message %s", msg.UID())
+	}
+
+	payload, err := s.msgMarshaller.Marshal(msg.Payload())
+	if err != nil {
+		return errors.Wrapf(err, "marshaling message %s for outbox", msg.UID())
+	}
+
+	headers, err := json.Marshal(msg.Headers())
+	if err != nil {
+		return errors.Wrapf(err, "marshaling headers of message %s for outbox", msg.UID())
+	}
+
+	//truncated, so the message is available right away even if column has seconds precision
+	createdAt := time.Now().Truncate(time.Second).UTC()
+
+	query := s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, name, payload, headers, created_at, available_at, attempts) VALUES (?, ?, ?, ?, ?, ?, 0);", outboxTableName))
+	args := []interface{}{
+		msg.UID(),
+		msg.Payload().GroupKind().String(),
+		payload,
+		headers,
+		sqldriver.Timestamp(s.driver, createdAt),
+		sqldriver.Timestamp(s.driver, createdAt.Add(delay)),
+	}
+
+	if tx != nil {
+		_, err = tx.ExecContext(ctx, query, args...)
+	} else {
+		_, err = s.db.ExecContext(ctx, query, args...)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "inserting message %s into outbox", msg.UID())
+	}
+
+	return nil
+}
+
+func (s sqlStore) Claim(ctx context.Context, limit int, uids ...string) (Claim, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning a transaction for outbox claim")
+	}
+
+	query := fmt.Sprintf("SELECT uid, payload, headers, attempts, created_at FROM %v WHERE dispatched_at IS NULL AND abandoned_at IS NULL AND available_at <= ?", outboxTableName)
+	args := []interface{}{sqldriver.Timestamp(s.driver, time.Now())}
+
+	if len(uids) > 0 {
+		query += fmt.Sprintf(" AND uid IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(uids)), ", "))
+		for _, uid := range uids {
+			args = append(args, uid)
+		}
+	}
+
+	query += fmt.Sprintf(" ORDER BY created_at LIMIT %d", limit)
+
+	//sqlite has no row locks, a write transaction locks whole database
+	if s.driver != sqldriver.SQLite {
+		query += " FOR UPDATE SKIP LOCKED"
+	}
+
+	query += ";"
+
+	entries, broken, err := s.queryEntries(ctx, tx, s.prepQuery(query), args...)
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return nil, errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	claim := &sqlClaim{store: s, tx: tx, entries: entries}
+
+	//messages which can't be decoded anymore would block the relay forever
+	for uid, reason := range broken {
+		if err := claim.Abandon(ctx, uid, reason); err != nil {
+			if rErr := tx.Rollback(); rErr != nil {
+				return nil, errors.Wrapf(rErr, "rollback when %s", err)
+			}
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return claim, nil
+}
+
+// queryEntries returns pending entries and errors of entries which can't be decoded
+func (s sqlStore) queryEntries(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]Entry, map[string]error, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "querying pending outbox messages")
+	}
+
+	defer rows.Close()
+
+	var entries []Entry
+	broken := make(map[string]error)
+
+	for rows.Next() {
+		var (
+			uid       sql.NullString
+			payload   []byte
+			rawHeader []byte
+			attempts  sql.NullInt64
+			createdAt sql.NullTime
+		)
+
+		if err := rows.Scan(&uid, &payload, &rawHeader, &attempts, &createdAt); err != nil {
+			return nil, nil, errors.Wrap(err, "scanning outbox row")
+		}
+
+		obj, err := s.msgMarshaller.Unmarshal(payload)
+
+		if err != nil {
+			broken[uid.String] = errors.Wrapf(err, "unmarshaling payload of outbox message %s", uid.String)
+			continue
+		}
+
+		headers := make(message.Headers)
+
+		if len(rawHeader) > 0 {
+			if err := json.Unmarshal(rawHeader, &headers); err != nil {
+				broken[uid.String] = errors.Wrapf(err, "unmarshaling headers of outbox message %s", uid.String)
+				continue
+			}
+		}
+
+		entries = append(entries, Entry{
+			Message:   message.NewOutcomingMessage(obj, message.WithHeaders(headers), message.WithUID(uid.String)),
+			Attempts:  int(attempts.Int64),
+			CreatedAt: createdAt.Time,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return entries, broken, nil
+}
+
+func (s sqlStore) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	return sqlmigrate.NewMigrator(s.db, s.driver).Migrate(ctx, MigrationsComponent, Migrations())
+}
+
+func (s sqlStore) prepQuery(query string) string {
+	return sqldriver.PrepQuery(s.driver, query)
+}
+
+type sqlClaim struct {
+	store   sqlStore
+	tx      *sql.Tx
+	entries []Entry
+}
+
+func (c sqlClaim) Entries() []Entry {
+	return c.entries
+}
+
+func (c sqlClaim) Dispatched(ctx context.Context, uid string) error {
+	_, err := c.tx.ExecContext(ctx, c.store.prepQuery(fmt.Sprintf("UPDATE %v SET dispatched_at=?, attempts=attempts+1 WHERE uid=?;", outboxTableName)), sqldriver.Timestamp(c.store.driver, time.Now().Round(time.Second)), uid)
+
+	if err != nil {
+		return errors.Wrapf(err, "marking outbox message %s as dispatched", uid)
+	}
+
+	return nil
+}
+
+func (c sqlClaim) Retry(ctx context.Context, uid string, at time.Time, reason error) error {
+	_, err := c.tx.ExecContext(ctx, c.store.prepQuery(fmt.Sprintf("UPDATE %v SET available_at=?, attempts=attempts+1, last_error=? WHERE uid=?;", outboxTableName)), sqldriver.Timestamp(c.store.driver, at), reason.Error(), uid)
+
+	if err != nil {
+		return errors.Wrapf(err, "rescheduling outbox message %s", uid)
+	}
+
+	return nil
+}
+
+func (c sqlClaim) Abandon(ctx context.Context, uid string, reason error) error {
+	_, err := c.tx.ExecContext(ctx, c.store.prepQuery(fmt.Sprintf("UPDATE %v SET abandoned_at=?, attempts=attempts+1, last_error=? WHERE uid=?;", outboxTableName)), sqldriver.Timestamp(c.store.driver, time.Now().Round(time.Second)), reason.Error(), uid)
+
+	if err != nil {
+		return errors.Wrapf(err, "abandoning outbox message %s", uid)
+	}
+
+	return nil
+}
+
+func (c sqlClaim) Commit() error {
+	return c.tx.Commit()
+}
+
+func (c sqlClaim) Rollback() error {
+	return c.tx.Rollback()
+}
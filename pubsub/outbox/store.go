@@ -0,0 +1,42 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+)
+
+const outboxTableName = "message_outbox"
+
+// Store persists outgoing messages until they are dispatched by Relay
+type Store interface {
+	// Add writes a message into outbox inside the caller's transaction, so it's sent out only if the transaction is committed.
+	// Delay specified by delivery options postpones dispatching of the message. Tx may be nil for stores which are not transactional
+	Add(ctx context.Context, tx *sql.Tx, msg *message.OutcomingMessage, opts ...endpoint.DeliveryOption) error
+	// Claim locks up to limit pending messages which are due for dispatching, so other relays skip them.
+	// If uids are specified, only these messages are claimed
+	Claim(ctx context.Context, limit int, uids ...string) (Claim, error)
+}
+
+// Entry is a message waiting in outbox to be dispatched
+type Entry struct {
+	Message   *message.OutcomingMessage
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// Claim is a set of pending entries locked by one relay. Results of dispatching are saved on Commit
+type Claim interface {
+	Entries() []Entry
+	// Dispatched marks a message as sent
+	Dispatched(ctx context.Context, uid string) error
+	// Retry postpones next dispatching attempt of a message
+	Retry(ctx context.Context, uid string, at time.Time, reason error) error
+	// Abandon stops dispatching attempts of a message
+	Abandon(ctx context.Context, uid string, reason error) error
+	Commit() error
+	Rollback() error
+}
@@ -22,10 +22,12 @@ type processor struct {
 	decoder           message.Marshaller
 	dispatcher        msgDispatcher.Dispatcher
 	msgExecCtxFactory execution.MessageExecutionCtxFactory
+	middlewares       []execution.Middleware
 }
 
-func NewMessageProcessor(decoder message.Marshaller, msgExecCtxFactory execution.MessageExecutionCtxFactory, msgDispatcher msgDispatcher.Dispatcher, logger log.Logger) Processor {
-	return &processor{decoder: decoder, msgExecCtxFactory: msgExecCtxFactory, dispatcher: msgDispatcher, logger: logger}
+// NewMessageProcessor creates default Processor. Middlewares wrap execution of every message, the first one is the outermost
+func NewMessageProcessor(decoder message.Marshaller, msgExecCtxFactory execution.MessageExecutionCtxFactory, msgDispatcher msgDispatcher.Dispatcher, logger log.Logger, middlewares ...execution.Middleware) Processor {
+	return &processor{decoder: decoder, msgExecCtxFactory: msgExecCtxFactory, dispatcher: msgDispatcher, logger: logger, middlewares: middlewares}
 }
 
 func (p *processor) Process(ctx context.Context, inPkg pkg.IncomingPkg) error {
@@ -51,13 +53,21 @@ func (p *processor) Process(ctx context.Context, inPkg pkg.IncomingPkg) error {
 
 	execCtx := p.msgExecCtxFactory.CreateCtx(ctx, inPkg, receivedMsg)
 
-	for _, exec := range executors {
-		if err := exec(execCtx); err != nil {
-			return errors.Wrapf(err, "error executing message %s %s", receivedMsg.UID(), payload.GroupKind())
+	execute := func(execCtx execution.MessageExecutionCtx) error {
+		for _, exec := range executors {
+			if err := exec(execCtx); err != nil {
+				return errors.Wrapf(err, "error executing message %s %s", receivedMsg.UID(), payload.GroupKind())
+			}
 		}
+
+		return nil
+	}
+
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		execute = p.middlewares[i](execute)
 	}
 
-	return nil
+	return execute(execCtx)
 }
 
 type NoExecutorsDefinedErr struct {
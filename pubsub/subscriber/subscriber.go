@@ -1,8 +1,11 @@
 package subscriber
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/go-foreman/foreman/log"
@@ -23,20 +26,69 @@ const (
 	scheduleTimeout          time.Duration = time.Second * 3
 )
 
+// DefaultTransportName is a name of the transport passed to NewSubscriber
+const DefaultTransportName = "default"
+
 type Subscriber interface {
 	Run(ctx context.Context, queues ...transport.Queue) error
 	Stop(ctx context.Context) error
 }
 
+// Option allows to configure the default subscriber
+type Option func(o *opts)
+
+type opts struct {
+	consumeOpts map[string][]transport.ConsumeOpts
+}
+
+// WithConsumeOptions overrides options the named transport is consumed with. By default amqp.WithQosPrefetchCount is used
+func WithConsumeOptions(transportName string, options ...transport.ConsumeOpts) Option {
+	return func(o *opts) {
+		o.consumeOpts[transportName] = options
+	}
+}
+
 type subscriber struct {
-	transport        transport.Transport
+	transports       map[string]transport.Transport
+	consumeOpts      map[string][]transport.ConsumeOpts
 	logger           log.Logger
 	processor        Processor
 	workerDispatcher *dispatcher
 }
 
-func NewSubscriber(transport transport.Transport, processor Processor, logger log.Logger) Subscriber {
-	return &subscriber{transport: transport, logger: logger, processor: processor, workerDispatcher: newDispatcher(maxTasksInProgress)}
+func NewSubscriber(t transport.Transport, processor Processor, logger log.Logger, options ...Option) Subscriber {
+	return NewMultiTransportSubscriber(map[string]transport.Transport{DefaultTransportName: t}, processor, logger, options...)
+}
+
+// NewMultiTransportSubscriber creates a subscriber which consumes from several named transports at once.
+// All of them share the same processor and pool of workers. Use TransportQueue to specify which transport a queue belongs to
+func NewMultiTransportSubscriber(transports map[string]transport.Transport, processor Processor, logger log.Logger, options ...Option) Subscriber {
+	subscriberOpts := &opts{consumeOpts: make(map[string][]transport.ConsumeOpts)}
+	for _, opt := range options {
+		opt(subscriberOpts)
+	}
+
+	return &subscriber{
+		transports:       transports,
+		consumeOpts:      subscriberOpts.consumeOpts,
+		logger:           logger,
+		processor:        processor,
+		workerDispatcher: newDispatcher(maxTasksInProgress),
+	}
+}
+
+// TransportQueue binds a queue to the named transport it must be consumed from
+func TransportQueue(transportName string, queue transport.Queue) transport.Queue {
+	return transportQueue{Queue: queue, transportName: transportName}
+}
+
+type transportQueue struct {
+	transport.Queue
+	transportName string
+}
+
+func (q transportQueue) String() string {
+	return q.transportName + "/" + q.Name()
 }
 
 func (s *subscriber) Run(ctx context.Context, queues ...transport.Queue) error {
@@ -52,7 +104,7 @@ func (s *subscriber) Run(ctx context.Context, queues ...transport.Queue) error {
 	defer shutdownCancel()
 	defer cancelConsumerCtx()
 
-	consumedPkgs, err := s.transport.Consume(consumerCtx, queues, amqp.WithQosPrefetchCount(maxTasksInProgress))
+	consumedPkgs, err := s.consume(consumerCtx, queues)
 
 	if err != nil {
 		return errors.WithStack(err)
@@ -98,6 +150,75 @@ func (s *subscriber) Run(ctx context.Context, queues ...transport.Queue) error {
 	}
 }
 
+// consume starts consuming queues from transports they are bound to and merges all the packages into one channel
+func (s *subscriber) consume(ctx context.Context, queues []transport.Queue) (<-chan pkg.IncomingPkg, error) {
+	queuesByTransport := make(map[string][]transport.Queue)
+
+	for _, q := range queues {
+		transportName := DefaultTransportName
+
+		if tq, ok := q.(transportQueue); ok {
+			transportName = tq.transportName
+			q = tq.Queue
+		} else if len(s.transports) == 1 {
+			for name := range s.transports {
+				transportName = name
+			}
+		}
+
+		if _, exists := s.transports[transportName]; !exists {
+			return nil, errors.Errorf("transport `%s` for queue %s is not registered in subscriber", transportName, q.Name())
+		}
+
+		queuesByTransport[transportName] = append(queuesByTransport[transportName], q)
+	}
+
+	var consumed []<-chan pkg.IncomingPkg
+
+	for transportName, transportQueues := range queuesByTransport {
+		consumeOpts, exists := s.consumeOpts[transportName]
+		if !exists {
+			consumeOpts = []transport.ConsumeOpts{amqp.WithQosPrefetchCount(maxTasksInProgress)}
+		}
+
+		pkgs, err := s.transports[transportName].Consume(ctx, transportQueues, consumeOpts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "consuming from transport `%s`", transportName)
+		}
+
+		consumed = append(consumed, pkgs)
+	}
+
+	if len(consumed) == 1 {
+		return consumed[0], nil
+	}
+
+	income := make(chan pkg.IncomingPkg)
+	consumersWait := &sync.WaitGroup{}
+
+	for _, pkgs := range consumed {
+		consumersWait.Add(1)
+		go func(pkgs <-chan pkg.IncomingPkg) {
+			defer consumersWait.Done()
+
+			for inPkg := range pkgs {
+				select {
+				case income <- inPkg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pkgs)
+	}
+
+	go func() {
+		consumersWait.Wait()
+		close(income)
+	}()
+
+	return income, nil
+}
+
 func (s *subscriber) processPackage(ctx context.Context, inPkg pkg.IncomingPkg) {
 	processorCtx, processorCancel := context.WithTimeout(ctx, packageProcessingMaxTime)
 	defer processorCancel()
@@ -131,9 +252,21 @@ func (s *subscriber) Stop(ctx context.Context) error {
 		}
 	}
 
-	s.logger.Logf(log.InfoLevel, "All tasks are finished. Disconnecting from transport.")
+	s.logger.Logf(log.InfoLevel, "All tasks are finished. Disconnecting from transports.")
+
+	var disconnectErrs []string
+
+	for name, t := range s.transports {
+		if err := t.Disconnect(ctx); err != nil {
+			disconnectErrs = append(disconnectErrs, fmt.Sprintf("transport `%s`: %s", name, err))
+		}
+	}
+
+	if len(disconnectErrs) > 0 {
+		return errors.Errorf("disconnecting from transports. %s", strings.Join(disconnectErrs, "; "))
+	}
 
-	return s.transport.Disconnect(ctx)
+	return nil
 }
 
 type processPkg struct {
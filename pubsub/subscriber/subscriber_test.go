@@ -0,0 +1,95 @@
+package subscriber
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/transport"
+	"github.com/go-foreman/foreman/pubsub/transport/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queue string
+
+func (q queue) Name() string {
+	return string(q)
+}
+
+type topic string
+
+func (t topic) Name() string {
+	return string(t)
+}
+
+type queueBind string
+
+func (q queueBind) DestinationTopic() string {
+	return string(q)
+}
+
+func (q queueBind) BindingKey() string {
+	return "#"
+}
+
+type processorFunc func(ctx context.Context, inPkg pkg.IncomingPkg) error
+
+func (f processorFunc) Process(ctx context.Context, inPkg pkg.IncomingPkg) error {
+	return f(ctx, inPkg)
+}
+
+func TestMultiTransportSubscriber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	rabbit := transport.NewStubTransport()
+	kafka := transport.NewStubTransport()
+
+	for _, stub := range []transport.Transport{rabbit, kafka} {
+		require.NoError(t, stub.CreateTopic(ctx, topic("events")))
+		require.NoError(t, stub.CreateQueue(ctx, queue("consumer"), queueBind("events")))
+	}
+
+	processed := make(chan string)
+	processor := processorFunc(func(ctx context.Context, inPkg pkg.IncomingPkg) error {
+		processed <- string(inPkg.Payload())
+		return nil
+	})
+
+	s := NewMultiTransportSubscriber(map[string]transport.Transport{"rabbit": rabbit, "kafka": kafka}, processor, log.NewNilLogger())
+
+	t.Run("queue is not bound to a transport", func(t *testing.T) {
+		err := s.Run(ctx, queue("consumer"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "transport `default` for queue consumer is not registered in subscriber")
+	})
+
+	t.Run("consume from both transports", func(t *testing.T) {
+		runCtx, cancelRun := context.WithCancel(ctx)
+		defer cancelRun()
+
+		go func() {
+			assert.NoError(t, s.Run(runCtx, TransportQueue("rabbit", queue("consumer")), TransportQueue("kafka", queue("consumer"))))
+		}()
+
+		destination := pkg.DeliveryDestination{DestinationTopic: "events"}
+		go func() {
+			assert.NoError(t, rabbit.Send(runCtx, pkg.NewOutboundPkg([]byte("from rabbit"), "application/json", destination, nil)))
+			assert.NoError(t, kafka.Send(runCtx, pkg.NewOutboundPkg([]byte("from kafka"), "application/json", destination, nil)))
+		}()
+
+		var received []string
+		for len(received) < 2 {
+			select {
+			case payload := <-processed:
+				received = append(received, payload)
+			case <-ctx.Done():
+				t.FailNow()
+			}
+		}
+
+		assert.ElementsMatch(t, []string{"from rabbit", "from kafka"}, received)
+	})
+}
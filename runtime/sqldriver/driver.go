@@ -0,0 +1,57 @@
+package sqldriver
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	MySQL    Driver = "mysql"
+	Postgres Driver = "pg"
+	SQLite   Driver = "sqlite"
+)
+
+// sqliteTimestampFormat has fixed width, so timestamps stored as text are compared correctly
+const sqliteTimestampFormat = "2006-01-02 15:04:05.000000000"
+
+// Driver specifies sql dialect used by stores.
+// It's required because of https://github.com/golang/go/issues/3602. Better this than +1 dependency or copy pasting code
+type Driver string
+
+// PrepQuery replaces wildcard params to specific driver. Standard wildcard is '?'
+func PrepQuery(driver Driver, query string) string {
+	var res []byte
+
+	counter := 1
+
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' && driver == Postgres {
+			res = append(append(res, '$'), []byte(strconv.Itoa(counter))...)
+			counter++
+
+			continue
+
+		}
+		res = append(res, query[i])
+	}
+
+	return string(res)
+}
+
+// Timestamp converts time into a query argument. SQLite has no time type, time is stored as UTC text there
+func Timestamp(driver Driver, t time.Time) interface{} {
+	if driver == SQLite {
+		return t.UTC().Format(sqliteTimestampFormat)
+	}
+
+	return t
+}
+
+// NullTimestamp is Timestamp for nullable columns, nil time is stored as NULL
+func NullTimestamp(driver Driver, t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+
+	return Timestamp(driver, *t)
+}
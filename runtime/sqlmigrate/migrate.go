@@ -0,0 +1,334 @@
+package sqlmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/pkg/errors"
+)
+
+const (
+	migrationsTableName = "schema_migrations"
+	lockName            = "foreman_schema_migrations"
+)
+
+// Querier is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Migration is a versioned change of a component's schema
+type Migration struct {
+	Version     int
+	Description string
+	// Statements returns DDL of the migration for a driver
+	Statements func(driver sqldriver.Driver) []string
+	// Applied optionally detects that the change was made before migrations were tracked, such a migration is only recorded
+	Applied func(ctx context.Context, q Querier, driver sqldriver.Driver) (bool, error)
+}
+
+// Migrator applies migrations of components and records applied versions in schema_migrations table
+type Migrator struct {
+	db     *sql.DB
+	driver sqldriver.Driver
+}
+
+// NewMigrator creates Migrator, it supports mysql, postgres and sqlite drivers
+func NewMigrator(db *sql.DB, driver sqldriver.Driver) *Migrator {
+	return &Migrator{db: db, driver: driver}
+}
+
+// Migrate applies pending migrations of a component in order of their versions.
+// Migrations are applied under an advisory lock, so instances which start simultaneously don't apply them twice
+func (m *Migrator) Migrate(ctx context.Context, component string, migrations []Migration) error {
+	if _, err := m.db.ExecContext(ctx, m.createTableStatement()); err != nil {
+		return errors.Wrapf(err, "creating %s table", migrationsTableName)
+	}
+
+	conn, err := m.db.Conn(ctx)
+
+	if err != nil {
+		return errors.Wrap(err, "obtaining a connection from pool for migrations")
+	}
+
+	defer conn.Close()
+
+	if err := m.lock(ctx, conn); err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer m.unlock(conn)
+
+	applied, err := m.appliedVersions(ctx, conn, component)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, migration := range sorted(migrations) {
+		if applied[migration.Version] {
+			continue
+		}
+
+		if err := m.apply(ctx, conn, component, migration); err != nil {
+			return errors.Wrapf(err, "applying migration %d `%s` of %s", migration.Version, migration.Description, component)
+		}
+	}
+
+	return nil
+}
+
+// SQL returns statements of pending migrations of a component including the ones which record them, so a DBA could apply them manually.
+// Nothing is changed in database
+func (m *Migrator) SQL(ctx context.Context, component string, migrations []Migration) ([]string, error) {
+	statements := []string{m.createTableStatement()}
+	applied := make(map[int]bool)
+
+	exists, err := TableExists(ctx, m.db, m.driver, migrationsTableName)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if exists {
+		if applied, err = m.appliedVersions(ctx, m.db, component); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	for _, migration := range sorted(migrations) {
+		if applied[migration.Version] {
+			continue
+		}
+
+		detected, err := m.detected(ctx, m.db, migration)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking whether migration %d of %s is applied", migration.Version, component)
+		}
+
+		if !detected {
+			statements = append(statements, migration.Statements(m.driver)...)
+		}
+
+		statements = append(statements, fmt.Sprintf(
+			"INSERT INTO %s (component, version, description, applied_at) VALUES ('%s', %d, '%s', CURRENT_TIMESTAMP);",
+			migrationsTableName,
+			escape(component),
+			migration.Version,
+			escape(migration.Description),
+		))
+	}
+
+	return statements, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, component string, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	//sqlite has no advisory lock, the migration could be applied by another process after applied versions were read
+	var count int
+
+	if err := tx.QueryRowContext(ctx, sqldriver.PrepQuery(m.driver, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE component=? AND version=?;", migrationsTableName)), component, migration.Version).Scan(&count); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return errors.Wrap(err, "checking whether migration is recorded")
+	}
+
+	if count > 0 {
+		return errors.WithStack(tx.Rollback())
+	}
+
+	detected, err := m.detected(ctx, tx, migration)
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return errors.WithStack(err)
+	}
+
+	if !detected {
+		for _, statement := range migration.Statements(m.driver) {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				if rErr := tx.Rollback(); rErr != nil {
+					return errors.Wrapf(rErr, "rollback when %s", err)
+				}
+				return errors.Wrapf(err, "executing `%s`", statement)
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		sqldriver.PrepQuery(m.driver, fmt.Sprintf("INSERT INTO %s (component, version, description, applied_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP);", migrationsTableName)),
+		component,
+		migration.Version,
+		migration.Description,
+	)
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return errors.Wrap(err, "recording migration")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) detected(ctx context.Context, q Querier, migration Migration) (bool, error) {
+	if migration.Applied == nil {
+		return false, nil
+	}
+
+	return migration.Applied(ctx, q, m.driver)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, q queryer, component string) (map[int]bool, error) {
+	rows, err := q.QueryContext(ctx, sqldriver.PrepQuery(m.driver, fmt.Sprintf("SELECT version FROM %s WHERE component=?;", migrationsTableName)), component)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying applied migrations of %s", component)
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, errors.Wrapf(err, "scanning applied migrations of %s", component)
+		}
+
+		applied[version] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	switch m.driver {
+	case sqldriver.MySQL:
+		r := sql.NullInt64{}
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1);", lockName).Scan(&r); err != nil {
+			return errors.Wrap(err, "acquiring migrations lock")
+		}
+
+		if r.Int64 != 1 {
+			return errors.Errorf("Got %d when acquiring migrations lock", r.Int64)
+		}
+	case sqldriver.Postgres:
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1));", lockName); err != nil {
+			return errors.Wrap(err, "acquiring migrations lock")
+		}
+	}
+
+	//sqlite has no advisory locks, a write transaction locks whole database
+
+	return nil
+}
+
+func (m *Migrator) unlock(conn *sql.Conn) {
+	//lock belongs to the connection, closing it releases the lock anyway
+	ctx := context.Background()
+
+	switch m.driver {
+	case sqldriver.MySQL:
+		_, _ = conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?);", lockName)
+	case sqldriver.Postgres:
+		_, _ = conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1));", lockName)
+	}
+}
+
+func (m *Migrator) createTableStatement() string {
+	return fmt.Sprintf(`create table if not exists %s
+	(
+		component varchar(255) not null,
+		version integer not null,
+		description varchar(255) null,
+		applied_at timestamp null,
+		primary key (component, version)
+	);`, migrationsTableName)
+}
+
+// TableExists checks whether a table exists in current database
+func TableExists(ctx context.Context, q Querier, driver sqldriver.Driver, table string) (bool, error) {
+	var query string
+
+	switch driver {
+	case sqldriver.SQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;"
+	case sqldriver.Postgres:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?;"
+	default:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?;"
+	}
+
+	var count int
+
+	if err := q.QueryRowContext(ctx, sqldriver.PrepQuery(driver, query), table).Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "checking whether table %s exists", table)
+	}
+
+	return count > 0, nil
+}
+
+// ColumnExists checks whether a column exists in a table of current database
+func ColumnExists(ctx context.Context, q Querier, driver sqldriver.Driver, table, column string) (bool, error) {
+	var query string
+
+	switch driver {
+	case sqldriver.SQLite:
+		query = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;"
+	case sqldriver.Postgres:
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?;"
+	default:
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?;"
+	}
+
+	var count int
+
+	if err := q.QueryRowContext(ctx, sqldriver.PrepQuery(driver, query), table, column).Scan(&count); err != nil {
+		return false, errors.Wrapf(err, "checking whether column %s exists in %s", column, table)
+	}
+
+	return count > 0, nil
+}
+
+func sorted(migrations []Migration) []Migration {
+	res := make([]Migration, len(migrations))
+	copy(res, migrations)
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res
+}
+
+func escape(str string) string {
+	return strings.ReplaceAll(str, "'", "''")
+}
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/saga/api/handlers/control"
+	"github.com/go-foreman/foreman/saga/api/handlers/status"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/graph"
+	"github.com/pkg/errors"
+)
+
+// Error is returned when saga api responds with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("saga api responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound is true if saga api responded with 404
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsConflict is true if saga api responded with 409, a saga already exists or its status doesn't allow the operation
+func IsConflict(err error) bool {
+	return statusCode(err) == http.StatusConflict
+}
+
+func statusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+
+	return 0
+}
+
+// Saga is a saga returned by saga api. Payloads are left encoded, decode them with a marshaller which knows saga types
+type Saga struct {
+	SagaUID   string          `json:"saga_uid"`
+	ParentUID string          `json:"parent_uid,omitempty"`
+	Status    string          `json:"status"`
+	State     string          `json:"state,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Events    []Event         `json:"events"`
+}
+
+// DecodePayload decodes the saga
+func (s Saga) DecodePayload(marshaller message.Marshaller) (message.Object, error) {
+	return decodePayload(marshaller, s.Payload)
+}
+
+// Event is a history event of a saga
+type Event struct {
+	UID        string          `json:"uid"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Payload    json.RawMessage `json:"payload"`
+	Origin     string          `json:"origin"`
+	SagaStatus string          `json:"saga_status"`
+	TraceUID   string          `json:"trace_uid"`
+}
+
+// DecodePayload decodes the event
+func (e Event) DecodePayload(marshaller message.Marshaller) (message.Object, error) {
+	return decodePayload(marshaller, e.Payload)
+}
+
+func decodePayload(marshaller message.Marshaller, payload json.RawMessage) (message.Object, error) {
+	obj, err := marshaller.Unmarshal(payload)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding payload")
+	}
+
+	return obj, nil
+}
+
+// Page is a page of sagas, NextCursor is empty when there are no more sagas
+type Page struct {
+	Sagas      []*Saga
+	NextCursor string
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient specifies a client requests are sent with, http.DefaultClient is used by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBearerToken authenticates requests, it's required by write operations
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// Client of saga api registered with component.WithSagaApiServer
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+}
+
+// NewClient creates Client. baseURL is an address of the server saga api mux is served by, i.e. http://orders:8080
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: http.DefaultClient}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// GetSaga returns a saga with its history
+func (c *Client) GetSaga(ctx context.Context, sagaUID string) (*Saga, error) {
+	res := &Saga{}
+
+	if _, err := c.do(ctx, http.MethodGet, sagaPath(sagaUID), nil, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetTree returns a saga with its child sagas
+func (c *Client) GetTree(ctx context.Context, sagaUID string) (*status.SagaTreeNode, error) {
+	res := &status.SagaTreeNode{}
+
+	if _, err := c.do(ctx, http.MethodGet, sagaPath(sagaUID)+"/tree", nil, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetSagaGraph renders a path taken by the saga in graph.Mermaid or graph.DOT format
+func (c *Client) GetSagaGraph(ctx context.Context, sagaUID string, format graph.Format) (string, error) {
+	var res string
+
+	if _, err := c.do(ctx, http.MethodGet, sagaPath(sagaUID)+"/graph", url.Values{"format": []string{string(format)}}, nil, &res); err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+// GetDefinitionGraph renders a workflow of a registered saga type in graph.Mermaid or graph.DOT format
+func (c *Client) GetDefinitionGraph(ctx context.Context, sagaType string, format graph.Format) (string, error) {
+	var res string
+
+	if _, err := c.do(ctx, http.MethodGet, "/sagas/definitions/"+url.PathEscape(sagaType)+"/graph", url.Values{"format": []string{string(format)}}, nil, &res); err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+// ListSagas returns a page of sagas matching the query, pass NextCursor with the same query to get the next page
+func (c *Client) ListSagas(ctx context.Context, query status.FilterQuery) (*Page, error) {
+	page := &Page{}
+
+	header, err := c.do(ctx, http.MethodGet, "/sagas", query.Values(), nil, &page.Sagas)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page.NextCursor = header.Get(status.NextCursorHeader)
+
+	return page, nil
+}
+
+// StartSaga sends a command to start a saga. req.Saga is a saga encoded by message.Marshaller
+func (c *Client) StartSaga(ctx context.Context, req control.StartRequest) (*control.CommandResponse, error) {
+	body, err := json.Marshal(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding start request")
+	}
+
+	res := &control.CommandResponse{}
+
+	if _, err := c.do(ctx, http.MethodPost, "/sagas", nil, body, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RecoverSaga sends a command to recover a failed saga
+func (c *Client) RecoverSaga(ctx context.Context, sagaUID string) (*control.CommandResponse, error) {
+	return c.command(ctx, sagaUID, bulk.Recover, nil)
+}
+
+// CompensateSaga sends a command to compensate a failed saga
+func (c *Client) CompensateSaga(ctx context.Context, sagaUID string) (*control.CommandResponse, error) {
+	return c.command(ctx, sagaUID, bulk.Compensate, nil)
+}
+
+// CancelSaga sends a command to cancel a saga, it's compensated if compensate is true
+func (c *Client) CancelSaga(ctx context.Context, sagaUID string, compensate bool) (*control.CommandResponse, error) {
+	var query url.Values
+
+	if compensate {
+		query = url.Values{"compensate": []string{"true"}}
+	}
+
+	return c.command(ctx, sagaUID, bulk.Cancel, query)
+}
+
+// DeleteSaga deletes a completed, compensated, cancelled or failed saga
+func (c *Client) DeleteSaga(ctx context.Context, sagaUID string) error {
+	_, err := c.do(ctx, http.MethodDelete, sagaPath(sagaUID), nil, nil, nil)
+	return err
+}
+
+// Bulk applies the action to sagas matching req.Filter, sorting params of the filter aren't used.
+// Repeat it with the returned cursor until the result is done
+func (c *Client) Bulk(ctx context.Context, action bulk.Action, req control.BulkRequest) (*bulk.Result, error) {
+	filter := req.Filter
+	filter.SortBy, filter.Order, filter.WithoutHistory = "", "", false
+
+	query := filter.Values()
+
+	if req.Compensate {
+		query.Set("compensate", "true")
+	}
+
+	if req.DryRun {
+		query.Set("dryRun", "true")
+	}
+
+	if req.Rate > 0 {
+		query.Set("rate", strconv.FormatFloat(req.Rate, 'f', -1, 64))
+	}
+
+	res := &bulk.Result{}
+
+	if _, err := c.do(ctx, http.MethodPost, "/sagas/bulk/"+url.PathEscape(string(action)), query, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) command(ctx context.Context, sagaUID string, action bulk.Action, query url.Values) (*control.CommandResponse, error) {
+	res := &control.CommandResponse{}
+
+	if _, err := c.do(ctx, http.MethodPost, sagaPath(sagaUID)+"/"+string(action), query, nil, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// do sends a request and decodes a json response into res if it isn't nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, res interface{}) (http.Header, error) {
+	target := c.baseURL + path
+
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating request %s %s", method, path)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "sending request %s %s", method, path)
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading response of %s %s", method, path)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, errors.WithStack(&Error{StatusCode: resp.StatusCode, Message: string(respBody)})
+	}
+
+	//graphs are returned as text
+	if text, ok := res.(*string); ok {
+		*text = string(respBody)
+		return resp.Header, nil
+	}
+
+	if res != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, res); err != nil {
+			return nil, errors.Wrapf(err, "decoding response of %s %s", method, path)
+		}
+	}
+
+	return resp.Header, nil
+}
+
+func sagaPath(sagaUID string) string {
+	return "/sagas/" + url.PathEscape(sagaUID)
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/api/handlers/control"
+	graphApi "github.com/go-foreman/foreman/saga/api/handlers/graph"
+	"github.com/go-foreman/foreman/saga/api/handlers/status"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/graph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderSaga struct {
+	saga.BaseSaga
+	OrderID string `json:"order_id"`
+}
+
+func (s *orderSaga) Init()                                     {}
+func (s *orderSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *orderSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *orderSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type endpointStub struct {
+	sent []message.Object
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.sent = append(e.sent, msg.Payload())
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("orders", &orderSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	store := saga.NewMemoryStore(marshaller)
+	stub := &endpointStub{}
+	router := endpoint.NewRouter()
+	router.RegisterEndpoint(stub, &contracts.StartSagaCommand{}, &contracts.RecoverSagaCommand{}, &contracts.CompensateSagaCommand{}, &contracts.CancelSagaCommand{})
+	relay := outbox.NewRelay(store.Outbox(), router, log.NewNilLogger())
+
+	statusHandler := status.NewStatusHandler(log.NewNilLogger(), status.NewStatusService(store))
+	controlHandler := control.NewControlHandler(log.NewNilLogger(), control.NewControlService(store, marshaller, bulk.NewExecutor(store, relay, log.NewNilLogger())), control.BearerTokenAuthenticator("secret"))
+	graphHandler := graphApi.NewGraphHandler(log.NewNilLogger(), graphApi.NewGraphService(store, map[string]*graph.Graph{
+		"orders.orderSaga": {Name: "orders.orderSaga", Nodes: []graph.Node{{ID: "start", Label: "Start", Kind: graph.StartNode}}},
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sagas", func(resp http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			controlHandler.Start(resp, r)
+			return
+		}
+		statusHandler.GetFilteredBy(resp, r)
+	})
+	mux.HandleFunc("/sagas/", func(resp http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			controlHandler.Execute(resp, r)
+		case http.MethodDelete:
+			controlHandler.Delete(resp, r)
+		default:
+			if strings.HasSuffix(r.URL.Path, "/graph") {
+				graphHandler.Get(resp, r)
+				return
+			}
+			if strings.HasSuffix(r.URL.Path, "/tree") {
+				statusHandler.GetTree(resp, r)
+				return
+			}
+			statusHandler.GetStatus(resp, r)
+		}
+	})
+	mux.HandleFunc("/sagas/bulk/", controlHandler.Bulk)
+	mux.HandleFunc(graphApi.DefinitionsPath, graphHandler.Get)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	createSaga := func(uid, parentUID string, update func(instance saga.Instance)) {
+		sagaObj := &orderSaga{OrderID: uid}
+		sagaObj.SetGroupKind(&scheme.GroupKind{Group: "orders", Kind: "orderSaga"})
+		instance := saga.NewSagaInstance(uid, parentUID, sagaObj)
+		require.NoError(t, store.Create(ctx, instance))
+		update(instance)
+		require.NoError(t, store.Update(ctx, instance))
+	}
+
+	createSaga("failed", "", func(instance saga.Instance) {
+		instance.Fail(&contracts.SagaFailedEvent{Reason: "timeout"})
+	})
+	createSaga("child", "failed", func(instance saga.Instance) {
+		instance.Progress()
+	})
+
+	c := NewClient(server.URL+"/", WithBearerToken("secret"))
+
+	t.Run("query sagas", func(t *testing.T) {
+		sagaResp, err := c.GetSaga(ctx, "failed")
+		require.NoError(t, err)
+		assert.Equal(t, "failed", sagaResp.Status)
+
+		payload, err := sagaResp.DecodePayload(marshaller)
+		require.NoError(t, err)
+		assert.Equal(t, "failed", payload.(*orderSaga).OrderID)
+
+		tree, err := c.GetTree(ctx, "failed")
+		require.NoError(t, err)
+		require.Len(t, tree.Children, 1)
+		assert.Equal(t, "child", tree.Children[0].SagaUID)
+
+		page, err := c.ListSagas(ctx, status.FilterQuery{Limit: 1, WithoutHistory: true})
+		require.NoError(t, err)
+		require.Len(t, page.Sagas, 1)
+		assert.Equal(t, "child", page.Sagas[0].SagaUID)
+		require.NotEmpty(t, page.NextCursor)
+
+		page, err = c.ListSagas(ctx, status.FilterQuery{Limit: 1, Cursor: page.NextCursor})
+		require.NoError(t, err)
+		require.Len(t, page.Sagas, 1)
+		assert.Equal(t, "failed", page.Sagas[0].SagaUID)
+
+		_, err = c.GetSaga(ctx, "unknown")
+		assert.True(t, IsNotFound(err))
+	})
+
+	t.Run("render graphs", func(t *testing.T) {
+		rendered, err := c.GetSagaGraph(ctx, "failed", graph.Mermaid)
+		require.NoError(t, err)
+		assert.Contains(t, rendered, `status("failed")`)
+
+		rendered, err = c.GetDefinitionGraph(ctx, "orders.orderSaga", graph.DOT)
+		require.NoError(t, err)
+		assert.Equal(t, "digraph \"orders.orderSaga\" {\n    rankdir=TB;\n    \"start\" [label=\"Start\", shape=circle];\n}\n", rendered)
+
+		_, err = c.GetDefinitionGraph(ctx, "orders.unknown", graph.Mermaid)
+		assert.True(t, IsNotFound(err))
+
+		_, err = c.GetSagaGraph(ctx, "failed", "svg")
+		assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	})
+
+	t.Run("control sagas", func(t *testing.T) {
+		raw, err := marshaller.Marshal(&orderSaga{OrderID: "new"})
+		require.NoError(t, err)
+
+		started, err := c.StartSaga(ctx, control.StartRequest{SagaUID: "new", Saga: raw})
+		require.NoError(t, err)
+		assert.Equal(t, "new", started.SagaUID)
+
+		_, err = c.RecoverSaga(ctx, "failed")
+		require.NoError(t, err)
+
+		_, err = c.CancelSaga(ctx, "child", true)
+		require.NoError(t, err)
+
+		_, err = c.CompensateSaga(ctx, "child")
+		assert.True(t, IsConflict(err))
+
+		res, err := c.Bulk(ctx, bulk.Compensate, control.BulkRequest{Filter: status.FilterQuery{Status: "failed", Limit: 10}, DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"failed"}, res.SagaUIDs)
+
+		require.Len(t, stub.sent, 3)
+		assert.Equal(t, "new", stub.sent[0].(*contracts.StartSagaCommand).SagaUID)
+		assert.Equal(t, "failed", stub.sent[1].(*contracts.RecoverSagaCommand).SagaUID)
+		assert.True(t, stub.sent[2].(*contracts.CancelSagaCommand).Compensate)
+
+		require.NoError(t, c.DeleteSaga(ctx, "failed"))
+		assert.True(t, IsNotFound(c.DeleteSaga(ctx, "failed")))
+	})
+
+	t.Run("unauthenticated client", func(t *testing.T) {
+		_, err := NewClient(server.URL).RecoverSaga(ctx, "failed")
+
+		apiErr := &Error{}
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	})
+}
@@ -0,0 +1,411 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/saga"
+	sagaApiErrors "github.com/go-foreman/foreman/saga/api/errors"
+	"github.com/go-foreman/foreman/saga/api/handlers/status"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxBodySize limits size of a saga payload in start request
+	maxBodySize = 1 << 20
+	// maxBulkLimit limits amount of sagas a bulk request looks at, use the returned cursor to continue
+	maxBulkLimit = 10000
+)
+
+// StartRequest is a body of saga start request. Saga is a saga payload with its kind and group, just like it's marshaled into a message
+type StartRequest struct {
+	SagaUID   string          `json:"saga_uid,omitempty"`
+	ParentUID string          `json:"parent_uid,omitempty"`
+	Saga      json.RawMessage `json:"saga"`
+}
+
+// CommandResponse is returned when a command is accepted, the command is handled by saga component asynchronously
+type CommandResponse struct {
+	SagaUID string `json:"saga_uid"`
+}
+
+// Authenticator rejects a request by returning an error. ResponseError specifies response status, it's 401 for other errors
+type Authenticator func(r *http.Request) error
+
+// BearerTokenAuthenticator accepts requests with `Authorization: Bearer <token>` header
+func BearerTokenAuthenticator(token string) Authenticator {
+	return func(r *http.Request) error {
+		header := r.Header.Get("Authorization")
+		provided := strings.TrimPrefix(header, "Bearer ")
+
+		if token == "" || provided == header || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return sagaApiErrors.NewResponseError(http.StatusUnauthorized, errors.New("invalid bearer token"))
+		}
+
+		return nil
+	}
+}
+
+type ControlService interface {
+	// Start sends StartSagaCommand for a saga decoded from the request
+	Start(ctx context.Context, req StartRequest) (*CommandResponse, error)
+	// Execute sends a command of the action to an existing saga
+	Execute(ctx context.Context, sagaId, action string, compensate bool) (*CommandResponse, error)
+	// Delete removes a saga which isn't running anymore
+	Delete(ctx context.Context, sagaId string) error
+	// Bulk sends a command of the action to every saga matching the filter
+	Bulk(ctx context.Context, action string, req BulkRequest) (*bulk.Result, error)
+}
+
+// BulkRequest describes a bulk operation, Filter selects sagas the same way GET /sagas does
+type BulkRequest struct {
+	Filter     status.FilterQuery
+	Compensate bool
+	DryRun     bool
+	Rate       float64
+}
+
+// NewControlService creates ControlService. Commands are sent with the executor, so they aren't lost if a broker is unavailable
+func NewControlService(store saga.Store, marshaller message.Marshaller, executor *bulk.Executor) ControlService {
+	return &controlService{store: store, marshaller: marshaller, bulk: executor}
+}
+
+type controlService struct {
+	store      saga.Store
+	marshaller message.Marshaller
+	bulk       *bulk.Executor
+}
+
+func (s controlService) Start(ctx context.Context, req StartRequest) (*CommandResponse, error) {
+	if len(req.Saga) == 0 {
+		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.New("saga is empty"))
+	}
+
+	obj, err := s.marshaller.Unmarshal(req.Saga)
+
+	if err != nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Wrap(err, "decoding saga"))
+	}
+
+	sagaObj, ok := obj.(saga.Saga)
+
+	if !ok {
+		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("`%s` is not a saga", obj.GroupKind().String()))
+	}
+
+	if req.SagaUID == "" {
+		req.SagaUID = uuid.New().String()
+	}
+
+	existing, err := s.store.GetById(ctx, req.SagaUID)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading saga `%s`", req.SagaUID)
+	}
+
+	if existing != nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusConflict, errors.Errorf("Saga `%s` already exists", req.SagaUID))
+	}
+
+	return s.send(ctx, req.SagaUID, &contracts.StartSagaCommand{SagaUID: req.SagaUID, ParentUID: req.ParentUID, Saga: sagaObj})
+}
+
+func (s controlService) Execute(ctx context.Context, sagaId, action string, compensate bool) (*CommandResponse, error) {
+	sagaInstance, err := s.fetchSaga(ctx, sagaId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := bulk.Command(bulk.Action(action), sagaInstance, compensate)
+
+	if err != nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, err)
+	}
+
+	if cmd == nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusConflict, errors.Errorf("Saga `%s` has status `%s`, you can't %s it", sagaId, sagaInstance.Status().String(), action))
+	}
+
+	return s.send(ctx, sagaId, cmd)
+}
+
+func (s controlService) Delete(ctx context.Context, sagaId string) error {
+	sagaInstance, err := s.fetchSaga(ctx, sagaId)
+
+	if err != nil {
+		return err
+	}
+
+	if !saga.Finished(sagaInstance.Status()) && !sagaInstance.Status().Failed() {
+		return sagaApiErrors.NewResponseError(http.StatusConflict, errors.Errorf("Saga `%s` has status `%s`, only finished or failed saga can be deleted", sagaId, sagaInstance.Status().String()))
+	}
+
+	if err := s.store.Delete(ctx, sagaId); err != nil {
+		return errors.Wrapf(err, "error deleting saga `%s`", sagaId)
+	}
+
+	return nil
+}
+
+func (s controlService) Bulk(ctx context.Context, action string, req BulkRequest) (*bulk.Result, error) {
+	if req.Filter.Limit <= 0 || req.Filter.Limit > maxBulkLimit {
+		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("Limit must be between 1 and %d", maxBulkLimit))
+	}
+
+	conditions := req.Filter.Conditions()
+
+	if len(conditions) == 0 {
+		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.New("At least one filter is required"))
+	}
+
+	opts := []bulk.Option{bulk.WithLimit(req.Filter.Limit), bulk.WithCursor(req.Filter.Cursor)}
+
+	if req.Compensate {
+		opts = append(opts, bulk.WithCompensation())
+	}
+
+	if req.DryRun {
+		opts = append(opts, bulk.WithDryRun())
+	}
+
+	if req.Rate > 0 {
+		opts = append(opts, bulk.WithRate(req.Rate))
+	}
+
+	res, err := s.bulk.Run(ctx, bulk.Action(action), conditions, opts...)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, bulk.ErrUnknownAction):
+			return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, err)
+		case errors.Is(err, saga.ErrInvalidCursor):
+			return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, err)
+		case res == nil:
+			return nil, errors.WithStack(err)
+		}
+
+		return nil, errors.Wrapf(err, "bulk %s stopped after %d sagas, cursor `%s`", action, res.Matched, res.Cursor)
+	}
+
+	return res, nil
+}
+
+func (s controlService) fetchSaga(ctx context.Context, sagaId string) (saga.Instance, error) {
+	sagaInstance, err := s.store.GetById(ctx, sagaId)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading saga `%s`", sagaId)
+	}
+
+	if sagaInstance == nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, errors.Errorf("Saga `%s` not found", sagaId))
+	}
+
+	return sagaInstance, nil
+}
+
+func (s controlService) send(ctx context.Context, sagaId string, cmd message.Object) (*CommandResponse, error) {
+	if err := s.bulk.Send(ctx, cmd); err != nil {
+		return nil, errors.Wrapf(err, "error sending command to saga `%s`", sagaId)
+	}
+
+	return &CommandResponse{SagaUID: sagaId}, nil
+}
+
+type ControlHandler struct {
+	service       ControlService
+	authenticator Authenticator
+	logger        log.Logger
+}
+
+// NewControlHandler creates ControlHandler, every request must pass the authenticator
+func NewControlHandler(logger log.Logger, service ControlService, authenticator Authenticator) *ControlHandler {
+	return &ControlHandler{service: service, authenticator: authenticator, logger: logger}
+}
+
+// Start handles POST /sagas with StartRequest body
+func (h *ControlHandler) Start(resp http.ResponseWriter, r *http.Request) {
+	if !h.authenticate(resp, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(resp, r.Body, maxBodySize))
+
+	if err != nil {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Wrap(err, "reading request body")))
+		return
+	}
+
+	req := StartRequest{}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Wrap(err, "decoding request body")))
+		return
+	}
+
+	cmdResp, err := h.service.Start(r.Context(), req)
+
+	if err != nil {
+		h.writeError(resp, err)
+		return
+	}
+
+	h.writeResponse(resp, http.StatusAccepted, cmdResp)
+}
+
+// Execute handles POST /sagas/{id}/{action}, where action is recover, compensate or cancel. Cancel accepts compensate=true query param
+func (h *ControlHandler) Execute(resp http.ResponseWriter, r *http.Request) {
+	if !h.authenticate(resp, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/sagas/")
+	sep := strings.LastIndex(path, "/")
+
+	if sep <= 0 {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusNotFound, errors.Errorf("action is not specified in %s", r.URL.Path)))
+		return
+	}
+
+	var compensate bool
+
+	if v := r.URL.Query().Get("compensate"); v != "" {
+		var err error
+		if compensate, err = strconv.ParseBool(v); err != nil {
+			h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("compensate `%s` is not a boolean", v)))
+			return
+		}
+	}
+
+	cmdResp, err := h.service.Execute(r.Context(), path[:sep], path[sep+1:], compensate)
+
+	if err != nil {
+		h.writeError(resp, err)
+		return
+	}
+
+	h.writeResponse(resp, http.StatusAccepted, cmdResp)
+}
+
+// Bulk handles POST /sagas/bulk/{action}. Sagas are selected by GET /sagas filter params, limit is required.
+// It accepts compensate, dryRun and rate (commands per second) params. Returned cursor continues the operation when it isn't done
+func (h *ControlHandler) Bulk(resp http.ResponseWriter, r *http.Request) {
+	if !h.authenticate(resp, r) {
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, "/sagas/bulk/")
+	values := r.URL.Query()
+	filter, err := status.ParseFilterQuery(values)
+
+	if err != nil {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, err))
+		return
+	}
+
+	req := BulkRequest{Filter: filter}
+
+	for param, dest := range map[string]*bool{"compensate": &req.Compensate, "dryRun": &req.DryRun} {
+		if v := values.Get(param); v != "" {
+			if *dest, err = strconv.ParseBool(v); err != nil {
+				h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("%s `%s` is not a boolean", param, v)))
+				return
+			}
+		}
+	}
+
+	if v := values.Get("rate"); v != "" {
+		if req.Rate, err = strconv.ParseFloat(v, 64); err != nil || req.Rate < 0 {
+			h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("rate `%s` is not a positive number", v)))
+			return
+		}
+	}
+
+	res, err := h.service.Bulk(r.Context(), action, req)
+
+	if err != nil {
+		h.writeError(resp, err)
+		return
+	}
+
+	h.writeResponse(resp, http.StatusOK, res)
+}
+
+// Delete handles DELETE /sagas/{id}
+func (h *ControlHandler) Delete(resp http.ResponseWriter, r *http.Request) {
+	if !h.authenticate(resp, r) {
+		return
+	}
+
+	sagaId := strings.TrimPrefix(r.URL.Path, "/sagas/")
+
+	if sagaId == "" || strings.Contains(sagaId, "/") {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.New("Saga id is empty")))
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), sagaId); err != nil {
+		h.writeError(resp, err)
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ControlHandler) authenticate(resp http.ResponseWriter, r *http.Request) bool {
+	err := h.authenticator(r)
+
+	if err == nil {
+		return true
+	}
+
+	if _, ok := errors.Cause(err).(sagaApiErrors.ResponseError); !ok {
+		err = sagaApiErrors.NewResponseError(http.StatusUnauthorized, err)
+	}
+
+	h.writeError(resp, err)
+
+	return false
+}
+
+func (h *ControlHandler) writeError(resp http.ResponseWriter, err error) {
+	h.logger.Log(log.ErrorLevel, err)
+
+	if respErr, ok := errors.Cause(err).(sagaApiErrors.ResponseError); ok {
+		resp.WriteHeader(respErr.Status())
+	} else {
+		resp.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if _, err := resp.Write([]byte(err.Error())); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+	}
+}
+
+func (h *ControlHandler) writeResponse(resp http.ResponseWriter, status int, body interface{}) {
+	rawResponse, err := json.Marshal(body)
+
+	if err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+
+	if _, err := resp.Write(rawResponse); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+	}
+}
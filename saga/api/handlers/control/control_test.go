@@ -0,0 +1,270 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSaga struct {
+	saga.BaseSaga
+	Name string `json:"name"`
+}
+
+func (s *testSaga) Init()                                     {}
+func (s *testSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *testSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *testSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type endpointStub struct {
+	sent []*message.OutcomingMessage
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func TestControlHandler(t *testing.T) {
+	ctx := context.Background()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &testSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	type env struct {
+		store   saga.Store
+		stub    *endpointStub
+		handler *ControlHandler
+		request func(method, target, body string) *httptest.ResponseRecorder
+	}
+
+	newEnv := func(t *testing.T) env {
+		store := saga.NewMemoryStore(marshaller)
+		stub := &endpointStub{}
+		router := endpoint.NewRouter()
+		router.RegisterEndpoint(stub, &contracts.StartSagaCommand{}, &contracts.RecoverSagaCommand{}, &contracts.CompensateSagaCommand{}, &contracts.CancelSagaCommand{})
+		relay := outbox.NewRelay(store.Outbox(), router, log.NewNilLogger())
+		handler := NewControlHandler(log.NewNilLogger(), NewControlService(store, marshaller, bulk.NewExecutor(store, relay, log.NewNilLogger())), BearerTokenAuthenticator("secret"))
+
+		routes := map[string]http.HandlerFunc{
+			http.MethodPost + " /sagas":       handler.Start,
+			http.MethodPost + " /sagas/":      handler.Execute,
+			http.MethodDelete + " /sagas/":    handler.Delete,
+			http.MethodPost + " /sagas/bulk/": handler.Bulk,
+		}
+
+		return env{
+			store:   store,
+			stub:    stub,
+			handler: handler,
+			request: func(method, target, body string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, target, strings.NewReader(body))
+				r.Header.Set("Authorization", "Bearer secret")
+				recorder := httptest.NewRecorder()
+
+				route := method + " /sagas"
+				if strings.HasPrefix(target, "/sagas/bulk/") {
+					route += "/bulk/"
+				} else if strings.HasPrefix(target, "/sagas/") {
+					route += "/"
+				}
+
+				routes[route](recorder, r)
+				return recorder
+			},
+		}
+	}
+
+	createSaga := func(t *testing.T, store saga.Store, uid string, update func(instance saga.Instance)) {
+		sagaObj := &testSaga{}
+		sagaObj.SetGroupKind(&scheme.GroupKind{Group: "test", Kind: "testSaga"})
+		instance := saga.NewSagaInstance(uid, "", sagaObj)
+		require.NoError(t, store.Create(ctx, instance))
+
+		if update != nil {
+			update(instance)
+			require.NoError(t, store.Update(ctx, instance))
+		}
+	}
+
+	t.Run("start saga", func(t *testing.T) {
+		e := newEnv(t)
+
+		recorder := e.request(http.MethodPost, "/sagas", `{"saga_uid": "saga-uid", "saga": {"group": "test", "kind": "testSaga", "name": "first"}}`)
+		require.Equal(t, http.StatusAccepted, recorder.Code, recorder.Body.String())
+
+		resp := CommandResponse{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+		assert.Equal(t, "saga-uid", resp.SagaUID)
+
+		require.Len(t, e.stub.sent, 1)
+		cmd, ok := e.stub.sent[0].Payload().(*contracts.StartSagaCommand)
+		require.True(t, ok)
+		assert.Equal(t, "saga-uid", cmd.SagaUID)
+		assert.Equal(t, "first", cmd.Saga.(*testSaga).Name)
+	})
+
+	t.Run("start saga generates uid", func(t *testing.T) {
+		e := newEnv(t)
+
+		recorder := e.request(http.MethodPost, "/sagas", `{"saga": {"group": "test", "kind": "testSaga"}}`)
+		require.Equal(t, http.StatusAccepted, recorder.Code, recorder.Body.String())
+
+		resp := CommandResponse{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+		assert.NotEmpty(t, resp.SagaUID)
+	})
+
+	t.Run("invalid start requests", func(t *testing.T) {
+		e := newEnv(t)
+		createSaga(t, e.store, "existing", nil)
+
+		for body, code := range map[string]int{
+			`{`:                   http.StatusBadRequest,
+			`{"saga_uid": "uid"}`: http.StatusBadRequest,
+			`{"saga": {"group": "test", "kind": "unknown"}}`:                          http.StatusBadRequest,
+			`{"saga": {"group": "foreman.saga", "kind": "RecoverSagaCommand"}}`:       http.StatusBadRequest,
+			`{"saga_uid": "existing", "saga": {"group": "test", "kind": "testSaga"}}`: http.StatusConflict,
+		} {
+			recorder := e.request(http.MethodPost, "/sagas", body)
+			assert.Equal(t, code, recorder.Code, body)
+		}
+
+		assert.Empty(t, e.stub.sent)
+	})
+
+	t.Run("unauthenticated request", func(t *testing.T) {
+		e := newEnv(t)
+
+		for _, header := range []string{"", "Bearer wrong", "secret"} {
+			r := httptest.NewRequest(http.MethodPost, "/sagas", strings.NewReader(`{"saga": {"group": "test", "kind": "testSaga"}}`))
+			if header != "" {
+				r.Header.Set("Authorization", header)
+			}
+			recorder := httptest.NewRecorder()
+			e.handler.Start(recorder, r)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, header)
+		}
+
+		assert.Empty(t, e.stub.sent)
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		e := newEnv(t)
+		createSaga(t, e.store, "failed", func(instance saga.Instance) {
+			instance.Fail(&contracts.SagaFailedEvent{})
+		})
+		createSaga(t, e.store, "running", func(instance saga.Instance) {
+			instance.Progress()
+		})
+		createSaga(t, e.store, "completed", func(instance saga.Instance) {
+			instance.Complete()
+		})
+
+		for target, code := range map[string]int{
+			"/sagas/failed/recover":                    http.StatusAccepted,
+			"/sagas/failed/compensate":                 http.StatusAccepted,
+			"/sagas/running/cancel?compensate=true":    http.StatusAccepted,
+			"/sagas/running/recover":                   http.StatusConflict,
+			"/sagas/running/compensate":                http.StatusConflict,
+			"/sagas/completed/cancel":                  http.StatusConflict,
+			"/sagas/running/cancel?compensate=perhaps": http.StatusBadRequest,
+			"/sagas/running/restart":                   http.StatusNotFound,
+			"/sagas/unknown/recover":                   http.StatusNotFound,
+			"/sagas/running":                           http.StatusNotFound,
+		} {
+			recorder := e.request(http.MethodPost, target, "")
+			assert.Equal(t, code, recorder.Code, target)
+		}
+
+		require.Len(t, e.stub.sent, 3)
+
+		var cancelCmd *contracts.CancelSagaCommand
+		for _, msg := range e.stub.sent {
+			if cmd, ok := msg.Payload().(*contracts.CancelSagaCommand); ok {
+				cancelCmd = cmd
+			}
+		}
+
+		require.NotNil(t, cancelCmd)
+		assert.Equal(t, "running", cancelCmd.SagaUID)
+		assert.True(t, cancelCmd.Compensate)
+	})
+
+	t.Run("delete saga", func(t *testing.T) {
+		e := newEnv(t)
+		createSaga(t, e.store, "completed", func(instance saga.Instance) {
+			instance.Complete()
+		})
+		createSaga(t, e.store, "running", func(instance saga.Instance) {
+			instance.Progress()
+		})
+
+		assert.Equal(t, http.StatusNoContent, e.request(http.MethodDelete, "/sagas/completed", "").Code)
+		assert.Equal(t, http.StatusConflict, e.request(http.MethodDelete, "/sagas/running", "").Code)
+		assert.Equal(t, http.StatusNotFound, e.request(http.MethodDelete, "/sagas/completed", "").Code)
+
+		instance, err := e.store.GetById(ctx, "running")
+		require.NoError(t, err)
+		assert.NotNil(t, instance)
+	})
+
+	t.Run("bulk operation", func(t *testing.T) {
+		e := newEnv(t)
+		for _, uid := range []string{"failed-1", "failed-2", "failed-3"} {
+			createSaga(t, e.store, uid, func(instance saga.Instance) {
+				instance.Fail(&contracts.SagaFailedEvent{})
+			})
+		}
+
+		recorder := e.request(http.MethodPost, "/sagas/bulk/recover?status=failed&limit=2&dryRun=true", "")
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.Empty(t, e.stub.sent)
+
+		res := bulk.Result{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		assert.True(t, res.DryRun)
+		assert.False(t, res.Done)
+		assert.Equal(t, []string{"failed-1", "failed-2"}, res.SagaUIDs)
+
+		recorder = e.request(http.MethodPost, "/sagas/bulk/recover?status=failed&limit=10&cursor="+res.Cursor, "")
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+		res = bulk.Result{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		assert.True(t, res.Done)
+		assert.Equal(t, []string{"failed-3"}, res.SagaUIDs)
+		require.Len(t, e.stub.sent, 1)
+		assert.IsType(t, &contracts.RecoverSagaCommand{}, e.stub.sent[0].Payload())
+
+		for target, code := range map[string]int{
+			"/sagas/bulk/recover?status=failed":                       http.StatusBadRequest,
+			"/sagas/bulk/recover?limit=10":                            http.StatusBadRequest,
+			"/sagas/bulk/recover?status=failed&limit=10&rate=fast":    http.StatusBadRequest,
+			"/sagas/bulk/recover?status=failed&limit=10&cursor=wrong": http.StatusBadRequest,
+			"/sagas/bulk/restart?status=failed&limit=10":              http.StatusNotFound,
+		} {
+			assert.Equal(t, code, e.request(http.MethodPost, target, "").Code, target)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/saga"
+	sagaApiErrors "github.com/go-foreman/foreman/saga/api/errors"
+	sagaGraph "github.com/go-foreman/foreman/saga/graph"
+	"github.com/pkg/errors"
+)
+
+// DefinitionsPath is a prefix of saga definition graphs, GET /sagas/definitions/{sagaType}/graph
+const DefinitionsPath = "/sagas/definitions/"
+
+type GraphService interface {
+	// SagaGraph returns a path taken by the saga
+	SagaGraph(ctx context.Context, sagaId string) (*sagaGraph.Graph, error)
+	// DefinitionGraph returns a workflow of a registered saga type
+	DefinitionGraph(sagaType string) (*sagaGraph.Graph, error)
+}
+
+// NewGraphService creates a graph service, definitions are graphs of registered sagas by their type
+func NewGraphService(store saga.Store, definitions map[string]*sagaGraph.Graph) GraphService {
+	return &graphService{store: store, definitions: definitions}
+}
+
+type graphService struct {
+	store       saga.Store
+	definitions map[string]*sagaGraph.Graph
+}
+
+func (s graphService) SagaGraph(ctx context.Context, sagaId string) (*sagaGraph.Graph, error) {
+	instance, err := s.store.GetById(ctx, sagaId)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if instance == nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, errors.Errorf("Saga `%s` not found", sagaId))
+	}
+
+	return sagaGraph.Instance(instance), nil
+}
+
+func (s graphService) DefinitionGraph(sagaType string) (*sagaGraph.Graph, error) {
+	g, exists := s.definitions[sagaType]
+
+	if !exists {
+		return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, errors.Errorf("Saga type `%s` isn't registered", sagaType))
+	}
+
+	return g, nil
+}
+
+type GraphHandler struct {
+	service GraphService
+	logger  log.Logger
+}
+
+func NewGraphHandler(logger log.Logger, service GraphService) *GraphHandler {
+	return &GraphHandler{service: service, logger: logger}
+}
+
+// Get renders a graph of a saga, GET /sagas/{id}/graph, or of a saga definition, GET /sagas/definitions/{sagaType}/graph.
+// The format query param is mermaid (default) or dot
+func (h *GraphHandler) Get(resp http.ResponseWriter, r *http.Request) {
+	format := sagaGraph.Format(r.URL.Query().Get("format"))
+
+	if format == "" {
+		format = sagaGraph.Mermaid
+	}
+
+	var (
+		g   *sagaGraph.Graph
+		err error
+	)
+
+	if strings.HasPrefix(r.URL.Path, DefinitionsPath) {
+		sagaType := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, DefinitionsPath), "/graph")
+		g, err = h.service.DefinitionGraph(sagaType)
+	} else {
+		sagaId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sagas/"), "/graph")
+
+		if sagaId == "" {
+			h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.New("Saga id is empty")))
+			return
+		}
+
+		g, err = h.service.SagaGraph(r.Context(), sagaId)
+	}
+
+	if err != nil {
+		h.writeError(resp, err)
+		return
+	}
+
+	rendered, err := g.Render(format)
+
+	if err != nil {
+		h.writeError(resp, sagaApiErrors.NewResponseError(http.StatusBadRequest, err))
+		return
+	}
+
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if _, err := resp.Write([]byte(rendered)); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+	}
+}
+
+func (h *GraphHandler) writeError(resp http.ResponseWriter, err error) {
+	h.logger.Log(log.ErrorLevel, err)
+
+	if respErr, ok := errors.Cause(err).(sagaApiErrors.ResponseError); ok {
+		resp.WriteHeader(respErr.Status())
+	} else {
+		resp.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if _, err := resp.Write([]byte(err.Error())); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+	}
+}
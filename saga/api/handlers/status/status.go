@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-foreman/foreman/log"
 	"github.com/go-foreman/foreman/saga"
@@ -13,19 +16,60 @@ import (
 )
 
 type StatusResponse struct {
-	SagaUID string      `json:"saga_uid"`
-	Status  string      `json:"status"`
-	Payload interface{} `json:"payload"`
-	Events  []SagaEvent `json:"events"`
+	SagaUID   string      `json:"saga_uid"`
+	ParentUID string      `json:"parent_uid,omitempty"`
+	Status    string      `json:"status"`
+	State     string      `json:"state,omitempty"`
+	Payload   interface{} `json:"payload"`
+	Events    []SagaEvent `json:"events"`
 }
 
 type SagaEvent struct {
 	saga.HistoryEvent
 }
 
+// SagaTreeNode is a saga with its child sagas
+type SagaTreeNode struct {
+	SagaUID  string          `json:"saga_uid"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	State    string          `json:"state,omitempty"`
+	Children []*SagaTreeNode `json:"children"`
+}
+
 type StatusService interface {
 	GetStatus(ctx context.Context, sagaId string) (*StatusResponse, error)
-	GetFilteredBy(ctx context.Context, sagaId, status, sagaType string) ([]*StatusResponse, error)
+	// GetTree returns a saga with its descendants
+	GetTree(ctx context.Context, sagaId string) (*SagaTreeNode, error)
+	// GetFilteredBy returns a page of sagas and a cursor of the next page, the cursor is empty if there are no more sagas.
+	// All matching sagas are returned unless the query has a limit or a cursor
+	GetFilteredBy(ctx context.Context, query FilterQuery) ([]*StatusResponse, string, error)
+}
+
+const (
+	// maxTreeDepth limits how deep descendants of a saga are loaded
+	maxTreeDepth = 10
+	defaultLimit = 100
+	maxLimit     = 1000
+	// NextCursorHeader contains a cursor of the next page of /sagas response
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// FilterQuery specifies which sagas are returned by GetFilteredBy. Zero values aren't used for filtering
+type FilterQuery struct {
+	SagaUID        string
+	Status         string
+	SagaType       string
+	ParentUID      string
+	StartedFrom    time.Time
+	StartedTo      time.Time
+	UpdatedFrom    time.Time
+	UpdatedTo      time.Time
+	SortBy         saga.SortField
+	Order          saga.SortOrder
+	Cursor         string
+	Limit          int
+	WithoutHistory bool
 }
 
 func NewStatusService(store saga.Store) StatusService {
@@ -53,33 +97,112 @@ func (s statusService) GetStatus(ctx context.Context, sagaId string) (*StatusRes
 		events[i] = SagaEvent{ev}
 	}
 
-	return &StatusResponse{SagaUID: sagaId, Status: sagaInstance.Status().String(), Payload: sagaInstance.Saga(), Events: events}, nil
+	return &StatusResponse{SagaUID: sagaId, ParentUID: sagaInstance.ParentID(), Status: sagaInstance.Status().String(), State: sagaInstance.State(), Payload: sagaInstance.Saga(), Events: events}, nil
 }
 
-func (s statusService) GetFilteredBy(ctx context.Context, sagaId, status, sagaName string) ([]*StatusResponse, error) {
+func (s statusService) GetTree(ctx context.Context, sagaId string) (*SagaTreeNode, error) {
+	sagaInstance, err := s.sagaStore.GetById(ctx, sagaId)
 
-	var opts []saga.FilterOption
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading saga `%s`", sagaId)
+	}
+
+	if sagaInstance == nil {
+		return nil, sagaApiErrors.NewResponseError(http.StatusNotFound, errors.Errorf("Saga `%s` not found", sagaId))
+	}
+
+	root := newTreeNode(sagaInstance)
+
+	if err := s.loadChildren(ctx, root, 1); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return root, nil
+}
+
+func (s statusService) loadChildren(ctx context.Context, node *SagaTreeNode, depth int) error {
+	if depth > maxTreeDepth {
+		return nil
+	}
+
+	children, err := s.sagaStore.GetByFilter(ctx, saga.WithParentId(node.SagaUID), saga.WithoutHistory())
+
+	if err != nil {
+		return errors.Wrapf(err, "error loading children of saga `%s`", node.SagaUID)
+	}
+
+	for _, child := range children {
+		childNode := newTreeNode(child)
+
+		if err := s.loadChildren(ctx, childNode, depth+1); err != nil {
+			return errors.WithStack(err)
+		}
+
+		node.Children = append(node.Children, childNode)
+	}
 
-	if sagaId != "" {
-		opts = append(opts, saga.WithSagaId(sagaId))
+	return nil
+}
+
+func newTreeNode(instance saga.Instance) *SagaTreeNode {
+	return &SagaTreeNode{
+		SagaUID:  instance.UID(),
+		Name:     instance.Saga().GroupKind().String(),
+		Status:   instance.Status().String(),
+		State:    instance.State(),
+		Children: make([]*SagaTreeNode, 0),
+	}
+}
+
+func (s statusService) GetFilteredBy(ctx context.Context, query FilterQuery) ([]*StatusResponse, string, error) {
+	limit := query.Limit
+
+	if limit < 0 || limit > maxLimit {
+		return nil, "", sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.Errorf("Limit must be between 1 and %d", maxLimit))
+	}
+
+	opts := query.Conditions()
+
+	//sagas matching conditions are paged only when a caller asks for it. A next page of a cursor and a listing without conditions,
+	//which store refuses to return whole, have the default size
+	if limit == 0 && (query.Cursor != "" || len(opts) == 0) {
+		limit = defaultLimit
+	}
+
+	if limit > 0 {
+		opts = append(opts, saga.WithLimit(limit))
 	}
 
-	if status != "" {
-		opts = append(opts, saga.WithStatus(status))
+	if query.SortBy != "" || query.Order != "" {
+		sortBy, order := saga.SortByStartedAt, saga.SortAsc
+
+		if query.SortBy != "" {
+			sortBy = query.SortBy
+		}
+
+		if query.Order != "" {
+			order = query.Order
+		}
+
+		opts = append(opts, saga.WithSort(sortBy, order))
 	}
 
-	if sagaName != "" {
-		opts = append(opts, saga.WithSagaName(sagaName))
+	if query.Cursor != "" {
+		opts = append(opts, saga.WithCursor(query.Cursor))
 	}
 
-	if len(opts) == 0 {
-		return nil, sagaApiErrors.NewResponseError(http.StatusBadRequest, errors.New("No filters specified"))
+	if query.WithoutHistory {
+		opts = append(opts, saga.WithoutHistory())
 	}
 
 	sagas, err := s.sagaStore.GetByFilter(ctx, opts...)
 
 	if err != nil {
-		return nil, errors.WithStack(err)
+		if errors.Is(err, saga.ErrInvalidCursor) {
+			return nil, "", sagaApiErrors.NewResponseError(http.StatusBadRequest, err)
+		}
+
+		return nil, "", errors.WithStack(err)
 	}
 
 	resp := make([]*StatusResponse, len(sagas))
@@ -92,14 +215,54 @@ func (s statusService) GetFilteredBy(ctx context.Context, sagaId, status, sagaNa
 		}
 
 		resp[i] = &StatusResponse{
-			SagaUID: instance.UID(),
-			Status:  instance.Status().String(),
-			Payload: instance.Saga(),
-			Events:  events,
+			SagaUID:   instance.UID(),
+			ParentUID: instance.ParentID(),
+			Status:    instance.Status().String(),
+			State:     instance.State(),
+			Payload:   instance.Saga(),
+			Events:    events,
 		}
 	}
 
-	return resp, nil
+	var nextCursor string
+
+	//a full page means there could be more sagas
+	if limit > 0 && len(sagas) == limit {
+		nextCursor = saga.NextCursor(sagas[len(sagas)-1], opts...)
+	}
+
+	return resp, nextCursor, nil
+}
+
+// Conditions returns filter options which select sagas, without sorting, paging and history options
+func (q FilterQuery) Conditions() []saga.FilterOption {
+	var opts []saga.FilterOption
+
+	if q.SagaUID != "" {
+		opts = append(opts, saga.WithSagaId(q.SagaUID))
+	}
+
+	if q.Status != "" {
+		opts = append(opts, saga.WithStatus(q.Status))
+	}
+
+	if q.SagaType != "" {
+		opts = append(opts, saga.WithSagaName(q.SagaType))
+	}
+
+	if q.ParentUID != "" {
+		opts = append(opts, saga.WithParentId(q.ParentUID))
+	}
+
+	if !q.StartedFrom.IsZero() || !q.StartedTo.IsZero() {
+		opts = append(opts, saga.WithStartedBetween(q.StartedFrom, q.StartedTo))
+	}
+
+	if !q.UpdatedFrom.IsZero() || !q.UpdatedTo.IsZero() {
+		opts = append(opts, saga.WithUpdatedBetween(q.UpdatedFrom, q.UpdatedTo))
+	}
+
+	return opts
 }
 
 type StatusHandler struct {
@@ -112,8 +275,20 @@ func NewStatusHandler(logger log.Logger, service StatusService) *StatusHandler {
 }
 
 func (h *StatusHandler) GetStatus(resp http.ResponseWriter, r *http.Request) {
+	h.get(resp, r, "", func(ctx context.Context, sagaId string) (interface{}, error) {
+		return h.service.GetStatus(ctx, sagaId)
+	})
+}
+
+// GetTree handles GET /sagas/{id}/tree, it returns the saga with its descendants
+func (h *StatusHandler) GetTree(resp http.ResponseWriter, r *http.Request) {
+	h.get(resp, r, "/tree", func(ctx context.Context, sagaId string) (interface{}, error) {
+		return h.service.GetTree(ctx, sagaId)
+	})
+}
 
-	sagaId := strings.TrimPrefix(r.URL.Path, "/sagas/")
+func (h *StatusHandler) get(resp http.ResponseWriter, r *http.Request, suffix string, load func(ctx context.Context, sagaId string) (interface{}, error)) {
+	sagaId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sagas/"), suffix)
 
 	if sagaId == "" {
 		resp.WriteHeader(http.StatusBadRequest)
@@ -125,7 +300,7 @@ func (h *StatusHandler) GetStatus(resp http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	statusResp, err := h.service.GetStatus(r.Context(), sagaId)
+	statusResp, err := load(r.Context(), sagaId)
 
 	if err != nil {
 		h.logger.Log(log.ErrorLevel, err)
@@ -157,13 +332,23 @@ func (h *StatusHandler) GetStatus(resp http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetFilteredBy lists sagas. Query params: sagaId, status, sagaType, parentId, startedFrom, startedTo, updatedFrom, updatedTo (RFC3339),
+// sortBy (started_at, updated_at), order (asc, desc), limit, cursor and withoutHistory. All matching sagas are returned unless limit or cursor is set,
+// a cursor of the next page is returned in X-Next-Cursor header
 func (h *StatusHandler) GetFilteredBy(resp http.ResponseWriter, r *http.Request) {
+	query, err := ParseFilterQuery(r.URL.Query())
+
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+
+		if _, err := resp.Write([]byte(err.Error())); err != nil {
+			h.logger.Log(log.ErrorLevel, err)
+		}
 
-	sagaId := r.URL.Query().Get("sagaId")
-	status := r.URL.Query().Get("status")
-	sagaType := r.URL.Query().Get("sagaType")
+		return
+	}
 
-	statusesResp, err := h.service.GetFilteredBy(r.Context(), sagaId, status, sagaType)
+	statusesResp, nextCursor, err := h.service.GetFilteredBy(r.Context(), query)
 
 	if err != nil {
 		h.logger.Log(log.ErrorLevel, err)
@@ -190,7 +375,121 @@ func (h *StatusHandler) GetFilteredBy(resp http.ResponseWriter, r *http.Request)
 
 	resp.Header().Set("Content-Type", "application/json")
 
+	if nextCursor != "" {
+		resp.Header().Set(NextCursorHeader, nextCursor)
+	}
+
 	if _, err := resp.Write(rawResponse); err != nil {
 		h.logger.Log(log.ErrorLevel, err)
 	}
 }
+
+// Values encodes the query into GET /sagas params, ParseFilterQuery decodes them back
+func (q FilterQuery) Values() url.Values {
+	values := url.Values{}
+
+	strs := map[string]string{
+		"sagaId":   q.SagaUID,
+		"status":   q.Status,
+		"sagaType": q.SagaType,
+		"parentId": q.ParentUID,
+		"sortBy":   string(q.SortBy),
+		"order":    string(q.Order),
+		"cursor":   q.Cursor,
+	}
+
+	for param, v := range strs {
+		if v != "" {
+			values.Set(param, v)
+		}
+	}
+
+	times := map[string]time.Time{
+		"startedFrom": q.StartedFrom,
+		"startedTo":   q.StartedTo,
+		"updatedFrom": q.UpdatedFrom,
+		"updatedTo":   q.UpdatedTo,
+	}
+
+	for param, t := range times {
+		if !t.IsZero() {
+			values.Set(param, t.Format(time.RFC3339))
+		}
+	}
+
+	if q.Limit != 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	if q.WithoutHistory {
+		values.Set("withoutHistory", "true")
+	}
+
+	return values
+}
+
+// ParseFilterQuery parses query params of GET /sagas into FilterQuery
+func ParseFilterQuery(values url.Values) (FilterQuery, error) {
+	query := FilterQuery{
+		SagaUID:   values.Get("sagaId"),
+		Status:    values.Get("status"),
+		SagaType:  values.Get("sagaType"),
+		ParentUID: values.Get("parentId"),
+		SortBy:    saga.SortField(values.Get("sortBy")),
+		Order:     saga.SortOrder(values.Get("order")),
+		Cursor:    values.Get("cursor"),
+	}
+
+	if query.SortBy != "" && query.SortBy != saga.SortByStartedAt && query.SortBy != saga.SortByUpdatedAt {
+		return query, errors.Errorf("sortBy must be one of %s, %s", saga.SortByStartedAt, saga.SortByUpdatedAt)
+	}
+
+	if query.Order != "" && query.Order != saga.SortAsc && query.Order != saga.SortDesc {
+		return query, errors.Errorf("order must be one of %s, %s", saga.SortAsc, saga.SortDesc)
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+
+		if err != nil {
+			return query, errors.Errorf("limit `%s` is not a number", v)
+		}
+
+		query.Limit = limit
+	}
+
+	if v := values.Get("withoutHistory"); v != "" {
+		withoutHistory, err := strconv.ParseBool(v)
+
+		if err != nil {
+			return query, errors.Errorf("withoutHistory `%s` is not a boolean", v)
+		}
+
+		query.WithoutHistory = withoutHistory
+	}
+
+	times := map[string]*time.Time{
+		"startedFrom": &query.StartedFrom,
+		"startedTo":   &query.StartedTo,
+		"updatedFrom": &query.UpdatedFrom,
+		"updatedTo":   &query.UpdatedTo,
+	}
+
+	for param, dest := range times {
+		v := values.Get(param)
+
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+
+		if err != nil {
+			return query, errors.Errorf("%s `%s` is not a RFC3339 time", param, v)
+		}
+
+		*dest = t
+	}
+
+	return query, nil
+}
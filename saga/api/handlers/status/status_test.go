@@ -0,0 +1,186 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSaga struct {
+	saga.BaseSaga
+}
+
+func (s *testSaga) Init()                                     {}
+func (s *testSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *testSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *testSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type pagedStore struct {
+	saga.Store
+	instances []saga.Instance
+	err       error
+}
+
+func (s *pagedStore) GetByFilter(ctx context.Context, filters ...saga.FilterOption) ([]saga.Instance, error) {
+	return s.instances, s.err
+}
+
+func TestStatusHandler_GetFilteredBy(t *testing.T) {
+	newInstances := func(count int) []saga.Instance {
+		res := make([]saga.Instance, count)
+		for i := range res {
+			res[i] = saga.NewSagaInstance("uid", "", &testSaga{})
+		}
+		return res
+	}
+
+	request := func(store saga.Store, query string) *httptest.ResponseRecorder {
+		handler := NewStatusHandler(log.NewNilLogger(), NewStatusService(store))
+		recorder := httptest.NewRecorder()
+		handler.GetFilteredBy(recorder, httptest.NewRequest(http.MethodGet, "/sagas?"+query, nil))
+		return recorder
+	}
+
+	t.Run("without filters", func(t *testing.T) {
+		ctx := context.Background()
+		schemeRegistry := scheme.NewKnownTypesRegistry()
+		schemeRegistry.AddKnownTypes("test", &testSaga{})
+		store := saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+
+		for i := 0; i < defaultLimit+1; i++ {
+			require.NoError(t, store.Create(ctx, saga.NewSagaInstance(fmt.Sprintf("uid-%d", i), "", &testSaga{})))
+		}
+
+		for _, query := range []string{"", "sortBy=updated_at&order=desc", "withoutHistory=true"} {
+			recorder := request(store, query)
+			require.Equal(t, http.StatusOK, recorder.Code, query)
+			assert.NotEmpty(t, recorder.Header().Get(NextCursorHeader), query)
+
+			var resp []*StatusResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+			assert.Len(t, resp, defaultLimit, query)
+		}
+	})
+
+	t.Run("conditions without limit return all sagas", func(t *testing.T) {
+		recorder := request(&pagedStore{instances: newInstances(defaultLimit + 1)}, "status=failed")
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get(NextCursorHeader))
+
+		var resp []*StatusResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+		assert.Len(t, resp, defaultLimit+1)
+	})
+
+	t.Run("cursor without limit has default page size", func(t *testing.T) {
+		recorder := request(&pagedStore{instances: newInstances(defaultLimit)}, "cursor=xxx")
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.NotEmpty(t, recorder.Header().Get(NextCursorHeader))
+	})
+
+	t.Run("full page has next cursor", func(t *testing.T) {
+		recorder := request(&pagedStore{instances: newInstances(2)}, "limit=2&sortBy=updated_at&order=desc")
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.NotEmpty(t, recorder.Header().Get(NextCursorHeader))
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		for _, query := range []string{"limit=x", "limit=1001", "sortBy=name", "order=up", "startedFrom=yesterday", "withoutHistory=maybe"} {
+			recorder := request(&pagedStore{}, query)
+			assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		recorder := request(&pagedStore{err: errors.Wrap(saga.ErrInvalidCursor, "broken")}, "cursor=xxx")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
+
+func TestStatusHandler_GetTree(t *testing.T) {
+	ctx := context.Background()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &testSaga{})
+	store := saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+
+	for _, instance := range []saga.Instance{
+		saga.NewSagaInstance("root", "", &testSaga{}),
+		saga.NewSagaInstance("child", "root", &testSaga{}),
+		saga.NewSagaInstance("grandchild", "child", &testSaga{}),
+		saga.NewSagaInstance("another", "", &testSaga{}),
+	} {
+		require.NoError(t, store.Create(ctx, instance))
+	}
+
+	handler := NewStatusHandler(log.NewNilLogger(), NewStatusService(store))
+
+	t.Run("saga with descendants", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.GetTree(recorder, httptest.NewRequest(http.MethodGet, "/sagas/root/tree", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		tree := &SagaTreeNode{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), tree))
+
+		assert.Equal(t, "root", tree.SagaUID)
+		require.Len(t, tree.Children, 1)
+		assert.Equal(t, "child", tree.Children[0].SagaUID)
+		require.Len(t, tree.Children[0].Children, 1)
+		assert.Equal(t, "grandchild", tree.Children[0].Children[0].SagaUID)
+		assert.Empty(t, tree.Children[0].Children[0].Children)
+	})
+
+	t.Run("status has parent", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.GetStatus(recorder, httptest.NewRequest(http.MethodGet, "/sagas/child", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		statusResp := &StatusResponse{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), statusResp))
+		assert.Equal(t, "root", statusResp.ParentUID)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.GetTree(recorder, httptest.NewRequest(http.MethodGet, "/sagas/unknown/tree", nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
+func TestFilterQuery_Values(t *testing.T) {
+	at := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	query := FilterQuery{
+		SagaUID:        "uid",
+		Status:         "failed",
+		SagaType:       "orders.OrderSaga",
+		ParentUID:      "parent",
+		StartedFrom:    at,
+		StartedTo:      at.Add(time.Hour),
+		UpdatedFrom:    at.Add(2 * time.Hour),
+		UpdatedTo:      at.Add(3 * time.Hour),
+		SortBy:         saga.SortByUpdatedAt,
+		Order:          saga.SortDesc,
+		Cursor:         "cursor",
+		Limit:          10,
+		WithoutHistory: true,
+	}
+
+	parsed, err := ParseFilterQuery(query.Values())
+	require.NoError(t, err)
+	assert.Equal(t, query, parsed)
+
+	assert.Empty(t, FilterQuery{}.Values())
+}
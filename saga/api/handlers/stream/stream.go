@@ -0,0 +1,178 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/watch"
+)
+
+const (
+	// SnapshotEvent is the first event of a single saga stream, it contains current state of the saga
+	SnapshotEvent = "snapshot"
+	// ChangeEvent contains a saga status and history events added by its update
+	ChangeEvent = "change"
+
+	defaultHeartbeat = 15 * time.Second
+	defaultBuffer    = 64
+)
+
+type Option func(h *StreamHandler)
+
+// WithHeartbeat specifies how often a comment is sent to keep an idle connection open, it's 15s by default
+func WithHeartbeat(interval time.Duration) Option {
+	return func(h *StreamHandler) {
+		h.heartbeat = interval
+	}
+}
+
+// WithBuffer specifies how many changes can wait to be sent to a client. A client which falls behind is disconnected and has to reconnect
+func WithBuffer(size int) Option {
+	return func(h *StreamHandler) {
+		h.buffer = size
+	}
+}
+
+type StreamHandler struct {
+	store     saga.Store
+	hub       *watch.Hub
+	logger    log.Logger
+	heartbeat time.Duration
+	buffer    int
+}
+
+func NewStreamHandler(logger log.Logger, store saga.Store, hub *watch.Hub, opts ...Option) *StreamHandler {
+	h := &StreamHandler{store: store, hub: hub, logger: logger, heartbeat: defaultHeartbeat, buffer: defaultBuffer}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// Stream sends saga changes as Server-Sent Events.
+// GET /sagas/{id}/stream streams one saga starting with its snapshot, GET /sagas/stream streams sagas matching sagaId, status, sagaType and parentId params
+func (h *StreamHandler) Stream(resp http.ResponseWriter, r *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+
+	if !ok {
+		h.writeError(resp, http.StatusInternalServerError, "streaming isn't supported by the server")
+		return
+	}
+
+	var (
+		filter   watch.Filter
+		snapshot *watch.Change
+		single   = r.URL.Path != "/sagas/stream"
+	)
+
+	if single {
+		filter.SagaUID = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sagas/"), "/stream")
+
+		if !strings.HasSuffix(r.URL.Path, "/stream") || filter.SagaUID == "" || strings.Contains(filter.SagaUID, "/") {
+			h.writeError(resp, http.StatusBadRequest, "Saga id is empty")
+			return
+		}
+	} else {
+		values := r.URL.Query()
+		filter = watch.Filter{
+			SagaUID:   values.Get("sagaId"),
+			ParentUID: values.Get("parentId"),
+			SagaName:  values.Get("sagaType"),
+			Status:    values.Get("status"),
+		}
+	}
+
+	//subscription starts before the snapshot is loaded, so no change is missed in between
+	sub := h.hub.Subscribe(filter, h.buffer)
+	defer sub.Close()
+
+	if single {
+		instance, err := h.store.GetById(r.Context(), filter.SagaUID)
+
+		if err != nil {
+			h.logger.Log(log.ErrorLevel, err)
+			h.writeError(resp, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if instance == nil {
+			h.writeError(resp, http.StatusNotFound, fmt.Sprintf("Saga `%s` not found", filter.SagaUID))
+			return
+		}
+
+		change := watch.Snapshot(instance)
+		snapshot = &change
+	}
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+
+	if snapshot != nil && !h.send(resp, SnapshotEvent, *snapshot) {
+		return
+	}
+
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(resp, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case change, ok := <-sub.C:
+			//the client fell behind, it reconnects and gets a fresh snapshot
+			if !ok {
+				return
+			}
+
+			//the change is already reflected by the snapshot
+			if snapshot != nil && change.Version <= snapshot.Version {
+				continue
+			}
+
+			if !h.send(resp, ChangeEvent, change) {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func (h *StreamHandler) send(resp http.ResponseWriter, event string, change watch.Change) bool {
+	data, err := json.Marshal(change)
+
+	if err != nil {
+		h.logger.Logf(log.ErrorLevel, "error marshaling change of saga %s. %s", change.SagaUID, err)
+		return false
+	}
+
+	if _, err := fmt.Fprintf(resp, "id: %s/%d\nevent: %s\ndata: %s\n\n", change.SagaUID, change.Version, event, data); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+		return false
+	}
+
+	return true
+}
+
+func (h *StreamHandler) writeError(resp http.ResponseWriter, status int, msg string) {
+	resp.WriteHeader(status)
+
+	if _, err := resp.Write([]byte(msg)); err != nil {
+		h.logger.Log(log.ErrorLevel, err)
+	}
+}
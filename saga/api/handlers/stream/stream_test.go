@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSaga struct {
+	saga.BaseSaga
+}
+
+func (s *testSaga) Init()                                     {}
+func (s *testSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *testSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *testSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type sse struct {
+	id    string
+	event string
+	data  watch.Change
+}
+
+// readEvent reads the next event skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) sse {
+	res := sse{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && res.event != "":
+			return res
+		case strings.HasPrefix(line, "id: "):
+			res.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			res.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res.data))
+		}
+	}
+}
+
+func TestStreamHandler_Stream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &testSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	store := saga.NewMemoryStore(marshaller)
+	hub := watch.NewHub(marshaller, log.NewNilLogger())
+	store.(saga.Observable).OnUpdate(hub.OnUpdate)
+
+	handler := NewStreamHandler(log.NewNilLogger(), store, hub, WithHeartbeat(time.Millisecond*10))
+	server := httptest.NewServer(http.HandlerFunc(handler.Stream))
+	defer server.Close()
+
+	sagaObj := &testSaga{}
+	sagaObj.SetGroupKind(&scheme.GroupKind{Group: "test", Kind: "testSaga"})
+	instance := saga.NewSagaInstance("1", "parent", sagaObj)
+	require.NoError(t, store.Create(ctx, instance))
+
+	open := func(t *testing.T, path string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			resp.Body.Close()
+		})
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	t.Run("saga stream starts with snapshot", func(t *testing.T) {
+		resp, reader := open(t, "/sagas/1/stream")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		snapshot := readEvent(t, reader)
+		assert.Equal(t, SnapshotEvent, snapshot.event)
+		assert.Equal(t, "1/0", snapshot.id)
+		assert.Equal(t, "created", snapshot.data.Status)
+		assert.Equal(t, "parent", snapshot.data.ParentUID)
+
+		//a change published before the snapshot was loaded is skipped
+		hub.Publish(watch.Change{SagaUID: "1", Version: 0})
+
+		instance.Fail(nil)
+		instance.AddHistoryEvent(&contracts.StateTransitionedEvent{From: "a", To: "b", Event: "ev"})
+		require.NoError(t, store.Update(ctx, instance))
+
+		change := readEvent(t, reader)
+		assert.Equal(t, ChangeEvent, change.event)
+		assert.Equal(t, "1/1", change.id)
+		assert.Equal(t, "failed", change.data.Status)
+		require.Len(t, change.data.Events, 1)
+		assert.Equal(t, "systemSaga.StateTransitionedEvent", change.data.Events[0].Name)
+	})
+
+	t.Run("filtered stream", func(t *testing.T) {
+		resp, reader := open(t, "/sagas/stream?parentId=parent&status=in_progress")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		hub.Publish(watch.Change{SagaUID: "2", ParentUID: "parent", Status: "failed", Version: 1})
+		hub.Publish(watch.Change{SagaUID: "3", ParentUID: "other", Status: "in_progress", Version: 1})
+		hub.Publish(watch.Change{SagaUID: "4", ParentUID: "parent", Status: "in_progress", Version: 3})
+
+		change := readEvent(t, reader)
+		assert.Equal(t, ChangeEvent, change.event)
+		assert.Equal(t, "4/3", change.id)
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		_, reader := open(t, "/sagas/stream?sagaId=5")
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, ": heartbeat\n", line)
+	})
+
+	t.Run("unknown saga", func(t *testing.T) {
+		resp, _ := open(t, "/sagas/unknown/stream")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("empty saga id", func(t *testing.T) {
+		resp, _ := open(t, "/sagas//stream")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpecPath is a path the OpenAPI document is served on by saga api server
+const OpenAPISpecPath = "/sagas/openapi.json"
+
+// OpenAPISpec is an OpenAPI 3 document of saga api
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// OpenAPIHandler serves OpenAPISpec
+func OpenAPIHandler(resp http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	_, _ = resp.Write(OpenAPISpec)
+}
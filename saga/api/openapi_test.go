@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/api/handlers/control"
+	"github.com/go-foreman/foreman/saga/api/handlers/status"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spec struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]parameter `json:"parameters"`
+		Schemas    map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+type parameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+func loadSpec(t *testing.T) spec {
+	s := spec{}
+	require.NoError(t, json.Unmarshal(OpenAPISpec, &s))
+	return s
+}
+
+// queryParams returns names of query params of an operation
+func (s spec) queryParams(t *testing.T, path, method string) []string {
+	operation := struct {
+		Parameters []parameter `json:"parameters"`
+	}{}
+	require.NoError(t, json.Unmarshal(s.Paths[path][method], &operation))
+
+	var res []string
+
+	for _, param := range operation.Parameters {
+		if param.Ref != "" {
+			var ok bool
+			param, ok = s.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			require.True(t, ok, param.Ref)
+		}
+
+		if param.In == "query" {
+			res = append(res, param.Name)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// jsonFields returns json names of struct fields including fields of embedded structs
+func jsonFields(typ reflect.Type) []string {
+	var res []string
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+
+		if field.Anonymous && tag == "" {
+			res = append(res, jsonFields(field.Type)...)
+			continue
+		}
+
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			res = append(res, name)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	s := loadSpec(t)
+	assert.True(t, strings.HasPrefix(s.OpenAPI, "3."))
+
+	t.Run("schemas match response types", func(t *testing.T) {
+		for schema, typ := range map[string]reflect.Type{
+			"SagaStatus":      reflect.TypeOf(status.StatusResponse{}),
+			"HistoryEvent":    reflect.TypeOf(saga.HistoryEvent{}),
+			"SagaTreeNode":    reflect.TypeOf(status.SagaTreeNode{}),
+			"StartRequest":    reflect.TypeOf(control.StartRequest{}),
+			"CommandResponse": reflect.TypeOf(control.CommandResponse{}),
+			"BulkResult":      reflect.TypeOf(bulk.Result{}),
+			"SagaChange":      reflect.TypeOf(watch.Change{}),
+			"SagaChangeEvent": reflect.TypeOf(watch.Event{}),
+		} {
+			var properties []string
+			for name := range s.Components.Schemas[schema].Properties {
+				properties = append(properties, name)
+			}
+			sort.Strings(properties)
+
+			assert.Equal(t, jsonFields(typ), properties, schema)
+		}
+	})
+
+	t.Run("filter params match FilterQuery", func(t *testing.T) {
+		at := time.Now()
+		query := status.FilterQuery{
+			SagaUID:        "uid",
+			Status:         "failed",
+			SagaType:       "orders.OrderSaga",
+			ParentUID:      "parent",
+			StartedFrom:    at,
+			StartedTo:      at,
+			UpdatedFrom:    at,
+			UpdatedTo:      at,
+			SortBy:         saga.SortByUpdatedAt,
+			Order:          saga.SortDesc,
+			Cursor:         "cursor",
+			Limit:          1,
+			WithoutHistory: true,
+		}
+
+		var params []string
+		for param := range query.Values() {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+
+		assert.Equal(t, params, s.queryParams(t, "/sagas", "get"))
+
+		//bulk operation doesn't sort and doesn't return history
+		bulkParams := []string{"compensate", "dryRun", "rate"}
+		for _, param := range params {
+			if param != "sortBy" && param != "order" && param != "withoutHistory" {
+				bulkParams = append(bulkParams, param)
+			}
+		}
+		sort.Strings(bulkParams)
+
+		assert.Equal(t, bulkParams, s.queryParams(t, "/sagas/bulk/{action}", "post"))
+	})
+
+	t.Run("served as json", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		OpenAPIHandler(recorder, httptest.NewRequest(http.MethodGet, OpenAPISpecPath, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.JSONEq(t, string(OpenAPISpec), recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		OpenAPIHandler(recorder, httptest.NewRequest(http.MethodPost, OpenAPISpecPath, nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
@@ -0,0 +1,270 @@
+package bulk
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+)
+
+// Action is an operation applied to every saga matching filters
+type Action string
+
+const (
+	Recover    Action = "recover"
+	Compensate Action = "compensate"
+	Cancel     Action = "cancel"
+
+	defaultBatchSize = 100
+)
+
+// ErrUnknownAction is returned for an action which isn't Recover, Compensate or Cancel
+var ErrUnknownAction = errors.New("unknown action")
+
+// Command returns a command which applies the action to a saga. It's nil if the saga can't be affected by the action in its current status
+func Command(action Action, instance saga.Instance, compensate bool) (message.Object, error) {
+	status := instance.Status()
+
+	switch action {
+	case Recover:
+		if status.Failed() {
+			return &contracts.RecoverSagaCommand{SagaUID: instance.UID()}, nil
+		}
+	case Compensate:
+		if status.Failed() {
+			return &contracts.CompensateSagaCommand{SagaUID: instance.UID()}, nil
+		}
+	case Cancel:
+		if !saga.Finished(status) && !(compensate && status.Compensating()) {
+			return &contracts.CancelSagaCommand{SagaUID: instance.UID(), Compensate: compensate}, nil
+		}
+	default:
+		return nil, errors.Wrapf(ErrUnknownAction, "`%s`", action)
+	}
+
+	return nil, nil
+}
+
+// Progress of a bulk operation
+type Progress struct {
+	// Matched is an amount of sagas matching filters which were looked at
+	Matched int `json:"matched"`
+	// Processed is an amount of sagas a command was sent to, or would be sent in dry run
+	Processed int `json:"processed"`
+	// Skipped is an amount of sagas which can't be affected by the action in their current status
+	Skipped int `json:"skipped"`
+	// Cursor points after the last saga looked at, an operation started with it doesn't process the same sagas again
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Result of a bulk operation
+type Result struct {
+	Progress
+	// SagaUIDs are sagas a command was sent to, or would be sent in dry run
+	SagaUIDs []string `json:"saga_uids"`
+	// Done is false if the operation stopped on limit and there could be more sagas, use Cursor to continue
+	Done   bool `json:"done"`
+	DryRun bool `json:"dry_run"`
+}
+
+type Option func(o *options)
+
+type options struct {
+	compensate bool
+	dryRun     bool
+	rate       float64
+	cursor     string
+	limit      int
+	batchSize  int
+	progress   func(Progress)
+}
+
+// WithCompensation compensates cancelled sagas, it's used only by Cancel action
+func WithCompensation() Option {
+	return func(o *options) {
+		o.compensate = true
+	}
+}
+
+// WithDryRun only previews sagas which would be affected, no commands are sent
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// WithRate limits amount of commands sent per second
+func WithRate(perSecond float64) Option {
+	return func(o *options) {
+		o.rate = perSecond
+	}
+}
+
+// WithCursor continues an operation after the saga the cursor was reported for
+func WithCursor(cursor string) Option {
+	return func(o *options) {
+		o.cursor = cursor
+	}
+}
+
+// WithLimit stops an operation after the amount of matched sagas
+func WithLimit(limit int) Option {
+	return func(o *options) {
+		o.limit = limit
+	}
+}
+
+// WithBatchSize specifies how many sagas are loaded from store at once, it's 100 by default
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithProgress reports progress after each saga. Persist the cursor to resume the operation after a restart
+func WithProgress(progress func(Progress)) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
+
+// Executor applies an action to sagas matching filters
+type Executor struct {
+	store  saga.Store
+	relay  *outbox.Relay
+	logger log.Logger
+}
+
+// NewExecutor creates Executor. Commands are written into store's outbox and dispatched by the relay, sagas handle them asynchronously
+func NewExecutor(store saga.Store, relay *outbox.Relay, logger log.Logger) *Executor {
+	return &Executor{store: store, relay: relay, logger: logger}
+}
+
+// Run sends a command of the action to every saga matching filters. Sagas are processed in order they were started, sorting and paging filters are overridden
+func (e *Executor) Run(ctx context.Context, action Action, filters []saga.FilterOption, opts ...Option) (*Result, error) {
+	o := &options{batchSize: defaultBatchSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.batchSize <= 0 || o.limit < 0 || o.rate < 0 {
+		return nil, errors.Errorf("batch size %d must be positive, limit %d and rate %f can't be negative", o.batchSize, o.limit, o.rate)
+	}
+
+	if action != Recover && action != Compensate && action != Cancel {
+		return nil, errors.Wrapf(ErrUnknownAction, "`%s`", action)
+	}
+
+	var wait func() error
+
+	if o.rate > 0 && !o.dryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / o.rate))
+		defer ticker.Stop()
+
+		sent := false
+		wait = func() error {
+			//the first command doesn't wait
+			if !sent {
+				sent = true
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				return nil
+			}
+		}
+	}
+
+	//started_at of a saga doesn't change, so a cursor stays valid while commands change statuses of sagas
+	sorting := saga.WithSort(saga.SortByStartedAt, saga.SortAsc)
+	res := &Result{Progress: Progress{Cursor: o.cursor}, SagaUIDs: make([]string, 0), DryRun: o.dryRun}
+
+	for {
+		batchSize := o.batchSize
+		if o.limit > 0 && o.limit-res.Matched < batchSize {
+			batchSize = o.limit - res.Matched
+		}
+
+		if batchSize == 0 {
+			return res, nil
+		}
+
+		page := make([]saga.FilterOption, 0, len(filters)+4)
+		page = append(page, filters...)
+		page = append(page, sorting, saga.WithLimit(batchSize), saga.WithoutHistory())
+
+		if res.Cursor != "" {
+			page = append(page, saga.WithCursor(res.Cursor))
+		}
+
+		instances, err := e.store.GetByFilter(ctx, page...)
+
+		if err != nil {
+			return res, errors.Wrap(err, "loading sagas")
+		}
+
+		for _, instance := range instances {
+			cmd, err := Command(action, instance, o.compensate)
+
+			if err != nil {
+				return res, errors.WithStack(err)
+			}
+
+			if cmd != nil && !o.dryRun {
+				if wait != nil {
+					if err := wait(); err != nil {
+						return res, errors.WithStack(err)
+					}
+				}
+
+				if err := e.Send(ctx, cmd); err != nil {
+					return res, errors.Wrapf(err, "sending %s to saga `%s`", action, instance.UID())
+				}
+			}
+
+			res.Matched++
+
+			if cmd != nil {
+				res.Processed++
+				res.SagaUIDs = append(res.SagaUIDs, instance.UID())
+			} else {
+				res.Skipped++
+			}
+
+			res.Cursor = saga.NextCursor(instance, sorting)
+
+			if o.progress != nil {
+				o.progress(res.Progress)
+			}
+		}
+
+		if len(instances) < batchSize {
+			res.Done = true
+			return res, nil
+		}
+	}
+}
+
+// Send writes a command into store's outbox and dispatches it. The command is accepted once it's in the outbox,
+// if it isn't sent right away the relay running in background of the message bus sends it later, so an error of dispatching is only logged
+func (e *Executor) Send(ctx context.Context, cmd message.Object) error {
+	msg := message.NewOutcomingMessage(cmd)
+
+	if err := e.store.Outbox().Add(ctx, nil, msg); err != nil {
+		return errors.Wrap(err, "writing command into outbox")
+	}
+
+	if _, err := e.relay.Dispatch(ctx, msg.UID()); err != nil {
+		e.logger.Logf(log.ErrorLevel, "error dispatching command %s, it will be retried by outbox relay. %s", msg.UID(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSaga struct {
+	saga.BaseSaga
+}
+
+func (s *testSaga) Init()                                     {}
+func (s *testSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *testSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *testSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type endpointStub struct {
+	sent []*message.OutcomingMessage
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func (e *endpointStub) sagaUIDs() []string {
+	res := make([]string, 0, len(e.sent))
+	for _, msg := range e.sent {
+		switch cmd := msg.Payload().(type) {
+		case *contracts.RecoverSagaCommand:
+			res = append(res, cmd.SagaUID)
+		case *contracts.CompensateSagaCommand:
+			res = append(res, cmd.SagaUID)
+		case *contracts.CancelSagaCommand:
+			res = append(res, cmd.SagaUID)
+		}
+	}
+	return res
+}
+
+// unavailableOutbox accepts messages, but they can't be claimed for dispatching
+type unavailableOutbox struct {
+	outbox.Store
+}
+
+func (o unavailableOutbox) Claim(ctx context.Context, limit int, uids ...string) (outbox.Claim, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestExecutor_Send(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	contracts.RegisterSagaContracts(schemeRegistry)
+	store := saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+
+	stub := &endpointStub{}
+	router := endpoint.NewRouter()
+	router.RegisterEndpoint(stub, &contracts.RecoverSagaCommand{})
+
+	executor := NewExecutor(store, outbox.NewRelay(unavailableOutbox{store.Outbox()}, router, log.NewNilLogger()), log.NewNilLogger())
+
+	//the command is in outbox, so it's accepted even though it isn't sent right away
+	require.NoError(t, executor.Send(ctx, &contracts.RecoverSagaCommand{SagaUID: "saga-uid"}))
+	assert.Empty(t, stub.sent)
+
+	dispatched, err := outbox.NewRelay(store.Outbox(), router, log.NewNilLogger()).Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+	assert.Equal(t, []string{"saga-uid"}, stub.sagaUIDs())
+}
+
+func TestExecutor_Run(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &testSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	//sagas aren't started, so they are sorted by uid
+	newEnv := func(t *testing.T) (*Executor, *endpointStub) {
+		store := saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+		stub := &endpointStub{}
+		router := endpoint.NewRouter()
+		router.RegisterEndpoint(stub, &contracts.RecoverSagaCommand{}, &contracts.CompensateSagaCommand{}, &contracts.CancelSagaCommand{})
+
+		create := func(uid string, update func(instance saga.Instance)) {
+			sagaObj := &testSaga{}
+			sagaObj.SetGroupKind(&scheme.GroupKind{Group: "test", Kind: "testSaga"})
+			instance := saga.NewSagaInstance(uid, "", sagaObj)
+			require.NoError(t, store.Create(ctx, instance))
+			update(instance)
+			require.NoError(t, store.Update(ctx, instance))
+		}
+
+		for i := 0; i < 5; i++ {
+			create(fmt.Sprintf("failed-%d", i), func(instance saga.Instance) {
+				instance.Fail(&contracts.SagaFailedEvent{})
+			})
+		}
+
+		for i := 0; i < 2; i++ {
+			create(fmt.Sprintf("running-%d", i), func(instance saga.Instance) {
+				instance.Progress()
+			})
+		}
+
+		return NewExecutor(store, outbox.NewRelay(store.Outbox(), router, log.NewNilLogger()), log.NewNilLogger()), stub
+	}
+
+	t.Run("recover failed sagas", func(t *testing.T) {
+		executor, stub := newEnv(t)
+
+		res, err := executor.Run(ctx, Recover, []saga.FilterOption{saga.WithSagaName("test.testSaga")}, WithBatchSize(2))
+		require.NoError(t, err)
+
+		assert.True(t, res.Done)
+		assert.Equal(t, 7, res.Matched)
+		assert.Equal(t, 5, res.Processed)
+		assert.Equal(t, 2, res.Skipped)
+		assert.Equal(t, []string{"failed-0", "failed-1", "failed-2", "failed-3", "failed-4"}, res.SagaUIDs)
+		assert.Equal(t, res.SagaUIDs, stub.sagaUIDs())
+	})
+
+	t.Run("dry run doesn't send commands", func(t *testing.T) {
+		executor, stub := newEnv(t)
+
+		res, err := executor.Run(ctx, Cancel, []saga.FilterOption{saga.WithStatus("in_progress")}, WithDryRun(), WithCompensation())
+		require.NoError(t, err)
+
+		assert.True(t, res.DryRun)
+		assert.Equal(t, []string{"running-0", "running-1"}, res.SagaUIDs)
+		assert.Empty(t, stub.sent)
+	})
+
+	t.Run("resume with cursor", func(t *testing.T) {
+		executor, stub := newEnv(t)
+
+		var reported []Progress
+		res, err := executor.Run(ctx, Compensate, []saga.FilterOption{saga.WithStatus("failed")}, WithLimit(3), WithBatchSize(2), WithProgress(func(p Progress) {
+			reported = append(reported, p)
+		}))
+		require.NoError(t, err)
+
+		assert.False(t, res.Done)
+		assert.Equal(t, []string{"failed-0", "failed-1", "failed-2"}, res.SagaUIDs)
+		require.Len(t, reported, 3)
+		assert.Equal(t, 3, reported[2].Processed)
+		assert.Equal(t, res.Cursor, reported[2].Cursor)
+
+		//an operation restarted with the reported cursor continues after the last processed saga
+		res, err = executor.Run(ctx, Compensate, []saga.FilterOption{saga.WithStatus("failed")}, WithCursor(reported[1].Cursor))
+		require.NoError(t, err)
+
+		assert.True(t, res.Done)
+		assert.Equal(t, []string{"failed-2", "failed-3", "failed-4"}, res.SagaUIDs)
+		assert.Len(t, stub.sent, 6)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		executor, stub := newEnv(t)
+
+		started := time.Now()
+		res, err := executor.Run(ctx, Recover, []saga.FilterOption{saga.WithStatus("failed")}, WithRate(50))
+		require.NoError(t, err)
+
+		assert.Equal(t, 5, res.Processed)
+		assert.Len(t, stub.sent, 5)
+		assert.GreaterOrEqual(t, int64(time.Since(started)), int64(4*20*time.Millisecond))
+	})
+
+	t.Run("invalid operation", func(t *testing.T) {
+		executor, _ := newEnv(t)
+
+		_, err := executor.Run(ctx, Action("restart"), []saga.FilterOption{saga.WithStatus("failed")})
+		assert.ErrorIs(t, err, ErrUnknownAction)
+
+		_, err = executor.Run(ctx, Recover, []saga.FilterOption{saga.WithStatus("failed")}, WithCursor("broken"))
+		assert.ErrorIs(t, err, saga.ErrInvalidCursor)
+	})
+}
@@ -0,0 +1,113 @@
+package saga
+
+import (
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/saga/contracts"
+)
+
+// Children describes child sagas started by a saga with SagaContext.StartChild
+type Children struct {
+	Started   []string
+	Completed []string
+	Failed    []string
+}
+
+// Outstanding returns children which neither completed nor failed yet
+func (c Children) Outstanding() []string {
+	finished := make(map[string]bool, len(c.Completed)+len(c.Failed))
+
+	for _, uid := range c.Completed {
+		finished[uid] = true
+	}
+
+	for _, uid := range c.Failed {
+		finished[uid] = true
+	}
+
+	var outstanding []string
+
+	for _, uid := range c.Started {
+		if !finished[uid] {
+			outstanding = append(outstanding, uid)
+		}
+	}
+
+	return outstanding
+}
+
+// AllCompleted is true when every started child is completed
+func (c Children) AllCompleted() bool {
+	return len(c.Started) > 0 && len(c.Completed) == len(c.Started)
+}
+
+// AllFinished is true when every started child either completed or failed
+func (c Children) AllFinished() bool {
+	return len(c.Started) > 0 && len(c.Outstanding()) == 0
+}
+
+// AnyCompleted is true when at least one child is completed
+func (c Children) AnyCompleted() bool {
+	return len(c.Completed) > 0
+}
+
+// AnyFailed is true when at least one child failed
+func (c Children) AnyFailed() bool {
+	return len(c.Failed) > 0
+}
+
+// ChildrenOf builds state of children from saga history. Started children are recorded there as dispatched StartSagaCommand
+func ChildrenOf(instance Instance) Children {
+	children := Children{}
+
+	for _, ev := range instance.HistoryEvents() {
+		children.apply(instance.UID(), ev.Payload)
+	}
+
+	return children
+}
+
+func (c *Children) apply(parentUID string, ev message.Object) {
+	switch payload := ev.(type) {
+	case *contracts.StartSagaCommand:
+		if payload.ParentUID == parentUID && !contains(c.Started, payload.SagaUID) {
+			c.Started = append(c.Started, payload.SagaUID)
+		}
+	case *contracts.SagaChildCompletedEvent:
+		if !contains(c.Completed, payload.SagaUID) {
+			c.Completed = append(c.Completed, payload.SagaUID)
+		}
+	case *contracts.SagaChildFailedEvent:
+		if !contains(c.Failed, payload.SagaUID) {
+			c.Failed = append(c.Failed, payload.SagaUID)
+		}
+	}
+}
+
+// ChildrenHandler is called when a child completes or fails, children include the received event
+type ChildrenHandler func(sagaCtx SagaContext, children Children) error
+
+// HandleChildren registers handlers of SagaChildCompletedEvent and SagaChildFailedEvent, call it in Init.
+// Use Children to decide whether the saga waits for all or any of them
+func (b *BaseSaga) HandleChildren(handler ChildrenHandler) *BaseSaga {
+	executor := func(sagaCtx SagaContext) error {
+		//received event is written into history after it's handled
+		children := ChildrenOf(sagaCtx.SagaInstance())
+		children.apply(sagaCtx.SagaInstance().UID(), sagaCtx.Message().Payload())
+
+		return handler(sagaCtx, children)
+	}
+
+	b.AddEventHandler(&contracts.SagaChildCompletedEvent{}, executor)
+
+	return b.AddEventHandler(&contracts.SagaChildFailedEvent{}, executor)
+}
+
+func contains(uids []string, uid string) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+
+	return false
+}
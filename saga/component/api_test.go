@@ -0,0 +1,89 @@
+package component
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/api"
+	"github.com/go-foreman/foreman/saga/api/handlers/control"
+	"github.com/go-foreman/foreman/saga/bulk"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/watch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestApiServerMatchesOpenAPISpec sends a request to every operation of the spec and checks that the response status is documented
+func TestApiServerMatchesOpenAPISpec(t *testing.T) {
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+	store := saga.NewMemoryStore(marshaller)
+	executor := bulk.NewExecutor(store, outbox.NewRelay(store.Outbox(), endpoint.NewRouter(), log.NewNilLogger()), log.NewNilLogger())
+
+	changes := watch.NewHub(marshaller, log.NewNilLogger())
+
+	mux := http.NewServeMux()
+	initApiServer(mux, store, control.BearerTokenAuthenticator("secret"), changes, nil, marshaller, executor, log.NewNilLogger())
+
+	readOnlyMux := http.NewServeMux()
+	initApiServer(readOnlyMux, store, nil, changes, nil, marshaller, executor, log.NewNilLogger())
+
+	spec := struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	require.NoError(t, json.Unmarshal(api.OpenAPISpec, &spec))
+	require.NotEmpty(t, spec.Paths)
+
+	for path, operations := range spec.Paths {
+		target := strings.NewReplacer("{sagaUid}", "unknown", "{action}", "recover", "{sagaType}", "unknown").Replace(path)
+
+		for method, raw := range operations {
+			if method == "parameters" {
+				continue
+			}
+
+			operation := struct {
+				Security  []json.RawMessage          `json:"security"`
+				Responses map[string]json.RawMessage `json:"responses"`
+			}{}
+			require.NoError(t, json.Unmarshal(raw, &operation))
+
+			request := func(handler http.Handler, authorization string) int {
+				//streams are open until the client disconnects
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				r := httptest.NewRequest(strings.ToUpper(method), target, strings.NewReader("{}")).WithContext(ctx)
+				if authorization != "" {
+					r.Header.Set("Authorization", authorization)
+				}
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, r)
+				return recorder.Code
+			}
+
+			code := request(mux, "Bearer secret")
+			assert.Contains(t, operation.Responses, strconv.Itoa(code), "%s %s", method, path)
+
+			if len(operation.Security) > 0 {
+				assert.Equal(t, http.StatusUnauthorized, request(mux, ""), "%s %s", method, path)
+				assert.Equal(t, http.StatusMethodNotAllowed, request(readOnlyMux, "Bearer secret"), "%s %s isn't available without authenticator", method, path)
+			} else {
+				assert.Equal(t, code, request(readOnlyMux, ""), "%s %s", method, path)
+			}
+		}
+	}
+}
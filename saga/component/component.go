@@ -1,17 +1,30 @@
 package component
 
 import (
+	"context"
 	"net/http"
+	"reflect"
+	"strings"
 
 	brigadier "github.com/go-foreman/foreman"
 	"github.com/go-foreman/foreman/log"
 	"github.com/go-foreman/foreman/pubsub/endpoint"
 	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/api"
+	"github.com/go-foreman/foreman/saga/api/handlers/control"
+	graphApi "github.com/go-foreman/foreman/saga/api/handlers/graph"
 	"github.com/go-foreman/foreman/saga/api/handlers/status"
+	"github.com/go-foreman/foreman/saga/api/handlers/stream"
+	"github.com/go-foreman/foreman/saga/bulk"
 	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/graph"
 	"github.com/go-foreman/foreman/saga/handlers"
 	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/go-foreman/foreman/saga/retention"
+	"github.com/go-foreman/foreman/saga/watch"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
@@ -22,20 +35,44 @@ type Component struct {
 	sagaMutex        mutex.Mutex
 	endpoints        []endpoint.Endpoint
 	configOpts       []configOption
+	relay            *outbox.Relay
+	bulk             *bulk.Executor
+	changes          *watch.Hub
+	definitions      map[string]*graph.Graph
+	retention        *retention.Retention
 }
 
 type opts struct {
 	uidService   saga.SagaUIDService
 	apiServerMux *http.ServeMux
+	apiAuth      control.Authenticator
+	relayOpts    []outbox.RelayOption
+	handlerOpts  []handlers.EventsHandlerOption
+	correlations []correlation
+	fanOut       endpoint.Endpoint
+	lifecycle    endpoint.Endpoint
+	retention    *retentionConfig
+}
+
+type retentionConfig struct {
+	leaderLock mutex.Mutex
+	policies   []retention.Policy
+	opts       []retention.Option
+}
+
+type correlation struct {
+	ev   message.Object
+	rule saga.CorrelationRule
 }
 
 type configOption func(o *opts)
 
+// NewSagaComponent creates saga component. sagaMutex is optional, when it's nil concurrent updates of a saga are resolved by its version
 func NewSagaComponent(sagaStoreFactory StoreFactory, sagaMutex mutex.Mutex, opts ...configOption) *Component {
 	return &Component{sagaStoreFactory: sagaStoreFactory, sagaMutex: sagaMutex, configOpts: opts}
 }
 
-func (c Component) Init(mBus *brigadier.MessageBus) error {
+func (c *Component) Init(mBus *brigadier.MessageBus) error {
 	opts := &opts{}
 	for _, config := range c.configOpts {
 		config(opts)
@@ -51,23 +88,77 @@ func (c Component) Init(mBus *brigadier.MessageBus) error {
 		return err
 	}
 
-	if opts.apiServerMux != nil {
-		initApiServer(opts.apiServerMux, store, mBus.Logger())
+	c.relay = outbox.NewRelay(store.Outbox(), mBus.Router(), mBus.Logger(), opts.relayOpts...)
+	//delayed deliveries and the ones which failed to be sent right after saga was saved are sent by the running relay
+	mBus.RunInBackground("saga outbox relay", c.relay.Run)
+	c.bulk = bulk.NewExecutor(store, c.relay, mBus.Logger())
+
+	if opts.retention != nil {
+		c.retention, err = retention.NewRetention(store, opts.retention.leaderLock, mBus.Logger(), opts.retention.policies, opts.retention.opts...)
+
+		if err != nil {
+			return errors.Wrap(err, "creating saga retention")
+		}
+
+		mBus.RunInBackground("saga retention", c.retention.Run)
+	}
+
+	if observable, ok := store.(saga.Observable); ok {
+		var hubOpts []watch.HubOption
+
+		if opts.fanOut != nil {
+			hubOpts = append(hubOpts, watch.WithFanOut(uuid.New().String(), opts.fanOut))
+		}
+
+		c.changes = watch.NewHub(mBus.Marshaller(), mBus.Logger(), hubOpts...)
+		observable.OnUpdate(c.changes.OnUpdate)
+
+		if opts.fanOut != nil {
+			mBus.RunInBackground("saga changes fan-out", c.changes.RunFanOut)
+			mBus.Dispatcher().SubscribeForEvent(&contracts.SagaChangedEvent{}, c.changes.HandleRemoteChange)
+		}
+	} else if opts.fanOut != nil {
+		return errors.New("saga store doesn't notify about updates, saga changes can't be sent to other replicas")
+	}
+
+	if len(opts.correlations) > 0 {
+		correlator := saga.NewCorrelator(mBus.SchemeRegistry())
+
+		for _, correlation := range opts.correlations {
+			if err := correlator.AddRule(correlation.ev, correlation.rule); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		opts.handlerOpts = append(opts.handlerOpts, handlers.WithCorrelator(correlator))
 	}
 
-	eventHandler := handlers.NewEventsHandler(store, c.sagaMutex, mBus.SchemeRegistry(), opts.uidService, mBus.Logger())
-	sagaControlHandler := handlers.NewSagaControlHandler(store, c.sagaMutex, mBus.SchemeRegistry(), opts.uidService, mBus.Logger())
+	eventHandler := handlers.NewEventsHandler(store, c.sagaMutex, mBus.SchemeRegistry(), opts.uidService, c.relay, mBus.Logger(), opts.handlerOpts...)
+	sagaControlHandler := handlers.NewSagaControlHandler(store, c.sagaMutex, mBus.SchemeRegistry(), opts.uidService, c.relay, mBus.Logger())
 
 	contracts.RegisterSagaContracts(mBus.SchemeRegistry())
 
 	mBus.Dispatcher().SubscribeForCmd(&contracts.StartSagaCommand{}, sagaControlHandler.Handle)
 	mBus.Dispatcher().SubscribeForCmd(&contracts.RecoverSagaCommand{}, sagaControlHandler.Handle)
 	mBus.Dispatcher().SubscribeForCmd(&contracts.CompensateSagaCommand{}, sagaControlHandler.Handle)
+	mBus.Dispatcher().SubscribeForCmd(&contracts.CancelSagaCommand{}, sagaControlHandler.Handle)
+	mBus.Dispatcher().SubscribeForCmd(&contracts.SuspendSagaCommand{}, sagaControlHandler.Handle)
+	mBus.Dispatcher().SubscribeForCmd(&contracts.ResumeSagaCommand{}, sagaControlHandler.Handle)
+	mBus.Dispatcher().SubscribeForCmd(&contracts.ReplayEventCommand{}, eventHandler.Handle)
+
+	c.definitions = make(map[string]*graph.Graph, len(c.sagas))
 
 	for _, s := range c.sagas {
 		s.SetSchema(mBus.SchemeRegistry())
 		s.Init()
 
+		definition, err := graph.Definition(s, mBus.SchemeRegistry())
+		if err != nil {
+			return errors.Wrapf(err, "creating graph of saga %s", reflect.TypeOf(s).String())
+		}
+
+		c.definitions[definition.Name] = definition
+
 		for evGK := range s.EventHandlers() {
 			//event obj must be registered in schema before
 			evObj, err := mBus.SchemeRegistry().NewObject(evGK)
@@ -84,15 +175,68 @@ func (c Component) Init(mBus *brigadier.MessageBus) error {
 			&contracts.StartSagaCommand{},
 			&contracts.RecoverSagaCommand{},
 			&contracts.CompensateSagaCommand{},
-			&contracts.SagaCompletedEvent{},
+			&contracts.CancelSagaCommand{},
+			&contracts.SuspendSagaCommand{},
+			&contracts.ResumeSagaCommand{},
+			&contracts.ReplayEventCommand{},
 			&contracts.SagaChildCompletedEvent{},
+			&contracts.SagaChildFailedEvent{},
 		)
 		mBus.Router().RegisterEndpoint(sagaEndpoint, c.contracts...)
 	}
 
+	//status events are for subscribers outside of sagas, they are not sent to saga endpoints where nobody handles them
+	if opts.lifecycle != nil {
+		mBus.Router().RegisterEndpoint(opts.lifecycle,
+			&contracts.SagaStartedEvent{},
+			&contracts.SagaCompletedEvent{},
+			&contracts.SagaFailedEvent{},
+			&contracts.SagaCompensatedEvent{},
+			&contracts.SagaCancelledEvent{},
+		)
+	}
+
+	if opts.apiServerMux != nil {
+		initApiServer(opts.apiServerMux, store, opts.apiAuth, c.changes, c.definitions, mBus.Marshaller(), c.bulk, mBus.Logger())
+	}
+
 	return nil
 }
 
+// Relay returns outbox relay which sends out saga deliveries. It's available after the component is initialized.
+// It runs in background while the subscriber of the message bus is running
+func (c *Component) Relay() *outbox.Relay {
+	return c.relay
+}
+
+// Bulk sends recover, compensate or cancel command to every saga matching filters. It's available after the component is initialized.
+// Progress reported by bulk.WithProgress has a cursor, pass it with bulk.WithCursor to resume the operation without processing the same sagas
+func (c *Component) Bulk(ctx context.Context, action bulk.Action, filters []saga.FilterOption, opts ...bulk.Option) (*bulk.Result, error) {
+	if c.bulk == nil {
+		return nil, errors.New("saga component isn't initialized")
+	}
+
+	return c.bulk.Run(ctx, action, filters, opts...)
+}
+
+// Retention returns a job which removes old sagas if WithRetention is configured, otherwise it's nil. It's available after the component is initialized.
+// It runs in background while the subscriber of the message bus is running
+func (c *Component) Retention() *retention.Retention {
+	return c.retention
+}
+
+// Changes returns a hub of saga changes, subscribe to it to follow sagas updated by this replica, and by others if WithSagaChangesFanOut is configured.
+// It's available after the component is initialized with a store implementing saga.Observable
+func (c *Component) Changes() *watch.Hub {
+	return c.changes
+}
+
+// Definitions returns graphs of registered sagas by saga type, render them with graph.Graph.Render. They are available after the component is initialized.
+// Use graph.Instance to get a path taken by a saga instance
+func (c *Component) Definitions() map[string]*graph.Graph {
+	return c.definitions
+}
+
 func (c *Component) RegisterSagas(sagas ...saga.Saga) {
 	c.sagas = append(c.sagas, sagas...)
 }
@@ -111,16 +255,126 @@ func WithSagaUIDService(svc saga.SagaUIDService) configOption {
 	}
 }
 
+func WithRelayOptions(relayOpts ...outbox.RelayOption) configOption {
+	return func(o *opts) {
+		o.relayOpts = append(o.relayOpts, relayOpts...)
+	}
+}
+
+// WithFailurePolicy specifies what happens when a saga event handler returns an error. By default the saga is marked failed and waits for manual recovery
+func WithFailurePolicy(policy handlers.FailurePolicy) configOption {
+	return func(o *opts) {
+		o.handlerOpts = append(o.handlerOpts, handlers.WithFailurePolicy(policy))
+	}
+}
+
+// WithCorrelationRule allows a saga to receive an event which has no saga uid in headers, the saga is found by a value from event payload.
+// The event must be handled by the saga and registered in scheme
+func WithCorrelationRule(ev message.Object, rule saga.CorrelationRule) configOption {
+	return func(o *opts) {
+		o.correlations = append(o.correlations, correlation{ev: ev, rule: rule})
+	}
+}
+
+// WithSagaApiServer registers saga api handlers and its OpenAPI document, served on api.OpenAPISpecPath, on the mux
 func WithSagaApiServer(mux *http.ServeMux) configOption {
 	return func(o *opts) {
 		o.apiServerMux = mux
 	}
 }
 
-func initApiServer(mux *http.ServeMux, store saga.Store, logger log.Logger) {
+// WithRetention removes sagas in terminal statuses after MaxAge of their policy, they are archived first if retention.WithArchiver is passed.
+// leaderLock is shared by replicas, so only one of them removes sagas at a time. The job runs in background while the subscriber of the message bus is running
+func WithRetention(leaderLock mutex.Mutex, policies []retention.Policy, retentionOpts ...retention.Option) configOption {
+	return func(o *opts) {
+		o.retention = &retentionConfig{leaderLock: leaderLock, policies: policies, opts: retentionOpts}
+	}
+}
+
+// WithSagaChangesFanOut sends changes of sagas updated by this replica to the endpoint and subscribes for changes from other replicas,
+// so status streams of every replica see all sagas. The transport must deliver contracts.SagaChangedEvent to every replica, i.e. with a fanout exchange
+func WithSagaChangesFanOut(fanOut endpoint.Endpoint) configOption {
+	return func(o *opts) {
+		o.fanOut = fanOut
+	}
+}
+
+// WithLifecycleEventsEndpoint publishes events about changes of saga status to the endpoint: contracts.SagaStartedEvent, SagaCompletedEvent,
+// SagaFailedEvent, SagaCompensatedEvent and SagaCancelledEvent. They have no saga uid header. Without the endpoint they are not published
+func WithLifecycleEventsEndpoint(lifecycle endpoint.Endpoint) configOption {
+	return func(o *opts) {
+		o.lifecycle = lifecycle
+	}
+}
+
+// WithSagaApiAuthenticator enables write endpoints of saga api server: start, recover, compensate, cancel and delete a saga.
+// Without it the api is read only
+func WithSagaApiAuthenticator(authenticator control.Authenticator) configOption {
+	return func(o *opts) {
+		o.apiAuth = authenticator
+	}
+}
+
+func initApiServer(mux *http.ServeMux, store saga.Store, authenticator control.Authenticator, changes *watch.Hub, definitions map[string]*graph.Graph, marshaller message.Marshaller, executor *bulk.Executor, logger log.Logger) {
 	statusHandler := status.NewStatusHandler(logger, status.NewStatusService(store))
-	mux.HandleFunc("/sagas", statusHandler.GetFilteredBy)
-	mux.HandleFunc("/sagas/", statusHandler.GetStatus)
+	graphHandler := graphApi.NewGraphHandler(logger, graphApi.NewGraphService(store, definitions))
+	mux.HandleFunc(api.OpenAPISpecPath, api.OpenAPIHandler)
+
+	//GET /sagas/{id}/... is routed by suffix of the path
+	sagaGetRoutes := map[string]http.HandlerFunc{"/graph": graphHandler.Get, "/tree": statusHandler.GetTree}
+
+	listRoutes := map[string]http.HandlerFunc{http.MethodGet: statusHandler.GetFilteredBy}
+	sagaRoutes := map[string]http.HandlerFunc{http.MethodGet: bySuffix(sagaGetRoutes, statusHandler.GetStatus)}
+	bulkRoutes := map[string]http.HandlerFunc{}
+	definitionRoutes := map[string]http.HandlerFunc{http.MethodGet: graphHandler.Get}
+
+	//write operations are available only when requests can be authenticated
+	if authenticator != nil {
+		controlHandler := control.NewControlHandler(logger, control.NewControlService(store, marshaller, executor), authenticator)
+		listRoutes[http.MethodPost] = controlHandler.Start
+		sagaRoutes[http.MethodPost] = controlHandler.Execute
+		sagaRoutes[http.MethodDelete] = controlHandler.Delete
+		bulkRoutes[http.MethodPost] = controlHandler.Bulk
+	}
+
+	if changes != nil {
+		streamHandler := stream.NewStreamHandler(logger, store, changes)
+		mux.HandleFunc("/sagas/stream", byMethod(map[string]http.HandlerFunc{http.MethodGet: streamHandler.Stream}))
+		sagaGetRoutes["/stream"] = streamHandler.Stream
+	}
+
+	mux.HandleFunc("/sagas", byMethod(listRoutes))
+	mux.HandleFunc("/sagas/", byMethod(sagaRoutes))
+	mux.HandleFunc("/sagas/bulk/", byMethod(bulkRoutes))
+	mux.HandleFunc(graphApi.DefinitionsPath, byMethod(definitionRoutes))
+}
+
+// bySuffix routes a request to a handler of its path suffix, requests without known suffix are handled by fallback
+func bySuffix(routes map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, r *http.Request) {
+		for suffix, handler := range routes {
+			if strings.HasSuffix(r.URL.Path, suffix) {
+				handler(resp, r)
+				return
+			}
+		}
+
+		fallback(resp, r)
+	}
+}
+
+// byMethod routes a request to a handler of its method
+func byMethod(routes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, r *http.Request) {
+		handler, ok := routes[r.Method]
+
+		if !ok {
+			resp.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(resp, r)
+	}
 }
 
 type StoreFactory func(msgMarshaller message.Marshaller) (saga.Store, error)
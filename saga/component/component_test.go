@@ -0,0 +1,341 @@
+package component
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	brigadier "github.com/go-foreman/foreman"
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/pubsub/transport"
+	"github.com/go-foreman/foreman/pubsub/transport/pkg"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/go-foreman/foreman/saga/retention"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type remindCmd struct {
+	message.ObjectMeta
+}
+
+type reminderSaga struct {
+	saga.BaseSaga
+}
+
+func (s *reminderSaga) Init() {}
+
+func (s *reminderSaga) Start(sagaCtx saga.SagaContext) error {
+	sagaCtx.Dispatch(&remindCmd{}, endpoint.WithDelay(time.Millisecond*200))
+	return nil
+}
+
+func (s *reminderSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *reminderSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type subscriberStub struct{}
+
+func (s subscriberStub) Run(ctx context.Context, queues ...transport.Queue) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s subscriberStub) Stop(ctx context.Context) error {
+	return nil
+}
+
+type syncEndpointStub struct {
+	lock sync.Mutex
+	sent []*message.OutcomingMessage
+}
+
+func (e *syncEndpointStub) Name() string {
+	return "stub"
+}
+
+func (e *syncEndpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func (e *syncEndpointStub) count() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.sent)
+}
+
+func TestComponent_RunsRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("reminders", &reminderSaga{}, &remindCmd{})
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	sagaComponent := NewSagaComponent(func(msgMarshaller message.Marshaller) (saga.Store, error) {
+		return saga.NewMemoryStore(msgMarshaller), nil
+	}, mutex.NewMemoryMutex(), WithRelayOptions(outbox.WithPollInterval(time.Millisecond*20)))
+	sagaComponent.RegisterSagas(&reminderSaga{})
+
+	mBus, err := brigadier.NewMessageBus(log.NewNilLogger(), marshaller, schemeRegistry, brigadier.WithSubscriber(subscriberStub{}), brigadier.WithComponents(sagaComponent))
+	require.NoError(t, err)
+
+	stub := &syncEndpointStub{}
+	mBus.Router().RegisterEndpoint(stub, &remindCmd{})
+
+	runCtx, stop := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		assert.NoError(t, mBus.Subscriber().Run(runCtx))
+	}()
+
+	startCmd := &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &reminderSaga{}}
+	gk, err := schemeRegistry.ObjectKind(startCmd)
+	require.NoError(t, err)
+	startCmd.SetGroupKind(gk)
+	received := message.NewReceivedMessage("msg-uid", startCmd, message.Headers{}, time.Now(), "reminders")
+	execCtx := execution.NewMessageExecutionCtxFactory(mBus.Router(), log.NewNilLogger()).CreateCtx(ctx, nil, received)
+
+	executors := mBus.Dispatcher().Match(startCmd)
+	require.Len(t, executors, 1)
+	require.NoError(t, executors[0](execCtx))
+
+	//the delivery is postponed, so it isn't sent right after saga is saved
+	assert.Equal(t, 0, stub.count())
+
+	assert.Eventually(t, func() bool {
+		return stub.count() == 1
+	}, time.Second*3, time.Millisecond*20)
+
+	stop()
+	<-stopped
+}
+
+func TestComponent_RunsRetention(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("orders", &completingSaga{})
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+	store := saga.NewMemoryStore(marshaller)
+
+	instance := saga.NewSagaInstance("saga-uid", "", &completingSaga{})
+	require.NoError(t, store.Create(ctx, instance))
+	instance.Complete()
+	require.NoError(t, store.Update(ctx, instance))
+
+	sagaComponent := NewSagaComponent(func(msgMarshaller message.Marshaller) (saga.Store, error) {
+		return store, nil
+	}, nil, WithRetention(mutex.NewMemoryMutex(), []retention.Policy{{MaxAge: time.Millisecond}}, retention.WithInterval(time.Millisecond*20)))
+
+	mBus, err := brigadier.NewMessageBus(log.NewNilLogger(), marshaller, schemeRegistry, brigadier.WithSubscriber(subscriberStub{}), brigadier.WithComponents(sagaComponent))
+	require.NoError(t, err)
+
+	runCtx, stop := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		assert.NoError(t, mBus.Subscriber().Run(runCtx))
+	}()
+
+	assert.Eventually(t, func() bool {
+		removed, err := store.GetById(ctx, "saga-uid")
+		return err == nil && removed == nil
+	}, time.Second*3, time.Millisecond*20)
+
+	stop()
+	<-stopped
+}
+
+type completingSaga struct {
+	saga.BaseSaga
+}
+
+func (s *completingSaga) Init() {}
+
+func (s *completingSaga) Start(sagaCtx saga.SagaContext) error {
+	sagaCtx.SagaInstance().Complete()
+	return nil
+}
+
+func (s *completingSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *completingSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type queue string
+
+func (q queue) Name() string {
+	return string(q)
+}
+
+// loopTransport delivers every sent package to its consumer and counts acknowledged ones
+type loopTransport struct {
+	pkgs  chan pkg.IncomingPkg
+	lock  sync.Mutex
+	sent  int
+	acked int
+}
+
+func newLoopTransport() *loopTransport {
+	return &loopTransport{pkgs: make(chan pkg.IncomingPkg, 100)}
+}
+
+func (t *loopTransport) CreateTopic(ctx context.Context, topic transport.Topic) error {
+	return nil
+}
+
+func (t *loopTransport) CreateQueue(ctx context.Context, queue transport.Queue, queueBind ...transport.QueueBind) error {
+	return nil
+}
+
+func (t *loopTransport) Consume(ctx context.Context, queues []transport.Queue, options ...transport.ConsumeOpts) (<-chan pkg.IncomingPkg, error) {
+	return t.pkgs, nil
+}
+
+func (t *loopTransport) Send(ctx context.Context, outboundPkg pkg.OutboundPkg, options ...transport.SendOpts) error {
+	t.lock.Lock()
+	t.sent++
+	t.lock.Unlock()
+
+	t.pkgs <- &loopPkg{uid: uuid.New().String(), payload: outboundPkg.Payload(), headers: outboundPkg.Headers(), transport: t}
+	return nil
+}
+
+func (t *loopTransport) Connect(context.Context) error {
+	return nil
+}
+
+func (t *loopTransport) Disconnect(context.Context) error {
+	return nil
+}
+
+func (t *loopTransport) counts() (int, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.sent, t.acked
+}
+
+type loopPkg struct {
+	uid       string
+	payload   []byte
+	headers   map[string]interface{}
+	transport *loopTransport
+}
+
+func (p *loopPkg) UID() string                     { return p.uid }
+func (p *loopPkg) Origin() string                  { return "loop" }
+func (p *loopPkg) Payload() []byte                 { return p.payload }
+func (p *loopPkg) Headers() map[string]interface{} { return p.headers }
+func (p *loopPkg) ReceivedAt() time.Time           { return time.Now() }
+func (p *loopPkg) PublishedAt() time.Time          { return time.Now() }
+
+func (p *loopPkg) Ack(options ...pkg.AcknowledgmentOption) error {
+	p.transport.lock.Lock()
+	defer p.transport.lock.Unlock()
+	p.transport.acked++
+	return nil
+}
+
+func (p *loopPkg) Nack(options ...pkg.AcknowledgmentOption) error   { return nil }
+func (p *loopPkg) Reject(options ...pkg.AcknowledgmentOption) error { return nil }
+
+func TestComponent_LifecycleEvents(t *testing.T) {
+	run := func(t *testing.T, withLifecycleEndpoint bool) (*loopTransport, <-chan *message.ReceivedMessage) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		t.Cleanup(cancel)
+
+		schemeRegistry := scheme.NewKnownTypesRegistry()
+		schemeRegistry.AddKnownTypes("orders", &completingSaga{})
+		marshaller := message.NewJsonMarshaller(schemeRegistry)
+		loop := newLoopTransport()
+
+		var opts []configOption
+		if withLifecycleEndpoint {
+			opts = append(opts, WithLifecycleEventsEndpoint(endpoint.NewAmqpEndpoint("saga-lifecycle", loop, pkg.DeliveryDestination{DestinationTopic: "saga-lifecycle"}, marshaller)))
+		}
+
+		sagaComponent := NewSagaComponent(func(msgMarshaller message.Marshaller) (saga.Store, error) {
+			return saga.NewMemoryStore(msgMarshaller), nil
+		}, mutex.NewMemoryMutex(), opts...)
+		sagaComponent.RegisterSagas(&completingSaga{})
+		sagaEndpoint := endpoint.NewAmqpEndpoint("sagas", loop, pkg.DeliveryDestination{DestinationTopic: "sagas"}, marshaller)
+		sagaComponent.RegisterSagaEndpoints(sagaEndpoint)
+
+		mBus, err := brigadier.NewMessageBus(log.NewNilLogger(), marshaller, schemeRegistry, brigadier.DefaultWithTransport(loop), brigadier.WithComponents(sagaComponent))
+		require.NoError(t, err)
+
+		//another service following sagas
+		lifecycleEvents := make(chan *message.ReceivedMessage, 10)
+		for _, ev := range []message.Object{&contracts.SagaStartedEvent{}, &contracts.SagaCompletedEvent{}} {
+			mBus.Dispatcher().SubscribeForEvent(ev, func(execCtx execution.MessageExecutionCtx) error {
+				lifecycleEvents <- execCtx.Message()
+				return nil
+			})
+		}
+
+		runCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+
+		go func() {
+			defer close(stopped)
+			assert.NoError(t, mBus.Subscriber().Run(runCtx, queue("sagas")))
+		}()
+
+		t.Cleanup(func() {
+			stop()
+			<-stopped
+		})
+
+		require.NoError(t, sagaEndpoint.Send(ctx, message.NewOutcomingMessage(&contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &completingSaga{}})))
+
+		return loop, lifecycleEvents
+	}
+
+	t.Run("published to lifecycle endpoint", func(t *testing.T) {
+		loop, lifecycleEvents := run(t, true)
+
+		//events are processed concurrently, so they come in any order
+		var received []string
+		for len(received) < 2 {
+			select {
+			case msg := <-lifecycleEvents:
+				received = append(received, reflect.TypeOf(msg.Payload()).String())
+				_, err := saga.NewSagaUIDService().ExtractSagaUID(msg.Headers())
+				assert.Error(t, err, "lifecycle event isn't addressed to the saga")
+			case <-time.After(time.Second * 3):
+				t.Fatalf("lifecycle events weren't received, got %v", received)
+			}
+		}
+
+		assert.ElementsMatch(t, []string{"*contracts.SagaStartedEvent", "*contracts.SagaCompletedEvent"}, received)
+
+		assert.Eventually(t, func() bool {
+			sent, acked := loop.counts()
+			return sent == 3 && acked == sent
+		}, time.Second*3, time.Millisecond*20)
+	})
+
+	t.Run("not published without lifecycle endpoint", func(t *testing.T) {
+		loop, _ := run(t, false)
+
+		assert.Eventually(t, func() bool {
+			sent, acked := loop.counts()
+			return sent == 1 && acked == sent
+		}, time.Second*3, time.Millisecond*20)
+	})
+}
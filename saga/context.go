@@ -8,6 +8,8 @@ import (
 	"github.com/go-foreman/foreman/pubsub/endpoint"
 	"github.com/go-foreman/foreman/pubsub/message"
 	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/google/uuid"
 )
 
 //SagaContext is sealed interface due to deliver method, that takes all dispatched deliveries and start sending out them
@@ -18,6 +20,9 @@ type SagaContext interface {
 	Context() context.Context
 	Valid() bool
 	Dispatch(payload message.Object, options ...endpoint.DeliveryOption)
+	// StartChild dispatches StartSagaCommand for a child saga and returns its uid.
+	// The parent receives SagaChildCompletedEvent or SagaChildFailedEvent when the child completes or fails
+	StartChild(saga Saga, options ...endpoint.DeliveryOption) string
 	Deliveries() []*Delivery
 	Return(options ...endpoint.DeliveryOption) error
 	LogMessage(level log.Level, msg string)
@@ -66,6 +71,13 @@ func (s *sagaCtx) Dispatch(toDeliver message.Object, options ...endpoint.Deliver
 	})
 }
 
+func (s *sagaCtx) StartChild(saga Saga, options ...endpoint.DeliveryOption) string {
+	childUID := uuid.New().String()
+	s.Dispatch(&contracts.StartSagaCommand{SagaUID: childUID, ParentUID: s.sagaInstance.UID(), Saga: saga}, options...)
+
+	return childUID
+}
+
 func (s sagaCtx) Deliveries() []*Delivery {
 	return s.deliveries
 }
@@ -16,8 +16,25 @@ func RegisterSagaContracts(scheme scheme.KnownTypesRegistry) {
 		&StartSagaCommand{},
 		&RecoverSagaCommand{},
 		&CompensateSagaCommand{},
+		&CancelSagaCommand{},
+		&SuspendSagaCommand{},
+		&ResumeSagaCommand{},
+		&ReplayEventCommand{},
+		&SagaStartedEvent{},
 		&SagaCompletedEvent{},
+		&SagaFailedEvent{},
+		&SagaCompensatedEvent{},
+		&SagaCancelledEvent{},
 		&SagaChildCompletedEvent{},
+		&SagaChildFailedEvent{},
+		&StepCompletedEvent{},
+		&StepCompensatedEvent{},
+		&StateTransitionedEvent{},
+		&EventDeferredEvent{},
+		&DeferredEventReplayedEvent{},
+		&EventParkedEvent{},
+		&ParkedEventsReplayedEvent{},
+		&SagaChangedEvent{},
 	)
 }
 
@@ -39,12 +56,167 @@ type CompensateSagaCommand struct {
 	SagaUID string `json:"saga_uid"`
 }
 
-type SagaCompletedEvent struct {
+// CancelSagaCommand stops a saga for good. If Compensate is set, compensation of the saga is started instead and the saga ends up compensated
+type CancelSagaCommand struct {
+	message.ObjectMeta
+	SagaUID    string `json:"saga_uid"`
+	Compensate bool   `json:"compensate"`
+}
+
+// SuspendSagaCommand pauses a saga, events it receives are parked until it's resumed
+type SuspendSagaCommand struct {
+	message.ObjectMeta
+	SagaUID string `json:"saga_uid"`
+}
+
+// ResumeSagaCommand returns a suspended saga into its previous status and sends parked events to it again in order they were received
+type ResumeSagaCommand struct {
 	message.ObjectMeta
 	SagaUID string `json:"saga_uid"`
 }
 
+// ReplayEventCommand sends an event to a saga again, i.e. a parked event when the saga is resumed.
+// It's routed to saga endpoints, so the event reaches the saga even if there is no route for the event itself
+type ReplayEventCommand struct {
+	message.ObjectMeta
+	SagaUID string         `json:"saga_uid"`
+	Event   message.Object `json:"event"`
+}
+
+// SagaStartedEvent is published when a saga leaves created status
+type SagaStartedEvent struct {
+	message.ObjectMeta
+	SagaUID        string `json:"saga_uid"`
+	SagaName       string `json:"saga_name"`
+	ParentUID      string `json:"parent_uid"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+// SagaCompletedEvent is published when a saga is completed
+type SagaCompletedEvent struct {
+	message.ObjectMeta
+	SagaUID        string `json:"saga_uid"`
+	SagaName       string `json:"saga_name"`
+	ParentUID      string `json:"parent_uid"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+// SagaFailedEvent is published when a saga fails, Reason contains a kind of the event it failed on
+type SagaFailedEvent struct {
+	message.ObjectMeta
+	SagaUID        string `json:"saga_uid"`
+	SagaName       string `json:"saga_name"`
+	ParentUID      string `json:"parent_uid"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason"`
+}
+
+// SagaCompensatedEvent is published when a saga finishes compensation
+type SagaCompensatedEvent struct {
+	message.ObjectMeta
+	SagaUID        string `json:"saga_uid"`
+	SagaName       string `json:"saga_name"`
+	ParentUID      string `json:"parent_uid"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+// SagaCancelledEvent is published when a saga is cancelled
+type SagaCancelledEvent struct {
+	message.ObjectMeta
+	SagaUID        string `json:"saga_uid"`
+	SagaName       string `json:"saga_name"`
+	ParentUID      string `json:"parent_uid"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+// SagaChildCompletedEvent is sent to a parent saga when its child is completed
 type SagaChildCompletedEvent struct {
 	message.ObjectMeta
 	SagaUID string `json:"saga_uid"`
 }
+
+// SagaChildFailedEvent is sent to a parent saga when its child fails
+type SagaChildFailedEvent struct {
+	message.ObjectMeta
+	SagaUID string `json:"saga_uid"`
+	Reason  string `json:"reason"`
+}
+
+// StepCompletedEvent is written into saga history when a step is completed, Sequence keeps order of completed steps
+type StepCompletedEvent struct {
+	message.ObjectMeta
+	Step     string `json:"step"`
+	Sequence int    `json:"sequence"`
+}
+
+// StepCompensatedEvent is written into saga history when compensation of a step is acknowledged
+type StepCompensatedEvent struct {
+	message.ObjectMeta
+	Step string `json:"step"`
+}
+
+// StateTransitionedEvent is written into saga history when a saga state machine changes its state
+type StateTransitionedEvent struct {
+	message.ObjectMeta
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Event string `json:"event"`
+}
+
+// EventDeferredEvent is written into saga history when an event arrives in a state which doesn't expect it.
+// The event is sent to the saga again after its state changes
+type EventDeferredEvent struct {
+	message.ObjectMeta
+	DeferUID string         `json:"defer_uid"`
+	State    string         `json:"state"`
+	Event    message.Object `json:"event"`
+}
+
+// DeferredEventReplayedEvent is written into saga history when a deferred event is sent to the saga again
+type DeferredEventReplayedEvent struct {
+	message.ObjectMeta
+	DeferUID string `json:"defer_uid"`
+}
+
+// EventParkedEvent is written into saga history when a suspended saga receives an event, Sequence keeps order of parked events
+type EventParkedEvent struct {
+	message.ObjectMeta
+	Sequence int            `json:"sequence"`
+	Event    message.Object `json:"event"`
+}
+
+// ParkedEventsReplayedEvent is written into saga history when a saga is resumed, parked events up to Sequence are sent to it again
+type ParkedEventsReplayedEvent struct {
+	message.ObjectMeta
+	Sequence int `json:"sequence"`
+}
+
+// SagaChangedEvent is published to other replicas when a saga is updated, so their status streams receive changes of sagas handled elsewhere.
+// Replica is the one which updated the saga
+type SagaChangedEvent struct {
+	message.ObjectMeta
+	Replica   string             `json:"replica"`
+	SagaUID   string             `json:"saga_uid"`
+	ParentUID string             `json:"parent_uid"`
+	SagaName  string             `json:"saga_name"`
+	Status    string             `json:"status"`
+	State     string             `json:"state"`
+	Version   int                `json:"version"`
+	Events    []SagaChangedEntry `json:"events"`
+}
+
+// SagaChangedEntry is a history event added by a saga update. CreatedAt is RFC3339 time and Payload is the encoded event
+type SagaChangedEntry struct {
+	UID        string `json:"uid"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"created_at"`
+	Origin     string `json:"origin"`
+	SagaStatus string `json:"saga_status"`
+	TraceUID   string `json:"trace_uid"`
+	Payload    string `json:"payload"`
+}
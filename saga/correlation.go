@@ -0,0 +1,91 @@
+package saga
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/pkg/errors"
+)
+
+// CorrelationRule finds a saga for an event which has no saga uid in headers, i.e. it's sent by an external system
+type CorrelationRule struct {
+	// Key is a name of correlation value. A saga is found by the value it was correlated with by Instance.Correlate under the same key
+	Key string
+	// Value extracts correlation value from event payload
+	Value func(ev message.Object) (string, error)
+	// Start creates a saga when there is no saga correlated with the value yet, the saga is started and handles the event.
+	// If it's nil, an event without correlated saga is rejected
+	Start func(ev message.Object) Saga
+}
+
+// Correlator keeps correlation rules of events
+type Correlator struct {
+	scheme scheme.KnownTypesRegistry
+	rules  map[scheme.GroupKind]CorrelationRule
+}
+
+// NewCorrelator creates Correlator, events of rules must be registered in the scheme
+func NewCorrelator(schemeRegistry scheme.KnownTypesRegistry) *Correlator {
+	return &Correlator{scheme: schemeRegistry, rules: make(map[scheme.GroupKind]CorrelationRule)}
+}
+
+// AddRule adds a correlation rule of an event, a rule of the same event is replaced
+func (c *Correlator) AddRule(ev message.Object, rule CorrelationRule) error {
+	if rule.Key == "" || rule.Value == nil {
+		return errors.Errorf("correlation rule of %s must have a key and a value", reflect.TypeOf(ev).String())
+	}
+
+	groupKind, err := c.scheme.ObjectKind(ev)
+
+	if err != nil {
+		return errors.Wrapf(err, "adding correlation rule of %s", reflect.TypeOf(ev).String())
+	}
+
+	c.rules[*groupKind] = rule
+
+	return nil
+}
+
+// Rule returns a correlation rule of an event
+func (c Correlator) Rule(groupKind scheme.GroupKind) (CorrelationRule, bool) {
+	rule, exists := c.rules[groupKind]
+	return rule, exists
+}
+
+// PayloadField extracts correlation value from a field of event payload. The field is found by its json name or by its name
+func PayloadField(name string) func(ev message.Object) (string, error) {
+	return func(ev message.Object) (string, error) {
+		val := reflect.ValueOf(ev)
+
+		for val.Kind() == reflect.Ptr {
+			val = val.Elem()
+		}
+
+		if val.Kind() != reflect.Struct {
+			return "", errors.Errorf("payload %s is not a struct", val.Type().String())
+		}
+
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Type().Field(i)
+			jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+
+			//unexported fields aren't marshaled either
+			if field.PkgPath != "" || (jsonName != name && field.Name != name) {
+				continue
+			}
+
+			value := fmt.Sprint(val.Field(i).Interface())
+
+			if value == "" {
+				return "", errors.Errorf("field %s of payload %s is empty", name, val.Type().String())
+			}
+
+			return value, nil
+		}
+
+		return "", errors.Errorf("payload %s has no field %s", val.Type().String(), name)
+	}
+}
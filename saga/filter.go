@@ -0,0 +1,301 @@
+package saga
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SortField is a field sagas are sorted by in GetByFilter
+type SortField string
+
+// SortOrder is a direction sagas are sorted in GetByFilter
+type SortOrder string
+
+const (
+	SortByStartedAt SortField = "started_at"
+	SortByUpdatedAt SortField = "updated_at"
+
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// ErrInvalidCursor is returned by GetByFilter when a cursor is malformed or was issued for another sorting
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type FilterOption func(opts *filterOptions)
+
+type filterOptions struct {
+	sagaId         string
+	status         string
+	sagaName       string
+	parentId       string
+	startedFrom    time.Time
+	startedTo      time.Time
+	updatedFrom    time.Time
+	updatedTo      time.Time
+	sortBy         SortField
+	order          SortOrder
+	cursor         string
+	limit          int
+	withoutHistory bool
+}
+
+func newFilterOptions(filters ...FilterOption) *filterOptions {
+	opts := &filterOptions{sortBy: SortByStartedAt, order: SortAsc}
+
+	for _, filter := range filters {
+		filter(opts)
+	}
+
+	return opts
+}
+
+func WithSagaId(sagaId string) FilterOption {
+	return func(opts *filterOptions) {
+		opts.sagaId = sagaId
+	}
+}
+
+func WithStatus(status string) FilterOption {
+	return func(opts *filterOptions) {
+		opts.status = status
+	}
+}
+
+func WithSagaName(sagaName string) FilterOption {
+	return func(opts *filterOptions) {
+		opts.sagaName = sagaName
+	}
+}
+
+// WithParentId filters sagas started by a parent saga
+func WithParentId(parentId string) FilterOption {
+	return func(opts *filterOptions) {
+		opts.parentId = parentId
+	}
+}
+
+// WithStartedBetween filters sagas started in [from, to). Zero time means the range is open from that side
+func WithStartedBetween(from, to time.Time) FilterOption {
+	return func(opts *filterOptions) {
+		opts.startedFrom = from
+		opts.startedTo = to
+	}
+}
+
+// WithUpdatedBetween filters sagas updated in [from, to). Zero time means the range is open from that side
+func WithUpdatedBetween(from, to time.Time) FilterOption {
+	return func(opts *filterOptions) {
+		opts.updatedFrom = from
+		opts.updatedTo = to
+	}
+}
+
+// WithSort specifies sorting of sagas, they are sorted by started_at ascending by default. Sagas without the time go first in ascending order
+func WithSort(field SortField, order SortOrder) FilterOption {
+	return func(opts *filterOptions) {
+		opts.sortBy = field
+		opts.order = order
+	}
+}
+
+// WithLimit limits amount of returned sagas. A limited query doesn't require other filters
+func WithLimit(limit int) FilterOption {
+	return func(opts *filterOptions) {
+		opts.limit = limit
+	}
+}
+
+// WithCursor returns sagas which go after the one the cursor was created for with NextCursor
+func WithCursor(cursor string) FilterOption {
+	return func(opts *filterOptions) {
+		opts.cursor = cursor
+	}
+}
+
+// WithoutHistory doesn't load history events of sagas
+func WithoutHistory() FilterOption {
+	return func(opts *filterOptions) {
+		opts.withoutHistory = true
+	}
+}
+
+// NextCursor creates a cursor pointing after the instance, usually the last one of a page. Filters must specify the same sorting the page was queried with
+func NextCursor(instance Instance, filters ...FilterOption) string {
+	opts := newFilterOptions(filters...)
+
+	c := cursor{SortBy: opts.sortBy, Order: opts.order, UID: instance.UID()}
+
+	if t := opts.sortTime(instance); t != nil {
+		c.Time = t.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+type cursor struct {
+	SortBy SortField `json:"s"`
+	Order  SortOrder `json:"o"`
+	Time   string    `json:"t,omitempty"`
+	UID    string    `json:"u"`
+}
+
+// sortKey is a position of a saga in sorted result, sagas with nil time go first
+type sortKey struct {
+	time *time.Time
+	uid  string
+}
+
+func (k sortKey) compare(another sortKey) int {
+	switch {
+	case k.time == nil && another.time != nil:
+		return -1
+	case k.time != nil && another.time == nil:
+		return 1
+	case k.time != nil && k.time.Before(*another.time):
+		return -1
+	case k.time != nil && k.time.After(*another.time):
+		return 1
+	case k.uid < another.uid:
+		return -1
+	case k.uid > another.uid:
+		return 1
+	}
+
+	return 0
+}
+
+func (o filterOptions) validate() error {
+	if o.sortBy != SortByStartedAt && o.sortBy != SortByUpdatedAt {
+		return errors.Errorf("unknown sort field %s", o.sortBy)
+	}
+
+	if o.order != SortAsc && o.order != SortDesc {
+		return errors.Errorf("unknown sort order %s", o.order)
+	}
+
+	if o.limit < 0 {
+		return errors.Errorf("limit %d is negative", o.limit)
+	}
+
+	return nil
+}
+
+// hasConditions is false if the filter selects whole store
+func (o filterOptions) hasConditions() bool {
+	return o.sagaId != "" || o.status != "" || o.sagaName != "" || o.parentId != "" ||
+		!o.startedFrom.IsZero() || !o.startedTo.IsZero() || !o.updatedFrom.IsZero() || !o.updatedTo.IsZero() ||
+		o.limit > 0
+}
+
+// decodeCursor returns the key sagas have to go after, nil if cursor isn't specified
+func (o filterOptions) decodeCursor() (*sortKey, error) {
+	if o.cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(o.cursor)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCursor, err.Error())
+	}
+
+	c := cursor{}
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrap(ErrInvalidCursor, err.Error())
+	}
+
+	if c.SortBy != o.sortBy || c.Order != o.order {
+		return nil, errors.Wrapf(ErrInvalidCursor, "cursor was issued for sorting by %s %s", c.SortBy, c.Order)
+	}
+
+	key := &sortKey{uid: c.UID}
+
+	if c.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, c.Time)
+
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidCursor, err.Error())
+		}
+
+		key.time = &t
+	}
+
+	return key, nil
+}
+
+func (o filterOptions) sortTime(instance Instance) *time.Time {
+	if o.sortBy == SortByUpdatedAt {
+		return instance.UpdatedAt()
+	}
+
+	return instance.StartedAt()
+}
+
+func (o filterOptions) sortKey(instance Instance) sortKey {
+	return sortKey{time: o.sortTime(instance), uid: instance.UID()}
+}
+
+// less sorts instances in requested order
+func (o filterOptions) less(a, b Instance) bool {
+	if o.order == SortDesc {
+		return o.sortKey(a).compare(o.sortKey(b)) > 0
+	}
+
+	return o.sortKey(a).compare(o.sortKey(b)) < 0
+}
+
+// after checks whether an instance goes after a cursor in requested order
+func (o filterOptions) after(instance Instance, cursorKey sortKey) bool {
+	if o.order == SortDesc {
+		return o.sortKey(instance).compare(cursorKey) < 0
+	}
+
+	return o.sortKey(instance).compare(cursorKey) > 0
+}
+
+func (o filterOptions) match(instance Instance) bool {
+	if o.sagaId != "" && instance.UID() != o.sagaId {
+		return false
+	}
+
+	if o.status != "" && instance.Status().String() != o.status {
+		return false
+	}
+
+	if o.sagaName != "" && instance.Saga().GroupKind().String() != o.sagaName {
+		return false
+	}
+
+	if o.parentId != "" && instance.ParentID() != o.parentId {
+		return false
+	}
+
+	return inRange(instance.StartedAt(), o.startedFrom, o.startedTo) && inRange(instance.UpdatedAt(), o.updatedFrom, o.updatedTo)
+}
+
+func inRange(t *time.Time, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+
+	if t == nil {
+		return false
+	}
+
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,387 @@
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	Mermaid Format = "mermaid"
+	DOT     Format = "dot"
+)
+
+// ErrUnknownFormat is returned when a graph is rendered in a format other than Mermaid or DOT
+var ErrUnknownFormat = errors.New("unknown graph format")
+
+type NodeKind string
+
+const (
+	StartNode NodeKind = "start"
+	// EventNode is an event handled by the saga
+	EventNode NodeKind = "event"
+	// MessageNode is a message dispatched by the saga which it doesn't handle
+	MessageNode NodeKind = "message"
+	StateNode   NodeKind = "state"
+	StepNode    NodeKind = "step"
+	// HistoryNode is a history event of a saga instance
+	HistoryNode NodeKind = "history"
+	// StatusNode is current status of a saga instance
+	StatusNode NodeKind = "status"
+)
+
+type Node struct {
+	ID    string
+	Label string
+	Kind  NodeKind
+}
+
+type Edge struct {
+	From  string
+	To    string
+	Label string
+	// Dashed edges aren't part of the main flow, i.e. compensations
+	Dashed bool
+}
+
+// Graph is a workflow of a saga definition or a path taken by a saga instance
+type Graph struct {
+	Name  string
+	Nodes []Node
+	Edges []Edge
+}
+
+type dispatchesDefinition interface {
+	DeclaredDispatches() map[scheme.GroupKind][]scheme.GroupKind
+}
+
+type stepsDefinition interface {
+	Steps() []saga.Step
+}
+
+type stateMachineDefinition interface {
+	States() []saga.State
+	Transitions() []saga.Transition
+}
+
+// Definition creates a graph of a saga definition: events it handles, messages declared with BaseSaga.DeclareDispatch,
+// states and transitions of its state machine and its steps. The saga must be initialized with SetSchema and Init
+func Definition(s saga.Saga, registry scheme.KnownTypesRegistry) (*Graph, error) {
+	sagaKind, err := registry.ObjectKind(s)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	g := &Graph{Name: sagaKind.String()}
+	g.addNode(Node{ID: "start", Label: "Start", Kind: StartNode})
+
+	handled := make(map[scheme.GroupKind]bool)
+	for groupKind := range s.EventHandlers() {
+		handled[groupKind] = true
+	}
+
+	//events handled by transitions and steps are shown on their edges
+	shown := make(map[scheme.GroupKind]bool)
+
+	if machine, ok := s.(stateMachineDefinition); ok {
+		for _, state := range machine.States() {
+			g.addNode(Node{ID: stateID(state.Name), Label: state.Name, Kind: StateNode})
+		}
+
+		for _, transition := range machine.Transitions() {
+			on, err := registry.ObjectKind(transition.On)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			shown[*on] = true
+			g.Edges = append(g.Edges, Edge{From: stateID(transition.From), To: stateID(transition.To), Label: on.String()})
+		}
+	}
+
+	if steps, ok := s.(stepsDefinition); ok {
+		for i, step := range steps.Steps() {
+			g.addNode(Node{ID: stepID(step.Name), Label: step.Name, Kind: StepNode})
+
+			if i > 0 {
+				g.Edges = append(g.Edges, Edge{From: stepID(steps.Steps()[i-1].Name), To: stepID(step.Name)})
+			}
+
+			compensatedOn, err := registry.ObjectKind(step.CompensatedOn)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			shown[*compensatedOn] = true
+			g.addNode(Node{ID: kindID(*compensatedOn), Label: compensatedOn.String(), Kind: EventNode})
+			g.Edges = append(g.Edges, Edge{From: stepID(step.Name), To: kindID(*compensatedOn), Label: "compensated on", Dashed: true})
+		}
+	}
+
+	if declared, ok := s.(dispatchesDefinition); ok {
+		dispatches := declared.DeclaredDispatches()
+
+		for _, on := range sortedKinds(dispatches) {
+			from := "start"
+
+			if on != (scheme.GroupKind{}) {
+				from = kindID(on)
+				g.addNode(Node{ID: from, Label: on.String(), Kind: EventNode})
+			}
+
+			for _, dispatched := range dispatches[on] {
+				kind := MessageNode
+				if handled[dispatched] {
+					kind = EventNode
+				}
+
+				g.addNode(Node{ID: kindID(dispatched), Label: dispatched.String(), Kind: kind})
+				g.Edges = append(g.Edges, Edge{From: from, To: kindID(dispatched), Label: "dispatches"})
+			}
+		}
+	}
+
+	var rest []scheme.GroupKind
+	for groupKind := range handled {
+		if !shown[groupKind] {
+			rest = append(rest, groupKind)
+		}
+	}
+
+	sortKinds(rest)
+
+	for _, groupKind := range rest {
+		g.addNode(Node{ID: kindID(groupKind), Label: groupKind.String(), Kind: EventNode})
+	}
+
+	return g, nil
+}
+
+// Instance creates a graph of a path taken by a saga instance, its history events in order they were created followed by its current status.
+// Edges are labeled with saga status when it changed
+func Instance(instance saga.Instance) *Graph {
+	g := &Graph{Name: instance.UID()}
+	g.addNode(Node{ID: "start", Label: "Start", Kind: StartNode})
+
+	events := instance.HistoryEvents()
+
+	var (
+		prev   = "start"
+		status string
+	)
+
+	for i, ev := range events {
+		id := fmt.Sprintf("h%d", i+1)
+		g.addNode(Node{ID: id, Label: fmt.Sprintf("%d. %s", i+1, historyLabel(ev)), Kind: HistoryNode})
+
+		edge := Edge{From: prev, To: id}
+		if ev.SagaStatus != status {
+			edge.Label = ev.SagaStatus
+			status = ev.SagaStatus
+		}
+
+		g.Edges = append(g.Edges, edge)
+		prev = id
+	}
+
+	g.addNode(Node{ID: "status", Label: instance.Status().String(), Kind: StatusNode})
+	g.Edges = append(g.Edges, Edge{From: prev, To: "status"})
+
+	return g
+}
+
+// Render renders the graph in Mermaid flowchart or Graphviz DOT format
+func (g *Graph) Render(format Format) (string, error) {
+	switch format {
+	case Mermaid:
+		return g.mermaid(), nil
+	case DOT:
+		return g.dot(), nil
+	default:
+		return "", errors.Wrapf(ErrUnknownFormat, "%s", format)
+	}
+}
+
+func (g *Graph) mermaid() string {
+	b := &strings.Builder{}
+	b.WriteString("flowchart TD\n")
+
+	if g.Name != "" {
+		fmt.Fprintf(b, "    %%%% %s\n", g.Name)
+	}
+
+	for _, node := range g.Nodes {
+		open, closing := mermaidShape(node.Kind)
+		fmt.Fprintf(b, "    %s%s\"%s\"%s\n", node.ID, open, mermaidEscape(node.Label), closing)
+	}
+
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.Dashed {
+			arrow = "-.->"
+		}
+
+		if edge.Label != "" {
+			fmt.Fprintf(b, "    %s %s|\"%s\"| %s\n", edge.From, arrow, mermaidEscape(edge.Label), edge.To)
+			continue
+		}
+
+		fmt.Fprintf(b, "    %s %s %s\n", edge.From, arrow, edge.To)
+	}
+
+	return b.String()
+}
+
+func (g *Graph) dot() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph %s {\n", dotQuote(g.Name))
+	b.WriteString("    rankdir=TB;\n")
+
+	for _, node := range g.Nodes {
+		fmt.Fprintf(b, "    %s [label=%s, %s];\n", dotQuote(node.ID), dotQuote(node.Label), dotShape(node.Kind))
+	}
+
+	for _, edge := range g.Edges {
+		var attrs []string
+
+		if edge.Label != "" {
+			attrs = append(attrs, "label="+dotQuote(edge.Label))
+		}
+
+		if edge.Dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+
+		if len(attrs) > 0 {
+			fmt.Fprintf(b, "    %s -> %s [%s];\n", dotQuote(edge.From), dotQuote(edge.To), strings.Join(attrs, ", "))
+			continue
+		}
+
+		fmt.Fprintf(b, "    %s -> %s;\n", dotQuote(edge.From), dotQuote(edge.To))
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// addNode adds a node unless a node with the same id is already added
+func (g *Graph) addNode(node Node) {
+	for i, existing := range g.Nodes {
+		if existing.ID == node.ID {
+			//a dispatched message turns out to be handled by the saga
+			if node.Kind == EventNode {
+				g.Nodes[i].Kind = EventNode
+			}
+			return
+		}
+	}
+
+	g.Nodes = append(g.Nodes, node)
+}
+
+func historyLabel(ev saga.HistoryEvent) string {
+	if transitioned, ok := ev.Payload.(*contracts.StateTransitionedEvent); ok {
+		return fmt.Sprintf("state %s -> %s", transitioned.From, transitioned.To)
+	}
+
+	if ev.Payload == nil {
+		return ev.UID
+	}
+
+	//group kind is set when the event is loaded from a store
+	if ev.Payload.GroupKind().Kind == "" {
+		return reflect.TypeOf(ev.Payload).Elem().Name()
+	}
+
+	return ev.Payload.GroupKind().String()
+}
+
+func mermaidShape(kind NodeKind) (string, string) {
+	switch kind {
+	case StartNode:
+		return "((", "))"
+	case EventNode:
+		return "([", "])"
+	case StateNode, StatusNode:
+		return "(", ")"
+	case StepNode:
+		return "[[", "]]"
+	default:
+		return "[", "]"
+	}
+}
+
+func dotShape(kind NodeKind) string {
+	switch kind {
+	case StartNode:
+		return "shape=circle"
+	case EventNode:
+		return "shape=ellipse"
+	case StateNode, StatusNode:
+		return "shape=box, style=rounded"
+	case StepNode:
+		return "shape=component"
+	default:
+		return "shape=box"
+	}
+}
+
+func mermaidEscape(label string) string {
+	return strings.ReplaceAll(label, `"`, "#quot;")
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// nodeID replaces characters which aren't allowed in ids of Mermaid nodes
+func nodeID(prefix, name string) string {
+	return prefix + "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func kindID(groupKind scheme.GroupKind) string {
+	return nodeID("gk", groupKind.String())
+}
+
+func stateID(name string) string {
+	return nodeID("state", name)
+}
+
+func stepID(name string) string {
+	return nodeID("step", name)
+}
+
+func sortedKinds(dispatches map[scheme.GroupKind][]scheme.GroupKind) []scheme.GroupKind {
+	kinds := make([]scheme.GroupKind, 0, len(dispatches))
+
+	for groupKind := range dispatches {
+		kinds = append(kinds, groupKind)
+	}
+
+	sortKinds(kinds)
+
+	return kinds
+}
+
+func sortKinds(kinds []scheme.GroupKind) {
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].String() < kinds[j].String()
+	})
+}
@@ -0,0 +1,175 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderPlaced struct{ message.ObjectMeta }
+type paymentCompleted struct{ message.ObjectMeta }
+type paymentRefunded struct{ message.ObjectMeta }
+type orderShipped struct{ message.ObjectMeta }
+type chargePayment struct{ message.ObjectMeta }
+type shipOrder struct{ message.ObjectMeta }
+type refundPayment struct{ message.ObjectMeta }
+
+type orderSaga struct {
+	saga.BaseSaga
+}
+
+func (s *orderSaga) Init() {
+	s.AddState(saga.State{Name: "placed"}).
+		AddState(saga.State{Name: "paid"}).
+		AddState(saga.State{Name: "shipped"}).
+		AddTransition(saga.Transition{From: "placed", To: "paid", On: &paymentCompleted{}}).
+		AddTransition(saga.Transition{From: "paid", To: "shipped", On: &orderShipped{}}).
+		AddStep(saga.Step{
+			Name:          "charge",
+			Action:        func(sagaCtx saga.SagaContext) error { return nil },
+			Compensation:  func(sagaCtx saga.SagaContext) message.Object { return &refundPayment{} },
+			CompensatedOn: &paymentRefunded{},
+		}).
+		AddEventHandler(&orderPlaced{}, func(sagaCtx saga.SagaContext) error { return nil }).
+		DeclareDispatch(nil, &chargePayment{}).
+		DeclareDispatch(&paymentCompleted{}, &shipOrder{}).
+		DeclareDispatch(&orderPlaced{}, &orderPlaced{})
+}
+
+func (s *orderSaga) Start(sagaCtx saga.SagaContext) error   { return nil }
+func (s *orderSaga) Recover(sagaCtx saga.SagaContext) error { return nil }
+
+func newOrderSaga(t *testing.T) (*orderSaga, scheme.KnownTypesRegistry) {
+	registry := scheme.NewKnownTypesRegistry()
+	registry.AddKnownTypes("orders",
+		&orderSaga{},
+		&orderPlaced{},
+		&paymentCompleted{},
+		&paymentRefunded{},
+		&orderShipped{},
+		&chargePayment{},
+		&shipOrder{},
+		&refundPayment{},
+	)
+	contracts.RegisterSagaContracts(registry)
+
+	s := &orderSaga{}
+	s.SetSchema(registry)
+	s.Init()
+
+	return s, registry
+}
+
+func TestDefinition(t *testing.T) {
+	s, registry := newOrderSaga(t)
+
+	g, err := Definition(s, registry)
+	require.NoError(t, err)
+
+	assert.Equal(t, "orders.orderSaga", g.Name)
+	assert.Equal(t, []Node{
+		{ID: "start", Label: "Start", Kind: StartNode},
+		{ID: "state_paid", Label: "paid", Kind: StateNode},
+		{ID: "state_placed", Label: "placed", Kind: StateNode},
+		{ID: "state_shipped", Label: "shipped", Kind: StateNode},
+		{ID: "step_charge", Label: "charge", Kind: StepNode},
+		{ID: "gk_orders_paymentRefunded", Label: "orders.paymentRefunded", Kind: EventNode},
+		{ID: "gk_orders_chargePayment", Label: "orders.chargePayment", Kind: MessageNode},
+		{ID: "gk_orders_orderPlaced", Label: "orders.orderPlaced", Kind: EventNode},
+		{ID: "gk_orders_paymentCompleted", Label: "orders.paymentCompleted", Kind: EventNode},
+		{ID: "gk_orders_shipOrder", Label: "orders.shipOrder", Kind: MessageNode},
+	}, g.Nodes)
+
+	assert.Equal(t, []Edge{
+		{From: "state_paid", To: "state_shipped", Label: "orders.orderShipped"},
+		{From: "state_placed", To: "state_paid", Label: "orders.paymentCompleted"},
+		{From: "step_charge", To: "gk_orders_paymentRefunded", Label: "compensated on", Dashed: true},
+		{From: "start", To: "gk_orders_chargePayment", Label: "dispatches"},
+		{From: "gk_orders_orderPlaced", To: "gk_orders_orderPlaced", Label: "dispatches"},
+		{From: "gk_orders_paymentCompleted", To: "gk_orders_shipOrder", Label: "dispatches"},
+	}, g.Edges)
+
+	t.Run("repeated declaration is ignored", func(t *testing.T) {
+		s.DeclareDispatch(nil, &chargePayment{}, &shipOrder{})
+		assert.Equal(t, []scheme.GroupKind{
+			{Group: "orders", Kind: "chargePayment"},
+			{Group: "orders", Kind: "shipOrder"},
+		}, s.DeclaredDispatches()[scheme.GroupKind{}])
+	})
+}
+
+func TestInstance(t *testing.T) {
+	s, _ := newOrderSaga(t)
+
+	instance := saga.NewSagaInstance("1", "", s)
+	instance.Progress()
+	instance.AddHistoryEvent(&contracts.StateTransitionedEvent{From: "placed", To: "paid", Event: "orders.paymentCompleted"})
+	placed := &orderPlaced{}
+	placed.SetGroupKind(&scheme.GroupKind{Group: "orders", Kind: "orderPlaced"})
+	instance.AddHistoryEvent(placed)
+	instance.Fail(nil)
+	instance.AddHistoryEvent(&contracts.StepCompletedEvent{Step: "charge", Sequence: 1})
+
+	g := Instance(instance)
+
+	assert.Equal(t, "1", g.Name)
+	assert.Equal(t, []Node{
+		{ID: "start", Label: "Start", Kind: StartNode},
+		{ID: "h1", Label: "1. state placed -> paid", Kind: HistoryNode},
+		{ID: "h2", Label: "2. orders.orderPlaced", Kind: HistoryNode},
+		{ID: "h3", Label: "3. StepCompletedEvent", Kind: HistoryNode},
+		{ID: "status", Label: "failed", Kind: StatusNode},
+	}, g.Nodes)
+	assert.Equal(t, []Edge{
+		{From: "start", To: "h1", Label: "in_progress"},
+		{From: "h1", To: "h2"},
+		{From: "h2", To: "h3", Label: "failed"},
+		{From: "h3", To: "status"},
+	}, g.Edges)
+}
+
+func TestGraph_Render(t *testing.T) {
+	g := &Graph{
+		Name: `order "1"`,
+		Nodes: []Node{
+			{ID: "start", Label: "Start", Kind: StartNode},
+			{ID: "gk_ev", Label: `ev "x"`, Kind: EventNode},
+			{ID: "step_a", Label: "a", Kind: StepNode},
+		},
+		Edges: []Edge{
+			{From: "start", To: "gk_ev", Label: "dispatches"},
+			{From: "step_a", To: "gk_ev", Dashed: true},
+		},
+	}
+
+	mermaid, err := g.Render(Mermaid)
+	require.NoError(t, err)
+	assert.Equal(t, `flowchart TD
+    %% order "1"
+    start(("Start"))
+    gk_ev(["ev #quot;x#quot;"])
+    step_a[["a"]]
+    start -->|"dispatches"| gk_ev
+    step_a -.-> gk_ev
+`, mermaid)
+
+	dot, err := g.Render(DOT)
+	require.NoError(t, err)
+	assert.Equal(t, `digraph "order \"1\"" {
+    rankdir=TB;
+    "start" [label="Start", shape=circle];
+    "gk_ev" [label="ev \"x\"", shape=ellipse];
+    "step_a" [label="a", shape=component];
+    "start" -> "gk_ev" [label="dispatches"];
+    "step_a" -> "gk_ev" [style=dashed];
+}
+`, dot)
+
+	_, err = g.Render("svg")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
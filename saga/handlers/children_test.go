@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type packageSaga struct {
+	sagaPkg.BaseSaga
+	Fail bool `json:"fail"`
+}
+
+func (s *packageSaga) Init() {}
+
+func (s *packageSaga) Start(sagaCtx sagaPkg.SagaContext) error {
+	if s.Fail {
+		sagaCtx.SagaInstance().Fail(&contracts.SagaFailedEvent{Reason: "package is lost"})
+		return nil
+	}
+
+	sagaCtx.SagaInstance().Complete()
+
+	return nil
+}
+
+func (s *packageSaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *packageSaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+type deliverySaga struct {
+	sagaPkg.BaseSaga
+	Packages []bool `json:"packages"`
+}
+
+func (s *deliverySaga) Init() {
+	s.HandleChildren(func(sagaCtx sagaPkg.SagaContext, children sagaPkg.Children) error {
+		switch {
+		case children.AnyFailed():
+			sagaCtx.SagaInstance().Fail(sagaCtx.Message().Payload())
+		case children.AllCompleted():
+			sagaCtx.SagaInstance().Complete()
+		}
+
+		return nil
+	})
+}
+
+func (s *deliverySaga) Start(sagaCtx sagaPkg.SagaContext) error {
+	for _, fail := range s.Packages {
+		sagaCtx.StartChild(&packageSaga{Fail: fail})
+	}
+
+	return nil
+}
+
+func (s *deliverySaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *deliverySaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+func TestChildSagas(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("delivery", &deliverySaga{}, &packageSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	run := func(t *testing.T, packages ...bool) (sagaPkg.Store, sagaPkg.Instance) {
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&contracts.StartSagaCommand{}, &contracts.SagaChildCompletedEvent{}, &contracts.SagaChildFailedEvent{}})
+
+		start := message.NewOutcomingMessage(&contracts.StartSagaCommand{SagaUID: "parent-uid", Saga: &deliverySaga{Packages: packages}}, message.WithHeaders(message.Headers{}))
+		require.NoError(t, e.controlHandler.Handle(e.receiveSent(start)))
+
+		//messages are handled in order they were sent until there is nothing left
+		for handled := 0; handled < len(e.stub.sent); handled++ {
+			sent := e.stub.sent[handled]
+
+			switch sent.Payload().(type) {
+			case *contracts.StartSagaCommand:
+				require.NoError(t, e.controlHandler.Handle(e.receiveSent(sent)))
+			default:
+				require.NoError(t, e.eventsHandler.Handle(e.receiveSent(sent)))
+			}
+		}
+
+		return e.store, e.instance("parent-uid")
+	}
+
+	t.Run("parent completes when all children complete", func(t *testing.T) {
+		store, parent := run(t, false, false)
+		assert.True(t, parent.Status().Completed())
+
+		children := sagaPkg.ChildrenOf(parent)
+		assert.Len(t, children.Started, 2)
+		assert.ElementsMatch(t, children.Started, children.Completed)
+		assert.Empty(t, children.Outstanding())
+
+		childInstances, err := store.GetByFilter(ctx, sagaPkg.WithParentId(parent.UID()))
+		require.NoError(t, err)
+		assert.Len(t, childInstances, 2)
+	})
+
+	t.Run("parent fails when any child fails", func(t *testing.T) {
+		_, parent := run(t, false, true)
+		assert.True(t, parent.Status().Failed())
+
+		children := sagaPkg.ChildrenOf(parent)
+		assert.Len(t, children.Failed, 1)
+		assert.True(t, children.AllFinished())
+	})
+}
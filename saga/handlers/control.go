@@ -5,8 +5,8 @@ import (
 	"fmt"
 
 	log "github.com/go-foreman/foreman/log"
-	"github.com/go-foreman/foreman/pubsub/message"
 	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/go-foreman/foreman/runtime/scheme"
 	sagaPkg "github.com/go-foreman/foreman/saga"
 	"github.com/go-foreman/foreman/saga/contracts"
@@ -14,8 +14,9 @@ import (
 	"github.com/pkg/errors"
 )
 
-func NewSagaControlHandler(sagaStore sagaPkg.Store, mutex mutex.Mutex, sagaRegistry scheme.KnownTypesRegistry, sagaUIDSvc sagaPkg.SagaUIDService, logger log.Logger) *SagaControlHandler {
-	return &SagaControlHandler{typesRegistry: sagaRegistry, store: sagaStore, mutex: mutex, sagaUIDSvc: sagaUIDSvc, logger: logger}
+// NewSagaControlHandler creates SagaControlHandler. Deliveries are persisted into store's outbox along with saga state and dispatched by the relay afterwards
+func NewSagaControlHandler(sagaStore sagaPkg.Store, mutex mutex.Mutex, sagaRegistry scheme.KnownTypesRegistry, sagaUIDSvc sagaPkg.SagaUIDService, relay *outbox.Relay, logger log.Logger) *SagaControlHandler {
+	return &SagaControlHandler{typesRegistry: sagaRegistry, store: sagaStore, mutex: mutex, sagaUIDSvc: sagaUIDSvc, relay: relay, logger: logger}
 }
 
 type SagaControlHandler struct {
@@ -24,13 +25,15 @@ type SagaControlHandler struct {
 	mutex         mutex.Mutex
 	logger        log.Logger
 	sagaUIDSvc    sagaPkg.SagaUIDService
+	relay         *outbox.Relay
 }
 
 func (h SagaControlHandler) Handle(execCtx execution.MessageExecutionCtx) error {
 	var (
-		sagaInstance sagaPkg.Instance
-		sagaCtx      sagaPkg.SagaContext
-		err          error
+		sagaInstance   sagaPkg.Instance
+		sagaCtx        sagaPkg.SagaContext
+		previousStatus string
+		err            error
 	)
 
 	ctx := execCtx.Context()
@@ -47,22 +50,21 @@ func (h SagaControlHandler) Handle(execCtx execution.MessageExecutionCtx) error
 			return errors.Wrapf(err, "saving created saga `%s` with id %s to store", cmd.Saga.GroupKind().String(), cmd.SagaUID)
 		}
 
+		previousStatus = sagaInstance.Status().String()
 		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		h.initSaga(sagaInstance)
 
 		if err := sagaInstance.Start(sagaCtx); err != nil {
 			return errors.Wrapf(err, "starting saga `%s`", sagaInstance.UID())
 		}
 
 	case *contracts.RecoverSagaCommand:
-		if err := h.mutex.Lock(ctx, cmd.SagaUID); err != nil {
+		release, err := h.lock(ctx, cmd.SagaUID)
+		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		defer func() {
-			if err := h.mutex.Release(ctx, cmd.SagaUID); err != nil {
-				h.logger.Log(log.ErrorLevel, err)
-			}
-		}()
+		defer release()
 
 		sagaInstance, err = h.fetchSaga(ctx, cmd.SagaUID)
 
@@ -75,22 +77,21 @@ func (h SagaControlHandler) Handle(execCtx execution.MessageExecutionCtx) error
 			return nil
 		}
 
+		previousStatus = sagaInstance.Status().String()
 		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		h.initSaga(sagaInstance)
 
 		if err := sagaInstance.Recover(sagaCtx); err != nil {
 			return errors.Wrapf(err, "recovering saga `%s`", sagaInstance.UID())
 		}
 
 	case *contracts.CompensateSagaCommand:
-		if err := h.mutex.Lock(ctx, cmd.SagaUID); err != nil {
+		release, err := h.lock(ctx, cmd.SagaUID)
+		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		defer func() {
-			if err := h.mutex.Release(ctx, cmd.SagaUID); err != nil {
-				h.logger.Log(log.ErrorLevel, err)
-			}
-		}()
+		defer release()
 
 		sagaInstance, err = h.fetchSaga(ctx, cmd.SagaUID)
 
@@ -103,30 +104,149 @@ func (h SagaControlHandler) Handle(execCtx execution.MessageExecutionCtx) error
 			return nil
 		}
 
+		previousStatus = sagaInstance.Status().String()
 		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		h.initSaga(sagaInstance)
 
 		if err := sagaInstance.Compensate(sagaCtx); err != nil {
 			return errors.Wrapf(err, "compensating saga `%s`", sagaInstance.UID())
 		}
 
+	case *contracts.CancelSagaCommand:
+		release, err := h.lock(ctx, cmd.SagaUID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		defer release()
+
+		sagaInstance, err = h.fetchSaga(ctx, cmd.SagaUID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if sagaInstance.Status().Completed() || sagaInstance.Status().Compensated() || sagaInstance.Status().Cancelled() || (cmd.Compensate && sagaInstance.Status().Compensating()) {
+			h.logger.Logf(log.InfoLevel, "Saga `%s` has status `%s`, you can't cancel the process", sagaInstance.UID(), sagaInstance.Status())
+			return nil
+		}
+
+		previousStatus = sagaInstance.Status().String()
+		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		h.initSaga(sagaInstance)
+
+		if cmd.Compensate {
+			if err := sagaInstance.Compensate(sagaCtx); err != nil {
+				return errors.Wrapf(err, "compensating cancelled saga `%s`", sagaInstance.UID())
+			}
+		} else {
+			sagaInstance.Cancel()
+		}
+
+	case *contracts.SuspendSagaCommand:
+		release, err := h.lock(ctx, cmd.SagaUID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		defer release()
+
+		sagaInstance, err = h.fetchSaga(ctx, cmd.SagaUID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !sagaInstance.Status().InProgress() && !sagaInstance.Status().Compensating() {
+			h.logger.Logf(log.InfoLevel, "Saga `%s` has status `%s`, you can't suspend the process", sagaInstance.UID(), sagaInstance.Status())
+			return nil
+		}
+
+		previousStatus = sagaInstance.Status().String()
+		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		sagaInstance.Suspend()
+
+	case *contracts.ResumeSagaCommand:
+		release, err := h.lock(ctx, cmd.SagaUID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		defer release()
+
+		sagaInstance, err = h.fetchSaga(ctx, cmd.SagaUID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !sagaInstance.Status().Suspended() {
+			h.logger.Logf(log.InfoLevel, "Saga `%s` has status `%s`, you can't resume the process", sagaInstance.UID(), sagaInstance.Status())
+			return nil
+		}
+
+		previousStatus = sagaInstance.Status().String()
+		sagaCtx = sagaPkg.NewSagaCtx(execCtx, sagaInstance)
+		sagaInstance.Resume()
+
+		//parked events are sent to the saga again in order they were received
+		for _, ev := range unparkEvents(sagaInstance) {
+			sagaCtx.Dispatch(&contracts.ReplayEventCommand{SagaUID: sagaInstance.UID(), Event: ev})
+		}
+
 	default:
-		return errors.Errorf("unknown command type `%s` for SagaControlHandler. Supported: StartSagaCommand, RecoverSagaCommand, CompensateSagaCommand", msg.Payload().GroupKind().String())
+		return errors.Errorf("unknown command type `%s` for SagaControlHandler. Supported: StartSagaCommand, RecoverSagaCommand, CompensateSagaCommand, CancelSagaCommand, SuspendSagaCommand, ResumeSagaCommand", msg.Payload().GroupKind().String())
 	}
 
 	sagaInstance.AddHistoryEvent(msg.Payload(), sagaPkg.WithOrigin(msg.Origin()), sagaPkg.WithTraceUID(msg.UID()))
 
-	for _, delivery := range sagaCtx.Deliveries() {
-		h.sagaUIDSvc.AddSagaId(msg.Headers(), sagaCtx.SagaInstance().UID())
-		outcomingMessage := message.NewOutcomingMessage(delivery.Payload, message.WithHeaders(msg.Headers()))
+	deliveries := pendingDeliveries(h.sagaUIDSvc, msg, sagaInstance.UID(), sagaCtx.Deliveries())
 
-		if err := execCtx.Send(outcomingMessage, delivery.Options...); err != nil {
-			execCtx.LogMessage(log.ErrorLevel, fmt.Sprintf("sending delivery for saga %s. Delivery: (%v). %s", sagaCtx.SagaInstance().UID(), delivery, err))
-			return errors.Wrapf(err, "sending delivery for saga %s. Delivery: (%v)", sagaCtx.SagaInstance().UID(), delivery)
-		}
-		sagaCtx.SagaInstance().AddHistoryEvent(delivery.Payload)
+	for _, delivery := range deliveries {
+		sagaInstance.AddHistoryEvent(delivery.Message.Payload())
+	}
+
+	deliveries = append(deliveries, lifecycleDeliveries(h.sagaUIDSvc, h.relay, msg, sagaInstance, previousStatus, "")...)
+
+	if err := checkReplayRoutes(h.relay, deliveries); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := mutex.CheckLease(h.mutex, sagaInstance.UID()); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := h.store.Update(ctx, sagaInstance, sagaPkg.WithDeliveries(deliveries...)); err != nil {
+		execCtx.LogMessage(log.ErrorLevel, fmt.Sprintf("saving saga %s with its deliveries. %s", sagaInstance.UID(), err))
+		return errors.Wrapf(err, "saving saga %s with its deliveries", sagaInstance.UID())
+	}
+
+	dispatchDeliveries(ctx, h.relay, h.logger, deliveries)
+
+	return nil
+}
+
+// lock locks saga if mutex is configured. Without mutex concurrent modification is detected by saga version on update
+func (h SagaControlHandler) lock(ctx context.Context, sagaUID string) (func(), error) {
+	if h.mutex == nil {
+		return func() {}, nil
 	}
 
-	return h.store.Update(ctx, sagaInstance)
+	if err := h.mutex.Lock(ctx, sagaUID); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := h.mutex.Release(ctx, sagaUID); err != nil {
+			h.logger.Log(log.ErrorLevel, err)
+		}
+	}, nil
+}
+
+// initSaga sets scheme and lets saga declare its handlers and steps
+func (h SagaControlHandler) initSaga(sagaInstance sagaPkg.Instance) {
+	sagaInstance.Saga().SetSchema(h.typesRegistry)
+	sagaInstance.Saga().Init()
 }
 
 //saga is map[string]interface{} on this step
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type invoicePaidEvent struct {
+	message.ObjectMeta
+	OrderID string `json:"order_id"`
+}
+
+type invoiceSaga struct {
+	sagaPkg.BaseSaga
+	OrderID string `json:"order_id"`
+	Paid    int    `json:"paid"`
+}
+
+func (s *invoiceSaga) Init() {
+	s.AddEventHandler(&invoicePaidEvent{}, func(sagaCtx sagaPkg.SagaContext) error {
+		s.Paid++
+		return nil
+	})
+}
+
+func (s *invoiceSaga) Start(sagaCtx sagaPkg.SagaContext) error {
+	sagaCtx.SagaInstance().Correlate("order_id", s.OrderID)
+	return nil
+}
+
+func (s *invoiceSaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *invoiceSaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+func TestSagaEventsHandler_Correlation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("invoices", &invoiceSaga{}, &invoicePaidEvent{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	//external events are received without saga uid in headers, a saga is found by correlation rule
+	start := func(t *testing.T, rule sagaPkg.CorrelationRule) *handlersEnv {
+		correlator := sagaPkg.NewCorrelator(schemeRegistry)
+		require.NoError(t, correlator.AddRule(&invoicePaidEvent{}, rule))
+
+		return newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&contracts.SagaStartedEvent{}}, withHandlerOptions(WithCorrelator(correlator)))
+	}
+
+	t.Run("event is correlated with started saga", func(t *testing.T) {
+		e := start(t, sagaPkg.CorrelationRule{Key: "order_id", Value: sagaPkg.PayloadField("order_id")})
+		require.NoError(t, e.controlHandler.Handle(e.receive("", &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &invoiceSaga{OrderID: "order-1"}})))
+		require.NoError(t, e.eventsHandler.Handle(e.receive("", &invoicePaidEvent{OrderID: "order-1"})))
+
+		instance := e.instance("saga-uid")
+		assert.Equal(t, 1, instance.Saga().(*invoiceSaga).Paid)
+		assert.Equal(t, map[string]string{"order_id": "order-1"}, instance.Correlations())
+	})
+
+	t.Run("saga is created by the first event", func(t *testing.T) {
+		e := start(t, sagaPkg.CorrelationRule{
+			Key:   "order_id",
+			Value: sagaPkg.PayloadField("order_id"),
+			Start: func(ev message.Object) sagaPkg.Saga {
+				return &invoiceSaga{OrderID: ev.(*invoicePaidEvent).OrderID}
+			},
+		})
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("", &invoicePaidEvent{OrderID: "order-2"})))
+		require.NoError(t, e.eventsHandler.Handle(e.receive("", &invoicePaidEvent{OrderID: "order-2"})))
+
+		instance, err := e.store.GetByCorrelation(ctx, "order_id", "order-2")
+		require.NoError(t, err)
+		require.NotNil(t, instance)
+		assert.True(t, instance.Status().InProgress())
+		assert.NotNil(t, instance.StartedAt())
+		assert.Equal(t, 2, instance.Saga().(*invoiceSaga).Paid)
+
+		sagas, err := e.store.GetByFilter(ctx, sagaPkg.WithSagaName("invoices.invoiceSaga"))
+		require.NoError(t, err)
+		assert.Len(t, sagas, 1)
+	})
+
+	t.Run("event without correlated saga is rejected", func(t *testing.T) {
+		e := start(t, sagaPkg.CorrelationRule{Key: "order_id", Value: sagaPkg.PayloadField("order_id")})
+
+		assert.Error(t, e.eventsHandler.Handle(e.receive("", &invoicePaidEvent{OrderID: "order-3"})))
+		assert.Error(t, e.eventsHandler.Handle(e.receive("", &invoicePaidEvent{})))
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+)
+
+// pendingDeliveries builds outgoing messages for saga deliveries. Every message gets own copy of received headers, otherwise they would share uid header
+func pendingDeliveries(sagaUIDSvc sagaPkg.SagaUIDService, received *message.ReceivedMessage, sagaUID string, deliveries []*sagaPkg.Delivery) []sagaPkg.PendingDelivery {
+	pending := make([]sagaPkg.PendingDelivery, 0, len(deliveries))
+
+	for _, delivery := range deliveries {
+		pending = append(pending, sagaPkg.PendingDelivery{
+			Message: newSagaMessage(sagaUIDSvc, received, sagaUID, delivery.Payload),
+			Options: delivery.Options,
+		})
+	}
+
+	return pending
+}
+
+func newSagaMessage(sagaUIDSvc sagaPkg.SagaUIDService, received *message.ReceivedMessage, sagaUID string, payload message.Object) *message.OutcomingMessage {
+	headers := make(message.Headers, len(received.Headers()))
+	for k, v := range received.Headers() {
+		headers[k] = v
+	}
+
+	sagaUIDSvc.AddSagaId(headers, sagaUID)
+
+	return message.NewOutcomingMessage(payload, message.WithHeaders(headers))
+}
+
+// lifecycleDeliveries builds events about a change of saga status and notifies a parent saga when its child completes, fails or is cancelled.
+// They are published along with other deliveries, so they are sent only if the status is persisted. Status events are built only if they are routed,
+// i.e. to an endpoint of component.WithLifecycleEventsEndpoint. failureReason is used by SagaFailedEvent, it describes the event saga failed on if it's empty
+func lifecycleDeliveries(sagaUIDSvc sagaPkg.SagaUIDService, relay *outbox.Relay, received *message.ReceivedMessage, instance sagaPkg.Instance, previousStatus, failureReason string) []sagaPkg.PendingDelivery {
+	var (
+		events []message.Object
+		//parent saga is notified about completion or failure of its child
+		parentEvents []message.Object
+		status       = instance.Status()
+		name         = instance.Saga().GroupKind().String()
+	)
+
+	if status.String() == previousStatus {
+		return nil
+	}
+
+	if previousStatus == sagaPkg.StatusCreated {
+		events = append(events, &contracts.SagaStartedEvent{SagaUID: instance.UID(), SagaName: name, ParentUID: instance.ParentID(), PreviousStatus: previousStatus, Status: status.String()})
+	}
+
+	switch {
+	case status.Completed():
+		events = append(events, &contracts.SagaCompletedEvent{SagaUID: instance.UID(), SagaName: name, ParentUID: instance.ParentID(), PreviousStatus: previousStatus, Status: status.String()})
+
+		if instance.ParentID() != "" {
+			parentEvents = append(parentEvents, &contracts.SagaChildCompletedEvent{SagaUID: instance.UID()})
+		}
+	case status.Failed():
+		reason := failureReason
+		if failedOn := status.FailedOnEvent(); reason == "" && failedOn != nil {
+			reason = fmt.Sprintf("failed on event %s", failedOn.GroupKind().String())
+		}
+
+		events = append(events, &contracts.SagaFailedEvent{SagaUID: instance.UID(), SagaName: name, ParentUID: instance.ParentID(), PreviousStatus: previousStatus, Status: status.String(), Reason: reason})
+
+		if instance.ParentID() != "" {
+			parentEvents = append(parentEvents, &contracts.SagaChildFailedEvent{SagaUID: instance.UID(), Reason: reason})
+		}
+	case status.Compensated():
+		events = append(events, &contracts.SagaCompensatedEvent{SagaUID: instance.UID(), SagaName: name, ParentUID: instance.ParentID(), PreviousStatus: previousStatus, Status: status.String()})
+	case status.Cancelled():
+		events = append(events, &contracts.SagaCancelledEvent{SagaUID: instance.UID(), SagaName: name, ParentUID: instance.ParentID(), PreviousStatus: previousStatus, Status: status.String()})
+
+		//a parent waiting for its children must not wait for a cancelled one forever
+		if instance.ParentID() != "" {
+			parentEvents = append(parentEvents, &contracts.SagaChildFailedEvent{SagaUID: instance.UID(), Reason: "cancelled"})
+		}
+	}
+
+	deliveries := make([]sagaPkg.PendingDelivery, 0, len(events)+len(parentEvents))
+
+	for _, ev := range events {
+		if relay.Routed(ev) {
+			deliveries = append(deliveries, sagaPkg.PendingDelivery{Message: newLifecycleMessage(sagaUIDSvc, received, ev)})
+		}
+	}
+
+	for _, ev := range parentEvents {
+		deliveries = append(deliveries, sagaPkg.PendingDelivery{Message: newSagaMessage(sagaUIDSvc, received, instance.ParentID(), ev)})
+	}
+
+	return deliveries
+}
+
+// newLifecycleMessage builds a status event for subscribers outside of the saga, it keeps received headers except saga uid,
+// so the event isn't taken for an event of the saga
+func newLifecycleMessage(sagaUIDSvc sagaPkg.SagaUIDService, received *message.ReceivedMessage, payload message.Object) *message.OutcomingMessage {
+	sagaHeaders := make(message.Headers)
+	sagaUIDSvc.AddSagaId(sagaHeaders, "saga")
+
+	headers := make(message.Headers, len(received.Headers()))
+	for k, v := range received.Headers() {
+		if _, isSagaHeader := sagaHeaders[k]; !isSagaHeader {
+			headers[k] = v
+		}
+	}
+
+	return message.NewOutcomingMessage(payload, message.WithHeaders(headers))
+}
+
+// checkReplayRoutes fails when replayed events can't reach the saga, the relay would drop them and they would be lost
+func checkReplayRoutes(relay *outbox.Relay, deliveries []sagaPkg.PendingDelivery) error {
+	for _, delivery := range deliveries {
+		if replay, ok := delivery.Message.Payload().(*contracts.ReplayEventCommand); ok && !relay.Routed(replay) {
+			return errors.Errorf("events of saga %s can't be replayed, no endpoint is registered for ReplayEventCommand. Register saga endpoints with component.RegisterSagaEndpoints", replay.SagaUID)
+		}
+	}
+
+	return nil
+}
+
+// dispatchDeliveries sends out deliveries which were persisted along with saga state.
+// An error is only logged, relay retries sending them when it's running
+func dispatchDeliveries(ctx context.Context, relay *outbox.Relay, logger log.Logger, deliveries []sagaPkg.PendingDelivery) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	uids := make([]string, len(deliveries))
+	for i, delivery := range deliveries {
+		uids[i] = delivery.Message.UID()
+	}
+
+	if _, err := relay.Dispatch(ctx, uids...); err != nil {
+		logger.Logf(log.ErrorLevel, "error dispatching saga deliveries %v, they will be retried by outbox relay. %s", uids, err)
+	}
+}
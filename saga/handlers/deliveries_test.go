@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderSaga struct {
+	sagaPkg.BaseSaga
+}
+
+func (s *orderSaga) Init()                                        {}
+func (s *orderSaga) Start(sagaCtx sagaPkg.SagaContext) error      { return nil }
+func (s *orderSaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *orderSaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+type paymentFailedEvent struct {
+	message.ObjectMeta
+}
+
+func TestLifecycleDeliveries(t *testing.T) {
+	uidSvc := sagaPkg.NewSagaUIDService()
+	received := message.NewReceivedMessage("msg-uid", &paymentFailedEvent{}, message.Headers{}, time.Now(), "orders")
+	uidSvc.AddSagaId(received.Headers(), "saga-uid")
+
+	store := sagaPkg.NewMemoryStore(message.NewJsonMarshaller(scheme.NewKnownTypesRegistry()))
+	router := endpoint.NewRouter()
+	router.RegisterEndpoint(&endpointStub{}, &contracts.SagaStartedEvent{}, &contracts.SagaCompletedEvent{}, &contracts.SagaFailedEvent{}, &contracts.SagaCompensatedEvent{})
+	relay := outbox.NewRelay(store.Outbox(), router, log.NewNilLogger())
+
+	newInstance := func() sagaPkg.Instance {
+		s := &orderSaga{}
+		s.SetGroupKind(&scheme.GroupKind{Group: "orders", Kind: "orderSaga"})
+		return sagaPkg.NewSagaInstance("saga-uid", "parent-uid", s)
+	}
+
+	t.Run("started saga", func(t *testing.T) {
+		instance := newInstance()
+		previous := instance.Status().String()
+		require.NoError(t, instance.Start(nil))
+
+		deliveries := lifecycleDeliveries(uidSvc, relay, received, instance, previous, "")
+		require.Len(t, deliveries, 1)
+
+		ev, ok := deliveries[0].Message.Payload().(*contracts.SagaStartedEvent)
+		require.True(t, ok)
+		assert.Equal(t, contracts.SagaStartedEvent{SagaUID: "saga-uid", SagaName: "orders.orderSaga", ParentUID: "parent-uid", PreviousStatus: "created", Status: "in_progress"}, *ev)
+
+		_, err := uidSvc.ExtractSagaUID(deliveries[0].Message.Headers())
+		assert.Error(t, err, "status event is not addressed to the saga")
+	})
+
+	t.Run("saga completed right after start", func(t *testing.T) {
+		instance := newInstance()
+		previous := instance.Status().String()
+		require.NoError(t, instance.Start(nil))
+		instance.Complete()
+
+		deliveries := lifecycleDeliveries(uidSvc, relay, received, instance, previous, "")
+		require.Len(t, deliveries, 3)
+		assert.IsType(t, &contracts.SagaStartedEvent{}, deliveries[0].Message.Payload())
+		assert.IsType(t, &contracts.SagaCompletedEvent{}, deliveries[1].Message.Payload())
+		assert.Equal(t, &contracts.SagaChildCompletedEvent{SagaUID: "saga-uid"}, deliveries[2].Message.Payload())
+
+		parentUID, err := uidSvc.ExtractSagaUID(deliveries[2].Message.Headers())
+		require.NoError(t, err)
+		assert.Equal(t, "parent-uid", parentUID, "child completion is sent to parent")
+	})
+
+	t.Run("failed saga", func(t *testing.T) {
+		instance := newInstance()
+		require.NoError(t, instance.Start(nil))
+		previous := instance.Status().String()
+
+		failedOn := &paymentFailedEvent{}
+		failedOn.SetGroupKind(&scheme.GroupKind{Group: "orders", Kind: "paymentFailedEvent"})
+		instance.Fail(failedOn)
+
+		deliveries := lifecycleDeliveries(uidSvc, relay, received, instance, previous, "")
+		require.Len(t, deliveries, 2)
+
+		ev, ok := deliveries[0].Message.Payload().(*contracts.SagaFailedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "in_progress", ev.PreviousStatus)
+		assert.Equal(t, "failed", ev.Status)
+		assert.Equal(t, "failed on event orders.paymentFailedEvent", ev.Reason)
+		assert.Equal(t, &contracts.SagaChildFailedEvent{SagaUID: "saga-uid", Reason: ev.Reason}, deliveries[1].Message.Payload())
+	})
+
+	t.Run("compensated saga", func(t *testing.T) {
+		instance := newInstance()
+		require.NoError(t, instance.Compensate(nil))
+		previous := instance.Status().String()
+		instance.CompleteCompensation()
+
+		deliveries := lifecycleDeliveries(uidSvc, relay, received, instance, previous, "")
+		require.Len(t, deliveries, 1)
+		assert.IsType(t, &contracts.SagaCompensatedEvent{}, deliveries[0].Message.Payload())
+	})
+
+	t.Run("status events without route", func(t *testing.T) {
+		instance := newInstance()
+		require.NoError(t, instance.Start(nil))
+		previous := instance.Status().String()
+		instance.Cancel()
+
+		deliveries := lifecycleDeliveries(uidSvc, relay, received, instance, previous, "")
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, &contracts.SagaChildFailedEvent{SagaUID: "saga-uid", Reason: "cancelled"}, deliveries[0].Message.Payload())
+	})
+
+	t.Run("status is not changed", func(t *testing.T) {
+		instance := newInstance()
+		require.NoError(t, instance.Start(nil))
+
+		assert.Empty(t, lifecycleDeliveries(uidSvc, relay, received, instance, instance.Status().String(), ""))
+	})
+}
@@ -12,35 +12,141 @@ import (
 
 	"github.com/go-foreman/foreman/pubsub/message"
 	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/go-foreman/foreman/runtime/scheme"
 	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
+const defaultConflictRetries = 5
+
+// FailureAction is taken when a saga event handler keeps returning an error after all retries
+type FailureAction string
+
+const (
+	// FailureActionManual leaves a failed saga until RecoverSagaCommand or CompensateSagaCommand is sent
+	FailureActionManual FailureAction = "manual"
+	// FailureActionCompensate sends CompensateSagaCommand to a failed saga, unless it failed while compensating
+	FailureActionCompensate FailureAction = "compensate"
+)
+
+// FailurePolicy specifies what happens when a saga event handler returns an error.
+// The event is handled again Retries times on a freshly loaded saga, then the saga is marked failed on the event and Action is taken
+type FailurePolicy struct {
+	Retries    int
+	RetryDelay time.Duration
+	Action     FailureAction
+}
+
+// EventsHandlerOption allows to configure SagaEventsHandler
+type EventsHandlerOption func(h *SagaEventsHandler)
+
+// WithFailurePolicy replaces default policy which marks a saga failed right away and waits for manual recovery
+func WithFailurePolicy(policy FailurePolicy) EventsHandlerOption {
+	return func(h *SagaEventsHandler) {
+		h.failurePolicy = policy
+	}
+}
+
+// WithCorrelator allows to handle events which have no saga uid in headers, a saga is found by correlation rule of the event
+func WithCorrelator(correlator *sagaPkg.Correlator) EventsHandlerOption {
+	return func(h *SagaEventsHandler) {
+		h.correlator = correlator
+	}
+}
+
+// WithConflictRetries specifies how many times an event is handled again on a freshly loaded saga when it was modified concurrently
+func WithConflictRetries(retries int) EventsHandlerOption {
+	return func(h *SagaEventsHandler) {
+		h.conflictRetries = retries
+	}
+}
+
 type SagaEventsHandler struct {
-	sagaStore  sagaPkg.Store
-	sagaUIDSvc sagaPkg.SagaUIDService
-	scheme     scheme.KnownTypesRegistry
-	mutex      sagaMutex.Mutex
-	logger     log.Logger
+	sagaStore       sagaPkg.Store
+	sagaUIDSvc      sagaPkg.SagaUIDService
+	scheme          scheme.KnownTypesRegistry
+	mutex           sagaMutex.Mutex
+	relay           *outbox.Relay
+	logger          log.Logger
+	conflictRetries int
+	failurePolicy   FailurePolicy
+	correlator      *sagaPkg.Correlator
+}
+
+// handlerError is returned by saga event handler, failure policy is applied to it
+type handlerError struct {
+	error
 }
 
-func NewEventsHandler(sagaStore sagaPkg.Store, mutex sagaMutex.Mutex, scheme scheme.KnownTypesRegistry, extractor sagaPkg.SagaUIDService, logger log.Logger) *SagaEventsHandler {
-	return &SagaEventsHandler{sagaStore: sagaStore, sagaUIDSvc: extractor, scheme: scheme, mutex: mutex, logger: logger}
+func (e handlerError) Unwrap() error {
+	return e.error
+}
+
+// NewEventsHandler creates SagaEventsHandler. Deliveries are persisted into store's outbox along with saga state and dispatched by the relay afterwards.
+// Mutex is optional, without it concurrent updates of a saga are detected by its version and the event is handled again
+func NewEventsHandler(sagaStore sagaPkg.Store, mutex sagaMutex.Mutex, scheme scheme.KnownTypesRegistry, extractor sagaPkg.SagaUIDService, relay *outbox.Relay, logger log.Logger, opts ...EventsHandlerOption) *SagaEventsHandler {
+	h := &SagaEventsHandler{sagaStore: sagaStore, sagaUIDSvc: extractor, scheme: scheme, mutex: mutex, relay: relay, logger: logger, conflictRetries: defaultConflictRetries, failurePolicy: FailurePolicy{Action: FailureActionManual}}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
 }
 
 func (e SagaEventsHandler) Handle(execCtx execution.MessageExecutionCtx) error {
+	//a replayed event is handled as if it was received itself
+	if replay, ok := execCtx.Message().Payload().(*contracts.ReplayEventCommand); ok {
+		received := execCtx.Message()
+		execCtx = execution.WithMessage(execCtx, message.NewReceivedMessage(received.UID(), replay.Event, received.Headers(), received.ReceivedAt(), received.Origin()))
+	}
+
 	msg := execCtx.Message()
 	ctx := execCtx.Context()
-	msgGK := msg.Payload().GroupKind().String()
 
-	sagaId, err := e.sagaUIDSvc.ExtractSagaUID(msg.Headers())
+	sagaId, err := e.resolveSagaUID(ctx, msg)
 
 	if err != nil {
-		return errors.Wrapf(err, "extracting saga id from message %s", msg.UID())
+		return errors.WithStack(err)
+	}
+
+	var conflicts, failures int
+
+	for {
+		err := e.locked(ctx, sagaId, func() error {
+			return e.handle(execCtx, sagaId)
+		})
+
+		switch {
+		case err == nil:
+			return nil
+		case sagaPkg.IsVersionConflict(err) && conflicts < e.conflictRetries:
+			conflicts++
+			e.logger.Logf(log.WarnLevel, "saga %s was modified concurrently while handling message %s, handling it again. Attempt %d", sagaId, msg.UID(), conflicts)
+		case errors.As(err, &handlerError{}) && failures < e.failurePolicy.Retries:
+			failures++
+			e.logger.Logf(log.WarnLevel, "saga %s failed to handle message %s, handling it again. Attempt %d. %s", sagaId, msg.UID(), failures, err)
+
+			//the saga is unlocked while waiting, so its other events aren't blocked by the retry delay
+			if err := sleep(ctx, e.failurePolicy.RetryDelay); err != nil {
+				return errors.WithStack(err)
+			}
+		case errors.As(err, &handlerError{}):
+			return e.markFailed(execCtx, sagaId, err)
+		default:
+			return err
+		}
+	}
+}
+
+// locked runs fn holding a lock of the saga, so nobody can process events for this saga in another consumer's replicas
+func (e SagaEventsHandler) locked(ctx context.Context, sagaId string, fn func() error) error {
+	if e.mutex == nil {
+		return fn()
 	}
 
-	//lock saga so nobody can process events for this saga in another consumer's replicas
 	if err := e.mutex.Lock(ctx, sagaId); err != nil {
 		return errors.WithStack(err)
 	}
@@ -54,6 +160,73 @@ func (e SagaEventsHandler) Handle(execCtx execution.MessageExecutionCtx) error {
 		}
 	}()
 
+	return fn()
+}
+
+// markFailed persists a saga as failed on received event, handler's changes of the saga and its deliveries are discarded.
+// The saga is loaded again when it was modified concurrently
+func (e SagaEventsHandler) markFailed(execCtx execution.MessageExecutionCtx, sagaId string, handlerErr error) error {
+	for conflicts := 0; ; conflicts++ {
+		err := e.locked(execCtx.Context(), sagaId, func() error {
+			return e.fail(execCtx, sagaId, handlerErr)
+		})
+
+		if err == nil || !sagaPkg.IsVersionConflict(err) || conflicts >= e.conflictRetries {
+			return err
+		}
+
+		e.logger.Logf(log.WarnLevel, "saga %s was modified concurrently while marking it failed, marking it again. Attempt %d", sagaId, conflicts+1)
+	}
+}
+
+func (e SagaEventsHandler) fail(execCtx execution.MessageExecutionCtx, sagaId string, handlerErr error) error {
+	msg := execCtx.Message()
+	ctx := execCtx.Context()
+
+	sagaInstance, err := e.sagaStore.GetById(ctx, sagaId)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error retrieving saga %s from store", sagaId)
+	}
+
+	if sagaInstance == nil {
+		return errors.Errorf("Saga %s not found", sagaId)
+	}
+
+	previousStatus := sagaInstance.Status().String()
+
+	sagaInstance.AddHistoryEvent(msg.Payload(), sagaPkg.WithOrigin(msg.Origin()), sagaPkg.WithTraceUID(msg.UID()))
+	sagaInstance.Fail(msg.Payload())
+
+	deliveries := lifecycleDeliveries(e.sagaUIDSvc, e.relay, msg, sagaInstance, previousStatus, handlerErr.Error())
+
+	//compensation isn't started again if it's compensation that failed, otherwise it would never end
+	if e.failurePolicy.Action == FailureActionCompensate && previousStatus != sagaPkg.StatusCompensating {
+		deliveries = append(deliveries, sagaPkg.PendingDelivery{
+			Message: newSagaMessage(e.sagaUIDSvc, msg, sagaInstance.UID(), &contracts.CompensateSagaCommand{SagaUID: sagaInstance.UID()}),
+		})
+	}
+
+	if err := sagaMutex.CheckLease(e.mutex, sagaId); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := e.sagaStore.Update(ctx, sagaInstance, sagaPkg.WithDeliveries(deliveries...)); err != nil {
+		return errors.Wrapf(err, "saving failed saga %s", sagaInstance.UID())
+	}
+
+	e.logger.Logf(log.ErrorLevel, "saga %s is marked failed on message %s, failure action `%s`. %s", sagaInstance.UID(), msg.UID(), e.failurePolicy.Action, handlerErr)
+
+	dispatchDeliveries(ctx, e.relay, e.logger, deliveries)
+
+	return nil
+}
+
+func (e SagaEventsHandler) handle(execCtx execution.MessageExecutionCtx, sagaId string) error {
+	msg := execCtx.Message()
+	ctx := execCtx.Context()
+	msgGK := msg.Payload().GroupKind().String()
+
 	sagaInstance, err := e.sagaStore.GetById(ctx, sagaId)
 
 	if err != nil {
@@ -68,28 +241,55 @@ func (e SagaEventsHandler) Handle(execCtx execution.MessageExecutionCtx) error {
 		return errors.Errorf("Saga %s already completed", sagaId)
 	}
 
+	if sagaInstance.Status().Compensated() {
+		return errors.Errorf("Saga %s already compensated", sagaId)
+	}
+
+	if sagaInstance.Status().Cancelled() {
+		return errors.Errorf("Saga %s already cancelled", sagaId)
+	}
+
+	//events of a suspended saga are kept until it's resumed
+	if sagaInstance.Status().Suspended() {
+		parkEvent(sagaInstance, msg.Payload())
+
+		if err := sagaMutex.CheckLease(e.mutex, sagaId); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := e.sagaStore.Update(ctx, sagaInstance); err != nil {
+			return errors.Wrapf(err, "parking event %s from message %s for suspended saga %s", msgGK, msg.UID(), sagaInstance.UID())
+		}
+
+		execCtx.LogMessage(log.InfoLevel, fmt.Sprintf("saga %s is suspended, event %s from message %s is parked", sagaInstance.UID(), msgGK, msg.UID()))
+
+		return nil
+	}
+
+	previousStatus := sagaInstance.Status().String()
+
 	saga := sagaInstance.Saga()
 	saga.SetSchema(e.scheme)
 	saga.Init()
 
 	sagaCtx := sagaPkg.NewSagaCtx(execCtx, sagaInstance)
-	sagaInstance.Progress()
+
+	if previousStatus == sagaPkg.StatusCreated {
+		//a saga created by correlated event is started before it handles the event
+		if err := sagaInstance.Start(sagaCtx); err != nil {
+			execCtx.LogMessage(log.ErrorLevel, fmt.Sprintf("error starting saga on event %s from message %s: %s", msgGK, msg.UID(), err))
+			return handlerError{errors.Wrapf(err, "starting saga on event %s from message %s", msgGK, msg.UID())}
+		}
+	} else if !sagaInstance.Status().Compensating() {
+		//a compensating saga stays compensating while it receives acknowledgements of compensations
+		sagaInstance.Progress()
+	}
 
 	if handler, exists := saga.EventHandlers()[msg.Payload().GroupKind()]; exists {
 
 		if err := handler(sagaCtx); err != nil {
 			execCtx.LogMessage(log.ErrorLevel, fmt.Sprintf("error handling saga event %s from message %s: %s", msgGK, msg.UID(), err))
-			return errors.Wrapf(err, "handling event %s from message %s", msgGK, msg.UID())
-		}
-
-		for _, delivery := range sagaCtx.Deliveries() {
-			e.sagaUIDSvc.AddSagaId(execCtx.Message().Headers(), sagaInstance.UID())
-			outcomingMsg := message.NewOutcomingMessage(delivery.Payload, message.WithHeaders(execCtx.Message().Headers()))
-
-			if err := execCtx.Send(outcomingMsg, delivery.Options...); err != nil {
-				execCtx.LogMessage(log.ErrorLevel, fmt.Sprintf("error sending delivery for saga %s. Delivery: (%v). %s", sagaCtx.SagaInstance().UID(), delivery, err))
-				return errors.Wrapf(err, "sending delivery for saga %s. Delivery: (%v)", sagaCtx.SagaInstance().UID(), delivery)
-			}
+			return handlerError{errors.Wrapf(err, "handling event %s from message %s", msgGK, msg.UID())}
 		}
 	} else {
 		e.logger.Logf(log.WarnLevel, "no handler defined for event %s from message %s", msgGK, msg.UID())
@@ -98,24 +298,100 @@ func (e SagaEventsHandler) Handle(execCtx execution.MessageExecutionCtx) error {
 	//write received event into history
 	sagaInstance.AddHistoryEvent(msg.Payload(), sagaPkg.WithOrigin(msg.Origin()), sagaPkg.WithTraceUID(msg.UID()))
 
+	deliveries := pendingDeliveries(e.sagaUIDSvc, msg, sagaInstance.UID(), sagaCtx.Deliveries())
+
 	//just to remember what we sent out
-	for _, ev := range sagaCtx.Deliveries() {
-		sagaInstance.AddHistoryEvent(ev.Payload)
+	for _, delivery := range deliveries {
+		sagaInstance.AddHistoryEvent(delivery.Message.Payload())
+	}
+
+	deliveries = append(deliveries, lifecycleDeliveries(e.sagaUIDSvc, e.relay, msg, sagaInstance, previousStatus, "")...)
+
+	if err := checkReplayRoutes(e.relay, deliveries); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := sagaMutex.CheckLease(e.mutex, sagaId); err != nil {
+		return errors.WithStack(err)
 	}
 
-	if err := e.sagaStore.Update(ctx, sagaInstance); err != nil {
+	if err := e.sagaStore.Update(ctx, sagaInstance, sagaPkg.WithDeliveries(deliveries...)); err != nil {
 		return errors.Wrapf(err, "error saving saga's %s state to db", sagaInstance.UID())
 	}
 
-	//sending an event about saga completion to parent if it exists and to all regular handlers.
-	if sagaInstance.Status().Completed() {
-		//if parent exists - we should forward this event to parent saga
-		if sagaInstance.ParentID() != "" {
-			e.sagaUIDSvc.AddSagaId(execCtx.Message().Headers(), sagaInstance.ParentID())
+	dispatchDeliveries(ctx, e.relay, e.logger, deliveries)
+
+	return nil
+}
+
+// resolveSagaUID takes saga uid from message headers. A message without it is correlated with a saga by correlation rule of its event,
+// the saga is created if the rule allows it
+func (e SagaEventsHandler) resolveSagaUID(ctx context.Context, msg *message.ReceivedMessage) (string, error) {
+	sagaId, err := e.sagaUIDSvc.ExtractSagaUID(msg.Headers())
+
+	if err == nil {
+		return sagaId, nil
+	}
+
+	var (
+		rule   sagaPkg.CorrelationRule
+		exists bool
+	)
+
+	if e.correlator != nil {
+		rule, exists = e.correlator.Rule(msg.Payload().GroupKind())
+	}
+
+	if !exists {
+		return "", errors.Wrapf(err, "extracting saga id from message %s", msg.UID())
+	}
+
+	value, err := rule.Value(msg.Payload())
+
+	if err != nil {
+		return "", errors.Wrapf(err, "extracting correlation value %s from message %s", rule.Key, msg.UID())
+	}
 
-			return execCtx.Send(message.NewOutcomingMessage(&contracts.SagaChildCompletedEvent{SagaUID: sagaInstance.UID()}, message.WithHeaders(execCtx.Message().Headers())))
+	sagaInstance, err := e.sagaStore.GetByCorrelation(ctx, rule.Key, value)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "retrieving saga correlated with message %s by %s=%s", msg.UID(), rule.Key, value)
+	}
+
+	if sagaInstance != nil {
+		return sagaInstance.UID(), nil
+	}
+
+	if rule.Start == nil {
+		return "", errors.Errorf("no saga is correlated with message %s by %s=%s", msg.UID(), rule.Key, value)
+	}
+
+	sagaInstance = sagaPkg.NewSagaInstance(uuid.New().String(), "", rule.Start(msg.Payload()))
+	sagaInstance.Correlate(rule.Key, value)
+
+	if err := e.sagaStore.Create(ctx, sagaInstance); err != nil {
+		//a concurrent event with the same correlation value could create the saga first
+		if existing, getErr := e.sagaStore.GetByCorrelation(ctx, rule.Key, value); getErr == nil && existing != nil {
+			return existing.UID(), nil
 		}
+
+		return "", errors.Wrapf(err, "creating saga correlated with message %s by %s=%s", msg.UID(), rule.Key, value)
 	}
 
-	return nil
+	e.logger.Logf(log.InfoLevel, "saga %s is created for message %s correlated by %s=%s", sagaInstance.UID(), msg.UID(), rule.Key, value)
+
+	return sagaInstance.UID(), nil
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
 }
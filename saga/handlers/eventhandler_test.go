@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	sagaMutex "github.com/go-foreman/foreman/saga/mutex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// paymentProvider fails to charge a payment given number of times before it succeeds
+type paymentProvider struct {
+	failures int
+	calls    int
+}
+
+type paymentSaga struct {
+	sagaPkg.BaseSaga
+	provider *paymentProvider
+}
+
+func (s *paymentSaga) Init() {
+	s.AddEventHandler(&paymentFailedEvent{}, func(sagaCtx sagaPkg.SagaContext) error {
+		s.provider.calls++
+		sagaCtx.Dispatch(&paymentFailedEvent{})
+
+		if s.provider.calls <= s.provider.failures {
+			return errors.New("payment provider is down")
+		}
+
+		return nil
+	})
+}
+
+func (s *paymentSaga) Start(sagaCtx sagaPkg.SagaContext) error      { return nil }
+func (s *paymentSaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *paymentSaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+// paymentStore gives loaded payment sagas the provider of a test
+type paymentStore struct {
+	sagaPkg.Store
+	provider *paymentProvider
+}
+
+func (s *paymentStore) GetById(ctx context.Context, sagaId string) (sagaPkg.Instance, error) {
+	instance, err := s.Store.GetById(ctx, sagaId)
+
+	if instance != nil {
+		instance.Saga().(*paymentSaga).provider = s.provider
+	}
+
+	return instance, err
+}
+
+type endpointStub struct {
+	sent []*message.OutcomingMessage
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func (e *endpointStub) payloads() []message.Object {
+	res := make([]message.Object, len(e.sent))
+	for i, msg := range e.sent {
+		res[i] = msg.Payload()
+	}
+	return res
+}
+
+// handlersEnv is saga handlers wired with a store and a relay which sends routed messages to endpointStub
+type handlersEnv struct {
+	t              *testing.T
+	ctx            context.Context
+	scheme         scheme.KnownTypesRegistry
+	uidSvc         sagaPkg.SagaUIDService
+	store          sagaPkg.Store
+	stub           *endpointStub
+	router         endpoint.Router
+	controlHandler *SagaControlHandler
+	eventsHandler  *SagaEventsHandler
+}
+
+type envOptions struct {
+	store       sagaPkg.Store
+	mutex       sagaMutex.Mutex
+	handlerOpts []EventsHandlerOption
+}
+
+type envOption func(o *envOptions)
+
+func withStore(store sagaPkg.Store) envOption {
+	return func(o *envOptions) {
+		o.store = store
+	}
+}
+
+func withMutex(mutex sagaMutex.Mutex) envOption {
+	return func(o *envOptions) {
+		o.mutex = mutex
+	}
+}
+
+func withHandlerOptions(opts ...EventsHandlerOption) envOption {
+	return func(o *envOptions) {
+		o.handlerOpts = append(o.handlerOpts, opts...)
+	}
+}
+
+// newHandlersEnv creates saga handlers with a memory store unless withStore is passed, routed messages are sent to env's stub
+func newHandlersEnv(t *testing.T, ctx context.Context, schemeRegistry scheme.KnownTypesRegistry, routed []message.Object, opts ...envOption) *handlersEnv {
+	o := &envOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.store == nil {
+		o.store = sagaPkg.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+	}
+
+	uidSvc := sagaPkg.NewSagaUIDService()
+	stub := &endpointStub{}
+	router := endpoint.NewRouter()
+	router.RegisterEndpoint(stub, routed...)
+	relay := outbox.NewRelay(o.store.Outbox(), router, log.NewNilLogger())
+
+	return &handlersEnv{
+		t:              t,
+		ctx:            ctx,
+		scheme:         schemeRegistry,
+		uidSvc:         uidSvc,
+		store:          o.store,
+		stub:           stub,
+		router:         router,
+		controlHandler: NewSagaControlHandler(o.store, o.mutex, schemeRegistry, uidSvc, relay, log.NewNilLogger()),
+		eventsHandler:  NewEventsHandler(o.store, o.mutex, schemeRegistry, uidSvc, relay, log.NewNilLogger(), o.handlerOpts...),
+	}
+}
+
+// receive creates execution context of a message addressed to the saga, the message has no saga uid in headers if sagaUID is empty
+func (e *handlersEnv) receive(sagaUID string, payload message.Object) execution.MessageExecutionCtx {
+	//decoder sets group kind of received payload
+	gk, err := e.scheme.ObjectKind(payload)
+	require.NoError(e.t, err)
+	payload.SetGroupKind(gk)
+
+	headers := message.Headers{}
+	if sagaUID != "" {
+		e.uidSvc.AddSagaId(headers, sagaUID)
+	}
+
+	return e.execCtx(message.NewReceivedMessage("msg-uid", payload, headers, time.Now(), string(gk.Group)))
+}
+
+// receiveSent decodes a sent message as it's received by a subscriber
+func (e *handlersEnv) receiveSent(sent *message.OutcomingMessage) execution.MessageExecutionCtx {
+	marshaller := message.NewJsonMarshaller(e.scheme)
+
+	data, err := marshaller.Marshal(sent.Payload())
+	require.NoError(e.t, err)
+	payload, err := marshaller.Unmarshal(data)
+	require.NoError(e.t, err)
+
+	return e.execCtx(message.NewReceivedMessage(sent.UID(), payload, sent.Headers(), time.Now(), string(payload.GroupKind().Group)))
+}
+
+func (e *handlersEnv) execCtx(msg *message.ReceivedMessage) execution.MessageExecutionCtx {
+	return execution.NewMessageExecutionCtxFactory(e.router, log.NewNilLogger()).CreateCtx(e.ctx, nil, msg)
+}
+
+func (e *handlersEnv) instance(sagaUID string) sagaPkg.Instance {
+	instance, err := e.store.GetById(e.ctx, sagaUID)
+	require.NoError(e.t, err)
+	return instance
+}
+
+func TestSagaEventsHandler_FailurePolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("payments", &paymentSaga{}, &paymentFailedEvent{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	newStore := func(provider *paymentProvider) sagaPkg.Store {
+		return &paymentStore{Store: sagaPkg.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry)), provider: provider}
+	}
+
+	//a store passed in opts replaces the default one
+	handle := func(t *testing.T, provider *paymentProvider, opts ...envOption) (sagaPkg.Instance, *endpointStub, error) {
+		opts = append([]envOption{withStore(newStore(provider))}, opts...)
+
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&paymentFailedEvent{}, &contracts.SagaFailedEvent{}, &contracts.CompensateSagaCommand{}}, opts...)
+
+		instance := sagaPkg.NewSagaInstance("saga-uid", "", &paymentSaga{})
+		require.NoError(t, instance.Start(nil))
+		require.NoError(t, e.store.Create(ctx, instance))
+
+		err := e.eventsHandler.Handle(e.receive(instance.UID(), &paymentFailedEvent{}))
+
+		return e.instance(instance.UID()), e.stub, err
+	}
+
+	t.Run("failed saga waits for manual recovery", func(t *testing.T) {
+		provider := &paymentProvider{failures: 1}
+		instance, stub, err := handle(t, provider)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, provider.calls)
+		assert.True(t, instance.Status().Failed())
+		assert.IsType(t, &paymentFailedEvent{}, instance.Status().FailedOnEvent())
+
+		require.Len(t, stub.sent, 1, "deliveries of failed handler are discarded")
+		failedEv, ok := stub.sent[0].Payload().(*contracts.SagaFailedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "in_progress", failedEv.PreviousStatus)
+		assert.Contains(t, failedEv.Reason, "payment provider is down")
+	})
+
+	t.Run("event is retried before saga is marked failed", func(t *testing.T) {
+		provider := &paymentProvider{failures: 3}
+		instance, stub, err := handle(t, provider, withHandlerOptions(WithFailurePolicy(FailurePolicy{Retries: 2, Action: FailureActionManual})))
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, provider.calls)
+		assert.True(t, instance.Status().Failed())
+		assert.Len(t, stub.sent, 1)
+	})
+
+	t.Run("retried event succeeds", func(t *testing.T) {
+		provider := &paymentProvider{failures: 1}
+		instance, stub, err := handle(t, provider, withHandlerOptions(WithFailurePolicy(FailurePolicy{Retries: 2, RetryDelay: time.Millisecond})))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, provider.calls)
+		assert.True(t, instance.Status().InProgress())
+		require.Len(t, stub.sent, 1)
+		assert.IsType(t, &paymentFailedEvent{}, stub.sent[0].Payload())
+	})
+
+	t.Run("failed saga is compensated", func(t *testing.T) {
+		instance, stub, err := handle(t, &paymentProvider{failures: 1}, withHandlerOptions(WithFailurePolicy(FailurePolicy{Action: FailureActionCompensate})))
+		require.NoError(t, err)
+
+		assert.True(t, instance.Status().Failed())
+		require.Len(t, stub.sent, 2)
+		assert.IsType(t, &contracts.SagaFailedEvent{}, stub.sent[0].Payload())
+		assert.Equal(t, &contracts.CompensateSagaCommand{SagaUID: instance.UID()}, stub.payloads()[1])
+	})
+	t.Run("saga is unlocked between retries", func(t *testing.T) {
+		mutex := &mutexStub{}
+		instance, _, err := handle(t, &paymentProvider{failures: 3}, withMutex(mutex), withHandlerOptions(WithFailurePolicy(FailurePolicy{Retries: 2, RetryDelay: time.Millisecond})))
+		require.NoError(t, err)
+
+		assert.True(t, instance.Status().Failed())
+		//every attempt and marking the saga failed take the lock separately
+		assert.Equal(t, []string{"lock", "release", "lock", "release", "lock", "release", "lock", "release"}, mutex.calls)
+	})
+
+	t.Run("failed saga is marked again on conflict", func(t *testing.T) {
+		provider := &paymentProvider{failures: 1}
+		store := &conflictingStore{Store: newStore(provider), conflicts: 2}
+		instance, stub, err := handle(t, provider, withStore(store), withHandlerOptions(WithConflictRetries(2)))
+		require.NoError(t, err)
+
+		assert.True(t, instance.Status().Failed())
+		assert.Equal(t, 0, store.conflicts)
+		assert.Len(t, stub.sent, 1)
+	})
+
+	t.Run("failed saga isn't marked after conflict retries", func(t *testing.T) {
+		provider := &paymentProvider{failures: 1}
+		store := &conflictingStore{Store: newStore(provider), conflicts: 3}
+		instance, stub, err := handle(t, provider, withStore(store), withHandlerOptions(WithConflictRetries(2)))
+		assert.True(t, sagaPkg.IsVersionConflict(err))
+
+		assert.True(t, instance.Status().InProgress())
+		assert.Empty(t, stub.sent)
+	})
+}
+
+type mutexStub struct {
+	calls []string
+}
+
+func (m *mutexStub) Lock(ctx context.Context, sagaId string) error {
+	m.calls = append(m.calls, "lock")
+	return nil
+}
+
+func (m *mutexStub) TryLock(ctx context.Context, sagaId string) (bool, error) {
+	m.calls = append(m.calls, "lock")
+	return true, nil
+}
+
+func (m *mutexStub) Release(ctx context.Context, sagaId string) error {
+	m.calls = append(m.calls, "release")
+	return nil
+}
+
+// conflictingStore fails updates of failed sagas with version conflict as if they were modified concurrently
+type conflictingStore struct {
+	sagaPkg.Store
+	conflicts int
+}
+
+func (s *conflictingStore) Update(ctx context.Context, instance sagaPkg.Instance, opts ...sagaPkg.UpdateOption) error {
+	if instance.Status().Failed() && s.conflicts > 0 {
+		s.conflicts--
+		return errors.WithStack(sagaPkg.VersionConflictError{SagaUID: instance.UID(), Version: instance.Version()})
+	}
+
+	return s.Store.Update(ctx, instance, opts...)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+)
+
+// parkEvent writes an event received by a suspended saga into its history, so it's sent to the saga again on resume
+func parkEvent(instance sagaPkg.Instance, payload message.Object) {
+	parked, _ := parkedEvents(instance)
+	instance.AddHistoryEvent(&contracts.EventParkedEvent{Sequence: len(parked) + 1, Event: payload})
+}
+
+// unparkEvents returns events which were parked and not replayed yet in order they were received, they are marked replayed in saga history
+func unparkEvents(instance sagaPkg.Instance) []message.Object {
+	parked, replayedUpTo := parkedEvents(instance)
+
+	var res []message.Object
+
+	for _, ev := range parked {
+		if ev.Sequence > replayedUpTo {
+			res = append(res, ev.Event)
+		}
+	}
+
+	if len(res) > 0 {
+		instance.AddHistoryEvent(&contracts.ParkedEventsReplayedEvent{Sequence: parked[len(parked)-1].Sequence})
+	}
+
+	return res
+}
+
+// parkedEvents returns all events parked by a saga ordered by sequence and the last sequence which was replayed
+func parkedEvents(instance sagaPkg.Instance) ([]*contracts.EventParkedEvent, int) {
+	var (
+		parked       []*contracts.EventParkedEvent
+		replayedUpTo int
+	)
+
+	for _, ev := range instance.HistoryEvents() {
+		switch payload := ev.Payload.(type) {
+		case *contracts.EventParkedEvent:
+			parked = append(parked, payload)
+		case *contracts.ParkedEventsReplayedEvent:
+			if payload.Sequence > replayedUpTo {
+				replayedUpTo = payload.Sequence
+			}
+		}
+	}
+
+	//events created within a second could be loaded in any order
+	sort.Slice(parked, func(i, j int) bool {
+		return parked[i].Sequence < parked[j].Sequence
+	})
+
+	return parked, replayedUpTo
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stockReservedEvent struct {
+	message.ObjectMeta
+	Quantity int `json:"quantity"`
+}
+
+type paymentReceivedEvent struct {
+	message.ObjectMeta
+}
+
+type checkoutSaga struct {
+	sagaPkg.BaseSaga
+	Policy sagaPkg.UnexpectedEventPolicy `json:"policy"`
+}
+
+func (s *checkoutSaga) Init() {
+	s.AddState(sagaPkg.State{Name: "reserving"})
+	s.AddState(sagaPkg.State{
+		Name: "paying",
+		OnEntry: func(sagaCtx sagaPkg.SagaContext) error {
+			sagaCtx.Dispatch(&chargeCmd{})
+			return nil
+		},
+	})
+	s.AddState(sagaPkg.State{
+		Name: "paid",
+		OnEntry: func(sagaCtx sagaPkg.SagaContext) error {
+			sagaCtx.SagaInstance().Complete()
+			return nil
+		},
+	})
+	s.AddState(sagaPkg.State{Name: "out_of_stock"})
+
+	s.AddTransition(sagaPkg.Transition{
+		From: "reserving",
+		To:   "paying",
+		On:   &stockReservedEvent{},
+		Guard: func(sagaCtx sagaPkg.SagaContext) bool {
+			return sagaCtx.Message().Payload().(*stockReservedEvent).Quantity > 0
+		},
+	})
+	s.AddTransition(sagaPkg.Transition{From: "reserving", To: "out_of_stock", On: &stockReservedEvent{}})
+	s.AddTransition(sagaPkg.Transition{From: "paying", To: "paid", On: &paymentReceivedEvent{}})
+
+	s.OnUnexpectedEvent(s.Policy)
+}
+
+func (s *checkoutSaga) Start(sagaCtx sagaPkg.SagaContext) error {
+	return s.EnterState(sagaCtx, "reserving")
+}
+
+func (s *checkoutSaga) Compensate(sagaCtx sagaPkg.SagaContext) error { return nil }
+func (s *checkoutSaga) Recover(sagaCtx sagaPkg.SagaContext) error    { return nil }
+
+func TestSagaStateMachine(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("checkout", &checkoutSaga{}, &stockReservedEvent{}, &paymentReceivedEvent{}, &chargeCmd{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	start := func(t *testing.T, policy sagaPkg.UnexpectedEventPolicy) *handlersEnv {
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&chargeCmd{}, &contracts.ReplayEventCommand{}})
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &checkoutSaga{Policy: policy}})))
+
+		return e
+	}
+
+	instance := func(t *testing.T, e *handlersEnv) sagaPkg.Instance {
+		return e.instance("saga-uid")
+	}
+
+	t.Run("transitions with entry actions", func(t *testing.T) {
+		e := start(t, sagaPkg.IgnoreUnexpectedEvent)
+		assert.Equal(t, "reserving", instance(t, e).State())
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &stockReservedEvent{Quantity: 1})))
+		assert.Equal(t, "paying", instance(t, e).State())
+		assert.IsType(t, &chargeCmd{}, e.stub.payloads()[0])
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &paymentReceivedEvent{})))
+		assert.Equal(t, "paid", instance(t, e).State())
+		assert.True(t, instance(t, e).Status().Completed())
+	})
+
+	t.Run("guard rejects transition", func(t *testing.T) {
+		e := start(t, sagaPkg.IgnoreUnexpectedEvent)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &stockReservedEvent{Quantity: 0})))
+		assert.Equal(t, "out_of_stock", instance(t, e).State())
+		assert.Empty(t, e.stub.sent)
+	})
+
+	t.Run("unexpected event is ignored", func(t *testing.T) {
+		e := start(t, sagaPkg.IgnoreUnexpectedEvent)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &paymentReceivedEvent{})))
+		assert.Equal(t, "reserving", instance(t, e).State())
+		assert.True(t, instance(t, e).Status().InProgress())
+	})
+
+	t.Run("unexpected event fails saga", func(t *testing.T) {
+		e := start(t, sagaPkg.FailOnUnexpectedEvent)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &paymentReceivedEvent{})))
+		assert.Equal(t, "reserving", instance(t, e).State())
+		assert.True(t, instance(t, e).Status().Failed())
+	})
+
+	t.Run("unexpected event is deferred until state changes", func(t *testing.T) {
+		e := start(t, sagaPkg.DeferUnexpectedEvent)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &paymentReceivedEvent{})))
+		assert.Equal(t, "reserving", instance(t, e).State())
+		assert.Empty(t, e.stub.sent)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &stockReservedEvent{Quantity: 1})))
+		assert.Equal(t, "paying", instance(t, e).State())
+		require.Len(t, e.stub.payloads(), 2)
+		replay, ok := e.stub.payloads()[1].(*contracts.ReplayEventCommand)
+		require.True(t, ok)
+		assert.IsType(t, &paymentReceivedEvent{}, replay.Event)
+
+		//replayed event is addressed to the saga
+		sagaUID, err := e.uidSvc.ExtractSagaUID(e.stub.sent[1].Headers())
+		require.NoError(t, err)
+		assert.Equal(t, "saga-uid", sagaUID)
+
+		require.NoError(t, e.eventsHandler.Handle(e.receiveSent(e.stub.sent[1])))
+		assert.Equal(t, "paid", instance(t, e).State())
+		assert.True(t, instance(t, e).Status().Completed())
+	})
+	t.Run("state isn't changed when deferred events can't be replayed", func(t *testing.T) {
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&chargeCmd{}})
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &checkoutSaga{Policy: sagaPkg.DeferUnexpectedEvent}})))
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &paymentReceivedEvent{})))
+		assert.Error(t, e.eventsHandler.Handle(e.receive("saga-uid", &stockReservedEvent{Quantity: 1})))
+		assert.Equal(t, "reserving", instance(t, e).State())
+		assert.Empty(t, e.stub.sent)
+	})
+}
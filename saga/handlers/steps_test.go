@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reserveCmd struct {
+	message.ObjectMeta
+}
+
+type releaseCmd struct {
+	message.ObjectMeta
+}
+
+type releasedEvent struct {
+	message.ObjectMeta
+}
+
+type chargeCmd struct {
+	message.ObjectMeta
+}
+
+type refundCmd struct {
+	message.ObjectMeta
+}
+
+type refundedEvent struct {
+	message.ObjectMeta
+}
+
+type shippingSaga struct {
+	sagaPkg.BaseSaga
+}
+
+func (s *shippingSaga) Init() {
+	s.AddStep(sagaPkg.Step{
+		Name: "reserve",
+		Action: func(sagaCtx sagaPkg.SagaContext) error {
+			sagaCtx.Dispatch(&reserveCmd{})
+			return nil
+		},
+		Compensation: func(sagaCtx sagaPkg.SagaContext) message.Object {
+			return &releaseCmd{}
+		},
+		CompensatedOn: &releasedEvent{},
+	})
+	s.AddStep(sagaPkg.Step{
+		Name: "charge",
+		Action: func(sagaCtx sagaPkg.SagaContext) error {
+			sagaCtx.Dispatch(&chargeCmd{})
+			return nil
+		},
+		Compensation: func(sagaCtx sagaPkg.SagaContext) message.Object {
+			return &refundCmd{}
+		},
+		CompensatedOn: &refundedEvent{},
+	})
+}
+
+func (s *shippingSaga) Start(sagaCtx sagaPkg.SagaContext) error {
+	if err := s.RunStep(sagaCtx, "reserve"); err != nil {
+		return err
+	}
+
+	return s.RunStep(sagaCtx, "charge")
+}
+
+func (s *shippingSaga) Recover(sagaCtx sagaPkg.SagaContext) error { return nil }
+
+func TestStepsCompensation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("shipping", &shippingSaga{}, &reserveCmd{}, &releaseCmd{}, &releasedEvent{}, &chargeCmd{}, &refundCmd{}, &refundedEvent{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&reserveCmd{}, &releaseCmd{}, &chargeCmd{}, &refundCmd{}, &contracts.SagaCompensatedEvent{}})
+
+	receive := func(payload message.Object) execution.MessageExecutionCtx {
+		return e.receive("saga-uid", payload)
+	}
+
+	lastSent := func() message.Object {
+		require.NotEmpty(t, e.stub.sent)
+		return e.stub.sent[len(e.stub.sent)-1].Payload()
+	}
+
+	require.NoError(t, e.controlHandler.Handle(receive(&contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &shippingSaga{}})))
+	assert.Len(t, e.stub.payloads(), 2)
+
+	instance, err := e.store.GetById(ctx, "saga-uid")
+	require.NoError(t, err)
+	instance.Fail(&refundedEvent{})
+	require.NoError(t, e.store.Update(ctx, instance))
+
+	//steps are compensated in reverse order
+	require.NoError(t, e.controlHandler.Handle(receive(&contracts.CompensateSagaCommand{SagaUID: "saga-uid"})))
+	assert.IsType(t, &refundCmd{}, lastSent())
+
+	instance, err = e.store.GetById(ctx, "saga-uid")
+	require.NoError(t, err)
+	assert.True(t, instance.Status().Compensating())
+
+	//compensation of a step which isn't awaited is ignored
+	sentCount := len(e.stub.sent)
+	require.NoError(t, e.eventsHandler.Handle(receive(&releasedEvent{})))
+	assert.Len(t, e.stub.sent, sentCount)
+
+	require.NoError(t, e.eventsHandler.Handle(receive(&refundedEvent{})))
+	assert.IsType(t, &releaseCmd{}, lastSent())
+
+	instance, err = e.store.GetById(ctx, "saga-uid")
+	require.NoError(t, err)
+	assert.True(t, instance.Status().Compensating())
+
+	require.NoError(t, e.eventsHandler.Handle(receive(&releasedEvent{})))
+	assert.IsType(t, &contracts.SagaCompensatedEvent{}, lastSent())
+
+	instance, err = e.store.GetById(ctx, "saga-uid")
+	require.NoError(t, err)
+	assert.True(t, instance.Status().Compensated())
+
+	var compensated []string
+	for _, ev := range instance.HistoryEvents() {
+		if stepEv, ok := ev.Payload.(*contracts.StepCompensatedEvent); ok {
+			compensated = append(compensated, stepEv.Step)
+		}
+	}
+	assert.Equal(t, []string{"charge", "reserve"}, compensated)
+}
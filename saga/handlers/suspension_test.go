@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	sagaPkg "github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSagaSuspensionAndCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("invoices", &invoiceSaga{}, &invoicePaidEvent{})
+	schemeRegistry.AddKnownTypes("shipping", &shippingSaga{}, &reserveCmd{}, &releaseCmd{}, &releasedEvent{}, &chargeCmd{}, &refundCmd{}, &refundedEvent{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+
+	start := func(t *testing.T, saga sagaPkg.Saga) *handlersEnv {
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&contracts.ReplayEventCommand{}, &reserveCmd{}, &chargeCmd{}, &refundCmd{}, &contracts.SagaCancelledEvent{}})
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: saga})))
+
+		return e
+	}
+
+	t.Run("events of suspended saga are replayed in order on resume", func(t *testing.T) {
+		e := start(t, &invoiceSaga{OrderID: "order"})
+
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.SuspendSagaCommand{SagaUID: "saga-uid"})))
+		assert.True(t, e.instance("saga-uid").Status().Suspended())
+		assert.Equal(t, "in_progress", e.instance("saga-uid").Status().SuspendedFrom())
+
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &invoicePaidEvent{OrderID: "first"})))
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &invoicePaidEvent{OrderID: "second"})))
+		assert.Equal(t, 0, e.instance("saga-uid").Saga().(*invoiceSaga).Paid)
+		assert.Empty(t, e.stub.sent)
+
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.ResumeSagaCommand{SagaUID: "saga-uid"})))
+		assert.True(t, e.instance("saga-uid").Status().InProgress())
+
+		//replays are routed to saga endpoints, the events themselves have no route
+		replayed := e.stub.payloads()
+		require.Len(t, replayed, 2)
+		assert.Equal(t, "first", replayed[0].(*contracts.ReplayEventCommand).Event.(*invoicePaidEvent).OrderID)
+		assert.Equal(t, "second", replayed[1].(*contracts.ReplayEventCommand).Event.(*invoicePaidEvent).OrderID)
+
+		for _, sent := range e.stub.sent {
+			require.NoError(t, e.eventsHandler.Handle(e.receiveSent(sent)))
+		}
+
+		assert.Equal(t, 2, e.instance("saga-uid").Saga().(*invoiceSaga).Paid)
+
+		//events are replayed only once
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.SuspendSagaCommand{SagaUID: "saga-uid"})))
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.ResumeSagaCommand{SagaUID: "saga-uid"})))
+		assert.Len(t, e.stub.sent, 2)
+	})
+
+	t.Run("saga isn't resumed when parked events can't be replayed", func(t *testing.T) {
+		e := newHandlersEnv(t, ctx, schemeRegistry, []message.Object{&invoicePaidEvent{}})
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.StartSagaCommand{SagaUID: "saga-uid", Saga: &invoiceSaga{OrderID: "order"}})))
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.SuspendSagaCommand{SagaUID: "saga-uid"})))
+		require.NoError(t, e.eventsHandler.Handle(e.receive("saga-uid", &invoicePaidEvent{OrderID: "first"})))
+
+		assert.Error(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.ResumeSagaCommand{SagaUID: "saga-uid"})))
+		assert.True(t, e.instance("saga-uid").Status().Suspended())
+		assert.Empty(t, e.stub.sent)
+	})
+
+	t.Run("cancelled saga doesn't handle events", func(t *testing.T) {
+		e := start(t, &invoiceSaga{OrderID: "order"})
+
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.CancelSagaCommand{SagaUID: "saga-uid"})))
+		assert.True(t, e.instance("saga-uid").Status().Cancelled())
+		require.Len(t, e.stub.payloads(), 1)
+		assert.IsType(t, &contracts.SagaCancelledEvent{}, e.stub.payloads()[0])
+
+		assert.Error(t, e.eventsHandler.Handle(e.receive("saga-uid", &invoicePaidEvent{OrderID: "late"})))
+
+		//cancelled saga can't be resumed or cancelled again
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.ResumeSagaCommand{SagaUID: "saga-uid"})))
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.CancelSagaCommand{SagaUID: "saga-uid", Compensate: true})))
+		assert.True(t, e.instance("saga-uid").Status().Cancelled())
+		assert.Len(t, e.stub.sent, 1)
+	})
+
+	t.Run("cancellation with compensation", func(t *testing.T) {
+		e := start(t, &shippingSaga{})
+		sent := len(e.stub.sent)
+
+		require.NoError(t, e.controlHandler.Handle(e.receive("saga-uid", &contracts.CancelSagaCommand{SagaUID: "saga-uid", Compensate: true})))
+		assert.True(t, e.instance("saga-uid").Status().Compensating())
+		require.Len(t, e.stub.sent, sent+1)
+		assert.IsType(t, &refundCmd{}, e.stub.payloads()[sent])
+	})
+}
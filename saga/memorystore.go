@@ -0,0 +1,352 @@
+package saga
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/pkg/errors"
+)
+
+type memoryStore struct {
+	*updateHooks
+	msgMarshaller message.Marshaller
+	lock          sync.RWMutex
+	instances     map[string]*sagaInstance
+	outbox        outbox.Store
+}
+
+// NewMemoryStore creates thread safe in-memory saga store. It's suitable for tests and short-lived workflows which don't need durability.
+// Instances are deep copied by marshalling them, so saga and event types must be registered in scheme just like for sql store
+func NewMemoryStore(msgMarshaller message.Marshaller) Store {
+	return &memoryStore{msgMarshaller: msgMarshaller, instances: make(map[string]*sagaInstance), outbox: outbox.NewMemoryStore(), updateHooks: &updateHooks{}}
+}
+
+func (m *memoryStore) Create(ctx context.Context, saga Instance) error {
+	instance, err := m.copyInstance(saga)
+
+	if err != nil {
+		return errors.Wrapf(err, "copying saga instance %s", saga.UID())
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.instances[saga.UID()]; exists {
+		return errors.Errorf("saga instance %s already exists", saga.UID())
+	}
+
+	if err := m.checkCorrelations(instance); err != nil {
+		return errors.WithStack(err)
+	}
+
+	m.instances[saga.UID()] = instance
+
+	return nil
+}
+
+func (m *memoryStore) GetById(ctx context.Context, sagaId string) (Instance, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	instance, exists := m.instances[sagaId]
+
+	if !exists {
+		return nil, nil
+	}
+
+	res, err := m.copyInstance(instance)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "copying saga instance %s", sagaId)
+	}
+
+	return res, nil
+}
+
+func (m *memoryStore) GetByFilter(ctx context.Context, filters ...FilterOption) ([]Instance, error) {
+	if len(filters) == 0 {
+		return nil, errors.Errorf("No filters found, you have to specify at least one so result won't be whole store")
+	}
+
+	opts := newFilterOptions(filters...)
+
+	if err := opts.validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !opts.hasConditions() {
+		return nil, errors.Errorf("All specified filters are empty, you have to specify at least one so result won't be whole store")
+	}
+
+	cursorKey, err := opts.decodeCursor()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	matched := make([]*sagaInstance, 0)
+
+	for _, instance := range m.instances {
+		if !opts.match(instance) || (cursorKey != nil && !opts.after(instance, *cursorKey)) {
+			continue
+		}
+
+		matched = append(matched, instance)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return opts.less(matched[i], matched[j])
+	})
+
+	if opts.limit > 0 && len(matched) > opts.limit {
+		matched = matched[:opts.limit]
+	}
+
+	res := make([]Instance, len(matched))
+
+	for i, instance := range matched {
+		instanceCopy, err := m.copyInstance(instance)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "copying saga instance %s", instance.UID())
+		}
+
+		if opts.withoutHistory {
+			instanceCopy.historyEvents = make([]HistoryEvent, 0)
+		}
+
+		res[i] = instanceCopy
+	}
+
+	return res, nil
+}
+
+// Update saves a copy of saga instance if its version wasn't changed since it had been loaded, otherwise VersionConflictError is returned.
+// Pending deliveries are added into in-memory outbox
+func (m *memoryStore) GetByCorrelation(ctx context.Context, key, value string) (Instance, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, instance := range m.instances {
+		if correlated, exists := instance.correlations[key]; !exists || correlated != value {
+			continue
+		}
+
+		res, err := m.copyInstance(instance)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "copying saga instance %s", instance.UID())
+		}
+
+		return res, nil
+	}
+
+	return nil, nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, saga Instance, opts ...UpdateOption) error {
+	newEvents, err := m.update(ctx, saga, opts...)
+
+	if err != nil {
+		return err
+	}
+
+	m.notify(ctx, saga, newEvents)
+
+	return nil
+}
+
+// update saves saga and returns history events which weren't saved before
+func (m *memoryStore) update(ctx context.Context, saga Instance, opts ...UpdateOption) ([]HistoryEvent, error) {
+	updateOpts := &updateOptions{}
+	for _, opt := range opts {
+		opt(updateOpts)
+	}
+
+	instance, err := m.copyInstance(saga)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "copying saga instance %s on update", saga.UID())
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, exists := m.instances[saga.UID()]
+
+	if !exists || stored.version != saga.Version() {
+		return nil, errors.WithStack(VersionConflictError{SagaUID: saga.UID(), Version: saga.Version()})
+	}
+
+	if err := m.checkCorrelations(instance); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, delivery := range updateOpts.deliveries {
+		if err := m.outbox.Add(ctx, nil, delivery.Message, delivery.Options...); err != nil {
+			return nil, errors.Wrapf(err, "persisting delivery %s for saga %s", delivery.Message.UID(), saga.UID())
+		}
+	}
+
+	instance.version = saga.Version() + 1
+	m.instances[saga.UID()] = instance
+
+	if setter, ok := saga.(versionSetter); ok {
+		setter.setVersion(instance.version)
+	}
+
+	saved := make(map[string]struct{}, len(stored.historyEvents))
+	for _, ev := range stored.historyEvents {
+		saved[ev.UID] = struct{}{}
+	}
+
+	var newEvents []HistoryEvent
+
+	for _, ev := range saga.HistoryEvents() {
+		if _, exists := saved[ev.UID]; !exists {
+			newEvents = append(newEvents, ev)
+		}
+	}
+
+	return newEvents, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, sagaId string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.instances[sagaId]; !exists {
+		return errors.Errorf("no saga instance %s found", sagaId)
+	}
+
+	delete(m.instances, sagaId)
+
+	return nil
+}
+
+// checkCorrelations ensures that correlation values of an instance aren't used by another saga, must be called under lock
+func (m *memoryStore) checkCorrelations(instance *sagaInstance) error {
+	for key, value := range instance.correlations {
+		for _, another := range m.instances {
+			if another.UID() != instance.UID() && another.correlations[key] == value {
+				return errors.Errorf("correlation %s=%s of saga %s is already used by saga %s", key, value, instance.UID(), another.UID())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStore) Outbox() outbox.Store {
+	return m.outbox
+}
+
+// copyInstance makes a deep copy of an instance, payloads are copied by marshalling
+func (m *memoryStore) copyInstance(instance Instance) (*sagaInstance, error) {
+	status, err := statusFromStr(instance.Status().String())
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing status of %s", instance.UID())
+	}
+
+	suspendedFrom, err := optionalStatusFromStr(instance.Status().SuspendedFrom())
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing status %s was suspended from", instance.UID())
+	}
+
+	res := &sagaInstance{
+		uid:           instance.UID(),
+		parentID:      instance.ParentID(),
+		state:         instance.State(),
+		correlations:  copyCorrelations(instance.Correlations()),
+		historyEvents: make([]HistoryEvent, len(instance.HistoryEvents())),
+		startedAt:     copyTime(instance.StartedAt()),
+		updatedAt:     copyTime(instance.UpdatedAt()),
+		version:       instance.Version(),
+		instanceStatus: instanceStatus{
+			status:        status,
+			suspendedFrom: suspendedFrom,
+		},
+	}
+
+	sagaCopy, err := m.copyObject(instance.Saga())
+
+	if err != nil {
+		return nil, errors.Wrap(err, "copying saga")
+	}
+
+	saga, ok := sagaCopy.(Saga)
+
+	if !ok {
+		return nil, errors.Errorf("error converting %s into type Saga interface", sagaCopy.GroupKind().String())
+	}
+
+	res.saga = saga
+
+	if failedEv := instance.Status().FailedOnEvent(); failedEv != nil {
+		if res.instanceStatus.lastFailedEv, err = m.copyObject(failedEv); err != nil {
+			return nil, errors.Wrap(err, "copying last failed event")
+		}
+	}
+
+	for i, ev := range instance.HistoryEvents() {
+		payload, err := m.copyObject(ev.Payload)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "copying history event %s", ev.UID)
+		}
+
+		ev.Payload = payload
+		res.historyEvents[i] = ev
+	}
+
+	return res, nil
+}
+
+func (m *memoryStore) copyObject(obj message.Object) (message.Object, error) {
+	data, err := m.msgMarshaller.Marshal(obj)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res, err := m.msgMarshaller.Unmarshal(data)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return res, nil
+}
+
+func copyCorrelations(correlations map[string]string) map[string]string {
+	if correlations == nil {
+		return nil
+	}
+
+	res := make(map[string]string, len(correlations))
+
+	for key, value := range correlations {
+		res[key] = value
+	}
+
+	return res
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	res := *t
+
+	return &res
+}
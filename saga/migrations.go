@@ -0,0 +1,122 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+)
+
+// MigrationsComponent is a name under which migrations of sql saga store are recorded
+const MigrationsComponent = "saga"
+
+// Migrations returns schema migrations of sql saga store. They are applied by NewSQLSagaStore unless WithoutMigrations is passed
+func Migrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create saga and saga_history tables",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{
+					fmt.Sprintf(`create table if not exists %v
+	(
+		uid varchar(255) not null primary key,
+		parent_uid varchar(255) null,
+		name varchar(255) null,
+		payload text null,
+		status varchar(255) null,
+		started_at timestamp null,
+		updated_at timestamp null,
+		last_failed_ev text null
+	);`, sagaTableName),
+					fmt.Sprintf(`create table if not exists %v
+	(
+		uid varchar(255) not null primary key,
+		saga_uid varchar(255) not null,
+		name varchar(255) null,
+		status varchar(255) null,
+		payload text null,
+		origin varchar(255) null,
+		created_at timestamp null,
+		trace_uid varchar(255) null,
+		constraint saga_history_saga_model_id_fk
+			foreign key (saga_uid) references %v (uid)
+				on update cascade on delete cascade
+	);`, sagaHistoryTableName, sagaTableName),
+				}
+			},
+		},
+		{
+			Version:     2,
+			Description: "add version column to saga table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %v ADD COLUMN version integer not null default 0;", sagaTableName)}
+			},
+			//tables created before migrations were tracked may have the column already
+			Applied: columnExists(sagaTableName, "version"),
+		},
+		{
+			Version:     3,
+			Description: "add state column to saga table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %v ADD COLUMN state varchar(255) null;", sagaTableName)}
+			},
+			Applied: columnExists(sagaTableName, "state"),
+		},
+		{
+			Version:     4,
+			Description: "create saga_correlation table",
+			Statements: func(driver sqldriver.Driver) []string {
+				statements := []string{fmt.Sprintf(`create table if not exists %v
+	(
+		name varchar(255) not null,
+		value varchar(255) not null,
+		saga_uid varchar(255) not null,
+		primary key (name, value),
+		constraint saga_correlation_saga_uid_fk
+			foreign key (saga_uid) references %v (uid)
+				on update cascade on delete cascade
+	);`, sagaCorrelationTableName, sagaTableName)}
+
+				//mysql creates an index for foreign key itself
+				if driver != sqldriver.MySQL {
+					statements = append(statements, fmt.Sprintf("create index if not exists saga_correlation_saga_uid_idx on %v (saga_uid);", sagaCorrelationTableName))
+				}
+
+				return statements
+			},
+		},
+		{
+			Version:     5,
+			Description: "add suspended_from column to saga table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %v ADD COLUMN suspended_from varchar(255) null;", sagaTableName)}
+			},
+			Applied: columnExists(sagaTableName, "suspended_from"),
+		},
+		{
+			Version:     6,
+			Description: "add payload_version column to saga table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %v ADD COLUMN payload_version integer not null default 0;", sagaTableName)}
+			},
+			Applied: columnExists(sagaTableName, "payload_version"),
+		},
+		{
+			Version:     7,
+			Description: "add seq column to saga_history table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %v ADD COLUMN seq integer not null default 0;", sagaHistoryTableName)}
+			},
+			Applied: columnExists(sagaHistoryTableName, "seq"),
+		},
+	}
+}
+
+// columnExists detects that a column was added, mysql commits DDL implicitly, so the column could be added while recording the migration failed
+func columnExists(table, column string) func(ctx context.Context, q sqlmigrate.Querier, driver sqldriver.Driver) (bool, error) {
+	return func(ctx context.Context, q sqlmigrate.Querier, driver sqldriver.Driver) (bool, error) {
+		return sqlmigrate.ColumnExists(ctx, q, driver, table, column)
+	}
+}
@@ -0,0 +1,213 @@
+package mutex
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// LeaseBackend stores leases of saga locks. A lease belongs to its owner until it's released or expires
+type LeaseBackend interface {
+	// Acquire takes a lease which is free or expired. Returns a fencing token which grows every time the lease of the key is acquired
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (token int64, acquired bool, err error)
+	// Renew prolongs a lease, returns false if the lease doesn't belong to owner anymore
+	Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Release frees a lease, returns false if the lease doesn't belong to owner anymore
+	Release(ctx context.Context, key, owner string) (bool, error)
+}
+
+// Lease is a held saga lock
+type Lease interface {
+	// Token is a fencing token, a holder of a lease with a bigger token took over the lock from this one
+	Token() int64
+	// Done is closed when the lease is lost because it couldn't be renewed in time
+	Done() <-chan struct{}
+}
+
+// LeaseMutex is a Mutex which locks sagas with leases that expire unless renewed, so a crashed holder doesn't keep a lock forever
+type LeaseMutex interface {
+	Mutex
+	// Lease returns currently held lease of the saga
+	Lease(sagaId string) (Lease, bool)
+}
+
+// CheckLease returns ErrLeaseLost if a lease of the saga held by mutex is lost. Call it right before saving what was done under the lock,
+// so a holder which lock was taken over doesn't overwrite changes of the new one. Mutexes without leases and sagas which aren't locked are not checked
+func CheckLease(m Mutex, sagaId string) error {
+	leaseMutex, ok := m.(LeaseMutex)
+
+	if !ok {
+		return nil
+	}
+
+	l, held := leaseMutex.Lease(sagaId)
+
+	if !held {
+		return nil
+	}
+
+	select {
+	case <-l.Done():
+		return WithMutexErr(errors.Wrapf(ErrLeaseLost, "lease of saga %s with token %d", sagaId, l.Token()))
+	default:
+		return nil
+	}
+}
+
+type leaseMutex struct {
+	backend LeaseBackend
+	opts    *options
+	mapLock sync.Mutex
+	leases  map[string]*lease
+}
+
+// NewLeaseMutex creates LeaseMutex over a backend. Held leases are renewed in background until they are released
+func NewLeaseMutex(backend LeaseBackend, opts ...Option) LeaseMutex {
+	return &leaseMutex{backend: backend, opts: newOptions(opts...), leases: make(map[string]*lease)}
+}
+
+func (m *leaseMutex) Lock(ctx context.Context, sagaId string) error {
+	ctx, cancel := m.opts.lockCtx(ctx)
+	defer cancel()
+
+	for {
+		acquired, err := m.TryLock(ctx, sagaId)
+
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return WithMutexErr(errors.Wrapf(ctx.Err(), "acquiring lock for saga %s", sagaId))
+		case <-time.After(m.opts.retryInterval):
+		}
+	}
+}
+
+func (m *leaseMutex) TryLock(ctx context.Context, sagaId string) (bool, error) {
+	owner := uuid.New().String()
+
+	token, acquired, err := m.backend.Acquire(ctx, sagaId, owner, m.opts.ttl)
+
+	if err != nil {
+		return false, WithMutexErr(errors.Wrapf(err, "acquiring lock for saga %s", sagaId))
+	}
+
+	if !acquired {
+		return false, nil
+	}
+
+	l := &lease{owner: owner, token: token, done: make(chan struct{}), stop: make(chan struct{}), stopped: make(chan struct{})}
+
+	m.mapLock.Lock()
+	previous, exists := m.leases[sagaId]
+	m.leases[sagaId] = l
+	m.mapLock.Unlock()
+
+	//previous lease was lost without being released, its heartbeat is not needed anymore
+	if exists {
+		previous.stopHeartbeat()
+	}
+
+	go m.heartbeat(sagaId, l)
+
+	return true, nil
+}
+
+func (m *leaseMutex) Release(ctx context.Context, sagaId string) error {
+	m.mapLock.Lock()
+	l, exists := m.leases[sagaId]
+	delete(m.leases, sagaId)
+	m.mapLock.Unlock()
+
+	if !exists {
+		return WithMutexErr(errors.Wrapf(ErrLockNotHeld, "releasing lock for saga %s", sagaId))
+	}
+
+	l.stopHeartbeat()
+
+	released, err := m.backend.Release(ctx, sagaId, l.owner)
+
+	if err != nil {
+		return WithMutexErr(errors.Wrapf(err, "releasing lock for saga %s", sagaId))
+	}
+
+	if !released {
+		return WithMutexErr(errors.Wrapf(ErrLockNotHeld, "lease of saga %s expired before it was released", sagaId))
+	}
+
+	return nil
+}
+
+func (m *leaseMutex) Lease(sagaId string) (Lease, bool) {
+	m.mapLock.Lock()
+	defer m.mapLock.Unlock()
+
+	l, exists := m.leases[sagaId]
+
+	return l, exists
+}
+
+// heartbeat renews the lease until it's released. The lease is considered lost if it was taken over or wasn't renewed within TTL
+func (m *leaseMutex) heartbeat(sagaId string, l *lease) {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(m.opts.heartbeatInterval)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.opts.heartbeatInterval)
+		renewed, err := m.backend.Renew(ctx, sagaId, l.owner, m.opts.ttl)
+		cancel()
+
+		if err == nil && renewed {
+			renewedAt = time.Now()
+			continue
+		}
+
+		if err == nil || time.Since(renewedAt) >= m.opts.ttl {
+			close(l.done)
+			return
+		}
+	}
+}
+
+type lease struct {
+	owner    string
+	token    int64
+	done     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (l *lease) Token() int64 {
+	return l.token
+}
+
+func (l *lease) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *lease) stopHeartbeat() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.stopped
+}
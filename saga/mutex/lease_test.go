@@ -0,0 +1,120 @@
+package mutex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseMutex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	t.Run("try lock a held lease", func(t *testing.T) {
+		backend := NewMemoryLeaseBackend()
+		first := NewLeaseMutex(backend)
+		second := NewLeaseMutex(backend)
+
+		acquired, err := first.TryLock(ctx, "aaa")
+		require.NoError(t, err)
+		assert.True(t, acquired)
+
+		acquired, err = second.TryLock(ctx, "aaa")
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.NoError(t, first.Release(ctx, "aaa"))
+
+		acquired, err = second.TryLock(ctx, "aaa")
+		require.NoError(t, err)
+		assert.True(t, acquired)
+		require.NoError(t, second.Release(ctx, "aaa"))
+	})
+
+	t.Run("lock timeout", func(t *testing.T) {
+		m := NewMemoryMutex(WithLockTimeout(time.Millisecond * 100))
+
+		require.NoError(t, m.Lock(ctx, "aaa"))
+
+		err := m.Lock(ctx, "aaa")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "acquiring lock for saga aaa")
+
+		require.NoError(t, m.Release(ctx, "aaa"))
+	})
+
+	t.Run("heartbeat keeps lease", func(t *testing.T) {
+		backend := NewMemoryLeaseBackend()
+		first := NewLeaseMutex(backend, WithTTL(time.Millisecond*60))
+		second := NewLeaseMutex(backend)
+
+		require.NoError(t, first.Lock(ctx, "aaa"))
+		time.Sleep(time.Millisecond * 200)
+
+		acquired, err := second.TryLock(ctx, "aaa")
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.NoError(t, first.Release(ctx, "aaa"))
+	})
+
+	t.Run("expired lease is taken over with a bigger token", func(t *testing.T) {
+		backend := NewMemoryLeaseBackend()
+		//heartbeat is slower than ttl, so the lease expires
+		first := NewLeaseMutex(backend, WithTTL(time.Millisecond*50), WithHeartbeatInterval(time.Second))
+		second := NewLeaseMutex(backend)
+
+		require.NoError(t, first.Lock(ctx, "aaa"))
+		firstLease, held := first.Lease("aaa")
+		require.True(t, held)
+
+		require.NoError(t, second.Lock(ctx, "aaa"))
+		secondLease, held := second.Lease("aaa")
+		require.True(t, held)
+		assert.Greater(t, secondLease.Token(), firstLease.Token())
+
+		err := first.Release(ctx, "aaa")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrLockNotHeld))
+
+		require.NoError(t, second.Release(ctx, "aaa"))
+	})
+
+	t.Run("lost lease is reported", func(t *testing.T) {
+		backend := NewMemoryLeaseBackend()
+		m := NewLeaseMutex(backend, WithTTL(time.Millisecond*50), WithHeartbeatInterval(time.Millisecond*20))
+
+		require.NoError(t, m.Lock(ctx, "aaa"))
+		l, held := m.Lease("aaa")
+		require.True(t, held)
+		require.NoError(t, CheckLease(m, "aaa"))
+
+		//somebody else took the lease over
+		memBackend := backend.(*memoryLeaseBackend)
+		memBackend.mutex.Lock()
+		memBackend.leases["aaa"].owner = "another"
+		memBackend.mutex.Unlock()
+
+		select {
+		case <-l.Done():
+		case <-ctx.Done():
+			t.FailNow()
+		}
+
+		err := CheckLease(m, "aaa")
+		assert.True(t, errors.Is(err, ErrLeaseLost))
+
+		err = m.Release(ctx, "aaa")
+		assert.True(t, errors.Is(err, ErrLockNotHeld))
+	})
+
+	t.Run("release not held lock", func(t *testing.T) {
+		err := NewMemoryMutex().Release(ctx, "aaa")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrLockNotHeld))
+	})
+}
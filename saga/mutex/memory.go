@@ -0,0 +1,83 @@
+package mutex
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewMemoryMutex creates an in-process LeaseMutex, it's suitable for tests and single node deployments
+func NewMemoryMutex(opts ...Option) LeaseMutex {
+	return NewLeaseMutex(NewMemoryLeaseBackend(), opts...)
+}
+
+type memoryLease struct {
+	owner     string
+	token     int64
+	expiresAt time.Time
+}
+
+type memoryLeaseBackend struct {
+	mutex  sync.Mutex
+	leases map[string]*memoryLease
+}
+
+// NewMemoryLeaseBackend creates LeaseBackend which keeps leases in memory. Share it between mutexes to lock within one process
+func NewMemoryLeaseBackend() LeaseBackend {
+	return &memoryLeaseBackend{leases: make(map[string]*memoryLease)}
+}
+
+func (b *memoryLeaseBackend) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (int64, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	l, exists := b.leases[key]
+
+	if !exists {
+		l = &memoryLease{}
+		b.leases[key] = l
+	}
+
+	if l.owner != "" && l.expiresAt.After(now) {
+		return 0, false, nil
+	}
+
+	l.owner = owner
+	l.token++
+	l.expiresAt = now.Add(ttl)
+
+	return l.token, true, nil
+}
+
+func (b *memoryLeaseBackend) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	l, exists := b.leases[key]
+
+	now := time.Now()
+	if !exists || l.owner != owner || !l.expiresAt.After(now) {
+		return false, nil
+	}
+
+	l.expiresAt = now.Add(ttl)
+
+	return true, nil
+}
+
+func (b *memoryLeaseBackend) Release(ctx context.Context, key, owner string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	l, exists := b.leases[key]
+
+	if !exists || l.owner != owner {
+		return false, nil
+	}
+
+	//the record is kept, so fencing token keeps growing
+	l.owner = ""
+
+	return true, nil
+}
@@ -0,0 +1,30 @@
+package mutex
+
+import (
+	"fmt"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+)
+
+// MigrationsComponent is a name under which migrations of sql lease backend are recorded
+const MigrationsComponent = "saga_lock"
+
+// Migrations returns schema migrations of sql lease backend. They are applied on first use of the backend unless WithoutMigrations is passed
+func Migrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create saga_lock table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf(`create table if not exists %v
+	(
+		saga_uid varchar(255) not null primary key,
+		owner varchar(255) not null,
+		token bigint not null,
+		expires_at bigint not null
+	);`, leaseTableName)}
+			},
+		},
+	}
+}
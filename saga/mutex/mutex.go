@@ -2,17 +2,106 @@ package mutex
 
 import (
 	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTTL           = time.Second * 30
+	defaultRetryInterval = time.Millisecond * 50
 )
 
+// ErrLockNotHeld is returned when a lock is released by someone who doesn't hold it
+var ErrLockNotHeld = errors.New("saga lock is not held")
+
+// ErrLeaseLost is returned when a lease wasn't renewed in time and could be taken over by another holder
+var ErrLeaseLost = errors.New("saga lease is lost")
+
 type MutexErr struct {
 	error
 }
 
+func (e MutexErr) Unwrap() error {
+	return e.error
+}
+
 func WithMutexErr(err error) error {
 	return MutexErr{err}
 }
 
 type Mutex interface {
 	Lock(ctx context.Context, sagaId string) error
+	// TryLock acquires a lock without waiting. Returns false if the lock is held by someone else
+	TryLock(ctx context.Context, sagaId string) (bool, error)
 	Release(ctx context.Context, sagaId string) error
 }
+
+// Option allows to configure a mutex
+type Option func(o *options)
+
+type options struct {
+	lockTimeout       time.Duration
+	ttl               time.Duration
+	heartbeatInterval time.Duration
+	retryInterval     time.Duration
+	withoutMigrations bool
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{ttl: defaultTTL, retryInterval: defaultRetryInterval}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.heartbeatInterval <= 0 {
+		o.heartbeatInterval = o.ttl / 3
+	}
+
+	return o
+}
+
+// WithLockTimeout limits how long Lock waits for a lock. By default it waits until ctx is done
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithTTL specifies how long a lease lives without being renewed. Used by lease based mutexes only
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithHeartbeatInterval specifies how often a held lease is renewed, a third of TTL by default. Used by lease based mutexes only
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.heartbeatInterval = interval
+	}
+}
+
+// WithRetryInterval specifies how often Lock checks whether a held lease became free. Used by lease based mutexes only
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.retryInterval = interval
+	}
+}
+
+// WithoutMigrations disables applying schema migrations of saga_lock table. Used by sql lease based mutexes only
+func WithoutMigrations() Option {
+	return func(o *options) {
+		o.withoutMigrations = true
+	}
+}
+
+// lockCtx limits ctx with lock timeout if it's specified
+func (o options) lockCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.lockTimeout > 0 {
+		return context.WithTimeout(ctx, o.lockTimeout)
+	}
+
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,94 @@
+package redismutex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+const redisKeyPrefix = "saga_lock"
+
+// acquireScript sets the lease if it's free and increments fencing token of the key. Token key is not expired, so tokens keep growing
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// renewScript prolongs the lease only if it still belongs to the owner
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it still belongs to the owner
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLeaseBackend struct {
+	client redis.UniversalClient
+}
+
+// NewMutex creates mutex.LeaseMutex which keeps leases in redis
+func NewMutex(client redis.UniversalClient, opts ...mutex.Option) mutex.LeaseMutex {
+	return mutex.NewLeaseMutex(NewLeaseBackend(client), opts...)
+}
+
+// NewLeaseBackend creates mutex.LeaseBackend based on redis. A lease is set with SET NX PX and an owner token, it's renewed and released only by its owner.
+// Keys of a saga share a hash tag, so it works with redis cluster
+func NewLeaseBackend(client redis.UniversalClient) mutex.LeaseBackend {
+	return &redisLeaseBackend{client: client}
+}
+
+func (b redisLeaseBackend) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (int64, bool, error) {
+	token, err := acquireScript.Run(ctx, b.client, []string{leaseKey(key), tokenKey(key)}, owner, ttl.Milliseconds()).Int64()
+
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "setting lease of %s", key)
+	}
+
+	if token == 0 {
+		return 0, false, nil
+	}
+
+	return token, true, nil
+}
+
+func (b redisLeaseBackend) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(ctx, b.client, []string{leaseKey(key)}, owner, ttl.Milliseconds()).Int64()
+
+	if err != nil {
+		return false, errors.Wrapf(err, "renewing lease of %s", key)
+	}
+
+	return renewed == 1, nil
+}
+
+func (b redisLeaseBackend) Release(ctx context.Context, key, owner string) (bool, error) {
+	deleted, err := releaseScript.Run(ctx, b.client, []string{leaseKey(key)}, owner).Int64()
+
+	if err != nil {
+		return false, errors.Wrapf(err, "releasing lease of %s", key)
+	}
+
+	return deleted == 1, nil
+}
+
+func leaseKey(key string) string {
+	return fmt.Sprintf("%s:{%s}", redisKeyPrefix, key)
+}
+
+func tokenKey(key string) string {
+	return fmt.Sprintf("%s:{%s}:token", redisKeyPrefix, key)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"sync"
 
 	"github.com/go-foreman/foreman/saga"
@@ -11,30 +12,66 @@ import (
 	"github.com/pkg/errors"
 )
 
+const notHeldMsg = "connection which acquiring lock is not found in runtime map. Was Release() called after processing a message?"
+
 type mysqlMutex struct {
 	db          *sql.DB
 	mapLock     sync.Mutex
 	connections map[string]*sql.Conn
+	opts        *options
 }
 
-func NewSqlMutex(db *sql.DB, driver saga.SQLDriver) Mutex {
-	if driver == saga.MYSQLDriver {
-		return &mysqlMutex{db: db, connections: make(map[string]*sql.Conn)}
+// NewSqlMutex creates a mutex based on advisory locks, it holds a connection from the pool while a lock is acquired.
+// Only WithLockTimeout option is applicable. SQLite has no advisory locks, a lease mutex based on saga_lock table is created for it
+func NewSqlMutex(db *sql.DB, driver saga.SQLDriver, opts ...Option) Mutex {
+	switch driver {
+	case saga.MYSQLDriver:
+		return &mysqlMutex{db: db, connections: make(map[string]*sql.Conn), opts: newOptions(opts...)}
+	case saga.SQLiteDriver:
+		return NewLeaseMutex(&sqlLeaseBackend{db: db, driver: driver, withoutMigrations: newOptions(opts...).withoutMigrations}, opts...)
+	default:
+		return &pgsqlMutex{db: db, connections: make(map[string]*sql.Conn), opts: newOptions(opts...)}
 	}
-	return &pgsqlMutex{db: db, connections: make(map[string]*sql.Conn)}
 }
 
 func (m *mysqlMutex) Lock(ctx context.Context, sagaId string) error {
+	ctx, cancel := m.opts.lockCtx(ctx)
+	defer cancel()
+
+	//-1 means infinite timeout, the query is interrupted when ctx is done anyway
+	timeout := -1
+	if m.opts.lockTimeout > 0 {
+		timeout = int(math.Ceil(m.opts.lockTimeout.Seconds()))
+	}
+
+	acquired, err := m.getLock(ctx, sagaId, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	if !acquired {
+		return WithMutexErr(errors.Errorf("Got 0 when acquiring lock for saga %s", sagaId))
+	}
+
+	return nil
+}
+
+func (m *mysqlMutex) TryLock(ctx context.Context, sagaId string) (bool, error) {
+	return m.getLock(ctx, sagaId, 0)
+}
+
+func (m *mysqlMutex) getLock(ctx context.Context, sagaId string, timeout int) (bool, error) {
 	conn, err := m.db.Conn(ctx)
 
 	if err != nil {
-		return WithMutexErr(errors.Wrapf(err, "obtaining a connection from pool for saga %s", sagaId))
+		return false, WithMutexErr(errors.Wrapf(err, "obtaining a connection from pool for saga %s", sagaId))
 	}
 
 	r := sql.NullInt64{}
-	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1);", sagaId).Scan(&r); err != nil {
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?);", sagaId, timeout).Scan(&r); err != nil {
 		closingErr := conn.Close()
-		return WithMutexErr(errors.Wrapf(err, "acquiring lock for saga %s. %s", sagaId, closingErr))
+		return false, WithMutexErr(errors.Wrapf(err, "acquiring lock for saga %s. %s", sagaId, closingErr))
 	}
 
 	/*
@@ -49,12 +86,14 @@ func (m *mysqlMutex) Lock(ctx context.Context, sagaId string) error {
 
 		m.connections[sagaId] = conn
 
-		return nil
+		return true, nil
 	}
 
-	closingErr := conn.Close()
+	if err := conn.Close(); err != nil {
+		return false, WithMutexErr(errors.Wrapf(err, "closing connection after acquiring lock for saga %s", sagaId))
+	}
 
-	return WithMutexErr(errors.Errorf("Got 0 when acquiring lock for saga %s. %s", sagaId, closingErr))
+	return false, nil
 }
 
 func (m *mysqlMutex) Release(ctx context.Context, sagaId string) error {
@@ -62,7 +101,7 @@ func (m *mysqlMutex) Release(ctx context.Context, sagaId string) error {
 	conn, exists := m.connections[sagaId]
 	if !exists {
 		m.mapLock.Unlock()
-		return WithMutexErr(errors.Errorf("connection which acquiring lock is not found in runtime map. Was Release() called after processing a message?"))
+		return WithMutexErr(errors.Wrap(ErrLockNotHeld, notHeldMsg))
 	}
 
 	r := sql.NullInt64{}
@@ -90,9 +129,66 @@ type pgsqlMutex struct {
 	db          *sql.DB
 	mapLock     sync.Mutex
 	connections map[string]*sql.Conn
+	opts        *options
 }
 
 func (p *pgsqlMutex) Lock(ctx context.Context, sagaId string) error {
+	ctx, cancel := p.opts.lockCtx(ctx)
+	defer cancel()
+
+	conn, err := p.conn(ctx, sagaId)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1));`, sagaId); err != nil {
+		errMsg := fmt.Sprintf("acquiring lock for saga %s. %s", sagaId, err)
+
+		if closingErr := conn.Close(); closingErr != nil {
+			errMsg = fmt.Sprintf("%s. also failed to close connection %s", errMsg, closingErr.Error())
+		}
+		return WithMutexErr(errors.New(errMsg))
+	}
+
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+
+	p.connections[sagaId] = conn
+
+	return nil
+}
+
+func (p *pgsqlMutex) TryLock(ctx context.Context, sagaId string) (bool, error) {
+	conn, err := p.conn(ctx, sagaId)
+
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1));`, sagaId).Scan(&acquired); err != nil {
+		closingErr := conn.Close()
+		return false, WithMutexErr(errors.Wrapf(err, "acquiring lock for saga %s. %s", sagaId, closingErr))
+	}
+
+	if !acquired {
+		if err := conn.Close(); err != nil {
+			return false, WithMutexErr(errors.Wrapf(err, "closing connection after acquiring lock for saga %s", sagaId))
+		}
+
+		return false, nil
+	}
+
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+
+	p.connections[sagaId] = conn
+
+	return true, nil
+}
+
+func (p *pgsqlMutex) conn(ctx context.Context, sagaId string) (*sql.Conn, error) {
 	var (
 		conn *sql.Conn
 		err  error
@@ -112,7 +208,7 @@ func (p *pgsqlMutex) Lock(ctx context.Context, sagaId string) error {
 		conn, err = p.db.Conn(ctx)
 
 		if err != nil {
-			return WithMutexErr(errors.Wrapf(err, "obtaining a connection from pool for saga %s", sagaId))
+			return nil, WithMutexErr(errors.Wrapf(err, "obtaining a connection from pool for saga %s", sagaId))
 		}
 
 		if err := conn.PingContext(ctx); err != nil {
@@ -124,21 +220,7 @@ func (p *pgsqlMutex) Lock(ctx context.Context, sagaId string) error {
 		break
 	}
 
-	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1));`, sagaId); err != nil {
-		errMsg := fmt.Sprintf("acquiring lock for saga %s. %s", sagaId, err)
-
-		if closingErr := conn.Close(); closingErr != nil {
-			errMsg = fmt.Sprintf("%s. also failed to close connection %s", errMsg, closingErr.Error())
-		}
-		return WithMutexErr(errors.New(errMsg))
-	}
-
-	p.mapLock.Lock()
-	defer p.mapLock.Unlock()
-
-	p.connections[sagaId] = conn
-
-	return nil
+	return conn, nil
 }
 
 func (p *pgsqlMutex) Release(ctx context.Context, sagaId string) error {
@@ -147,7 +229,7 @@ func (p *pgsqlMutex) Release(ctx context.Context, sagaId string) error {
 
 	conn, exists := p.connections[sagaId]
 	if !exists {
-		return WithMutexErr(errors.Errorf("connection which acquiring lock is not found in runtime map. Was Release() called after processing a message?"))
+		return WithMutexErr(errors.Wrap(ErrLockNotHeld, notHeldMsg))
 	}
 
 	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1));", sagaId); err != nil {
@@ -0,0 +1,203 @@
+package mutex
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/pkg/errors"
+)
+
+const leaseTableName = "saga_lock"
+
+type sqlLeaseBackend struct {
+	db                *sql.DB
+	driver            saga.SQLDriver
+	withoutMigrations bool
+	initOnce          sync.Once
+	initErr           error
+}
+
+// NewSqlLeaseMutex creates LeaseMutex which keeps leases in a table. Unlike NewSqlMutex it doesn't hold a connection while a lock is acquired
+func NewSqlLeaseMutex(db *sql.DB, driver saga.SQLDriver, opts ...Option) (LeaseMutex, error) {
+	backend, err := NewSqlLeaseBackend(db, driver, opts...)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return NewLeaseMutex(backend, opts...), nil
+}
+
+// NewSqlLeaseBackend creates LeaseBackend based on saga_lock table, it supports mysql, postgres and sqlite drivers.
+// Expiration is stored in unix milliseconds, so clocks of the nodes sharing locks have to be in sync
+func NewSqlLeaseBackend(db *sql.DB, driver saga.SQLDriver, opts ...Option) (LeaseBackend, error) {
+	b := &sqlLeaseBackend{db: db, driver: driver, withoutMigrations: newOptions(opts...).withoutMigrations}
+
+	if err := b.ensureTables(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return b, nil
+}
+
+func (b *sqlLeaseBackend) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (int64, bool, error) {
+	if err := b.ensureTables(); err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+
+	//the lease is taken and its token is read in one transaction, so the token belongs to this owner
+	tx, err := b.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "beginning transaction to acquire lease of %s", key)
+	}
+
+	token, acquired, err := b.acquire(ctx, tx, key, owner, ttl)
+
+	if err != nil || !acquired {
+		if rErr := tx.Rollback(); rErr != nil {
+			return 0, false, errors.Wrapf(rErr, "rollback when acquiring lease of %s", key)
+		}
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, errors.Wrapf(err, "committing lease of %s", key)
+	}
+
+	return token, true, nil
+}
+
+func (b *sqlLeaseBackend) acquire(ctx context.Context, tx *sql.Tx, key, owner string, ttl time.Duration) (int64, bool, error) {
+	now := time.Now()
+
+	res, err := tx.ExecContext(ctx, b.insertQuery(), key, owner, now.Add(ttl).UnixNano()/int64(time.Millisecond))
+
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "inserting lease of %s", key)
+	}
+
+	inserted, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "getting response of insert query for lease of %s", key)
+	}
+
+	if inserted == 0 {
+		//lease exists, take it over if it's released or expired
+		res, err = tx.ExecContext(
+			ctx,
+			b.prepQuery(fmt.Sprintf("UPDATE %v SET owner=?, token=token+1, expires_at=? WHERE saga_uid=? AND expires_at<?;", leaseTableName)),
+			owner,
+			now.Add(ttl).UnixNano()/int64(time.Millisecond),
+			key,
+			now.UnixNano()/int64(time.Millisecond),
+		)
+
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "taking over lease of %s", key)
+		}
+
+		updated, err := res.RowsAffected()
+
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "getting response of update query for lease of %s", key)
+		}
+
+		if updated == 0 {
+			return 0, false, nil
+		}
+	}
+
+	var token int64
+	err = tx.QueryRowContext(ctx, b.prepQuery(fmt.Sprintf("SELECT token FROM %v WHERE saga_uid=? AND owner=?;", leaseTableName)), key, owner).Scan(&token)
+
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "querying token of lease %s", key)
+	}
+
+	return token, true, nil
+}
+
+func (b *sqlLeaseBackend) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	res, err := b.db.ExecContext(
+		ctx,
+		b.prepQuery(fmt.Sprintf("UPDATE %v SET expires_at=? WHERE saga_uid=? AND owner=? AND expires_at>=?;", leaseTableName)),
+		now.Add(ttl).UnixNano()/int64(time.Millisecond),
+		key,
+		owner,
+		now.UnixNano()/int64(time.Millisecond),
+	)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "renewing lease of %s", key)
+	}
+
+	rows, err := res.RowsAffected()
+
+	if err != nil {
+		return false, errors.Wrapf(err, "getting response of renew query for lease of %s", key)
+	}
+
+	return rows > 0, nil
+}
+
+func (b *sqlLeaseBackend) Release(ctx context.Context, key, owner string) (bool, error) {
+	//the row is kept, so fencing token keeps growing
+	res, err := b.db.ExecContext(ctx, b.prepQuery(fmt.Sprintf("UPDATE %v SET owner='', expires_at=0 WHERE saga_uid=? AND owner=?;", leaseTableName)), key, owner)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "releasing lease of %s", key)
+	}
+
+	rows, err := res.RowsAffected()
+
+	if err != nil {
+		return false, errors.Wrapf(err, "getting response of release query for lease of %s", key)
+	}
+
+	return rows > 0, nil
+}
+
+func (b *sqlLeaseBackend) insertQuery() string {
+	if b.driver == saga.MYSQLDriver {
+		return fmt.Sprintf("INSERT IGNORE INTO %v (saga_uid, owner, token, expires_at) VALUES (?, ?, 1, ?);", leaseTableName)
+	}
+
+	return b.prepQuery(fmt.Sprintf("INSERT INTO %v (saga_uid, owner, token, expires_at) VALUES (?, ?, 1, ?) ON CONFLICT (saga_uid) DO NOTHING;", leaseTableName))
+}
+
+// ensureTables creates saga_lock table once
+func (b *sqlLeaseBackend) ensureTables() error {
+	if b.withoutMigrations {
+		return nil
+	}
+
+	b.initOnce.Do(func() {
+		if err := b.initTables(); err != nil {
+			b.initErr = errors.Wrapf(err, "initializing tables for sql lease backend, driver %s", b.driver)
+		}
+	})
+
+	return b.initErr
+}
+
+func (b *sqlLeaseBackend) initTables() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	return sqlmigrate.NewMigrator(b.db, b.driver).Migrate(ctx, MigrationsComponent, Migrations())
+}
+
+// prepQuery replaces wildcard params to specific driver. Standard wildcard is '?'
+func (b *sqlLeaseBackend) prepQuery(query string) string {
+	return sqldriver.PrepQuery(b.driver, query)
+}
@@ -0,0 +1,36 @@
+package saga
+
+import (
+	"context"
+	"sync"
+)
+
+// UpdateHook is called after Store.Update saved a saga. events are history events which were added by the update
+type UpdateHook func(ctx context.Context, instance Instance, events []HistoryEvent)
+
+// Observable is implemented by stores which call hooks after a saga is updated, sql and memory stores implement it
+type Observable interface {
+	// OnUpdate registers a hook. It's called synchronously, so it must not block
+	OnUpdate(hook UpdateHook)
+}
+
+type updateHooks struct {
+	lock  sync.RWMutex
+	hooks []UpdateHook
+}
+
+func (h *updateHooks) OnUpdate(hook UpdateHook) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *updateHooks) notify(ctx context.Context, instance Instance, events []HistoryEvent) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for _, hook := range h.hooks {
+		hook(ctx, instance, events)
+	}
+}
@@ -0,0 +1,89 @@
+package saga
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/pkg/errors"
+)
+
+// PayloadUpgrade converts a stored saga payload from the previous schema version to the next one, i.e. renames or restructures fields.
+// payload is a decoded json object of the saga, numbers are json.Number
+type PayloadUpgrade func(payload map[string]interface{}) error
+
+// PayloadUpgrader keeps schema versions of saga payloads and upgrades between them.
+// Schema version of a saga type is the version of its last upgrade, it's 0 for a saga type without upgrades
+type PayloadUpgrader struct {
+	scheme   scheme.KnownTypesRegistry
+	upgrades map[scheme.GroupKind][]PayloadUpgrade
+}
+
+// NewPayloadUpgrader creates PayloadUpgrader, sagas of upgrades must be registered in the scheme
+func NewPayloadUpgrader(schemeRegistry scheme.KnownTypesRegistry) *PayloadUpgrader {
+	return &PayloadUpgrader{scheme: schemeRegistry, upgrades: make(map[scheme.GroupKind][]PayloadUpgrade)}
+}
+
+// AddUpgrade adds an upgrade of saga payload to the version. Upgrades of a saga type are added in order of versions starting from 1,
+// a payload stored with version N goes through upgrades from N+1 to the current version when it's loaded
+func (u *PayloadUpgrader) AddUpgrade(sagaObj Saga, version int, upgrade PayloadUpgrade) error {
+	if upgrade == nil {
+		return errors.Errorf("upgrade of %s to version %d is nil", reflect.TypeOf(sagaObj).String(), version)
+	}
+
+	groupKind, err := u.scheme.ObjectKind(sagaObj)
+
+	if err != nil {
+		return errors.Wrapf(err, "adding payload upgrade of %s", reflect.TypeOf(sagaObj).String())
+	}
+
+	if expected := len(u.upgrades[*groupKind]) + 1; version != expected {
+		return errors.Errorf("upgrade of %s to version %d is added out of order, expected version %d", groupKind.String(), version, expected)
+	}
+
+	u.upgrades[*groupKind] = append(u.upgrades[*groupKind], upgrade)
+
+	return nil
+}
+
+// Version returns current schema version of a saga type
+func (u PayloadUpgrader) Version(groupKind scheme.GroupKind) int {
+	return len(u.upgrades[groupKind])
+}
+
+// Upgrade converts a payload of the version into the current version of the saga type, a current payload is returned as is
+func (u PayloadUpgrader) Upgrade(groupKind scheme.GroupKind, version int, payload []byte) ([]byte, error) {
+	current := u.Version(groupKind)
+
+	if version == current {
+		return payload, nil
+	}
+
+	if version > current || version < 0 {
+		return nil, errors.Errorf("payload version %d of %s isn't supported, current version is %d", version, groupKind.String(), current)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	obj := make(map[string]interface{})
+
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, errors.Wrapf(err, "decoding payload of %s with version %d", groupKind.String(), version)
+	}
+
+	for next := version + 1; next <= current; next++ {
+		if err := u.upgrades[groupKind][next-1](obj); err != nil {
+			return nil, errors.Wrapf(err, "upgrading payload of %s to version %d", groupKind.String(), next)
+		}
+	}
+
+	upgraded, err := json.Marshal(obj)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "encoding payload of %s upgraded to version %d", groupKind.String(), current)
+	}
+
+	return upgraded, nil
+}
@@ -0,0 +1,77 @@
+package saga
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type upgradedSaga struct {
+	BaseSaga
+	Amount int64  `json:"amount"`
+	Name   string `json:"name"`
+}
+
+func (s *upgradedSaga) Init()                                {}
+func (s *upgradedSaga) Start(sagaCtx SagaContext) error      { return nil }
+func (s *upgradedSaga) Compensate(sagaCtx SagaContext) error { return nil }
+func (s *upgradedSaga) Recover(sagaCtx SagaContext) error    { return nil }
+
+func TestPayloadUpgrader(t *testing.T) {
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &upgradedSaga{})
+	groupKind := scheme.GroupKind{Group: "test", Kind: "upgradedSaga"}
+
+	upgrader := NewPayloadUpgrader(schemeRegistry)
+	require.NoError(t, upgrader.AddUpgrade(&upgradedSaga{}, 1, func(payload map[string]interface{}) error {
+		payload["name"] = payload["title"]
+		delete(payload, "title")
+		return nil
+	}))
+	require.NoError(t, upgrader.AddUpgrade(&upgradedSaga{}, 2, func(payload map[string]interface{}) error {
+		payload["name"] = "v2 " + payload["name"].(string)
+		return nil
+	}))
+
+	t.Run("upgrades are added in order", func(t *testing.T) {
+		assert.Error(t, upgrader.AddUpgrade(&upgradedSaga{}, 4, func(payload map[string]interface{}) error { return nil }))
+		assert.Error(t, upgrader.AddUpgrade(&upgradedSaga{}, 3, nil))
+		assert.Equal(t, 2, upgrader.Version(groupKind))
+		assert.Equal(t, 0, upgrader.Version(scheme.GroupKind{Group: "test", Kind: "unknown"}))
+	})
+
+	t.Run("payload is upgraded from its version", func(t *testing.T) {
+		upgraded, err := upgrader.Upgrade(groupKind, 0, []byte(`{"kind":"upgradedSaga","group":"test","amount":9007199254740993,"title":"order"}`))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"kind":"upgradedSaga","group":"test","amount":9007199254740993,"name":"v2 order"}`, string(upgraded))
+
+		upgraded, err = upgrader.Upgrade(groupKind, 1, []byte(`{"kind":"upgradedSaga","group":"test","name":"order"}`))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"kind":"upgradedSaga","group":"test","name":"v2 order"}`, string(upgraded))
+	})
+
+	t.Run("current payload is returned as is", func(t *testing.T) {
+		payload := []byte(`{"kind":"upgradedSaga","group":"test","name":"order"}`)
+		upgraded, err := upgrader.Upgrade(groupKind, 2, payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, upgraded)
+	})
+
+	t.Run("payload of newer version isn't supported", func(t *testing.T) {
+		_, err := upgrader.Upgrade(groupKind, 3, []byte(`{}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("failed upgrade", func(t *testing.T) {
+		failing := NewPayloadUpgrader(schemeRegistry)
+		require.NoError(t, failing.AddUpgrade(&upgradedSaga{}, 1, func(payload map[string]interface{}) error {
+			return errors.New("field is missing")
+		}))
+
+		_, err := failing.Upgrade(groupKind, 0, []byte(`{}`))
+		assert.EqualError(t, err, "upgrading payload of test.upgradedSaga to version 1: field is missing")
+	})
+}
@@ -0,0 +1,274 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/pkg/errors"
+)
+
+const archiveTableName = "saga_archive"
+
+// Record is an archived saga with its history
+type Record struct {
+	SagaUID    string          `json:"saga_uid"`
+	ParentUID  string          `json:"parent_uid,omitempty"`
+	SagaName   string          `json:"saga_name"`
+	Status     string          `json:"status"`
+	State      string          `json:"state,omitempty"`
+	Version    int             `json:"version"`
+	StartedAt  *time.Time      `json:"started_at"`
+	UpdatedAt  *time.Time      `json:"updated_at"`
+	ArchivedAt time.Time       `json:"archived_at"`
+	Payload    json.RawMessage `json:"payload"`
+	Events     []RecordEvent   `json:"events"`
+}
+
+// RecordEvent is an archived history event, Payload is the encoded event
+type RecordEvent struct {
+	UID        string          `json:"uid"`
+	Name       string          `json:"name"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Origin     string          `json:"origin"`
+	SagaStatus string          `json:"saga_status"`
+	TraceUID   string          `json:"trace_uid"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// NewRecord creates an archive record of a saga, payloads of the saga and its events are encoded with the marshaller
+func NewRecord(instance saga.Instance, marshaller message.Marshaller, archivedAt time.Time) (Record, error) {
+	payload, err := marshaller.Marshal(instance.Saga())
+
+	if err != nil {
+		return Record{}, errors.Wrapf(err, "marshaling saga %s", instance.UID())
+	}
+
+	record := Record{
+		SagaUID:    instance.UID(),
+		ParentUID:  instance.ParentID(),
+		SagaName:   instance.Saga().GroupKind().String(),
+		Status:     instance.Status().String(),
+		State:      instance.State(),
+		Version:    instance.Version(),
+		StartedAt:  instance.StartedAt(),
+		UpdatedAt:  instance.UpdatedAt(),
+		ArchivedAt: archivedAt.UTC(),
+		Payload:    payload,
+		Events:     make([]RecordEvent, 0, len(instance.HistoryEvents())),
+	}
+
+	for _, ev := range instance.HistoryEvents() {
+		evPayload, err := marshaller.Marshal(ev.Payload)
+
+		if err != nil {
+			return Record{}, errors.Wrapf(err, "marshaling history event %s of saga %s", ev.UID, instance.UID())
+		}
+
+		record.Events = append(record.Events, RecordEvent{
+			UID:        ev.UID,
+			Name:       ev.Payload.GroupKind().String(),
+			CreatedAt:  ev.CreatedAt,
+			Origin:     ev.OriginSource,
+			SagaStatus: ev.SagaStatus,
+			TraceUID:   ev.TraceUID,
+			Payload:    evPayload,
+		})
+	}
+
+	return record, nil
+}
+
+type fileArchiver struct {
+	dir        string
+	marshaller message.Marshaller
+	lock       sync.Mutex
+	now        func() time.Time
+}
+
+// NewFileArchiver creates Archiver which appends records as JSON lines to a file per day, dir/sagas-2006-01-02.jsonl.
+// A file is synced before sagas are deleted
+func NewFileArchiver(dir string, marshaller message.Marshaller) Archiver {
+	return &fileArchiver{dir: dir, marshaller: marshaller, now: time.Now}
+}
+
+func (a *fileArchiver) Archive(ctx context.Context, sagas []saga.Instance) error {
+	now := a.now().UTC()
+	var lines []byte
+
+	for _, instance := range sagas {
+		record, err := NewRecord(instance, a.marshaller, now)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		line, err := json.Marshal(record)
+
+		if err != nil {
+			return errors.Wrapf(err, "encoding archive record of saga %s", instance.UID())
+		}
+
+		lines = append(append(lines, line...), '\n')
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	path := filepath.Join(a.dir, fmt.Sprintf("sagas-%s.jsonl", now.Format("2006-01-02")))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return errors.Wrapf(err, "opening archive file %s", path)
+	}
+
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "writing archive file %s", path)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "syncing archive file %s", path)
+	}
+
+	return errors.Wrapf(file.Close(), "closing archive file %s", path)
+}
+
+// MigrationsComponent is a name under which migrations of sql archiver are recorded
+const MigrationsComponent = "saga_archive"
+
+// Migrations returns schema migrations of sql archiver. They are applied by NewSQLArchiver unless WithoutMigrations is passed
+func Migrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create saga_archive table",
+			Statements: func(driver sqldriver.Driver) []string {
+				//a record contains whole history, it could exceed 64KB of mysql text
+				recordType := "text"
+				if driver == sqldriver.MySQL {
+					recordType = "longtext"
+				}
+
+				return []string{fmt.Sprintf(`create table if not exists %v
+	(
+		uid varchar(255) not null primary key,
+		parent_uid varchar(255) null,
+		name varchar(255) null,
+		status varchar(255) null,
+		started_at timestamp null,
+		updated_at timestamp null,
+		archived_at timestamp not null,
+		record %s not null
+	);`, archiveTableName, recordType)}
+			},
+		},
+	}
+}
+
+type SQLArchiverOption func(a *sqlArchiver)
+
+// WithoutMigrations doesn't create saga_archive table, apply Migrations yourself
+func WithoutMigrations() SQLArchiverOption {
+	return func(a *sqlArchiver) {
+		a.withoutMigrations = true
+	}
+}
+
+type sqlArchiver struct {
+	db                *sql.DB
+	driver            saga.SQLDriver
+	marshaller        message.Marshaller
+	withoutMigrations bool
+}
+
+// NewSQLArchiver creates Archiver which inserts records into saga_archive table, it supports mysql, postgres and sqlite drivers.
+// A record of a saga archived again replaces the previous one
+func NewSQLArchiver(db *sql.DB, driver saga.SQLDriver, marshaller message.Marshaller, opts ...SQLArchiverOption) (Archiver, error) {
+	a := &sqlArchiver{db: db, driver: driver, marshaller: marshaller}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if !a.withoutMigrations {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		if err := sqlmigrate.NewMigrator(db, driver).Migrate(ctx, MigrationsComponent, Migrations()); err != nil {
+			return nil, errors.Wrapf(err, "initializing tables for sql archiver, driver %s", driver)
+		}
+	}
+
+	return a, nil
+}
+
+func (a *sqlArchiver) Archive(ctx context.Context, sagas []saga.Instance) error {
+	now := time.Now().UTC()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+
+	for _, instance := range sagas {
+		if err := a.insert(ctx, tx, instance, now); err != nil {
+			if rErr := tx.Rollback(); rErr != nil {
+				return errors.Wrapf(err, "rolling back transaction. %s", rErr)
+			}
+
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "committing transaction")
+}
+
+func (a *sqlArchiver) insert(ctx context.Context, tx *sql.Tx, instance saga.Instance, now time.Time) error {
+	record, err := NewRecord(instance, a.marshaller, now)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	encoded, err := json.Marshal(record)
+
+	if err != nil {
+		return errors.Wrapf(err, "encoding archive record of saga %s", instance.UID())
+	}
+
+	if _, err := tx.ExecContext(ctx, a.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE uid=?;", archiveTableName)), record.SagaUID); err != nil {
+		return errors.Wrapf(err, "deleting previous archive record of saga %s", record.SagaUID)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		a.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, parent_uid, name, status, started_at, updated_at, archived_at, record) VALUES (?, ?, ?, ?, ?, ?, ?, ?);", archiveTableName)),
+		record.SagaUID,
+		record.ParentUID,
+		record.SagaName,
+		record.Status,
+		sqldriver.NullTimestamp(a.driver, record.StartedAt),
+		sqldriver.NullTimestamp(a.driver, record.UpdatedAt),
+		sqldriver.Timestamp(a.driver, now),
+		string(encoded),
+	)
+
+	return errors.Wrapf(err, "inserting archive record of saga %s", record.SagaUID)
+}
+
+// prepQuery replaces wildcard params to specific driver. Standard wildcard is '?'
+func (a *sqlArchiver) prepQuery(query string) string {
+	return sqldriver.PrepQuery(a.driver, query)
+}
@@ -0,0 +1,318 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LockKey is a key of the leader lock, sagas are removed by one replica at a time
+	LockKey = "foreman.saga.retention"
+
+	defaultBatchSize = 100
+	defaultInterval  = time.Hour
+)
+
+// ErrLeadershipLost is returned when the lease of the leader lock is lost in the middle of a run
+var ErrLeadershipLost = errors.New("retention leader lock is lost")
+
+// Policy selects sagas which are removed
+type Policy struct {
+	// SagaName is a saga type as group.Kind. A policy without saga type applies to sagas of types which have no own policy
+	SagaName string
+	// MaxAge is how long a saga is kept after its last update
+	MaxAge time.Duration
+	// Statuses of removed sagas, completed, compensated and cancelled by default
+	Statuses []string
+}
+
+// Stats are amounts of rows removed by a batch
+type Stats struct {
+	// SagaName is a saga type of the policy, it's empty for a policy of all types
+	SagaName      string
+	Status        string
+	Sagas         int
+	HistoryEvents int
+	Archived      bool
+}
+
+// Metrics receives stats of every removed batch, plug a metrics client into it
+type Metrics interface {
+	BatchProcessed(stats Stats)
+}
+
+// MetricsFunc is a Metrics which calls the function
+type MetricsFunc func(stats Stats)
+
+func (f MetricsFunc) BatchProcessed(stats Stats) {
+	f(stats)
+}
+
+// Archiver persists sagas before they are deleted
+type Archiver interface {
+	// Archive is called with a batch of sagas with their history, sagas are deleted only if it succeeds.
+	// A saga could be archived again if its deletion failed
+	Archive(ctx context.Context, sagas []saga.Instance) error
+}
+
+type Option func(r *Retention)
+
+// WithArchiver archives sagas before they are deleted, they are only deleted by default
+func WithArchiver(archiver Archiver) Option {
+	return func(r *Retention) {
+		r.archiver = archiver
+	}
+}
+
+// WithBatchSize specifies how many sagas are loaded and removed at once, it's 100 by default
+func WithBatchSize(size int) Option {
+	return func(r *Retention) {
+		r.batchSize = size
+	}
+}
+
+// WithInterval specifies how often Run removes sagas, it's an hour by default
+func WithInterval(interval time.Duration) Option {
+	return func(r *Retention) {
+		r.interval = interval
+	}
+}
+
+// WithMetrics reports stats of every removed batch
+func WithMetrics(metrics Metrics) Option {
+	return func(r *Retention) {
+		r.metrics = metrics
+	}
+}
+
+// Retention removes sagas in terminal statuses which weren't updated longer than MaxAge of their policy
+type Retention struct {
+	store      saga.Store
+	leaderLock mutex.Mutex
+	logger     log.Logger
+	policies   []Policy
+	typed      map[string]bool
+	archiver   Archiver
+	metrics    Metrics
+	batchSize  int
+	interval   time.Duration
+	now        func() time.Time
+}
+
+// NewRetention creates Retention. leaderLock is optional, when it's nil every replica removes sagas.
+// Pass a mutex shared by replicas, i.e. mutex.NewSqlLeaseMutex, so only one of them removes sagas at a time
+func NewRetention(store saga.Store, leaderLock mutex.Mutex, logger log.Logger, policies []Policy, opts ...Option) (*Retention, error) {
+	r := &Retention{
+		store:      store,
+		leaderLock: leaderLock,
+		logger:     logger,
+		typed:      make(map[string]bool),
+		batchSize:  defaultBatchSize,
+		interval:   defaultInterval,
+		now:        time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.batchSize <= 0 || r.interval <= 0 {
+		return nil, errors.Errorf("batch size %d and interval %s must be positive", r.batchSize, r.interval)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, policy := range policies {
+		if policy.MaxAge <= 0 {
+			return nil, errors.Errorf("max age of policy for saga type `%s` must be positive", policy.SagaName)
+		}
+
+		if seen[policy.SagaName] {
+			return nil, errors.Errorf("policy for saga type `%s` is duplicated", policy.SagaName)
+		}
+
+		seen[policy.SagaName] = true
+
+		if policy.SagaName != "" {
+			r.typed[policy.SagaName] = true
+		}
+
+		if len(policy.Statuses) == 0 {
+			policy.Statuses = saga.FinishedStatuses()
+		}
+
+		r.policies = append(r.policies, policy)
+	}
+
+	return r, nil
+}
+
+// Run removes sagas every interval until ctx is done
+func (r *Retention) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RunOnce(ctx); err != nil {
+			r.logger.Logf(log.ErrorLevel, "error removing sagas by retention policies. %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce removes sagas matching policies and returns the amount of removed sagas.
+// Nothing is removed if the leader lock is held by another replica
+func (r *Retention) RunOnce(ctx context.Context) (int, error) {
+	var lost <-chan struct{}
+
+	if r.leaderLock != nil {
+		acquired, err := r.leaderLock.TryLock(ctx, LockKey)
+
+		if err != nil {
+			return 0, errors.Wrap(err, "acquiring retention leader lock")
+		}
+
+		if !acquired {
+			r.logger.Logf(log.DebugLevel, "retention leader lock is held by another replica")
+			return 0, nil
+		}
+
+		defer func() {
+			if err := r.leaderLock.Release(ctx, LockKey); err != nil {
+				r.logger.Logf(log.WarnLevel, "error releasing retention leader lock. %s", err)
+			}
+		}()
+
+		//a lease which isn't renewed in time could be taken over by another replica
+		if leaseMutex, ok := r.leaderLock.(mutex.LeaseMutex); ok {
+			if lease, ok := leaseMutex.Lease(LockKey); ok {
+				lost = lease.Done()
+			}
+		}
+	}
+
+	removed := 0
+
+	for _, policy := range r.policies {
+		for _, status := range policy.Statuses {
+			n, err := r.apply(ctx, policy, status, lost)
+			removed += n
+
+			if err != nil {
+				return removed, errors.Wrapf(err, "applying policy for saga type `%s` to %s sagas", policy.SagaName, status)
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+func (r *Retention) apply(ctx context.Context, policy Policy, status string, lost <-chan struct{}) (int, error) {
+	filters := []saga.FilterOption{
+		saga.WithStatus(status),
+		saga.WithUpdatedBetween(time.Time{}, r.now().Add(-policy.MaxAge)),
+		saga.WithSort(saga.SortByUpdatedAt, saga.SortAsc),
+		saga.WithLimit(r.batchSize),
+	}
+
+	if policy.SagaName != "" {
+		filters = append(filters, saga.WithSagaName(policy.SagaName))
+	}
+
+	var (
+		cursor  string
+		removed int
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return removed, errors.WithStack(ctx.Err())
+		case <-lost:
+			return removed, errors.WithStack(ErrLeadershipLost)
+		default:
+		}
+
+		page := filters
+		if cursor != "" {
+			page = append(append([]saga.FilterOption{}, filters...), saga.WithCursor(cursor))
+		}
+
+		instances, err := r.store.GetByFilter(ctx, page...)
+
+		if err != nil {
+			return removed, errors.Wrap(err, "loading sagas")
+		}
+
+		if len(instances) == 0 {
+			return removed, nil
+		}
+
+		//sagas which are left go before the cursor, deleted ones don't affect it
+		cursor = saga.NextCursor(instances[len(instances)-1], filters...)
+
+		batch := make([]saga.Instance, 0, len(instances))
+
+		for _, instance := range instances {
+			//sagas of types with own policy are kept according to it
+			if policy.SagaName == "" && r.typed[instance.Saga().GroupKind().String()] {
+				continue
+			}
+
+			batch = append(batch, instance)
+		}
+
+		n, err := r.remove(ctx, policy, status, batch)
+		removed += n
+
+		if err != nil {
+			return removed, errors.WithStack(err)
+		}
+
+		if len(instances) < r.batchSize {
+			return removed, nil
+		}
+	}
+}
+
+func (r *Retention) remove(ctx context.Context, policy Policy, status string, batch []saga.Instance) (int, error) {
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	stats := Stats{SagaName: policy.SagaName, Status: status, Archived: r.archiver != nil}
+
+	if r.archiver != nil {
+		if err := r.archiver.Archive(ctx, batch); err != nil {
+			return 0, errors.Wrap(err, "archiving sagas")
+		}
+	}
+
+	var err error
+
+	for _, instance := range batch {
+		if err = r.store.Delete(ctx, instance.UID()); err != nil {
+			err = errors.Wrapf(err, "deleting saga %s", instance.UID())
+			break
+		}
+
+		stats.Sagas++
+		stats.HistoryEvents += len(instance.HistoryEvents())
+	}
+
+	if r.metrics != nil && stats.Sagas > 0 {
+		r.metrics.BatchProcessed(stats)
+	}
+
+	return stats.Sagas, err
+}
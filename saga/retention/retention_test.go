@@ -0,0 +1,216 @@
+package retention
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderSaga struct {
+	saga.BaseSaga
+	OrderID string `json:"order_id"`
+}
+
+func (s *orderSaga) Init()                                     {}
+func (s *orderSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *orderSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *orderSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type invoiceSaga struct {
+	orderSaga
+}
+
+type archiverFunc func(ctx context.Context, sagas []saga.Instance) error
+
+func (f archiverFunc) Archive(ctx context.Context, sagas []saga.Instance) error {
+	return f(ctx, sagas)
+}
+
+func TestRetention(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &orderSaga{}, &invoiceSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	newStore := func(t *testing.T) saga.Store {
+		store := saga.NewMemoryStore(marshaller)
+
+		create := func(uid string, sagaObj saga.Saga, kind string, update func(instance saga.Instance)) {
+			sagaObj.SetGroupKind(&scheme.GroupKind{Group: "test", Kind: kind})
+			instance := saga.NewSagaInstance(uid, "", sagaObj)
+			require.NoError(t, store.Create(ctx, instance))
+			update(instance)
+			instance.AddHistoryEvent(&contracts.StepCompletedEvent{Step: "step", Sequence: 1})
+			require.NoError(t, store.Update(ctx, instance))
+		}
+
+		create("completed-1", &orderSaga{OrderID: "1"}, "orderSaga", func(instance saga.Instance) { instance.Complete() })
+		create("completed-2", &orderSaga{OrderID: "2"}, "orderSaga", func(instance saga.Instance) { instance.Complete() })
+		create("compensated", &orderSaga{OrderID: "3"}, "orderSaga", func(instance saga.Instance) { instance.CompleteCompensation() })
+		create("failed", &orderSaga{OrderID: "4"}, "orderSaga", func(instance saga.Instance) { instance.Fail(nil) })
+		create("in-progress", &orderSaga{OrderID: "5"}, "orderSaga", func(instance saga.Instance) { instance.Progress() })
+		create("invoice", &invoiceSaga{}, "invoiceSaga", func(instance saga.Instance) { instance.Complete() })
+
+		return store
+	}
+
+	remaining := func(t *testing.T, store saga.Store) []string {
+		instances, err := store.GetByFilter(ctx, saga.WithLimit(100))
+		require.NoError(t, err)
+
+		var uids []string
+		for _, instance := range instances {
+			uids = append(uids, instance.UID())
+		}
+		sort.Strings(uids)
+
+		return uids
+	}
+
+	//sagas were updated an hour ago
+	later := func() time.Time {
+		return time.Now().Add(time.Hour)
+	}
+
+	t.Run("removes sagas by policies", func(t *testing.T) {
+		store := newStore(t)
+		dir := t.TempDir()
+		var stats []Stats
+
+		r, err := NewRetention(store, nil, log.NewNilLogger(), []Policy{
+			{MaxAge: time.Minute * 30},
+			{SagaName: "test.invoiceSaga", MaxAge: time.Hour * 2},
+		}, WithBatchSize(1), WithArchiver(NewFileArchiver(dir, marshaller)), WithMetrics(MetricsFunc(func(s Stats) {
+			stats = append(stats, s)
+		})))
+		require.NoError(t, err)
+		r.now = later
+
+		removed, err := r.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, removed)
+		assert.Equal(t, []string{"failed", "in-progress", "invoice"}, remaining(t, store))
+
+		assert.Equal(t, []Stats{
+			{Status: "completed", Sagas: 1, HistoryEvents: 1, Archived: true},
+			{Status: "completed", Sagas: 1, HistoryEvents: 1, Archived: true},
+			{Status: "compensated", Sagas: 1, HistoryEvents: 1, Archived: true},
+		}, stats)
+
+		file, err := os.Open(filepath.Join(dir, "sagas-"+time.Now().UTC().Format("2006-01-02")+".jsonl"))
+		require.NoError(t, err)
+		defer file.Close()
+
+		var records []Record
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			record := Record{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		require.NoError(t, scanner.Err())
+
+		require.Len(t, records, 3)
+		assert.Equal(t, "completed-1", records[0].SagaUID)
+		assert.Equal(t, "test.orderSaga", records[0].SagaName)
+		assert.Equal(t, "completed", records[0].Status)
+		require.Len(t, records[0].Events, 1)
+		assert.Equal(t, "systemSaga.StepCompletedEvent", records[0].Events[0].Name)
+
+		archived, err := marshaller.Unmarshal(records[0].Payload)
+		require.NoError(t, err)
+		assert.Equal(t, "1", archived.(*orderSaga).OrderID)
+
+		//a saga of a type with own policy is removed when it's old enough for it
+		r.now = func() time.Time {
+			return time.Now().Add(time.Hour * 3)
+		}
+		removed, err = r.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		assert.Equal(t, []string{"failed", "in-progress"}, remaining(t, store))
+	})
+
+	t.Run("policy statuses", func(t *testing.T) {
+		store := newStore(t)
+
+		r, err := NewRetention(store, nil, log.NewNilLogger(), []Policy{{MaxAge: time.Minute, Statuses: []string{"failed"}}})
+		require.NoError(t, err)
+		r.now = later
+
+		removed, err := r.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		assert.NotContains(t, remaining(t, store), "failed")
+	})
+
+	t.Run("sagas aren't deleted when archiving fails", func(t *testing.T) {
+		store := newStore(t)
+
+		r, err := NewRetention(store, nil, log.NewNilLogger(), []Policy{{MaxAge: time.Minute}}, WithArchiver(archiverFunc(func(ctx context.Context, sagas []saga.Instance) error {
+			return errors.New("disk is full")
+		})))
+		require.NoError(t, err)
+		r.now = later
+
+		removed, err := r.RunOnce(ctx)
+		assert.Error(t, err)
+		assert.Equal(t, 0, removed)
+		assert.Len(t, remaining(t, store), 6)
+	})
+
+	t.Run("only leader removes sagas", func(t *testing.T) {
+		store := newStore(t)
+		backend := mutex.NewMemoryLeaseBackend()
+		other := mutex.NewLeaseMutex(backend)
+
+		acquired, err := other.TryLock(ctx, LockKey)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		r, err := NewRetention(store, mutex.NewLeaseMutex(backend), log.NewNilLogger(), []Policy{{MaxAge: time.Minute}})
+		require.NoError(t, err)
+		r.now = later
+
+		removed, err := r.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed)
+
+		require.NoError(t, other.Release(ctx, LockKey))
+
+		removed, err = r.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 4, removed)
+
+		//the lock is released after the run
+		acquired, err = other.TryLock(ctx, LockKey)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("invalid policies", func(t *testing.T) {
+		_, err := NewRetention(saga.NewMemoryStore(marshaller), nil, log.NewNilLogger(), []Policy{{}})
+		assert.Error(t, err)
+
+		_, err = NewRetention(saga.NewMemoryStore(marshaller), nil, log.NewNilLogger(), []Policy{{MaxAge: time.Hour}, {MaxAge: time.Minute}})
+		assert.Error(t, err)
+	})
+}
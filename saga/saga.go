@@ -22,6 +22,9 @@ type BaseSaga struct {
 	message.ObjectMeta
 	adjacencyMap map[scheme.GroupKind]Executor
 	scheme       scheme.KnownTypesRegistry
+	steps        []Step
+	machine      stateMachine
+	dispatches   map[scheme.GroupKind][]scheme.GroupKind
 }
 
 type Executor func(execCtx SagaContext) error
@@ -32,24 +35,63 @@ func (b *BaseSaga) AddEventHandler(ev message.Object, handler Executor) *BaseSag
 		b.adjacencyMap = make(map[scheme.GroupKind]Executor)
 	}
 
-	if b.scheme == nil {
-		panic("schema wasn't set")
+	b.adjacencyMap[b.objectKind(ev)] = handler
+	return b
+}
+
+func (b *BaseSaga) SetSchema(scheme scheme.KnownTypesRegistry) {
+	b.scheme = scheme
+}
+
+func (b BaseSaga) EventHandlers() map[scheme.GroupKind]Executor {
+	return b.adjacencyMap
+}
+
+// DeclareDispatch documents that the handler of ev dispatches messages, pass nil ev for messages dispatched by Start.
+// Declarations don't affect saga execution, they are used to render saga graph
+func (b *BaseSaga) DeclareDispatch(ev message.Object, dispatched ...message.Object) *BaseSaga {
+	if b.dispatches == nil {
+		b.dispatches = make(map[scheme.GroupKind][]scheme.GroupKind)
 	}
 
-	groupKind, err := b.scheme.ObjectKind(ev)
+	var on scheme.GroupKind
 
-	if err != nil {
-		panic(fmt.Sprintf("ev %s is not registered in schema", reflect.TypeOf(ev).String()))
+	if ev != nil {
+		on = b.objectKind(ev)
+	}
+
+	//Init could be called again on the same saga
+declared:
+	for _, obj := range dispatched {
+		groupKind := b.objectKind(obj)
+
+		for _, existing := range b.dispatches[on] {
+			if existing == groupKind {
+				continue declared
+			}
+		}
+
+		b.dispatches[on] = append(b.dispatches[on], groupKind)
 	}
 
-	b.adjacencyMap[*groupKind] = handler
 	return b
 }
 
-func (b *BaseSaga) SetSchema(scheme scheme.KnownTypesRegistry) {
-	b.scheme = scheme
+// DeclaredDispatches returns messages declared with DeclareDispatch by handled event, messages dispatched by Start are under zero GroupKind
+func (b BaseSaga) DeclaredDispatches() map[scheme.GroupKind][]scheme.GroupKind {
+	return b.dispatches
 }
 
-func (b BaseSaga) EventHandlers() map[scheme.GroupKind]Executor {
-	return b.adjacencyMap
+func (b *BaseSaga) objectKind(obj message.Object) scheme.GroupKind {
+	if b.scheme == nil {
+		panic("schema wasn't set")
+	}
+
+	groupKind, err := b.scheme.ObjectKind(obj)
+
+	if err != nil {
+		panic(fmt.Sprintf("ev %s is not registered in schema", reflect.TypeOf(obj).String()))
+	}
+
+	return *groupKind
 }
@@ -7,26 +7,67 @@ import (
 	"github.com/google/uuid"
 )
 
+// Saga statuses as they are returned by Status.String and stored by Store
 const (
-	sagaStatusInProgress   status = "in_progress"
-	sagaStatusFailed       status = "failed"
-	sagaStatusCompleted    status = "completed"
-	sagaStatusCreated      status = "created"
-	sagaStatusCompensating status = "compensating"
-	sagaStatusRecovering   status = "recovering"
+	StatusInProgress   = "in_progress"
+	StatusFailed       = "failed"
+	StatusCompleted    = "completed"
+	StatusCreated      = "created"
+	StatusCompensating = "compensating"
+	StatusRecovering   = "recovering"
+	StatusCompensated  = "compensated"
+	StatusSuspended    = "suspended"
+	StatusCancelled    = "cancelled"
 )
 
+const (
+	sagaStatusInProgress   status = StatusInProgress
+	sagaStatusFailed       status = StatusFailed
+	sagaStatusCompleted    status = StatusCompleted
+	sagaStatusCreated      status = StatusCreated
+	sagaStatusCompensating status = StatusCompensating
+	sagaStatusRecovering   status = StatusRecovering
+	sagaStatusCompensated  status = StatusCompensated
+	sagaStatusSuspended    status = StatusSuspended
+	sagaStatusCancelled    status = StatusCancelled
+)
+
+// FinishedStatuses are final statuses of a saga, a saga in them doesn't handle events and commands anymore
+func FinishedStatuses() []string {
+	return []string{StatusCompleted, StatusCompensated, StatusCancelled}
+}
+
+// Finished is true when a saga reached one of FinishedStatuses
+func Finished(status Status) bool {
+	return status.Completed() || status.Compensated() || status.Cancelled()
+}
+
 type Instance interface {
 	UID() string
 	Saga() Saga
 	Status() Status
+	// State is a state of saga's state machine, it's empty if the saga doesn't declare one
+	State() string
+	SetState(state string)
+	// Correlate associates the saga with a value, events which have no saga uid in headers find it by the value. See CorrelationRule
+	Correlate(key, value string)
+	// Correlations returns correlation values of the saga by their keys
+	Correlations() map[string]string
 
 	Start(sagaCtx SagaContext) error
 	Compensate(sagaCtx SagaContext) error
 	Recover(sagaCtx SagaContext) error
 	Progress()
 	Complete()
+	// CompleteCompensation is called by a saga when all compensating actions are done
+	CompleteCompensation()
 	Fail(ev message.Object)
+	// Suspend pauses a saga, its events are parked until it's resumed
+	Suspend()
+	// Resume returns a suspended saga into the status it had before suspension
+	Resume()
+	// Cancel stops a saga for good, it doesn't handle events anymore
+	Cancel()
 
 	HistoryEvents() []HistoryEvent
 	AddHistoryEvent(ev message.Object, opts ...AddEvOpt)
@@ -34,6 +75,8 @@ type Instance interface {
 	StartedAt() *time.Time
 	UpdatedAt() *time.Time
 	ParentID() string
+	// Version is incremented by Store on every update, a stale version means the instance was modified after it had been loaded
+	Version() int
 }
 
 type Status interface {
@@ -42,7 +85,12 @@ type Status interface {
 	FailedOnEvent() message.Object
 	Recovering() bool
 	Compensating() bool
+	Compensated() bool
 	Completed() bool
+	Suspended() bool
+	// SuspendedFrom is a status a suspended saga is resumed into
+	SuspendedFrom() string
+	Cancelled() bool
 	String() string
 }
 
@@ -61,11 +109,14 @@ func NewSagaInstance(id, parentId string, saga Saga) Instance {
 type sagaInstance struct {
 	uid            string
 	parentID       string
+	state          string
+	correlations   map[string]string
 	saga           Saga
 	historyEvents  []HistoryEvent
 	startedAt      *time.Time
 	updatedAt      *time.Time
 	instanceStatus instanceStatus
+	version        int
 }
 
 func (s sagaInstance) ParentID() string {
@@ -84,6 +135,26 @@ func (s sagaInstance) Status() Status {
 	return s.instanceStatus
 }
 
+func (s sagaInstance) State() string {
+	return s.state
+}
+
+func (s *sagaInstance) SetState(state string) {
+	s.state = state
+}
+
+func (s *sagaInstance) Correlate(key, value string) {
+	if s.correlations == nil {
+		s.correlations = make(map[string]string)
+	}
+
+	s.correlations[key] = value
+}
+
+func (s sagaInstance) Correlations() map[string]string {
+	return s.correlations
+}
+
 func (s *sagaInstance) Start(sagaCtx SagaContext) error {
 	s.instanceStatus.status = sagaStatusInProgress
 	current := time.Now().Round(time.Second).UTC()
@@ -109,6 +180,11 @@ func (s *sagaInstance) Complete() {
 	s.update()
 }
 
+func (s *sagaInstance) CompleteCompensation() {
+	s.instanceStatus.status = sagaStatusCompensated
+	s.update()
+}
+
 func (s *sagaInstance) Progress() {
 	s.instanceStatus.status = sagaStatusInProgress
 }
@@ -119,6 +195,29 @@ func (s *sagaInstance) Fail(ev message.Object) {
 	s.update()
 }
 
+func (s *sagaInstance) Suspend() {
+	s.instanceStatus.suspendedFrom = s.instanceStatus.status
+	s.instanceStatus.status = sagaStatusSuspended
+	s.update()
+}
+
+func (s *sagaInstance) Resume() {
+	s.instanceStatus.status = s.instanceStatus.suspendedFrom
+
+	//saga suspended before its status was remembered continues in progress
+	if s.instanceStatus.status == "" {
+		s.instanceStatus.status = sagaStatusInProgress
+	}
+
+	s.instanceStatus.suspendedFrom = ""
+	s.update()
+}
+
+func (s *sagaInstance) Cancel() {
+	s.instanceStatus.status = sagaStatusCancelled
+	s.update()
+}
+
 func (s sagaInstance) HistoryEvents() []HistoryEvent {
 	return s.historyEvents
 }
@@ -137,6 +236,14 @@ func (s sagaInstance) UpdatedAt() *time.Time {
 	return s.updatedAt
 }
 
+func (s sagaInstance) Version() int {
+	return s.version
+}
+
+func (s *sagaInstance) setVersion(version int) {
+	s.version = version
+}
+
 func (s *sagaInstance) update() {
 	currentTime := time.Now().Round(time.Second).UTC()
 	s.updatedAt = &currentTime
@@ -205,19 +312,36 @@ func (s status) Compensating() bool {
 	return s == sagaStatusCompensating
 }
 
+func (s status) Compensated() bool {
+	return s == sagaStatusCompensated
+}
+
 func (s status) Completed() bool {
 	return s == sagaStatusCompleted
 }
 
+func (s status) Suspended() bool {
+	return s == sagaStatusSuspended
+}
+
+func (s status) Cancelled() bool {
+	return s == sagaStatusCancelled
+}
+
 func (s status) String() string {
 	return string(s)
 }
 
 type instanceStatus struct {
 	status
-	lastFailedEv message.Object
+	lastFailedEv  message.Object
+	suspendedFrom status
 }
 
 func (i instanceStatus) FailedOnEvent() message.Object {
 	return i.lastFailedEv
 }
+
+func (i instanceStatus) SuspendedFrom() string {
+	return i.suspendedFrom.String()
+}
@@ -4,37 +4,90 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
 	"github.com/pkg/errors"
 )
 
 const (
-	MYSQLDriver SQLDriver = "mysql"
-	PGDriver    SQLDriver = "pg"
+	MYSQLDriver  = sqldriver.MySQL
+	PGDriver     = sqldriver.Postgres
+	SQLiteDriver = sqldriver.SQLite
 )
 
-type SQLDriver string
+type SQLDriver = sqldriver.Driver
 
 type sqlStore struct {
+	*updateHooks
 	msgMarshaller message.Marshaller
 	db            *sql.DB
 	driver        SQLDriver
+	outbox        outbox.Store
+	upgrader      *PayloadUpgrader
 }
 
-// NewSQLSagaStore creates sql saga store, it supports mysql and postgres drivers.
+// SQLStoreOption configures sql saga store
+type SQLStoreOption func(o *sqlStoreOpts)
+
+type sqlStoreOpts struct {
+	withoutMigrations bool
+	upgrader          *PayloadUpgrader
+}
+
+// WithoutMigrations disables applying schema migrations of the store and its outbox, i.e. when a DBA applies them using sqlmigrate.Migrator SQL
+func WithoutMigrations() SQLStoreOption {
+	return func(o *sqlStoreOpts) {
+		o.withoutMigrations = true
+	}
+}
+
+// WithPayloadUpgrader upgrades payloads stored with previous schema versions of their saga types when sagas are loaded.
+// An upgraded payload is written back with the current version on the next Update
+func WithPayloadUpgrader(upgrader *PayloadUpgrader) SQLStoreOption {
+	return func(o *sqlStoreOpts) {
+		o.upgrader = upgrader
+	}
+}
+
+// NewSQLSagaStore creates sql saga store, it supports mysql, postgres and sqlite drivers.
 // driver param is required because of https://github.com/golang/go/issues/3602. Better this than +1 dependency or copy pasting code
-func NewSQLSagaStore(db *sql.DB, driver SQLDriver, msgMarshaller message.Marshaller) (Store, error) {
-	s := &sqlStore{db: db, driver: driver, msgMarshaller: msgMarshaller}
-	if err := s.initTables(); err != nil {
+// Pending deliveries are written into sql outbox in the same database.
+func NewSQLSagaStore(db *sql.DB, driver SQLDriver, msgMarshaller message.Marshaller, opts ...SQLStoreOption) (Store, error) {
+	storeOpts := &sqlStoreOpts{}
+	for _, opt := range opts {
+		opt(storeOpts)
+	}
+
+	s := &sqlStore{db: db, driver: driver, msgMarshaller: msgMarshaller, updateHooks: &updateHooks{}, upgrader: storeOpts.upgrader}
+
+	var outboxOpts []outbox.SQLStoreOption
+
+	if storeOpts.withoutMigrations {
+		outboxOpts = append(outboxOpts, outbox.WithoutMigrations())
+	} else if err := s.migrate(); err != nil {
 		return nil, errors.Wrapf(err, "initializing tables for SQLSagaStore, driver %s", driver)
 	}
 
+	outboxStore, err := outbox.NewSQLStore(db, driver, msgMarshaller, outboxOpts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s.outbox = outboxStore
+
 	return s, nil
 }
 
+func (s sqlStore) Outbox() outbox.Store {
+	return s.outbox
+}
+
 // Create saves saga instance into mysql store. History events, last failed event are not persisted at this step, there is not way for them to be at creation step.
 func (s sqlStore) Create(ctx context.Context, sagaInstance Instance) error {
 	payload, err := s.msgMarshaller.Marshal(sagaInstance.Saga())
@@ -49,14 +102,17 @@ func (s sqlStore) Create(ctx context.Context, sagaInstance Instance) error {
 		return errors.Wrapf(err, "beginning a transaction for saga %s", sagaInstance.UID())
 	}
 
-	_, err = tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, parent_uid, name, payload, status, started_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?);", sagaTableName)),
+	_, err = tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, parent_uid, name, payload, payload_version, status, state, started_at, updated_at, version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);", sagaTableName)),
 		sagaInstance.UID(),
 		sagaInstance.ParentID(),
 		sagaInstance.Saga().GroupKind().String(),
 		payload,
+		s.payloadVersion(sagaInstance.Saga()),
 		sagaInstance.Status().String(),
-		sagaInstance.StartedAt(),
-		sagaInstance.UpdatedAt(),
+		sagaInstance.State(),
+		sqldriver.NullTimestamp(s.driver, sagaInstance.StartedAt()),
+		sqldriver.NullTimestamp(s.driver, sagaInstance.UpdatedAt()),
+		sagaInstance.Version(),
 	)
 	if err != nil {
 		if rErr := tx.Rollback(); rErr != nil {
@@ -65,6 +121,13 @@ func (s sqlStore) Create(ctx context.Context, sagaInstance Instance) error {
 		return errors.Wrapf(err, "inserting saga instance %s", sagaInstance.UID())
 	}
 
+	if err := s.saveCorrelations(ctx, tx, sagaInstance); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return errors.WithStack(err)
+	}
+
 	if err := tx.Commit(); err != nil {
 		return errors.Wrapf(err, "committing saga instance %s into the store", sagaInstance.UID())
 	}
@@ -72,7 +135,49 @@ func (s sqlStore) Create(ctx context.Context, sagaInstance Instance) error {
 	return nil
 }
 
-func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
+func (s sqlStore) GetByCorrelation(ctx context.Context, key, value string) (Instance, error) {
+	var sagaUID string
+
+	err := s.db.QueryRowContext(ctx, s.prepQuery(fmt.Sprintf("SELECT saga_uid FROM %v WHERE name=? AND value=?;", sagaCorrelationTableName)), key, value).Scan(&sagaUID)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "querying saga correlated by %s=%s", key, value)
+	}
+
+	return s.GetById(ctx, sagaUID)
+}
+
+// saveCorrelations replaces correlation values of a saga, a value which is used by another saga violates primary key
+func (s sqlStore) saveCorrelations(ctx context.Context, tx *sql.Tx, sagaInstance Instance) error {
+	if len(sagaInstance.Correlations()) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE saga_uid=?;", sagaCorrelationTableName)), sagaInstance.UID()); err != nil {
+		return errors.Wrapf(err, "deleting correlations of saga %s", sagaInstance.UID())
+	}
+
+	for key, value := range sagaInstance.Correlations() {
+		_, err := tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("INSERT INTO %v (name, value, saga_uid) VALUES (?, ?, ?);", sagaCorrelationTableName)), key, value, sagaInstance.UID())
+
+		if err != nil {
+			return errors.Wrapf(err, "correlating saga %s by %s=%s", sagaInstance.UID(), key, value)
+		}
+	}
+
+	return nil
+}
+
+// Update saves saga instance if its version wasn't changed since it had been loaded, otherwise VersionConflictError is returned
+func (s sqlStore) Update(ctx context.Context, sagaInstance Instance, opts ...UpdateOption) error {
+	updateOpts := &updateOptions{}
+	for _, opt := range opts {
+		opt(updateOpts)
+	}
+
 	payload, err := s.msgMarshaller.Marshal(sagaInstance.Saga())
 	sagaName := sagaInstance.Saga().GroupKind().String()
 
@@ -97,15 +202,22 @@ func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
 		return errors.WithStack(err)
 	}
 
-	_, err = tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("UPDATE %v SET parent_uid=?, name=?, payload=?, status=?, started_at=?, updated_at=?, last_failed_ev=? WHERE uid=?;", sagaTableName)),
+	nextVersion := sagaInstance.Version() + 1
+
+	res, err := tx.ExecContext(ctx, s.prepQuery(fmt.Sprintf("UPDATE %v SET parent_uid=?, name=?, payload=?, payload_version=?, status=?, suspended_from=?, state=?, started_at=?, updated_at=?, last_failed_ev=?, version=? WHERE uid=? AND version=?;", sagaTableName)),
 		sagaInstance.ParentID(),
 		sagaName,
 		payload,
+		s.payloadVersion(sagaInstance.Saga()),
 		sagaInstance.Status().String(),
-		sagaInstance.StartedAt(),
-		sagaInstance.UpdatedAt(),
+		sagaInstance.Status().SuspendedFrom(),
+		sagaInstance.State(),
+		sqldriver.NullTimestamp(s.driver, sagaInstance.StartedAt()),
+		sqldriver.NullTimestamp(s.driver, sagaInstance.UpdatedAt()),
 		lastFailedEv,
+		nextVersion,
 		sagaInstance.UID(),
+		sagaInstance.Version(),
 	)
 
 	if err != nil {
@@ -115,6 +227,26 @@ func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
 		return errors.WithStack(err)
 	}
 
+	affected, err := res.RowsAffected()
+
+	if err == nil && affected == 0 {
+		err = VersionConflictError{SagaUID: sagaInstance.UID(), Version: sagaInstance.Version()}
+	}
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "error rollback when %s", err)
+		}
+		return errors.WithStack(err)
+	}
+
+	if err := s.saveCorrelations(ctx, tx, sagaInstance); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return errors.Wrapf(rErr, "rollback when %s", err)
+		}
+		return errors.WithStack(err)
+	}
+
 	rows, err := tx.QueryContext(ctx, s.prepQuery(fmt.Sprintf("SELECT uid FROM %v WHERE saga_uid=?;", sagaHistoryTableName)), sagaInstance.UID())
 
 	if err != nil {
@@ -140,12 +272,16 @@ func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
 		eventsIDs[eventID] = eventID
 	}
 
+	var newEvents []HistoryEvent
+
 	if len(eventsIDs) < len(sagaInstance.HistoryEvents()) {
-		for _, ev := range sagaInstance.HistoryEvents() {
+		for i, ev := range sagaInstance.HistoryEvents() {
 			if _, exists := eventsIDs[ev.UID]; exists {
 				continue
 			}
 
+			newEvents = append(newEvents, ev)
+
 			payload, err := s.msgMarshaller.Marshal(ev.Payload)
 
 			if err != nil {
@@ -156,14 +292,16 @@ func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
 				return errors.WithStack(err)
 			}
 
-			_, err = tx.Exec(s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, saga_uid, name, status, payload, origin, created_at, trace_uid) VALUES (?, ?, ?, ?, ?, ?, ?, ?);", sagaHistoryTableName)),
+			//created_at has precision of a second, history is ordered by position of an event in it. Rows inserted before seq was added have 0
+			_, err = tx.Exec(s.prepQuery(fmt.Sprintf("INSERT INTO %v (uid, saga_uid, seq, name, status, payload, origin, created_at, trace_uid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);", sagaHistoryTableName)),
 				ev.UID,
 				sagaInstance.UID(),
+				i+1,
 				ev.Payload.GroupKind().String(),
 				ev.SagaStatus,
 				payload,
 				ev.OriginSource,
-				ev.CreatedAt,
+				sqldriver.Timestamp(s.driver, ev.CreatedAt),
 				ev.TraceUID,
 			)
 
@@ -176,25 +314,44 @@ func (s sqlStore) Update(ctx context.Context, sagaInstance Instance) error {
 		}
 	}
 
+	for _, delivery := range updateOpts.deliveries {
+		if err := s.outbox.Add(ctx, tx, delivery.Message, delivery.Options...); err != nil {
+			if rErr := tx.Rollback(); rErr != nil {
+				return errors.Wrapf(rErr, "rollback when %s", err)
+			}
+			return errors.Wrapf(err, "persisting delivery %s for saga %s", delivery.Message.UID(), sagaInstance.UID())
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return errors.Wrapf(err, "committing update of events for saga %s", sagaInstance.UID())
 	}
 
+	if setter, ok := sagaInstance.(versionSetter); ok {
+		setter.setVersion(nextVersion)
+	}
+
+	s.notify(ctx, sagaInstance, newEvents)
+
 	return nil
 }
 
 func (s sqlStore) GetById(ctx context.Context, sagaId string) (Instance, error) {
 	sagaData := sagaSqlModel{}
-	err := s.db.QueryRowContext(ctx, s.prepQuery(fmt.Sprintf("SELECT s.uid, s.parent_uid, s.name, s.payload, s.status, s.last_failed_ev, s.started_at, s.updated_at FROM %v s WHERE uid=?;", sagaTableName)), sagaId).
+	err := s.db.QueryRowContext(ctx, s.prepQuery(fmt.Sprintf("SELECT s.uid, s.parent_uid, s.name, s.payload, s.payload_version, s.status, s.suspended_from, s.state, s.last_failed_ev, s.started_at, s.updated_at, s.version FROM %v s WHERE uid=?;", sagaTableName)), sagaId).
 		Scan(
 			&sagaData.ID,
 			&sagaData.ParentID,
 			&sagaData.Name,
 			&sagaData.Payload,
+			&sagaData.PayloadVersion,
 			&sagaData.Status,
+			&sagaData.SuspendedFrom,
+			&sagaData.State,
 			&sagaData.LastFailedMsg,
 			&sagaData.StartedAt,
-			&sagaData.UpdatedAt)
+			&sagaData.UpdatedAt,
+			&sagaData.Version)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -217,6 +374,10 @@ func (s sqlStore) GetById(ctx context.Context, sagaId string) (Instance, error)
 
 	sagaInstance.historyEvents = messages
 
+	if err := s.loadCorrelations(ctx, sagaInstance); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return sagaInstance, nil
 }
 
@@ -225,93 +386,286 @@ func (s sqlStore) GetByFilter(ctx context.Context, filters ...FilterOption) ([]I
 		return nil, errors.Errorf("No filters found, you have to specify at least one so result won't be whole store")
 	}
 
-	opts := &filterOptions{}
+	opts := newFilterOptions(filters...)
+
+	if err := opts.validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !opts.hasConditions() {
+		return nil, errors.Errorf("All specified filters are empty, you have to specify at least one so result won't be whole store")
+	}
+
+	conditions, args, err := s.filterConditions(opts)
 
-	for _, filter := range filters {
-		filter(opts)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	//todo use https://github.com/Masterminds/squirrel ? +1 dependency, is it really needed?
-	query := fmt.Sprintf(
-		`SELECT 
-			s.uid,
-			s.parent_uid,
-			s.name,
-			s.payload,
-			s.status,
-			s.started_at,
-			s.updated_at,
-			s.last_failed_ev,
-			sh.uid,
-			sh.name,
-			sh.status,
-			sh.payload,
-			sh.origin,
-			sh.created_at,
-			sh.trace_uid 
-		FROM %s s LEFT JOIN %s sh 
-		ON s.uid = sh.saga_uid WHERE`,
-		sagaTableName, sagaHistoryTableName)
+	query := fmt.Sprintf("SELECT s.uid, s.parent_uid, s.name, s.payload, s.payload_version, s.status, s.suspended_from, s.state, s.last_failed_ev, s.started_at, s.updated_at, s.version FROM %s s", sagaTableName)
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	//sagas without time go first in ascending order regardless of how a database sorts nulls
+	sortColumn := "s." + string(opts.sortBy)
+	direction := strings.ToUpper(string(opts.order))
+	query += fmt.Sprintf(" ORDER BY CASE WHEN %[1]s IS NULL THEN 0 ELSE 1 END %[2]s, %[1]s %[2]s, s.uid %[2]s", sortColumn, direction)
+
+	if opts.limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.prepQuery(query+";"), args...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "querying sagas with filter")
+	}
+
+	defer rows.Close()
+
+	var instances []*sagaInstance
+
+	for rows.Next() {
+		sagaData := sagaSqlModel{}
+
+		if err := rows.Scan(
+			&sagaData.ID,
+			&sagaData.ParentID,
+			&sagaData.Name,
+			&sagaData.Payload,
+			&sagaData.PayloadVersion,
+			&sagaData.Status,
+			&sagaData.SuspendedFrom,
+			&sagaData.State,
+			&sagaData.LastFailedMsg,
+			&sagaData.StartedAt,
+			&sagaData.UpdatedAt,
+			&sagaData.Version,
+		); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		instance, err := s.instanceFromModel(sagaData)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		instance.historyEvents = make([]HistoryEvent, 0)
+		instances = append(instances, instance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !opts.withoutHistory {
+		if err := s.loadEvents(ctx, instances); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 
+	if err := s.loadCorrelations(ctx, instances...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := make([]Instance, len(instances))
+
+	for i, instance := range instances {
+		res[i] = instance
+	}
+
+	return res, nil
+}
+
+// filterConditions builds WHERE conditions of GetByFilter query
+func (s sqlStore) filterConditions(opts *filterOptions) ([]string, []interface{}, error) {
 	var (
 		args       []interface{}
 		conditions []string
 	)
 
 	if opts.sagaId != "" {
-		conditions = append(conditions, " s.uid = ?")
+		conditions = append(conditions, "s.uid = ?")
 		args = append(args, opts.sagaId)
 	}
 
 	if opts.status != "" {
-		conditions = append(conditions, " s.status = ?")
+		conditions = append(conditions, "s.status = ?")
 		args = append(args, opts.status)
 	}
 
 	if opts.sagaName != "" {
-		conditions = append(conditions, " s.name = ?")
+		conditions = append(conditions, "s.name = ?")
 		args = append(args, opts.sagaName)
 	}
 
-	if len(conditions) == 0 {
-		return nil, errors.Errorf("All specified filters are empty, you have to specify at least one so result won't be whole store")
+	if opts.parentId != "" {
+		conditions = append(conditions, "s.parent_uid = ?")
+		args = append(args, opts.parentId)
 	}
 
-	for i, condition := range conditions {
-		query += condition
+	ranges := []struct {
+		column   string
+		from, to time.Time
+	}{
+		{"s.started_at", opts.startedFrom, opts.startedTo},
+		{"s.updated_at", opts.updatedFrom, opts.updatedTo},
+	}
 
-		if i < len(conditions)-1 {
-			query += " AND"
+	for _, r := range ranges {
+		if !r.from.IsZero() {
+			conditions = append(conditions, r.column+" >= ?")
+			args = append(args, sqldriver.Timestamp(s.driver, r.from))
 		}
 
-		if i == len(conditions)-1 {
-			query += ";"
+		if !r.to.IsZero() {
+			conditions = append(conditions, r.column+" < ?")
+			args = append(args, sqldriver.Timestamp(s.driver, r.to))
 		}
 	}
 
-	rows, err := s.db.QueryContext(ctx, s.prepQuery(query), args...)
+	cursorKey, err := opts.decodeCursor()
 
 	if err != nil {
-		return nil, errors.Wrap(err, "querying sagas with filter")
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if cursorKey != nil {
+		column := "s." + string(opts.sortBy)
+		ascending := opts.order == SortAsc
+
+		switch {
+		case cursorKey.time == nil && ascending:
+			conditions = append(conditions, fmt.Sprintf("((%[1]s IS NULL AND s.uid > ?) OR %[1]s IS NOT NULL)", column))
+			args = append(args, cursorKey.uid)
+		case cursorKey.time == nil:
+			conditions = append(conditions, fmt.Sprintf("(%s IS NULL AND s.uid < ?)", column))
+			args = append(args, cursorKey.uid)
+		case ascending:
+			conditions = append(conditions, fmt.Sprintf("(%[1]s > ? OR (%[1]s = ? AND s.uid > ?))", column))
+			args = append(args, sqldriver.Timestamp(s.driver, *cursorKey.time), sqldriver.Timestamp(s.driver, *cursorKey.time), cursorKey.uid)
+		default:
+			conditions = append(conditions, fmt.Sprintf("(%[1]s IS NULL OR %[1]s < ? OR (%[1]s = ? AND s.uid < ?))", column))
+			args = append(args, sqldriver.Timestamp(s.driver, *cursorKey.time), sqldriver.Timestamp(s.driver, *cursorKey.time), cursorKey.uid)
+		}
+	}
+
+	return conditions, args, nil
+}
+
+// loadEvents queries history events of instances in chunks, so amount of query params stays within limits of databases
+func (s sqlStore) loadEvents(ctx context.Context, instances []*sagaInstance) error {
+	const chunkSize = 500
+
+	byUID := make(map[string]*sagaInstance, len(instances))
+
+	for _, instance := range instances {
+		byUID[instance.UID()] = instance
+	}
+
+	for start := 0; start < len(instances); start += chunkSize {
+		end := start + chunkSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, instance := range instances[start:end] {
+			args = append(args, instance.UID())
+		}
+
+		query := fmt.Sprintf(
+			"SELECT saga_uid, uid, name, status, payload, origin, created_at, trace_uid FROM %v WHERE saga_uid IN (%s) ORDER BY seq, created_at;",
+			sagaHistoryTableName,
+			strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "),
+		)
+
+		if err := s.scanEvents(ctx, s.prepQuery(query), args, byUID); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// loadCorrelations sets correlation values of sagas
+func (s sqlStore) loadCorrelations(ctx context.Context, instances ...*sagaInstance) error {
+	const chunkSize = 500
+
+	byUID := make(map[string]*sagaInstance, len(instances))
+
+	for _, instance := range instances {
+		byUID[instance.UID()] = instance
+	}
+
+	for start := 0; start < len(instances); start += chunkSize {
+		end := start + chunkSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, instance := range instances[start:end] {
+			args = append(args, instance.UID())
+		}
+
+		query := fmt.Sprintf(
+			"SELECT saga_uid, name, value FROM %v WHERE saga_uid IN (%s);",
+			sagaCorrelationTableName,
+			strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "),
+		)
+
+		if err := s.scanCorrelations(ctx, s.prepQuery(query), args, byUID); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+func (s sqlStore) scanCorrelations(ctx context.Context, query string, args []interface{}, byUID map[string]*sagaInstance) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return errors.Wrap(err, "querying correlations of sagas")
 	}
 
 	defer rows.Close()
 
-	sagas := make(map[string]*sagaInstance)
+	for rows.Next() {
+		var sagaUID, key, value string
+
+		if err := rows.Scan(&sagaUID, &key, &value); err != nil {
+			return errors.Wrap(err, "scanning correlations of sagas")
+		}
+
+		if instance, exists := byUID[sagaUID]; exists {
+			instance.Correlate(key, value)
+		}
+	}
+
+	return errors.WithStack(rows.Err())
+}
+
+func (s sqlStore) scanEvents(ctx context.Context, query string, args []interface{}, byUID map[string]*sagaInstance) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return errors.Wrap(err, "querying events of sagas")
+	}
+
+	defer rows.Close()
 
 	for rows.Next() {
-		sagaData := sagaSqlModel{}
+		var sagaUID string
 		ev := historyEventSqlModel{}
 
 		if err := rows.Scan(
-			&sagaData.ID,
-			&sagaData.ParentID,
-			&sagaData.Name,
-			&sagaData.Payload,
-			&sagaData.Status,
-			&sagaData.StartedAt,
-			&sagaData.UpdatedAt,
-			&sagaData.LastFailedMsg,
+			&sagaUID,
 			&ev.ID,
 			&ev.Name,
 			&ev.SagaStatus,
@@ -320,48 +674,35 @@ func (s sqlStore) GetByFilter(ctx context.Context, filters ...FilterOption) ([]I
 			&ev.CreatedAt,
 			&ev.TraceUID,
 		); err != nil {
-			return nil, errors.WithStack(err)
+			return errors.Wrap(err, "scanning events of sagas")
 		}
 
-		sagaInstance, exists := sagas[sagaData.ID.String]
+		historyEvent, err := s.eventFromModel(ev)
 
-		if !exists {
-			instance, err := s.instanceFromModel(sagaData)
-
-			if err != nil {
-				return nil, errors.WithStack(err)
-			}
-			sagas[sagaData.ID.String] = instance
-			sagaInstance = instance
+		if err != nil {
+			return errors.WithStack(err)
 		}
 
-		if ev.ID.String != "" {
-			historyEvent, err := s.eventFromModel(ev)
-
-			if err != nil {
-				return nil, errors.WithStack(err)
-			}
-
-			sagaInstance.historyEvents = append(sagaInstance.historyEvents, *historyEvent)
+		if instance, exists := byUID[sagaUID]; exists {
+			instance.historyEvents = append(instance.historyEvents, *historyEvent)
 		}
 	}
 
-	if rows.Err() != nil {
-		return nil, errors.WithStack(err)
-	}
+	return errors.WithStack(rows.Err())
+}
 
-	res := make([]Instance, len(sagas))
+func (s sqlStore) Delete(ctx context.Context, sagaId string) error {
+	//sqlite enforces foreign keys only when they are enabled for a connection, so history and correlations aren't deleted by cascade
+	if s.driver == SQLiteDriver {
+		if _, err := s.db.ExecContext(ctx, s.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE saga_uid=?;", sagaHistoryTableName)), sagaId); err != nil {
+			return errors.Wrapf(err, "executing delete query for history of saga %s", sagaId)
+		}
 
-	var i int
-	for _, instance := range sagas {
-		res[i] = instance
-		i++
+		if _, err := s.db.ExecContext(ctx, s.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE saga_uid=?;", sagaCorrelationTableName)), sagaId); err != nil {
+			return errors.Wrapf(err, "executing delete query for correlations of saga %s", sagaId)
+		}
 	}
 
-	return res, nil
-}
-
-func (s sqlStore) Delete(ctx context.Context, sagaId string) error {
 	res, err := s.db.ExecContext(ctx, s.prepQuery(fmt.Sprintf("DELETE FROM %v WHERE uid=?;", sagaTableName)), sagaId)
 	if err != nil {
 		return errors.Wrapf(err, "executing delete query for saga %s", sagaId)
@@ -381,7 +722,7 @@ func (s sqlStore) Delete(ctx context.Context, sagaId string) error {
 }
 
 func (s sqlStore) queryEvents(sagaId string) ([]HistoryEvent, error) {
-	rows, err := s.db.Query(s.prepQuery(fmt.Sprintf("SELECT uid, name, status, payload, origin, created_at, trace_uid FROM %v WHERE saga_uid=? ORDER BY created_at;", sagaHistoryTableName)), sagaId)
+	rows, err := s.db.Query(s.prepQuery(fmt.Sprintf("SELECT uid, name, status, payload, origin, created_at, trace_uid FROM %v WHERE saga_uid=? ORDER BY seq, created_at;", sagaHistoryTableName)), sagaId)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "querying events for saga %s", sagaId)
@@ -447,13 +788,21 @@ func (s sqlStore) instanceFromModel(sagaData sagaSqlModel) (*sagaInstance, error
 		return nil, errors.Wrapf(err, "parsing status of %s", sagaData.ID.String)
 	}
 
+	suspendedFrom, err := optionalStatusFromStr(sagaData.SuspendedFrom.String)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing status %s was suspended from", sagaData.ID.String)
+	}
+
 	sagaInstance := &sagaInstance{
 		uid: sagaData.ID.String,
 		instanceStatus: instanceStatus{
-			status: status,
+			status:        status,
+			suspendedFrom: suspendedFrom,
 		},
 		parentID:      sagaData.ParentID.String,
+		state:         sagaData.State.String,
 		historyEvents: make([]HistoryEvent, 0),
+		version:       sagaData.Version,
 	}
 
 	if sagaData.StartedAt.Valid {
@@ -471,7 +820,13 @@ func (s sqlStore) instanceFromModel(sagaData sagaSqlModel) (*sagaInstance, error
 		}
 	}
 
-	saga, err := s.msgMarshaller.Unmarshal(sagaData.Payload)
+	payload, err := s.upgradePayload(sagaData)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "upgrading payload of saga %s", sagaData.ID.String)
+	}
+
+	saga, err := s.msgMarshaller.Unmarshal(payload)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "error deserializing payload %v into saga %s", sagaData.Payload, sagaData.Name.String)
@@ -488,80 +843,42 @@ func (s sqlStore) instanceFromModel(sagaData sagaSqlModel) (*sagaInstance, error
 	return sagaInstance, nil
 }
 
-func (s sqlStore) initTables() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-
-	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+// upgradePayload converts a payload stored with previous schema version of the saga type into the current one
+func (s sqlStore) upgradePayload(sagaData sagaSqlModel) ([]byte, error) {
+	if s.upgrader == nil {
+		if sagaData.PayloadVersion != 0 {
+			return nil, errors.Errorf("payload version %d of %s isn't supported, there are no payload upgrades", sagaData.PayloadVersion, sagaData.Name.String)
+		}
 
-	if err != nil {
-		return errors.WithStack(err)
+		return sagaData.Payload, nil
 	}
 
-	_, err = tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists %v
-	(
-		uid varchar(255) not null primary key,
-		parent_uid varchar(255) null,
-		name varchar(255) null,
-		payload text null,
-		status varchar(255) null,
-		started_at timestamp null,
-		updated_at timestamp null,
-		last_failed_ev text null
-	);`, sagaTableName))
+	groupKind, err := scheme.FromString(sagaData.Name.String)
 
 	if err != nil {
-		if rErr := tx.Rollback(); rErr != nil {
-			return errors.Wrapf(rErr, "error rollback when %s", err)
-		}
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	_, err = tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists %v
-	(
-		uid varchar(255) not null primary key,
-		saga_uid varchar(255) not null,
-		name varchar(255) null,
-		status varchar(255) null,
-		payload text null,
-		origin varchar(255) null,
-		created_at timestamp null,
-		trace_uid varchar(255) null,
-		constraint saga_history_saga_model_id_fk
-			foreign key (saga_uid) references %v (uid)
-				on update cascade on delete cascade
-	);`, sagaHistoryTableName, sagaTableName))
+	return s.upgrader.Upgrade(groupKind, sagaData.PayloadVersion, sagaData.Payload)
+}
 
-	if err != nil {
-		if rErr := tx.Rollback(); rErr != nil {
-			return errors.Wrapf(rErr, "error rollback when %s", err)
-		}
-		return errors.WithStack(err)
+// payloadVersion returns current schema version of saga payload
+func (s sqlStore) payloadVersion(saga Saga) int {
+	if s.upgrader == nil {
+		return 0
 	}
 
-	if err := tx.Commit(); err != nil {
-		return errors.WithStack(err)
-	}
+	return s.upgrader.Version(saga.GroupKind())
+}
 
-	return nil
+func (s sqlStore) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	return sqlmigrate.NewMigrator(s.db, s.driver).Migrate(ctx, MigrationsComponent, Migrations())
 }
 
 // prepQuery replaces wildcard params to specific driver. Standard wildcard is '?'
 func (s *sqlStore) prepQuery(query string) string {
-	var res []byte
-
-	counter := 1
-
-	for i := 0; i < len(query); i++ {
-		if query[i] == '?' && s.driver == PGDriver {
-			res = append(append(res, '$'), []byte(strconv.Itoa(counter))...)
-			counter++
-
-			continue
-
-		}
-		res = append(res, query[i])
-	}
-
-	return string(res)
+	return sqldriver.PrepQuery(s.driver, query)
 }
@@ -0,0 +1,239 @@
+package saga
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// UnexpectedEventPolicy specifies what happens with an event which has no allowed transition from the current state of a saga
+type UnexpectedEventPolicy string
+
+const (
+	// IgnoreUnexpectedEvent drops the event, it's only written into saga history
+	IgnoreUnexpectedEvent UnexpectedEventPolicy = "ignore"
+	// DeferUnexpectedEvent keeps the event in saga history and sends it to the saga again after its state changes
+	DeferUnexpectedEvent UnexpectedEventPolicy = "defer"
+	// FailOnUnexpectedEvent returns ErrUnexpectedEvent from the event handler, so the saga fails according to failure policy of events handler
+	FailOnUnexpectedEvent UnexpectedEventPolicy = "fail"
+)
+
+// ErrUnexpectedEvent is returned by a saga state machine with FailOnUnexpectedEvent policy
+var ErrUnexpectedEvent = errors.New("unexpected event")
+
+// State is a named state of a saga state machine
+type State struct {
+	Name string
+	// OnEntry is executed when the saga enters the state
+	OnEntry Executor
+	// OnExit is executed when the saga leaves the state
+	OnExit Executor
+}
+
+// Transition moves a saga from one state to another when it receives On event
+type Transition struct {
+	From string
+	To   string
+	On   message.Object
+	// Guard allows the transition if it returns true. Transitions of the same state and event are checked in order they were added, the first allowed is taken
+	Guard func(sagaCtx SagaContext) bool
+	// Action is executed after exit from From state and before entry into To state
+	Action Executor
+}
+
+type stateMachine struct {
+	states      map[string]State
+	transitions map[scheme.GroupKind][]Transition
+	policy      UnexpectedEventPolicy
+}
+
+// AddState declares a state of the saga state machine, call it in Init. A state with the same name is replaced
+func (b *BaseSaga) AddState(state State) *BaseSaga {
+	if b.machine.states == nil {
+		b.machine.states = make(map[string]State)
+	}
+
+	b.machine.states[state.Name] = state
+
+	return b
+}
+
+// AddTransition declares a transition between states added with AddState, call it in Init.
+// An event handler for On event is registered, so it must not be handled by the saga itself
+func (b *BaseSaga) AddTransition(transition Transition) *BaseSaga {
+	for _, name := range []string{transition.From, transition.To} {
+		if _, exists := b.machine.states[name]; !exists {
+			panic(fmt.Sprintf("state %s of transition on %s is not declared", name, reflect.TypeOf(transition.On).String()))
+		}
+	}
+
+	if b.machine.transitions == nil {
+		b.machine.transitions = make(map[scheme.GroupKind][]Transition)
+	}
+
+	b.AddEventHandler(transition.On, b.transit)
+
+	groupKind, _ := b.scheme.ObjectKind(transition.On)
+	b.machine.transitions[*groupKind] = append(b.machine.transitions[*groupKind], transition)
+
+	return b
+}
+
+// States returns declared states of the saga state machine sorted by name
+func (b BaseSaga) States() []State {
+	states := make([]State, 0, len(b.machine.states))
+
+	for _, state := range b.machine.states {
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+
+	return states
+}
+
+// Transitions returns declared transitions of the saga state machine sorted by their From state, transitions of the same state keep order they were added in
+func (b BaseSaga) Transitions() []Transition {
+	var (
+		transitions []Transition
+		kinds       []scheme.GroupKind
+	)
+
+	for groupKind := range b.machine.transitions {
+		kinds = append(kinds, groupKind)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].String() < kinds[j].String()
+	})
+
+	for _, groupKind := range kinds {
+		transitions = append(transitions, b.machine.transitions[groupKind]...)
+	}
+
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].From < transitions[j].From
+	})
+
+	return transitions
+}
+
+// OnUnexpectedEvent sets a policy for events which arrive in a state without allowed transition on them. Such events are ignored by default
+func (b *BaseSaga) OnUnexpectedEvent(policy UnexpectedEventPolicy) *BaseSaga {
+	b.machine.policy = policy
+	return b
+}
+
+// EnterState moves the saga into a state regardless of transitions, usually it's called in Start to enter an initial state.
+// Exit action of the current state and entry action of the new one are executed
+func (b *BaseSaga) EnterState(sagaCtx SagaContext, name string) error {
+	return b.changeState(sagaCtx, name, "", nil)
+}
+
+// transit is an event handler of all events which have transitions
+func (b *BaseSaga) transit(sagaCtx SagaContext) error {
+	payload := sagaCtx.Message().Payload()
+	current := sagaCtx.SagaInstance().State()
+
+	for _, transition := range b.machine.transitions[payload.GroupKind()] {
+		if transition.From != current {
+			continue
+		}
+
+		if transition.Guard != nil && !transition.Guard(sagaCtx) {
+			continue
+		}
+
+		return b.changeState(sagaCtx, transition.To, payload.GroupKind().String(), transition.Action)
+	}
+
+	return b.unexpectedEvent(sagaCtx)
+}
+
+func (b *BaseSaga) changeState(sagaCtx SagaContext, to, event string, action Executor) error {
+	instance := sagaCtx.SagaInstance()
+	from := instance.State()
+
+	next, exists := b.machine.states[to]
+
+	if !exists {
+		return errors.Errorf("state %s is not declared", to)
+	}
+
+	if current, exists := b.machine.states[from]; exists && current.OnExit != nil {
+		if err := current.OnExit(sagaCtx); err != nil {
+			return errors.Wrapf(err, "exiting state %s", from)
+		}
+	}
+
+	if action != nil {
+		if err := action(sagaCtx); err != nil {
+			return errors.Wrapf(err, "transition from %s to %s", from, to)
+		}
+	}
+
+	instance.SetState(to)
+	instance.AddHistoryEvent(&contracts.StateTransitionedEvent{From: from, To: to, Event: event})
+
+	if next.OnEntry != nil {
+		if err := next.OnEntry(sagaCtx); err != nil {
+			return errors.Wrapf(err, "entering state %s", to)
+		}
+	}
+
+	//deferred events could be expected by the new state
+	if from != to {
+		replayDeferredEvents(sagaCtx)
+	}
+
+	return nil
+}
+
+func (b *BaseSaga) unexpectedEvent(sagaCtx SagaContext) error {
+	payload := sagaCtx.Message().Payload()
+	state := sagaCtx.SagaInstance().State()
+
+	switch b.machine.policy {
+	case FailOnUnexpectedEvent:
+		return errors.Wrapf(ErrUnexpectedEvent, "event %s in state %s", payload.GroupKind().String(), state)
+	case DeferUnexpectedEvent:
+		sagaCtx.LogMessage(log.InfoLevel, fmt.Sprintf("event %s is deferred in state %s", payload.GroupKind().String(), state))
+		sagaCtx.SagaInstance().AddHistoryEvent(&contracts.EventDeferredEvent{DeferUID: uuid.New().String(), State: state, Event: payload})
+	default:
+		sagaCtx.LogMessage(log.WarnLevel, fmt.Sprintf("event %s is ignored in state %s", payload.GroupKind().String(), state))
+	}
+
+	return nil
+}
+
+// replayDeferredEvents sends deferred events to the saga again through saga endpoints
+func replayDeferredEvents(sagaCtx SagaContext) {
+	instance := sagaCtx.SagaInstance()
+	replayed := make(map[string]bool)
+
+	for _, ev := range instance.HistoryEvents() {
+		if replayedEv, ok := ev.Payload.(*contracts.DeferredEventReplayedEvent); ok {
+			replayed[replayedEv.DeferUID] = true
+		}
+	}
+
+	for _, ev := range instance.HistoryEvents() {
+		deferredEv, ok := ev.Payload.(*contracts.EventDeferredEvent)
+
+		if !ok || replayed[deferredEv.DeferUID] {
+			continue
+		}
+
+		sagaCtx.Dispatch(&contracts.ReplayEventCommand{SagaUID: instance.UID(), Event: deferredEv.Event})
+		instance.AddHistoryEvent(&contracts.DeferredEventReplayedEvent{DeferUID: deferredEv.DeferUID})
+	}
+}
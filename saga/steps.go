@@ -0,0 +1,164 @@
+package saga
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+)
+
+// Step is a unit of saga work which is undone when the saga is compensated
+type Step struct {
+	Name string
+	// Action does the work of the step, usually dispatches a command
+	Action Executor
+	// Compensation returns a command which undoes the step
+	Compensation func(sagaCtx SagaContext) message.Object
+	// CompensatedOn is an event which acknowledges that compensation of the step is done. Every step needs own event type
+	CompensatedOn message.Object
+}
+
+// AddStep declares a step of the saga, call it in Init. An event handler for CompensatedOn event is registered, so it must not be handled by the saga itself
+func (b *BaseSaga) AddStep(step Step) *BaseSaga {
+	for i, s := range b.steps {
+		if s.Name == step.Name {
+			b.steps[i] = step
+			return b.AddEventHandler(step.CompensatedOn, b.stepCompensated(step.Name))
+		}
+	}
+
+	b.steps = append(b.steps, step)
+
+	return b.AddEventHandler(step.CompensatedOn, b.stepCompensated(step.Name))
+}
+
+// Steps returns declared steps of the saga
+func (b BaseSaga) Steps() []Step {
+	return b.steps
+}
+
+// RunStep executes action of a step and records the step completed in saga history if it succeeds
+func (b *BaseSaga) RunStep(sagaCtx SagaContext, name string) error {
+	step, err := b.step(name)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := step.Action(sagaCtx); err != nil {
+		return errors.Wrapf(err, "running step %s", name)
+	}
+
+	completed := completedSteps(sagaCtx.SagaInstance())
+
+	for _, completedStep := range completed {
+		if completedStep.Step == name {
+			return nil
+		}
+	}
+
+	sagaCtx.SagaInstance().AddHistoryEvent(&contracts.StepCompletedEvent{Step: name, Sequence: len(completed) + 1})
+
+	return nil
+}
+
+// Compensate dispatches compensations of completed steps one by one in reverse order, every next one is dispatched when the previous is acknowledged.
+// The saga is marked compensated after the last one. A saga which overrides Compensate can call it to compensate its steps
+func (b *BaseSaga) Compensate(sagaCtx SagaContext) error {
+	return b.compensateNext(sagaCtx)
+}
+
+func (b *BaseSaga) stepCompensated(name string) Executor {
+	return func(sagaCtx SagaContext) error {
+		instance := sagaCtx.SagaInstance()
+
+		if !instance.Status().Compensating() {
+			sagaCtx.LogMessage(log.WarnLevel, fmt.Sprintf("compensation of step %s is acknowledged, but saga isn't compensating", name))
+			return nil
+		}
+
+		pending := pendingCompensations(instance)
+
+		if len(pending) == 0 || pending[0] != name {
+			sagaCtx.LogMessage(log.WarnLevel, fmt.Sprintf("compensation of step %s is acknowledged, but it isn't awaited", name))
+			return nil
+		}
+
+		instance.AddHistoryEvent(&contracts.StepCompensatedEvent{Step: name})
+
+		return b.compensateNext(sagaCtx)
+	}
+}
+
+func (b *BaseSaga) compensateNext(sagaCtx SagaContext) error {
+	pending := pendingCompensations(sagaCtx.SagaInstance())
+
+	if len(pending) == 0 {
+		sagaCtx.SagaInstance().CompleteCompensation()
+		return nil
+	}
+
+	step, err := b.step(pending[0])
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sagaCtx.Dispatch(step.Compensation(sagaCtx))
+
+	return nil
+}
+
+func (b BaseSaga) step(name string) (Step, error) {
+	for _, step := range b.steps {
+		if step.Name == name {
+			return step, nil
+		}
+	}
+
+	return Step{}, errors.Errorf("step %s is not declared", name)
+}
+
+// completedSteps returns steps recorded in history in order they were completed
+func completedSteps(instance Instance) []*contracts.StepCompletedEvent {
+	var completed []*contracts.StepCompletedEvent
+
+	for _, ev := range instance.HistoryEvents() {
+		if stepEv, ok := ev.Payload.(*contracts.StepCompletedEvent); ok {
+			completed = append(completed, stepEv)
+		}
+	}
+
+	//events created within a second could be loaded in any order
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].Sequence < completed[j].Sequence
+	})
+
+	return completed
+}
+
+// pendingCompensations returns completed steps which aren't compensated yet, the last completed goes first
+func pendingCompensations(instance Instance) []string {
+	compensated := make(map[string]bool)
+
+	for _, ev := range instance.HistoryEvents() {
+		if stepEv, ok := ev.Payload.(*contracts.StepCompensatedEvent); ok {
+			compensated[stepEv.Step] = true
+		}
+	}
+
+	var pending []string
+
+	completed := completedSteps(instance)
+
+	for i := len(completed) - 1; i >= 0; i-- {
+		if !compensated[completed[i].Step] {
+			pending = append(pending, completed[i].Step)
+		}
+	}
+
+	return pending
+}
@@ -3,51 +3,75 @@ package saga
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
 	"github.com/pkg/errors"
 )
 
 const (
-	sagaTableName        = "saga"
-	sagaHistoryTableName = "saga_history"
+	sagaTableName            = "saga"
+	sagaHistoryTableName     = "saga_history"
+	sagaCorrelationTableName = "saga_correlation"
 )
 
-type FilterOption func(opts *filterOptions)
-
 type Store interface {
 	Create(ctx context.Context, saga Instance) error
 	GetById(ctx context.Context, sagaId string) (Instance, error)
 	GetByFilter(ctx context.Context, filters ...FilterOption) ([]Instance, error)
-	Update(ctx context.Context, saga Instance) error
+	// GetByCorrelation returns a saga correlated with the value under the key, nil if there is none
+	GetByCorrelation(ctx context.Context, key, value string) (Instance, error)
+	// Update persists saga state. Pending deliveries passed with WithDeliveries are written into the outbox atomically with the state
+	Update(ctx context.Context, saga Instance, opts ...UpdateOption) error
 	Delete(ctx context.Context, sagaId string) error
+	// Outbox returns the store pending deliveries are written to, outbox.Relay dispatches them
+	Outbox() outbox.Store
 }
 
-func WithSagaId(sagaId string) FilterOption {
-	return func(opts *filterOptions) {
-		opts.sagaId = sagaId
-	}
+// VersionConflictError is returned by Store.Update when saga instance was modified by someone else after it had been loaded
+type VersionConflictError struct {
+	SagaUID string
+	Version int
 }
 
-func WithStatus(status string) FilterOption {
-	return func(opts *filterOptions) {
-		opts.status = status
-	}
+func (e VersionConflictError) Error() string {
+	return fmt.Sprintf("saga %s was modified concurrently, version %d is stale", e.SagaUID, e.Version)
 }
 
-func WithSagaName(sagaName string) FilterOption {
-	return func(opts *filterOptions) {
-		opts.sagaName = sagaName
-	}
+// IsVersionConflict checks whether err is caused by a stale saga version
+func IsVersionConflict(err error) bool {
+	var conflictErr VersionConflictError
+	return errors.As(err, &conflictErr)
+}
+
+// versionSetter is implemented by instances which version is tracked by store
+type versionSetter interface {
+	setVersion(version int)
+}
+
+type UpdateOption func(opts *updateOptions)
+
+type updateOptions struct {
+	deliveries []PendingDelivery
+}
+
+// PendingDelivery is a message which is sent out only after saga state is persisted
+type PendingDelivery struct {
+	Message *message.OutcomingMessage
+	Options []endpoint.DeliveryOption
 }
 
-type filterOptions struct {
-	sagaId   string
-	status   string
-	sagaName string
+// WithDeliveries specifies messages which must be persisted along with saga state and dispatched after it's saved
+func WithDeliveries(deliveries ...PendingDelivery) UpdateOption {
+	return func(opts *updateOptions) {
+		opts.deliveries = append(opts.deliveries, deliveries...)
+	}
 }
 
 func statusFromStr(str string) (status, error) {
-	statuses := []status{sagaStatusInProgress, sagaStatusFailed, sagaStatusInProgress, sagaStatusCompensating, sagaStatusCompleted, sagaStatusCreated, sagaStatusRecovering}
+	statuses := []status{sagaStatusInProgress, sagaStatusFailed, sagaStatusInProgress, sagaStatusCompensating, sagaStatusCompensated, sagaStatusCompleted, sagaStatusCreated, sagaStatusRecovering, sagaStatusSuspended, sagaStatusCancelled}
 	for _, s := range statuses {
 		if string(s) == str {
 			return s, nil
@@ -58,14 +82,18 @@ func statusFromStr(str string) (status, error) {
 }
 
 type sagaSqlModel struct {
-	ID            sql.NullString
-	ParentID      sql.NullString
-	Name          sql.NullString
-	Payload       []byte
-	Status        sql.NullString
-	LastFailedMsg []byte
-	StartedAt     sql.NullTime
-	UpdatedAt     sql.NullTime
+	ID             sql.NullString
+	ParentID       sql.NullString
+	Name           sql.NullString
+	Payload        []byte
+	PayloadVersion int
+	Status         sql.NullString
+	State          sql.NullString
+	SuspendedFrom  sql.NullString
+	LastFailedMsg  []byte
+	StartedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+	Version        int
 }
 
 type historyEventSqlModel struct {
@@ -77,3 +105,12 @@ type historyEventSqlModel struct {
 	SagaStatus   sql.NullString
 	TraceUID     sql.NullString
 }
+
+// optionalStatusFromStr parses a status which isn't always set
+func optionalStatusFromStr(str string) (status, error) {
+	if str == "" {
+		return "", nil
+	}
+
+	return statusFromStr(str)
+}
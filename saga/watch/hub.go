@@ -0,0 +1,327 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/pkg/errors"
+)
+
+// Change is a saga saved by Store.Update with history events the update added
+type Change struct {
+	SagaUID   string  `json:"saga_uid"`
+	ParentUID string  `json:"parent_uid,omitempty"`
+	SagaName  string  `json:"saga_name"`
+	Status    string  `json:"status"`
+	State     string  `json:"state,omitempty"`
+	Version   int     `json:"version"`
+	Events    []Event `json:"events"`
+}
+
+// Event is a history event of a saga, Payload is the encoded event
+type Event struct {
+	UID        string          `json:"uid"`
+	Name       string          `json:"name"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Origin     string          `json:"origin"`
+	SagaStatus string          `json:"saga_status"`
+	TraceUID   string          `json:"trace_uid"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Filter selects changes a subscriber receives. Zero values aren't used for filtering
+type Filter struct {
+	SagaUID   string
+	ParentUID string
+	SagaName  string
+	Status    string
+}
+
+func (f Filter) matches(change Change) bool {
+	return (f.SagaUID == "" || f.SagaUID == change.SagaUID) &&
+		(f.ParentUID == "" || f.ParentUID == change.ParentUID) &&
+		(f.SagaName == "" || f.SagaName == change.SagaName) &&
+		(f.Status == "" || f.Status == change.Status)
+}
+
+// Subscription receives changes matching its filter. C is closed when the subscription is closed
+// or when the subscriber doesn't keep up and its buffer overflows, then it has to subscribe again
+type Subscription struct {
+	C      <-chan Change
+	ch     chan Change
+	filter Filter
+	hub    *Hub
+}
+
+// Close stops receiving changes
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// fanOutBuffer is an amount of changes which can wait to be sent to other replicas, changes are dropped when it's full
+const fanOutBuffer = 1000
+
+type HubOption func(h *Hub)
+
+// WithFanOut sends changes of sagas updated by this replica to other replicas through the endpoint, Hub.RunFanOut must be running to send them.
+// Other replicas must receive contracts.SagaChangedEvent and handle it with Hub.HandleRemoteChange
+func WithFanOut(replica string, fanOut endpoint.Endpoint) HubOption {
+	return func(h *Hub) {
+		h.replica = replica
+		h.fanOut = fanOut
+	}
+}
+
+// Hub distributes saga changes to subscribers, register Hub.OnUpdate with saga.Observable store to feed it
+type Hub struct {
+	lock        sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	marshaller  message.Marshaller
+	logger      log.Logger
+	replica     string
+	fanOut      endpoint.Endpoint
+	fanOutQueue chan *contracts.SagaChangedEvent
+}
+
+func NewHub(marshaller message.Marshaller, logger log.Logger, opts ...HubOption) *Hub {
+	h := &Hub{subscribers: make(map[*Subscription]struct{}), marshaller: marshaller, logger: logger}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.fanOut != nil {
+		h.fanOutQueue = make(chan *contracts.SagaChangedEvent, fanOutBuffer)
+	}
+
+	return h
+}
+
+// Subscribe starts receiving changes matching the filter, buffer is an amount of changes which can wait to be received
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	ch := make(chan Change, buffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, hub: h}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, exists := h.subscribers[sub]; exists {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish sends the change to subscribers. It never blocks, a subscriber which buffer is full is unsubscribed
+func (h *Hub) Publish(change Change) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(change) {
+			continue
+		}
+
+		select {
+		case sub.ch <- change:
+		default:
+			h.logger.Logf(log.WarnLevel, "saga changes subscriber is too slow, it's unsubscribed")
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// OnUpdate is saga.UpdateHook which publishes a change of the saga and queues it for other replicas if fan-out is configured.
+// It never blocks the update, a change which doesn't fit into the queue isn't sent to other replicas
+func (h *Hub) OnUpdate(ctx context.Context, instance saga.Instance, events []saga.HistoryEvent) {
+	h.lock.RLock()
+	idle := len(h.subscribers) == 0 && h.fanOut == nil
+	h.lock.RUnlock()
+
+	if idle {
+		return
+	}
+
+	change, err := h.newChange(instance, events)
+
+	if err != nil {
+		h.logger.Logf(log.ErrorLevel, "error creating change of saga %s. %s", instance.UID(), err)
+		return
+	}
+
+	h.Publish(change)
+
+	if h.fanOut == nil {
+		return
+	}
+
+	//a change which isn't delivered to other replicas is only missed by their streams, saga update isn't affected
+	select {
+	case h.fanOutQueue <- changedEvent(h.replica, change):
+	default:
+		h.logger.Logf(log.WarnLevel, "saga changes fan-out queue is full, change of saga %s isn't sent to other replicas", instance.UID())
+	}
+}
+
+// RunFanOut sends changes queued by OnUpdate to other replicas until ctx is canceled. Run it in background when WithFanOut is configured
+func (h *Hub) RunFanOut(ctx context.Context) error {
+	if h.fanOut == nil {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-h.fanOutQueue:
+			if err := h.fanOut.Send(ctx, message.NewOutcomingMessage(ev)); err != nil {
+				h.logger.Logf(log.ErrorLevel, "error sending change of saga %s to other replicas. %s", ev.SagaUID, err)
+			}
+		}
+	}
+}
+
+// HandleRemoteChange is an executor of contracts.SagaChangedEvent, it publishes changes received from other replicas
+func (h *Hub) HandleRemoteChange(execCtx execution.MessageExecutionCtx) error {
+	ev, ok := execCtx.Message().Payload().(*contracts.SagaChangedEvent)
+
+	if !ok {
+		return errors.Errorf("unexpected message %s, SagaChangedEvent is expected", execCtx.Message().Payload().GroupKind().String())
+	}
+
+	//own changes are already published
+	if h.fanOut != nil && ev.Replica == h.replica {
+		return nil
+	}
+
+	change, err := changeFromEvent(ev)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	h.Publish(change)
+
+	return nil
+}
+
+func (h *Hub) newChange(instance saga.Instance, events []saga.HistoryEvent) (Change, error) {
+	change := Change{
+		SagaUID:   instance.UID(),
+		ParentUID: instance.ParentID(),
+		SagaName:  instance.Saga().GroupKind().String(),
+		Status:    instance.Status().String(),
+		State:     instance.State(),
+		Version:   instance.Version(),
+		Events:    make([]Event, 0, len(events)),
+	}
+
+	for _, ev := range events {
+		payload, err := h.marshaller.Marshal(ev.Payload)
+
+		if err != nil {
+			return change, errors.Wrapf(err, "marshaling history event %s", ev.UID)
+		}
+
+		change.Events = append(change.Events, Event{
+			UID:        ev.UID,
+			Name:       ev.Payload.GroupKind().String(),
+			CreatedAt:  ev.CreatedAt,
+			Origin:     ev.OriginSource,
+			SagaStatus: ev.SagaStatus,
+			TraceUID:   ev.TraceUID,
+			Payload:    payload,
+		})
+	}
+
+	return change, nil
+}
+
+// Snapshot creates a change of the saga without events, it describes current state of the saga
+func Snapshot(instance saga.Instance) Change {
+	return Change{
+		SagaUID:   instance.UID(),
+		ParentUID: instance.ParentID(),
+		SagaName:  instance.Saga().GroupKind().String(),
+		Status:    instance.Status().String(),
+		State:     instance.State(),
+		Version:   instance.Version(),
+		Events:    make([]Event, 0),
+	}
+}
+
+func changedEvent(replica string, change Change) *contracts.SagaChangedEvent {
+	ev := &contracts.SagaChangedEvent{
+		Replica:   replica,
+		SagaUID:   change.SagaUID,
+		ParentUID: change.ParentUID,
+		SagaName:  change.SagaName,
+		Status:    change.Status,
+		State:     change.State,
+		Version:   change.Version,
+		Events:    make([]contracts.SagaChangedEntry, len(change.Events)),
+	}
+
+	for i, entry := range change.Events {
+		ev.Events[i] = contracts.SagaChangedEntry{
+			UID:        entry.UID,
+			Name:       entry.Name,
+			CreatedAt:  entry.CreatedAt.Format(time.RFC3339Nano),
+			Origin:     entry.Origin,
+			SagaStatus: entry.SagaStatus,
+			TraceUID:   entry.TraceUID,
+			Payload:    string(entry.Payload),
+		}
+	}
+
+	return ev
+}
+
+func changeFromEvent(ev *contracts.SagaChangedEvent) (Change, error) {
+	change := Change{
+		SagaUID:   ev.SagaUID,
+		ParentUID: ev.ParentUID,
+		SagaName:  ev.SagaName,
+		Status:    ev.Status,
+		State:     ev.State,
+		Version:   ev.Version,
+		Events:    make([]Event, len(ev.Events)),
+	}
+
+	for i, entry := range ev.Events {
+		createdAt, err := time.Parse(time.RFC3339Nano, entry.CreatedAt)
+
+		if err != nil {
+			return change, errors.Wrapf(err, "parsing created_at of history event %s", entry.UID)
+		}
+
+		change.Events[i] = Event{
+			UID:        entry.UID,
+			Name:       entry.Name,
+			CreatedAt:  createdAt,
+			Origin:     entry.Origin,
+			SagaStatus: entry.SagaStatus,
+			TraceUID:   entry.TraceUID,
+			Payload:    json.RawMessage(entry.Payload),
+		}
+	}
+
+	return change, nil
+}
@@ -0,0 +1,221 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSaga struct {
+	saga.BaseSaga
+}
+
+func (s *testSaga) Init()                                     {}
+func (s *testSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *testSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *testSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+type endpointStub struct {
+	lock    sync.Mutex
+	sent    []*message.OutcomingMessage
+	blocked bool
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	if e.blocked {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func (e *endpointStub) messages() []*message.OutcomingMessage {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]*message.OutcomingMessage{}, e.sent...)
+}
+
+func newInstance(uid, parentUID string) saga.Instance {
+	sagaObj := &testSaga{}
+	sagaObj.SetGroupKind(&scheme.GroupKind{Group: "test", Kind: "testSaga"})
+	return saga.NewSagaInstance(uid, parentUID, sagaObj)
+}
+
+func receive(t *testing.T, sub *Subscription) Change {
+	select {
+	case change, ok := <-sub.C:
+		require.True(t, ok, "subscription is closed")
+		return change
+	case <-time.After(time.Second):
+		require.FailNow(t, "change isn't received")
+	}
+	return Change{}
+}
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub(nil, log.NewNilLogger())
+
+	t.Run("filters changes", func(t *testing.T) {
+		bySaga := hub.Subscribe(Filter{SagaUID: "1"}, 10)
+		defer bySaga.Close()
+		byParent := hub.Subscribe(Filter{ParentUID: "parent", Status: "failed"}, 10)
+		defer byParent.Close()
+		all := hub.Subscribe(Filter{}, 10)
+		defer all.Close()
+
+		hub.Publish(Change{SagaUID: "1", Status: "failed"})
+		hub.Publish(Change{SagaUID: "2", ParentUID: "parent", Status: "in_progress"})
+		hub.Publish(Change{SagaUID: "3", ParentUID: "parent", Status: "failed"})
+
+		assert.Equal(t, "1", receive(t, bySaga).SagaUID)
+		assert.Equal(t, "3", receive(t, byParent).SagaUID)
+		assert.Len(t, bySaga.C, 0)
+		assert.Len(t, byParent.C, 0)
+		assert.Len(t, all.C, 3)
+	})
+
+	t.Run("slow subscriber is unsubscribed", func(t *testing.T) {
+		slow := hub.Subscribe(Filter{}, 1)
+		fast := hub.Subscribe(Filter{}, 10)
+		defer fast.Close()
+
+		hub.Publish(Change{SagaUID: "1", Version: 1})
+		hub.Publish(Change{SagaUID: "1", Version: 2})
+
+		assert.Equal(t, 1, receive(t, slow).Version)
+		_, ok := <-slow.C
+		assert.False(t, ok)
+		//closing again is a noop
+		slow.Close()
+
+		assert.Equal(t, 1, receive(t, fast).Version)
+		assert.Equal(t, 2, receive(t, fast).Version)
+	})
+}
+
+func TestHub_OnUpdate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("test", &testSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	store := saga.NewMemoryStore(marshaller)
+	fanOut := &endpointStub{}
+	hub := NewHub(marshaller, log.NewNilLogger(), WithFanOut("replica-1", fanOut))
+	store.(saga.Observable).OnUpdate(hub.OnUpdate)
+
+	go func() {
+		assert.NoError(t, hub.RunFanOut(ctx))
+	}()
+
+	sub := hub.Subscribe(Filter{SagaUID: "1"}, 10)
+	defer sub.Close()
+
+	instance := newInstance("1", "parent")
+	require.NoError(t, store.Create(ctx, instance))
+
+	instance.Fail(nil)
+	instance.AddHistoryEvent(&contracts.StateTransitionedEvent{From: "a", To: "b", Event: "ev"})
+	require.NoError(t, store.Update(ctx, instance))
+
+	change := receive(t, sub)
+	assert.Equal(t, "1", change.SagaUID)
+	assert.Equal(t, "parent", change.ParentUID)
+	assert.Equal(t, "test.testSaga", change.SagaName)
+	assert.Equal(t, "failed", change.Status)
+	assert.Equal(t, instance.Version(), change.Version)
+	require.Len(t, change.Events, 1)
+	assert.Equal(t, instance.HistoryEvents()[0].UID, change.Events[0].UID)
+
+	payload := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(change.Events[0].Payload, &payload))
+	assert.Equal(t, "b", payload["to"])
+
+	t.Run("only new events are sent", func(t *testing.T) {
+		require.NoError(t, store.Update(ctx, instance))
+		assert.Empty(t, receive(t, sub).Events)
+	})
+
+	t.Run("changes are sent to other replicas", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			return len(fanOut.messages()) == 2
+		}, time.Second, time.Millisecond*10)
+
+		ev, ok := fanOut.messages()[0].Payload().(*contracts.SagaChangedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "replica-1", ev.Replica)
+
+		remote, err := changeFromEvent(ev)
+		require.NoError(t, err)
+		assert.Equal(t, change.Events[0].CreatedAt.UnixNano(), remote.Events[0].CreatedAt.UnixNano())
+		remote.Events[0].CreatedAt = change.Events[0].CreatedAt
+		assert.Equal(t, change, remote)
+
+		execCtxFactory := execution.NewMessageExecutionCtxFactory(endpoint.NewRouter(), log.NewNilLogger())
+		handle := func(h *Hub, ev *contracts.SagaChangedEvent) {
+			receivedMsg := message.NewReceivedMessage("uid", ev, message.Headers{}, time.Now(), "origin")
+			require.NoError(t, h.HandleRemoteChange(execCtxFactory.CreateCtx(ctx, nil, receivedMsg)))
+		}
+
+		//own changes are skipped
+		handle(hub, ev)
+		assert.Len(t, sub.C, 0)
+
+		otherHub := NewHub(marshaller, log.NewNilLogger(), WithFanOut("replica-2", &endpointStub{}))
+		otherSub := otherHub.Subscribe(Filter{}, 10)
+		defer otherSub.Close()
+
+		handle(otherHub, ev)
+		assert.Equal(t, "1", receive(t, otherSub).SagaUID)
+	})
+	t.Run("updates don't wait for other replicas", func(t *testing.T) {
+		blockedHub := NewHub(marshaller, log.NewNilLogger(), WithFanOut("replica-3", &endpointStub{blocked: true}))
+
+		runCtx, stop := context.WithCancel(ctx)
+		defer stop()
+
+		go func() {
+			assert.NoError(t, blockedHub.RunFanOut(runCtx))
+		}()
+
+		updated := make(chan struct{})
+
+		go func() {
+			defer close(updated)
+
+			//the queue overflows while the endpoint is blocked
+			for i := 0; i < fanOutBuffer+2; i++ {
+				blockedHub.OnUpdate(ctx, instance, nil)
+			}
+		}()
+
+		select {
+		case <-updated:
+		case <-time.After(time.Second * 2):
+			require.FailNow(t, "updates are blocked by fan-out")
+		}
+	})
+}
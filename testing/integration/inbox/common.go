@@ -0,0 +1,184 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/inbox"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/message/execution"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSQLInboxUseCases(t *testing.T, store inbox.Store) {
+	t.Run("record and detect duplicate", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		uid := uuid.New().String()
+
+		entry, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+		require.False(t, entry.Duplicate())
+		require.NotNil(t, entry.Tx())
+		require.NoError(t, entry.Commit())
+
+		duplicate, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+		assert.True(t, duplicate.Duplicate())
+		require.NoError(t, duplicate.Rollback())
+	})
+
+	t.Run("rolled back uid is not recorded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		uid := uuid.New().String()
+
+		entry, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+		require.NoError(t, entry.Rollback())
+
+		entry, err = store.Begin(ctx, uid)
+		require.NoError(t, err)
+		assert.False(t, entry.Duplicate())
+		require.NoError(t, entry.Rollback())
+	})
+
+	t.Run("concurrent duplicate waits for the first one", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		uid := uuid.New().String()
+
+		entry, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			assert.NoError(t, entry.Commit())
+		}()
+
+		duplicate, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+		assert.True(t, duplicate.Duplicate())
+		require.NoError(t, duplicate.Rollback())
+	})
+
+	t.Run("delete expired uids", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		uid := uuid.New().String()
+
+		entry, err := store.Begin(ctx, uid)
+		require.NoError(t, err)
+		require.NoError(t, entry.Commit())
+
+		deleted, err := store.DeleteOlderThan(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, deleted, int64(1))
+
+		entry, err = store.Begin(ctx, uid)
+		require.NoError(t, err)
+		assert.False(t, entry.Duplicate())
+		require.NoError(t, entry.Rollback())
+	})
+}
+
+type orderPlacedEvent struct {
+	message.ObjectMeta
+}
+
+type shipOrderCmd struct {
+	message.ObjectMeta
+}
+
+type endpointStub struct {
+	sent []*message.OutcomingMessage
+}
+
+func (e *endpointStub) Name() string {
+	return "stub"
+}
+
+func (e *endpointStub) Send(ctx context.Context, msg *message.OutcomingMessage, options ...endpoint.DeliveryOption) error {
+	e.sent = append(e.sent, msg)
+	return nil
+}
+
+func testTransactionalSend(t *testing.T, store inbox.Store, db *sql.DB, driver sqldriver.Driver) {
+	t.Run("messages sent by handler are added to outbox in inbox transaction", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		schemeRegistry := scheme.NewKnownTypesRegistry()
+		schemeRegistry.AddKnownTypes("orders", &orderPlacedEvent{}, &shipOrderCmd{})
+
+		outboxStore, err := outbox.NewSQLStore(db, driver, message.NewJsonMarshaller(schemeRegistry))
+		require.NoError(t, err)
+
+		stub := &endpointStub{}
+		router := endpoint.NewRouter()
+		router.RegisterEndpoint(stub, &shipOrderCmd{})
+		relay := outbox.NewRelay(outboxStore, router, log.NewNilLogger())
+		factory := execution.NewMessageExecutionCtxFactory(router, log.NewNilLogger(), execution.WithOutbox(outboxStore))
+
+		var (
+			sent    *message.OutcomingMessage
+			failure error
+		)
+
+		execute := inbox.NewInbox(store, log.NewNilLogger()).Middleware(func(execCtx execution.MessageExecutionCtx) error {
+			sent = message.NewOutcomingMessage(&shipOrderCmd{})
+			require.NoError(t, execCtx.Send(sent))
+			return failure
+		})
+
+		receive := func() execution.MessageExecutionCtx {
+			msg := message.NewReceivedMessage(uuid.New().String(), &orderPlacedEvent{}, message.Headers{}, time.Now(), "orders")
+			return factory.CreateCtx(ctx, nil, msg)
+		}
+
+		//the message is discarded with the transaction of failed handler
+		failure = errors.New("handler failed")
+		require.Error(t, execute(receive()))
+		dispatched, err := relay.Dispatch(ctx, sent.UID())
+		require.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+
+		failure = nil
+		require.NoError(t, execute(receive()))
+		assert.Empty(t, stub.sent, "message is sent by relay after the transaction is committed")
+
+		dispatched, err = relay.Dispatch(ctx, sent.UID())
+		require.NoError(t, err)
+		assert.Equal(t, 1, dispatched)
+		require.Len(t, stub.sent, 1)
+		assert.Equal(t, sent.UID(), stub.sent[0].UID())
+	})
+
+	t.Run("messages sent without transaction are published immediately", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		stub := &endpointStub{}
+		router := endpoint.NewRouter()
+		router.RegisterEndpoint(stub, &shipOrderCmd{})
+		factory := execution.NewMessageExecutionCtxFactory(router, log.NewNilLogger(), execution.WithOutbox(outbox.NewMemoryStore()))
+
+		msg := message.NewReceivedMessage(uuid.New().String(), &orderPlacedEvent{}, message.Headers{}, time.Now(), "orders")
+		require.NoError(t, factory.CreateCtx(ctx, nil, msg).Send(message.NewOutcomingMessage(&shipOrderCmd{})))
+		assert.Len(t, stub.sent, 1)
+	})
+}
@@ -0,0 +1,29 @@
+package inbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/inbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type mysqlInboxTest struct {
+	intSuite.MysqlSuite
+}
+
+func TestMysqlInboxSuite(t *testing.T) {
+	suite.Run(t, &mysqlInboxTest{})
+}
+
+func (m *mysqlInboxTest) TestMysqlInboxStore() {
+	t := m.T()
+
+	store, err := inbox.NewSQLStore(m.Connection(), sqldriver.MySQL)
+	require.NoError(t, err)
+
+	testSQLInboxUseCases(t, store)
+	testTransactionalSend(t, store, m.Connection(), sqldriver.MySQL)
+}
@@ -0,0 +1,29 @@
+package inbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/inbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type pgInboxTest struct {
+	intSuite.PgSuite
+}
+
+func TestPgInboxSuite(t *testing.T) {
+	suite.Run(t, &pgInboxTest{})
+}
+
+func (p *pgInboxTest) TestPgInboxStore() {
+	t := p.T()
+
+	store, err := inbox.NewSQLStore(p.Connection(), sqldriver.Postgres)
+	require.NoError(t, err)
+
+	testSQLInboxUseCases(t, store)
+	testTransactionalSend(t, store, p.Connection(), sqldriver.Postgres)
+}
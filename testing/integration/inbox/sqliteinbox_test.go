@@ -0,0 +1,29 @@
+package inbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/inbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteInboxTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteInboxSuite(t *testing.T) {
+	suite.Run(t, &sqliteInboxTest{})
+}
+
+func (s *sqliteInboxTest) TestSQLiteInboxStore() {
+	t := s.T()
+
+	store, err := inbox.NewSQLStore(s.Connection(), sqldriver.SQLite)
+	require.NoError(t, err)
+
+	testSQLInboxUseCases(t, store)
+	testTransactionalSend(t, store, s.Connection(), sqldriver.SQLite)
+}
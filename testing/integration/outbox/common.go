@@ -0,0 +1,148 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/endpoint"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGroup scheme.Group = "testgroup"
+
+type OrderPlacedEvent struct {
+	message.ObjectMeta
+	OrderID string `json:"order_id"`
+}
+
+func newMarshaller() message.Marshaller {
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes(testGroup, &OrderPlacedEvent{})
+	return message.NewJsonMarshaller(schemeRegistry)
+}
+
+// testRowLockingOutboxUseCases covers concurrent claims, they need row locks which are not supported by sqlite
+func testRowLockingOutboxUseCases(t *testing.T, store outbox.Store, dbConnection *sql.DB) {
+	t.Run("message is claimed only after commit", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		tx, err := dbConnection.BeginTx(ctx, &sql.TxOptions{})
+		require.NoError(t, err)
+
+		msg := message.NewOutcomingMessage(&OrderPlacedEvent{OrderID: "1"}, message.WithHeaders(message.Headers{"sagaUID": "xxx"}))
+		require.NoError(t, store.Add(ctx, tx, msg))
+
+		claim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+
+		require.NoError(t, tx.Commit())
+
+		claim, err = store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		require.Len(t, claim.Entries(), 1)
+
+		claimed := claim.Entries()[0].Message
+		assert.Equal(t, msg.UID(), claimed.UID())
+		assert.Equal(t, "xxx", claimed.Headers()["sagaUID"])
+		assert.Equal(t, "1", claimed.Payload().(*OrderPlacedEvent).OrderID)
+
+		require.NoError(t, claim.Dispatched(ctx, msg.UID()))
+		require.NoError(t, claim.Commit())
+
+		claim, err = store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+	})
+
+	t.Run("claimed message is skipped by another relay", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		msg := message.NewOutcomingMessage(&OrderPlacedEvent{OrderID: "3"})
+		require.NoError(t, store.Add(ctx, nil, msg))
+
+		claim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		require.Len(t, claim.Entries(), 1)
+
+		anotherClaim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, anotherClaim.Entries(), 0)
+		require.NoError(t, anotherClaim.Rollback())
+
+		require.NoError(t, claim.Retry(ctx, msg.UID(), time.Now().Add(time.Hour), errors.New("broker is down")))
+		require.NoError(t, claim.Commit())
+
+		claim, err = store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+	})
+}
+
+func testOutboxUseCases(t *testing.T, store outbox.Store, dbConnection *sql.DB) {
+	t.Run("rolled back message is never claimed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		tx, err := dbConnection.BeginTx(ctx, &sql.TxOptions{})
+		require.NoError(t, err)
+
+		msg := message.NewOutcomingMessage(&OrderPlacedEvent{OrderID: "2"})
+		require.NoError(t, store.Add(ctx, tx, msg))
+		require.NoError(t, tx.Rollback())
+
+		claim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+	})
+
+	t.Run("dispatched message is not claimed again", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		msg := message.NewOutcomingMessage(&OrderPlacedEvent{OrderID: "5"}, message.WithHeaders(message.Headers{"sagaUID": "yyy"}))
+		require.NoError(t, store.Add(ctx, nil, msg))
+
+		claim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		require.Len(t, claim.Entries(), 1)
+
+		claimed := claim.Entries()[0].Message
+		assert.Equal(t, "yyy", claimed.Headers()["sagaUID"])
+		assert.Equal(t, "5", claimed.Payload().(*OrderPlacedEvent).OrderID)
+
+		require.NoError(t, claim.Dispatched(ctx, msg.UID()))
+		require.NoError(t, claim.Commit())
+
+		claim, err = store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+	})
+
+	t.Run("delayed message", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		msg := message.NewOutcomingMessage(&OrderPlacedEvent{OrderID: "4"})
+		require.NoError(t, store.Add(ctx, nil, msg, endpoint.WithDelay(time.Hour)))
+
+		claim, err := store.Claim(ctx, 10, msg.UID())
+		require.NoError(t, err)
+		assert.Len(t, claim.Entries(), 0)
+		require.NoError(t, claim.Rollback())
+	})
+}
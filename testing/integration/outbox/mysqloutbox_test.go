@@ -0,0 +1,29 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type mysqlOutboxTest struct {
+	intSuite.MysqlSuite
+}
+
+func TestMysqlOutboxSuite(t *testing.T) {
+	suite.Run(t, &mysqlOutboxTest{})
+}
+
+func (s *mysqlOutboxTest) TestMysqlOutboxStore() {
+	t := s.T()
+
+	store, err := outbox.NewSQLStore(s.Connection(), sqldriver.MySQL, newMarshaller())
+	require.NoError(t, err)
+
+	testRowLockingOutboxUseCases(t, store, s.Connection())
+	testOutboxUseCases(t, store, s.Connection())
+}
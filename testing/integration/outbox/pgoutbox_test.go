@@ -0,0 +1,29 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type pgOutboxTest struct {
+	intSuite.PgSuite
+}
+
+func TestPgOutboxSuite(t *testing.T) {
+	suite.Run(t, &pgOutboxTest{})
+}
+
+func (s *pgOutboxTest) TestPgOutboxStore() {
+	t := s.T()
+
+	store, err := outbox.NewSQLStore(s.Connection(), sqldriver.Postgres, newMarshaller())
+	require.NoError(t, err)
+
+	testRowLockingOutboxUseCases(t, store, s.Connection())
+	testOutboxUseCases(t, store, s.Connection())
+}
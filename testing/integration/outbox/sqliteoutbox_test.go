@@ -0,0 +1,28 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/outbox"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteOutboxTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteOutboxSuite(t *testing.T) {
+	suite.Run(t, &sqliteOutboxTest{})
+}
+
+func (s *sqliteOutboxTest) TestSQLiteOutboxStore() {
+	t := s.T()
+
+	store, err := outbox.NewSQLStore(s.Connection(), sqldriver.SQLite, newMarshaller())
+	require.NoError(t, err)
+
+	testOutboxUseCases(t, store, s.Connection())
+}
@@ -0,0 +1,601 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGroup scheme.Group = "testgroup"
+)
+
+func testSQLStoreTables(t *testing.T, dbConnection *sql.DB) {
+	t.Run("initialized store tables", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		res, err := dbConnection.QueryContext(ctx, "SELECT * from saga")
+		require.NoError(t, err)
+		require.NotNil(t, res)
+		require.NoError(t, res.Close()) //nolint:sqlclosecheck
+		res, err = dbConnection.QueryContext(ctx, "SELECT * from saga_history")
+		require.NoError(t, err)
+		require.NoError(t, res.Close()) //nolint:sqlclosecheck
+	})
+}
+
+func testSQLStoreMigrations(t *testing.T, dbConnection *sql.DB, driver saga.SQLDriver, marshaller message.Marshaller) {
+	t.Run("migrations applied without being recorded are detected", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		//mysql commits DDL implicitly, so a column could be added while recording its migration failed
+		_, err := dbConnection.ExecContext(ctx, sqldriver.PrepQuery(driver, "DELETE FROM schema_migrations WHERE component=?;"), saga.MigrationsComponent)
+		require.NoError(t, err)
+
+		_, err = saga.NewSQLSagaStore(dbConnection, driver, marshaller)
+		require.NoError(t, err)
+
+		var recorded int
+		require.NoError(t, dbConnection.QueryRowContext(ctx, sqldriver.PrepQuery(driver, "SELECT COUNT(*) FROM schema_migrations WHERE component=?;"), saga.MigrationsComponent).Scan(&recorded))
+		assert.Equal(t, len(saga.Migrations()), recorded)
+	})
+}
+
+func testStoreUseCases(t *testing.T, store saga.Store, schemeRegistry scheme.KnownTypesRegistry) {
+
+	t.Run("create saga instance", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		workflowSaga := &WorkflowSaga{Field: "field", Value: "value"}
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", workflowSaga)
+		require.NoError(t, sagaInstance.Start(nil)) //started_at, updated_at are populated
+		require.NoError(t, store.Create(ctx, sagaInstance))
+		fetchedSagaInstance, err := store.GetById(ctx, sagaInstance.UID())
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstance)
+		assert.EqualValues(t, sagaInstance, fetchedSagaInstance)
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("delete saga instance", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		workflowSaga := &WorkflowSaga{Field: "field", Value: "value"}
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", workflowSaga)
+		require.NoError(t, store.Create(ctx, sagaInstance))
+		assert.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+		err := store.Delete(ctx, "xxx")
+		assert.Error(t, err)
+		assert.EqualError(t, err, fmt.Sprintf("no saga instance %s found", "xxx"))
+	})
+
+	//this test copies "Create saga instance test" because we don't use fixtures for testing right now and need a way to put records into db
+	t.Run("find saga instance by id", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		workflowSaga := &WorkflowSaga{Field: "field", Value: "value"}
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", workflowSaga)
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		foundSagaInstance, err := store.GetById(ctx, sagaInstance.UID())
+		assert.NoError(t, err)
+		require.NotNil(t, foundSagaInstance)
+		assert.EqualValues(t, sagaInstance, foundSagaInstance)
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+
+		unregisteredSaga := &UnregisteredSaga{WorkflowSaga: WorkflowSaga{Field: "x", Value: "y"}}
+		unregisteredSagaInstance := saga.NewSagaInstance(uuid.New().String(), "", unregisteredSaga)
+		err = store.Create(ctx, unregisteredSagaInstance)
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "no kind is registered in schema for the type UnregisteredSaga"))
+		fetchedUnregisteredSaga, err := store.GetById(ctx, unregisteredSagaInstance.UID())
+		assert.Nil(t, fetchedUnregisteredSaga)
+		assert.NoError(t, err)
+	})
+
+	t.Run("update saga instance", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		workflowSaga := &WorkflowSaga{Field: "field", Value: "value"}
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", workflowSaga)
+		require.NoError(t, store.Create(ctx, sagaInstance))
+		fetchedSagaInstance, err := store.GetById(ctx, sagaInstance.UID())
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstance)
+		assert.EqualValues(t, sagaInstance, fetchedSagaInstance)
+		assert.Len(t, fetchedSagaInstance.HistoryEvents(), 0)
+
+		someEv := &SomeEvent{Field: "field"}
+
+		sagaInstance = fetchedSagaInstance
+		fetchedSagaInstance.AddHistoryEvent(someEv, saga.WithOrigin("origin"), saga.WithTraceUID(uuid.New().String()))
+
+		err = store.Update(ctx, fetchedSagaInstance)
+		require.Error(t, err)
+		//we get this error because SomeEvent is not registered in schema, let's register it
+		require.True(t, strings.Contains(err.Error(), "no kind is registered in schema for the type SomeEvent"))
+
+		schemeRegistry.AddKnownTypes(testGroup, &SomeEvent{})
+
+		require.NoError(t, store.Update(ctx, fetchedSagaInstance))
+
+		fetchedSagaInstance, err = store.GetById(ctx, sagaInstance.UID())
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstance)
+		require.Len(t, sagaInstance.HistoryEvents(), 1)
+		require.Len(t, fetchedSagaInstance.HistoryEvents(), 1)
+		assert.EqualValues(t, sagaInstance.HistoryEvents()[0], fetchedSagaInstance.HistoryEvents()[0])
+
+		fetchedSagaInstance.Fail(&SomeEvent{
+			ObjectMeta: message.ObjectMeta{
+				TypeMeta: scheme.TypeMeta{
+					Kind:  "SomeEvent",
+					Group: testGroup.String(),
+				},
+			},
+			Field: "failed",
+		})
+		fetchedSagaInstance.SetState("charging")
+
+		require.NoError(t, store.Update(ctx, fetchedSagaInstance))
+		failedSagaInstance, err := store.GetById(ctx, sagaInstance.UID())
+		assert.NoError(t, err)
+		require.NotNil(t, failedSagaInstance)
+		assert.EqualValues(t, fetchedSagaInstance, failedSagaInstance)
+		assert.Equal(t, "charging", failedSagaInstance.State())
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("find saga instance by filter", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		anotherSaga := &FilterSaga{WorkFlow: WorkflowSaga{
+			Field: "field",
+			Value: "value",
+		}}
+		anotherSagaInstance := saga.NewSagaInstance(uuid.New().String(), "xxx", anotherSaga)
+		require.NoError(t, store.Create(ctx, anotherSagaInstance))
+		require.NotNil(t, anotherSagaInstance)
+		assert.EqualValues(t, anotherSaga, anotherSagaInstance.Saga())
+
+		fetchedSagaInstances, err := store.GetByFilter(ctx, saga.WithSagaId(anotherSagaInstance.UID()))
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstances)
+		assert.Len(t, fetchedSagaInstances, 1)
+		assert.EqualValues(t, anotherSagaInstance, fetchedSagaInstances[0])
+
+		gk, err := schemeRegistry.ObjectKind(anotherSaga)
+		require.NoError(t, err)
+
+		fetchedSagaInstances, err = store.GetByFilter(ctx, saga.WithSagaName(gk.String()))
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstances)
+		assert.Len(t, fetchedSagaInstances, 1)
+		assert.EqualValues(t, anotherSagaInstance, fetchedSagaInstances[0])
+
+		fetchedSagaInstances, err = store.GetByFilter(ctx, saga.WithStatus("created"))
+		assert.NoError(t, err)
+		require.NotNil(t, fetchedSagaInstances)
+		assert.Len(t, fetchedSagaInstances, 1)
+
+		noSagas, err := store.GetByFilter(ctx, saga.WithSagaName("xxx"))
+		assert.NoError(t, err)
+		require.NotNil(t, noSagas)
+		assert.Len(t, noSagas, 0)
+
+		noSagas, err = store.GetByFilter(ctx, saga.WithSagaId("xxxx"))
+		assert.NoError(t, err)
+		require.NotNil(t, noSagas)
+		assert.Len(t, noSagas, 0)
+
+		noSagas, err = store.GetByFilter(ctx, saga.WithStatus("completed"))
+		assert.NoError(t, err)
+		require.NotNil(t, noSagas)
+		assert.Len(t, noSagas, 0)
+	})
+
+	t.Run("paginate, sort and filter sagas by time", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		parentUID := uuid.New().String()
+
+		var uids []string
+
+		notStarted := saga.NewSagaInstance(uuid.New().String(), parentUID, &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, store.Create(ctx, notStarted))
+		uids = append(uids, notStarted.UID())
+
+		for i := 0; i < 4; i++ {
+			sagaInstance := saga.NewSagaInstance(uuid.New().String(), parentUID, &WorkflowSaga{Field: "field", Value: "value"})
+			require.NoError(t, sagaInstance.Start(nil))
+			sagaInstance.AddHistoryEvent(&SomeEvent{Field: "field"})
+			require.NoError(t, store.Create(ctx, sagaInstance))
+			require.NoError(t, store.Update(ctx, sagaInstance))
+			uids = append(uids, sagaInstance.UID())
+		}
+
+		defer func() {
+			for _, uid := range uids {
+				assert.NoError(t, store.Delete(ctx, uid))
+			}
+		}()
+
+		pageUIDs := func(instances []saga.Instance) []string {
+			res := make([]string, len(instances))
+			for i, instance := range instances {
+				res[i] = instance.UID()
+			}
+			return res
+		}
+
+		for _, order := range []saga.SortOrder{saga.SortAsc, saga.SortDesc} {
+			sorting := saga.WithSort(saga.SortByStartedAt, order)
+
+			all, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), sorting)
+			require.NoError(t, err)
+			require.Len(t, all, len(uids))
+
+			if order == saga.SortAsc {
+				assert.Equal(t, notStarted.UID(), all[0].UID(), "saga without started_at goes first")
+			} else {
+				assert.Equal(t, notStarted.UID(), all[len(all)-1].UID(), "saga without started_at goes last")
+			}
+
+			var (
+				paged  []saga.Instance
+				cursor string
+			)
+
+			for {
+				filters := []saga.FilterOption{saga.WithParentId(parentUID), sorting, saga.WithLimit(2)}
+				if cursor != "" {
+					filters = append(filters, saga.WithCursor(cursor))
+				}
+
+				page, err := store.GetByFilter(ctx, filters...)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page), 2)
+
+				if len(page) == 0 {
+					break
+				}
+
+				paged = append(paged, page...)
+				cursor = saga.NextCursor(page[len(page)-1], filters...)
+			}
+
+			assert.Equal(t, pageUIDs(all), pageUIDs(paged))
+		}
+
+		_, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithCursor("xxx"))
+		assert.True(t, errors.Is(err, saga.ErrInvalidCursor))
+
+		ascCursor := saga.NextCursor(notStarted, saga.WithSort(saga.SortByStartedAt, saga.SortAsc))
+		_, err = store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithSort(saga.SortByUpdatedAt, saga.SortAsc), saga.WithCursor(ascCursor))
+		assert.True(t, errors.Is(err, saga.ErrInvalidCursor))
+
+		started, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithStartedBetween(time.Time{}, time.Now().Add(time.Hour)))
+		require.NoError(t, err)
+		assert.Len(t, started, len(uids)-1)
+
+		notYetStarted, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithStartedBetween(time.Now().Add(time.Hour), time.Time{}))
+		require.NoError(t, err)
+		assert.Len(t, notYetStarted, 0)
+
+		updated, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithUpdatedBetween(time.Now().Add(-time.Hour), time.Time{}), saga.WithSort(saga.SortByUpdatedAt, saga.SortDesc))
+		require.NoError(t, err)
+		require.Len(t, updated, len(uids)-1)
+
+		for _, instance := range updated {
+			assert.Len(t, instance.HistoryEvents(), 1)
+		}
+
+		withoutHistory, err := store.GetByFilter(ctx, saga.WithParentId(parentUID), saga.WithoutHistory())
+		require.NoError(t, err)
+		require.Len(t, withoutHistory, len(uids))
+
+		for _, instance := range withoutHistory {
+			assert.Len(t, instance.HistoryEvents(), 0)
+		}
+
+		limited, err := store.GetByFilter(ctx, saga.WithLimit(1))
+		require.NoError(t, err)
+		assert.Len(t, limited, 1)
+	})
+
+	t.Run("history events keep their order", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		var expected []string
+
+		//events added within a second have the same created_at
+		for update := 0; update < 2; update++ {
+			for i := 0; i < 10; i++ {
+				field := fmt.Sprintf("%d-%d", update, i)
+				expected = append(expected, field)
+				sagaInstance.AddHistoryEvent(&SomeEvent{Field: field})
+			}
+
+			require.NoError(t, store.Update(ctx, sagaInstance))
+		}
+
+		fields := func(instance saga.Instance) []string {
+			var res []string
+			for _, ev := range instance.HistoryEvents() {
+				res = append(res, ev.Payload.(*SomeEvent).Field)
+			}
+			return res
+		}
+
+		fetched, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		assert.Equal(t, expected, fields(fetched))
+
+		filtered, err := store.GetByFilter(ctx, saga.WithSagaId(sagaInstance.UID()))
+		require.NoError(t, err)
+		require.Len(t, filtered, 1)
+		assert.Equal(t, expected, fields(filtered[0]))
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("update with stale version", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		first, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		second, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+
+		first.Progress()
+		require.NoError(t, store.Update(ctx, first))
+		assert.Equal(t, 1, first.Version())
+
+		second.Complete()
+		err = store.Update(ctx, second)
+		require.Error(t, err)
+		assert.True(t, saga.IsVersionConflict(err))
+
+		fetched, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		assert.Equal(t, 1, fetched.Version())
+		assert.True(t, fetched.Status().InProgress())
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("deliveries are persisted with saga state", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		delivery := saga.PendingDelivery{Message: message.NewOutcomingMessage(&SomeEvent{Field: "delivery"})}
+		sagaInstance.Progress()
+		require.NoError(t, store.Update(ctx, sagaInstance, saga.WithDeliveries(delivery)))
+
+		claim, err := store.Outbox().Claim(ctx, 10, delivery.Message.UID())
+		require.NoError(t, err)
+		require.Len(t, claim.Entries(), 1)
+		assert.Equal(t, delivery.Message.UID(), claim.Entries()[0].Message.UID())
+		require.IsType(t, &SomeEvent{}, claim.Entries()[0].Message.Payload())
+		assert.Equal(t, "delivery", claim.Entries()[0].Message.Payload().(*SomeEvent).Field)
+		require.NoError(t, claim.Dispatched(ctx, delivery.Message.UID()))
+		require.NoError(t, claim.Commit())
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("suspend and resume saga instance", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		require.NoError(t, sagaInstance.Compensate(nil))
+		sagaInstance.Suspend()
+		require.NoError(t, store.Update(ctx, sagaInstance))
+
+		suspended, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		assert.True(t, suspended.Status().Suspended())
+		assert.Equal(t, "compensating", suspended.Status().SuspendedFrom())
+
+		suspended.Resume()
+		require.NoError(t, store.Update(ctx, suspended))
+
+		resumed, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		assert.True(t, resumed.Status().Compensating())
+		assert.Empty(t, resumed.Status().SuspendedFrom())
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+
+	t.Run("find saga instance by correlation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		orderID := uuid.New().String()
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		sagaInstance.Correlate("order_id", orderID)
+		require.NoError(t, store.Create(ctx, sagaInstance))
+
+		fetched, err := store.GetByCorrelation(ctx, "order_id", orderID)
+		require.NoError(t, err)
+		require.NotNil(t, fetched)
+		assert.Equal(t, sagaInstance.UID(), fetched.UID())
+		assert.Equal(t, map[string]string{"order_id": orderID}, fetched.Correlations())
+
+		notFound, err := store.GetByCorrelation(ctx, "order_id", uuid.New().String())
+		require.NoError(t, err)
+		assert.Nil(t, notFound)
+
+		//a value can't correlate two sagas
+		another := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		another.Correlate("order_id", orderID)
+		require.Error(t, store.Create(ctx, another))
+
+		invoiceID := uuid.New().String()
+		fetched.Correlate("invoice_id", invoiceID)
+		require.NoError(t, store.Update(ctx, fetched))
+
+		byInvoice, err := store.GetByCorrelation(ctx, "invoice_id", invoiceID)
+		require.NoError(t, err)
+		require.NotNil(t, byInvoice)
+		assert.Equal(t, map[string]string{"order_id": orderID, "invoice_id": invoiceID}, byInvoice.Correlations())
+
+		filtered, err := store.GetByFilter(ctx, saga.WithSagaId(sagaInstance.UID()))
+		require.NoError(t, err)
+		require.Len(t, filtered, 1)
+		assert.Equal(t, byInvoice.Correlations(), filtered[0].Correlations())
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+
+		deleted, err := store.GetByCorrelation(ctx, "order_id", orderID)
+		require.NoError(t, err)
+		assert.Nil(t, deleted)
+	})
+}
+
+func testPayloadUpgrades(t *testing.T, db *sql.DB, driver saga.SQLDriver, schemeRegistry scheme.KnownTypesRegistry) {
+	t.Run("payload of previous version is upgraded on load", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		marshaller := message.NewJsonMarshaller(schemeRegistry)
+		oldStore, err := saga.NewSQLSagaStore(db, driver, marshaller, saga.WithoutMigrations())
+		require.NoError(t, err)
+
+		sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", &WorkflowSaga{Field: "field", Value: "value"})
+		require.NoError(t, oldStore.Create(ctx, sagaInstance))
+
+		//the payload was stored when the field had another name
+		_, err = db.ExecContext(ctx, sqldriver.PrepQuery(driver, "UPDATE saga SET payload=? WHERE uid=?;"), `{"group":"testgroup","kind":"WorkflowSaga","legacy_field":"field","value":"value"}`, sagaInstance.UID())
+		require.NoError(t, err)
+
+		upgrader := saga.NewPayloadUpgrader(schemeRegistry)
+		require.NoError(t, upgrader.AddUpgrade(&WorkflowSaga{}, 1, func(payload map[string]interface{}) error {
+			payload["field"] = payload["legacy_field"]
+			delete(payload, "legacy_field")
+			return nil
+		}))
+		require.NoError(t, upgrader.AddUpgrade(&WorkflowSaga{}, 2, func(payload map[string]interface{}) error {
+			payload["value"] = strings.ToUpper(payload["value"].(string))
+			return nil
+		}))
+
+		store, err := saga.NewSQLSagaStore(db, driver, marshaller, saga.WithoutMigrations(), saga.WithPayloadUpgrader(upgrader))
+		require.NoError(t, err)
+
+		fetched, err := store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		require.NotNil(t, fetched)
+		assert.Equal(t, "field", fetched.Saga().(*WorkflowSaga).Field)
+		assert.Equal(t, "VALUE", fetched.Saga().(*WorkflowSaga).Value)
+
+		payloadVersion := func() int {
+			var version int
+			require.NoError(t, db.QueryRowContext(ctx, sqldriver.PrepQuery(driver, "SELECT payload_version FROM saga WHERE uid=?;"), sagaInstance.UID()).Scan(&version))
+			return version
+		}
+
+		//it's written back on the next update
+		assert.Equal(t, 0, payloadVersion())
+		require.NoError(t, store.Update(ctx, fetched))
+		assert.Equal(t, 2, payloadVersion())
+
+		fetched, err = store.GetById(ctx, sagaInstance.UID())
+		require.NoError(t, err)
+		assert.Equal(t, "VALUE", fetched.Saga().(*WorkflowSaga).Value)
+
+		//a store without upgrades doesn't load a payload of newer version
+		_, err = oldStore.GetById(ctx, sagaInstance.UID())
+		assert.Error(t, err)
+
+		require.NoError(t, store.Delete(ctx, sagaInstance.UID()))
+	})
+}
+
+type WorkflowSaga struct {
+	saga.BaseSaga
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+func (w WorkflowSaga) Init() {
+	panic("implement me")
+}
+
+func (w WorkflowSaga) Start(execCtx saga.SagaContext) error {
+	return nil
+}
+
+func (w WorkflowSaga) Compensate(execCtx saga.SagaContext) error {
+	return nil
+}
+
+func (w WorkflowSaga) Recover(execCtx saga.SagaContext) error {
+	return nil
+}
+
+type FilterSaga struct {
+	saga.BaseSaga
+	WorkFlow WorkflowSaga `json:"work_flow"`
+}
+
+func (a FilterSaga) Init() {
+	panic("implement me")
+}
+
+func (a FilterSaga) Start(execCtx saga.SagaContext) error {
+	panic("implement me")
+}
+
+func (a FilterSaga) Compensate(execCtx saga.SagaContext) error {
+	panic("implement me")
+}
+
+func (a FilterSaga) Recover(execCtx saga.SagaContext) error {
+	panic("implement me")
+}
+
+type UnregisteredSaga struct {
+	WorkflowSaga
+}
+
+type SomeEvent struct {
+	message.ObjectMeta
+	Field string `json:"field"`
+}
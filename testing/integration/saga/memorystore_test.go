@@ -0,0 +1,50 @@
+package saga
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes(testGroup, &WorkflowSaga{})
+	schemeRegistry.AddKnownTypes(testGroup, &FilterSaga{})
+
+	testStoreUseCases(t, saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry)), schemeRegistry)
+}
+
+func TestMemoryStoreCopiesInstances(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes(testGroup, &WorkflowSaga{})
+	schemeRegistry.AddKnownTypes(testGroup, &SomeEvent{})
+	store := saga.NewMemoryStore(message.NewJsonMarshaller(schemeRegistry))
+
+	workflow := &WorkflowSaga{Field: "field", Value: "value"}
+	sagaInstance := saga.NewSagaInstance(uuid.New().String(), "", workflow)
+	require.NoError(t, store.Create(ctx, sagaInstance))
+
+	workflow.Field = "changed after create"
+
+	fetched, err := store.GetById(ctx, sagaInstance.UID())
+	require.NoError(t, err)
+	assert.Equal(t, "field", fetched.Saga().(*WorkflowSaga).Field)
+
+	fetched.Saga().(*WorkflowSaga).Field = "changed after fetch"
+	fetched.AddHistoryEvent(&SomeEvent{Field: "not saved"})
+
+	fetchedAgain, err := store.GetById(ctx, sagaInstance.UID())
+	require.NoError(t, err)
+	assert.Equal(t, "field", fetchedAgain.Saga().(*WorkflowSaga).Field)
+	assert.Len(t, fetchedAgain.HistoryEvents(), 0)
+}
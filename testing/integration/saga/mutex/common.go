@@ -2,18 +2,19 @@ package mutex
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func testSQLMutexUseCases(t *testing.T, mutexFabric func() mutex.Mutex, dbConnection *sql.DB) {
+func testMutexUseCases(t *testing.T, mutexFabric func() mutex.Mutex) {
 	sqlMutex := mutexFabric()
 
 	t.Run("acquire and release a mutex sequentially", func(t *testing.T) {
@@ -145,7 +146,30 @@ func testSQLMutexUseCases(t *testing.T, mutexFabric func() mutex.Mutex, dbConnec
 
 		err := sqlMutex.Release(ctx, id)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "connection which acquiring lock is not found in runtime map. Was Release() called after processing a message?")
+		assert.True(t, errors.Is(err, mutex.ErrLockNotHeld))
+	})
+
+	t.Run("try to acquire a lock", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		id := "ccc"
+		anotherInstanceMutex := mutexFabric()
+
+		acquired, err := sqlMutex.TryLock(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+
+		acquired, err = anotherInstanceMutex.TryLock(ctx, id)
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.NoError(t, sqlMutex.Release(ctx, id))
+
+		acquired, err = anotherInstanceMutex.TryLock(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, acquired)
+		require.NoError(t, anotherInstanceMutex.Release(ctx, id))
 	})
 
 	t.Run("acquire and release a lot of mutex", func(t *testing.T) {
@@ -159,8 +183,12 @@ func testSQLMutexUseCases(t *testing.T, mutexFabric func() mutex.Mutex, dbConnec
 		doneCh := make(chan struct{})
 
 		go func() {
+			releasing := sync.WaitGroup{}
+
 			for id := range locksIds {
+				releasing.Add(1)
 				go func(lockedId string) {
+					defer releasing.Done()
 					time.Sleep(time.Duration(rand.Intn(500-100)+100) * time.Millisecond)
 					releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 					defer cancel()
@@ -168,6 +196,7 @@ func testSQLMutexUseCases(t *testing.T, mutexFabric func() mutex.Mutex, dbConnec
 				}(id)
 			}
 
+			releasing.Wait()
 			doneCh <- struct{}{}
 		}()
 
@@ -0,0 +1,15 @@
+package mutex
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga/mutex"
+)
+
+func TestMemoryMutex(t *testing.T) {
+	backend := mutex.NewMemoryLeaseBackend()
+
+	testMutexUseCases(t, func() mutex.Mutex {
+		return mutex.NewLeaseMutex(backend)
+	})
+}
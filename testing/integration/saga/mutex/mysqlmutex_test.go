@@ -10,6 +10,7 @@ import (
 	"github.com/go-foreman/foreman/saga/mutex"
 	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -27,7 +28,7 @@ func (m *mysqlMutexTest) TestMysqlMutexStore() {
 
 	mysqlMutex := m.createMutexService()
 
-	testSQLMutexUseCases(t, m.createMutexService, m.Connection())
+	testMutexUseCases(t, m.createMutexService)
 
 	t.Run("manually fail to release already released lock", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -48,3 +49,14 @@ func (m *mysqlMutexTest) TestMysqlMutexStore() {
 func (m *mysqlMutexTest) createMutexService() mutex.Mutex {
 	return mutex.NewSqlMutex(m.Connection(), saga.MYSQLDriver)
 }
+
+func (m *mysqlMutexTest) TestMysqlLeaseMutex() {
+	t := m.T()
+
+	backend, err := mutex.NewSqlLeaseBackend(m.Connection(), saga.MYSQLDriver)
+	require.NoError(t, err)
+
+	testMutexUseCases(t, func() mutex.Mutex {
+		return mutex.NewLeaseMutex(backend)
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/go-foreman/foreman/saga/mutex"
 	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -26,7 +27,7 @@ func (m *pgMutexTest) TestMutexStore() {
 
 	pgMutex := m.createMutexService()
 
-	testSQLMutexUseCases(t, m.createMutexService, m.Connection())
+	testMutexUseCases(t, m.createMutexService)
 
 	t.Run("manually fail to release already released lock", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -45,3 +46,14 @@ func (m *pgMutexTest) TestMutexStore() {
 func (m *pgMutexTest) createMutexService() mutex.Mutex {
 	return mutex.NewSqlMutex(m.Connection(), saga.PGDriver)
 }
+
+func (m *pgMutexTest) TestPgLeaseMutex() {
+	t := m.T()
+
+	backend, err := mutex.NewSqlLeaseBackend(m.Connection(), saga.PGDriver)
+	require.NoError(t, err)
+
+	testMutexUseCases(t, func() mutex.Mutex {
+		return mutex.NewLeaseMutex(backend)
+	})
+}
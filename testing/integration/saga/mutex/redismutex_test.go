@@ -0,0 +1,27 @@
+package mutex
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/go-foreman/foreman/saga/mutex/redismutex"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/suite"
+)
+
+type redisMutexTest struct {
+	intSuite.RedisSuite
+}
+
+func TestRedisMutexSuite(t *testing.T) {
+	redisMutexTest := &redisMutexTest{}
+	suite.Run(t, redisMutexTest)
+}
+
+func (m *redisMutexTest) TestRedisMutex() {
+	testMutexUseCases(m.T(), m.createMutexService)
+}
+
+func (m *redisMutexTest) createMutexService() mutex.Mutex {
+	return redismutex.NewMutex(m.Client())
+}
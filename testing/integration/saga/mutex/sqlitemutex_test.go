@@ -0,0 +1,26 @@
+package mutex
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/mutex"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteMutexTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteMutexSuite(t *testing.T) {
+	suite.Run(t, &sqliteMutexTest{})
+}
+
+func (m *sqliteMutexTest) TestSQLiteMutex() {
+	testMutexUseCases(m.T(), m.createMutexService)
+}
+
+func (m *sqliteMutexTest) createMutexService() mutex.Mutex {
+	return mutex.NewSqlMutex(m.Connection(), saga.SQLiteDriver)
+}
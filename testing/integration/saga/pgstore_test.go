@@ -32,5 +32,8 @@ func (p *pgStoreTest) TestPGStore() {
 	require.NoError(t, err)
 	require.NotNil(t, pgStore)
 
-	testSQLStoreUseCases(t, pgStore, schemeRegistry, p.Connection())
+	testSQLStoreTables(t, p.Connection())
+	testSQLStoreMigrations(t, p.Connection(), saga.PGDriver, marshaller)
+	testStoreUseCases(t, pgStore, schemeRegistry)
+	testPayloadUpgrades(t, p.Connection(), saga.PGDriver, schemeRegistry)
 }
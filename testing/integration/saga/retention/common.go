@@ -0,0 +1,120 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/log"
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/contracts"
+	"github.com/go-foreman/foreman/saga/mutex"
+	"github.com/go-foreman/foreman/saga/retention"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type retentionSaga struct {
+	saga.BaseSaga
+	Amount int `json:"amount"`
+}
+
+func (s *retentionSaga) Init()                                     {}
+func (s *retentionSaga) Start(sagaCtx saga.SagaContext) error      { return nil }
+func (s *retentionSaga) Compensate(sagaCtx saga.SagaContext) error { return nil }
+func (s *retentionSaga) Recover(sagaCtx saga.SagaContext) error    { return nil }
+
+// testRetentionUseCases archives completed sagas of sql store into saga_archive table and deletes them with their history
+func testRetentionUseCases(t *testing.T, db *sql.DB, driver saga.SQLDriver, leaderLock mutex.Mutex) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes("retention", &retentionSaga{})
+	contracts.RegisterSagaContracts(schemeRegistry)
+	marshaller := message.NewJsonMarshaller(schemeRegistry)
+
+	store, err := saga.NewSQLSagaStore(db, driver, marshaller)
+	require.NoError(t, err)
+
+	archiver, err := retention.NewSQLArchiver(db, driver, marshaller)
+	require.NoError(t, err)
+
+	prefix := fmt.Sprintf("retention-%d-", time.Now().UnixNano())
+
+	for i := 0; i < 5; i++ {
+		sagaObj := &retentionSaga{Amount: i}
+		sagaObj.SetGroupKind(&scheme.GroupKind{Group: "retention", Kind: "retentionSaga"})
+		instance := saga.NewSagaInstance(fmt.Sprintf("%s%d", prefix, i), "", sagaObj)
+		require.NoError(t, store.Create(ctx, instance))
+
+		if i < 3 {
+			instance.Complete()
+		} else {
+			instance.Progress()
+		}
+
+		instance.AddHistoryEvent(&contracts.StepCompletedEvent{Step: "step", Sequence: 1})
+		require.NoError(t, store.Update(ctx, instance))
+	}
+
+	//timestamps are stored with second precision
+	time.Sleep(time.Second * 2)
+
+	var stats []retention.Stats
+
+	r, err := retention.NewRetention(store, leaderLock, log.NewNilLogger(), []retention.Policy{{SagaName: "retention.retentionSaga", MaxAge: time.Second}},
+		retention.WithArchiver(archiver),
+		retention.WithBatchSize(2),
+		retention.WithMetrics(retention.MetricsFunc(func(s retention.Stats) {
+			stats = append(stats, s)
+		})),
+	)
+	require.NoError(t, err)
+
+	removed, err := r.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Equal(t, []retention.Stats{
+		{SagaName: "retention.retentionSaga", Status: "completed", Sagas: 2, HistoryEvents: 2, Archived: true},
+		{SagaName: "retention.retentionSaga", Status: "completed", Sagas: 1, HistoryEvents: 1, Archived: true},
+	}, stats)
+
+	for i := 0; i < 5; i++ {
+		uid := fmt.Sprintf("%s%d", prefix, i)
+
+		instance, err := store.GetById(ctx, uid)
+		require.NoError(t, err)
+
+		var encoded string
+		err = db.QueryRowContext(ctx, sqldriver.PrepQuery(driver, "SELECT record FROM saga_archive WHERE uid=?;"), uid).Scan(&encoded)
+
+		if i >= 3 {
+			assert.NotNil(t, instance, uid)
+			assert.Equal(t, sql.ErrNoRows, err, uid)
+			continue
+		}
+
+		assert.Nil(t, instance, uid)
+		require.NoError(t, err, uid)
+
+		var historyCount int
+		require.NoError(t, db.QueryRowContext(ctx, sqldriver.PrepQuery(driver, "SELECT count(*) FROM saga_history WHERE saga_uid=?;"), uid).Scan(&historyCount))
+		assert.Equal(t, 0, historyCount, uid)
+
+		record := retention.Record{}
+		require.NoError(t, json.Unmarshal([]byte(encoded), &record))
+		assert.Equal(t, "completed", record.Status)
+		assert.Len(t, record.Events, 1)
+
+		archived, err := marshaller.Unmarshal(record.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, i, archived.(*retentionSaga).Amount)
+	}
+}
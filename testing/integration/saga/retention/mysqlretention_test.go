@@ -0,0 +1,26 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/mutex"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type mysqlRetentionTest struct {
+	intSuite.MysqlSuite
+}
+
+func TestMysqlRetentionSuite(t *testing.T) {
+	suite.Run(t, &mysqlRetentionTest{})
+}
+
+func (s *mysqlRetentionTest) TestMysqlRetention() {
+	leaderLock, err := mutex.NewSqlLeaseMutex(s.Connection(), saga.MYSQLDriver)
+	require.NoError(s.T(), err)
+
+	testRetentionUseCases(s.T(), s.Connection(), saga.MYSQLDriver, leaderLock)
+}
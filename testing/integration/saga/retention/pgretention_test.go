@@ -0,0 +1,26 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/mutex"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type pgRetentionTest struct {
+	intSuite.PgSuite
+}
+
+func TestPgRetentionSuite(t *testing.T) {
+	suite.Run(t, &pgRetentionTest{})
+}
+
+func (s *pgRetentionTest) TestPgRetention() {
+	leaderLock, err := mutex.NewSqlLeaseMutex(s.Connection(), saga.PGDriver)
+	require.NoError(s.T(), err)
+
+	testRetentionUseCases(s.T(), s.Connection(), saga.PGDriver, leaderLock)
+}
@@ -0,0 +1,26 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/saga"
+	"github.com/go-foreman/foreman/saga/mutex"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteRetentionTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteRetentionSuite(t *testing.T) {
+	suite.Run(t, &sqliteRetentionTest{})
+}
+
+func (s *sqliteRetentionTest) TestSQLiteRetention() {
+	leaderLock, err := mutex.NewSqlLeaseMutex(s.Connection(), saga.SQLiteDriver)
+	require.NoError(s.T(), err)
+
+	testRetentionUseCases(s.T(), s.Connection(), saga.SQLiteDriver, leaderLock)
+}
@@ -0,0 +1,37 @@
+package saga
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/pubsub/message"
+	"github.com/go-foreman/foreman/runtime/scheme"
+	"github.com/go-foreman/foreman/saga"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteStoreTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteSuite(t *testing.T) {
+	suite.Run(t, &sqliteStoreTest{})
+}
+
+func (s *sqliteStoreTest) TestSQLiteStore() {
+	t := s.T()
+
+	schemeRegistry := scheme.NewKnownTypesRegistry()
+	schemeRegistry.AddKnownTypes(testGroup, &WorkflowSaga{})
+	schemeRegistry.AddKnownTypes(testGroup, &FilterSaga{})
+	store, err := saga.NewSQLSagaStore(s.Connection(), saga.SQLiteDriver, message.NewJsonMarshaller(schemeRegistry))
+
+	require.NoError(t, err)
+	require.NotNil(t, store)
+
+	testSQLStoreTables(t, s.Connection())
+	testSQLStoreMigrations(t, s.Connection(), saga.SQLiteDriver, message.NewJsonMarshaller(schemeRegistry))
+	testStoreUseCases(t, store, schemeRegistry)
+	testPayloadUpgrades(t, s.Connection(), saga.SQLiteDriver, schemeRegistry)
+}
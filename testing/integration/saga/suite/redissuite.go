@@ -0,0 +1,50 @@
+package suite
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RedisSuite struct {
+	suite.Suite
+	client *redis.Client
+}
+
+// SetupSuite setup at the beginning of test
+func (s *RedisSuite) SetupSuite() {
+	address := "127.0.0.1:6379"
+
+	if v := os.Getenv("REDIS_ADDRESS"); v != "" {
+		address = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s.client = redis.NewClient(&redis.Options{Addr: address})
+	require.NoError(s.T(), s.client.Ping(ctx).Err())
+}
+
+func (s *RedisSuite) Client() *redis.Client {
+	return s.client
+}
+
+// TearDownSuite teardown at the end of test
+func (s *RedisSuite) TearDownSuite() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	keys, err := s.client.Keys(ctx, "saga_lock:*").Result()
+	require.NoError(s.T(), err)
+
+	if len(keys) > 0 {
+		require.NoError(s.T(), s.client.Del(ctx, keys...).Err())
+	}
+
+	require.NoError(s.T(), s.client.Close())
+}
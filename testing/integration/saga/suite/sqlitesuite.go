@@ -0,0 +1,43 @@
+package suite
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSuite runs tests against a database file in a temporary directory, it doesn't need a database server
+type SQLiteSuite struct {
+	suite.Suite
+	dbConn *sql.DB
+	dir    string
+}
+
+// SetupSuite setup at the beginning of test
+func (s *SQLiteSuite) SetupSuite() {
+	var err error
+	s.dir, err = os.MkdirTemp("", "foreman-sqlite")
+	require.NoError(s.T(), err)
+
+	connectionStr := fmt.Sprintf("file:%s?_busy_timeout=10000&_txlock=immediate", filepath.Join(s.dir, "foreman.db"))
+
+	s.dbConn, err = sql.Open("sqlite3", connectionStr)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.dbConn.Ping())
+}
+
+func (s *SQLiteSuite) Connection() *sql.DB {
+	return s.dbConn
+}
+
+// TearDownSuite teardown at the end of test
+func (s *SQLiteSuite) TearDownSuite() {
+	require.NoError(s.T(), s.dbConn.Close())
+	require.NoError(s.T(), os.RemoveAll(s.dir))
+}
@@ -0,0 +1,185 @@
+package sqlmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	"github.com/go-foreman/foreman/runtime/sqlmigrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTableName = "migrate_test"
+
+func testMigrations() []sqlmigrate.Migration {
+	return []sqlmigrate.Migration{
+		{
+			Version:     1,
+			Description: "create migrate_test table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("create table %s (uid varchar(255) not null primary key);", testTableName)}
+			},
+		},
+		{
+			Version:     2,
+			Description: "add name column to migrate_test table",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN name varchar(255) null;", testTableName)}
+			},
+			Applied: func(ctx context.Context, q sqlmigrate.Querier, driver sqldriver.Driver) (bool, error) {
+				return sqlmigrate.ColumnExists(ctx, q, driver, testTableName, "name")
+			},
+		},
+	}
+}
+
+func testMigratorUseCases(t *testing.T, db *sql.DB, driver sqldriver.Driver) {
+	migrator := sqlmigrate.NewMigrator(db, driver)
+
+	cleanup := func(t *testing.T, component string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s;", testTableName))
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, sqldriver.PrepQuery(driver, "DELETE FROM schema_migrations WHERE component=?;"), component)
+		require.NoError(t, err)
+	}
+
+	applied := func(t *testing.T, component string) []int {
+		rows, err := db.Query(sqldriver.PrepQuery(driver, "SELECT version FROM schema_migrations WHERE component=? ORDER BY version;"), component)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		var versions []int
+		for rows.Next() {
+			var version int
+			require.NoError(t, rows.Scan(&version))
+			versions = append(versions, version)
+		}
+		require.NoError(t, rows.Err())
+
+		return versions
+	}
+
+	t.Run("apply pending migrations once", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		component := "apply"
+		defer cleanup(t, component)
+
+		require.NoError(t, migrator.Migrate(ctx, component, testMigrations()[:1]))
+		require.NoError(t, migrator.Migrate(ctx, component, testMigrations()[:1]))
+		assert.Equal(t, []int{1}, applied(t, component))
+
+		exists, err := sqlmigrate.ColumnExists(ctx, db, driver, testTableName, "name")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		require.NoError(t, migrator.Migrate(ctx, component, testMigrations()))
+		assert.Equal(t, []int{1, 2}, applied(t, component))
+
+		exists, err = sqlmigrate.ColumnExists(ctx, db, driver, testTableName, "name")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("record migration applied before tracking", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		component := "detect"
+		defer cleanup(t, component)
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf("create table %s (uid varchar(255) not null primary key, name varchar(255) null);", testTableName))
+		require.NoError(t, err)
+
+		migrations := testMigrations()
+		migrations[0].Applied = func(ctx context.Context, q sqlmigrate.Querier, driver sqldriver.Driver) (bool, error) {
+			return sqlmigrate.TableExists(ctx, q, driver, testTableName)
+		}
+
+		require.NoError(t, migrator.Migrate(ctx, component, migrations))
+		assert.Equal(t, []int{1, 2}, applied(t, component))
+	})
+
+	t.Run("failed migration is not recorded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		component := "failed"
+		defer cleanup(t, component)
+
+		migrations := append(testMigrations()[:1], sqlmigrate.Migration{
+			Version:     2,
+			Description: "broken",
+			Statements: func(driver sqldriver.Driver) []string {
+				return []string{"ALTER TABLE not_existing_table ADD COLUMN name varchar(255) null;"}
+			},
+		})
+
+		err := migrator.Migrate(ctx, component, migrations)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "applying migration 2 `broken` of failed")
+		assert.Equal(t, []int{1}, applied(t, component))
+	})
+
+	t.Run("emit sql of pending migrations", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		component := "emit"
+		defer cleanup(t, component)
+
+		require.NoError(t, migrator.Migrate(ctx, component, testMigrations()[:1]))
+
+		statements, err := migrator.SQL(ctx, component, testMigrations())
+		require.NoError(t, err)
+		require.Len(t, statements, 3)
+		assert.Contains(t, statements[0], "create table if not exists schema_migrations")
+		assert.Equal(t, fmt.Sprintf("ALTER TABLE %s ADD COLUMN name varchar(255) null;", testTableName), statements[1])
+		assert.True(t, strings.HasPrefix(statements[2], "INSERT INTO schema_migrations (component, version, description, applied_at) VALUES ('emit', 2, 'add name column to migrate_test table'"))
+
+		//nothing is applied
+		assert.Equal(t, []int{1}, applied(t, component))
+
+		for _, statement := range statements {
+			_, err := db.ExecContext(ctx, statement)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []int{1, 2}, applied(t, component))
+
+		statements, err = migrator.SQL(ctx, component, testMigrations())
+		require.NoError(t, err)
+		assert.Len(t, statements, 1)
+	})
+
+	t.Run("concurrent migrations are applied once", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		component := "concurrent"
+		defer cleanup(t, component)
+
+		wg := sync.WaitGroup{}
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, sqlmigrate.NewMigrator(db, driver).Migrate(ctx, component, testMigrations()))
+			}()
+		}
+
+		wg.Wait()
+		assert.Equal(t, []int{1, 2}, applied(t, component))
+	})
+}
@@ -0,0 +1,21 @@
+package sqlmigrate
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/suite"
+)
+
+type mysqlMigrateTest struct {
+	intSuite.MysqlSuite
+}
+
+func TestMysqlMigrateSuite(t *testing.T) {
+	suite.Run(t, &mysqlMigrateTest{})
+}
+
+func (s *mysqlMigrateTest) TestMysqlMigrator() {
+	testMigratorUseCases(s.T(), s.Connection(), sqldriver.MySQL)
+}
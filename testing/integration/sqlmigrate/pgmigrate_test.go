@@ -0,0 +1,21 @@
+package sqlmigrate
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/suite"
+)
+
+type pgMigrateTest struct {
+	intSuite.PgSuite
+}
+
+func TestPgMigrateSuite(t *testing.T) {
+	suite.Run(t, &pgMigrateTest{})
+}
+
+func (s *pgMigrateTest) TestPgMigrator() {
+	testMigratorUseCases(s.T(), s.Connection(), sqldriver.Postgres)
+}
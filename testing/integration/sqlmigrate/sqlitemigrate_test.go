@@ -0,0 +1,21 @@
+package sqlmigrate
+
+import (
+	"testing"
+
+	"github.com/go-foreman/foreman/runtime/sqldriver"
+	intSuite "github.com/go-foreman/foreman/testing/integration/saga/suite"
+	"github.com/stretchr/testify/suite"
+)
+
+type sqliteMigrateTest struct {
+	intSuite.SQLiteSuite
+}
+
+func TestSQLiteMigrateSuite(t *testing.T) {
+	suite.Run(t, &sqliteMigrateTest{})
+}
+
+func (s *sqliteMigrateTest) TestSQLiteMigrator() {
+	testMigratorUseCases(s.T(), s.Connection(), sqldriver.SQLite)
+}